@@ -54,7 +54,7 @@ const (
 
 type MachineAgent struct {
 	state                                     AgentState
-	waitTimeout                               time.Duration
+	waitTimeout, heartbeatInterval            time.Duration
 	client                                    *Client
 	events                                    *EventStream
 	machine                                   *models.Machine
@@ -78,6 +78,7 @@ func (c *Client) NewAgent(m *models.Machine,
 		exitOnNotRunnable: exitOnNotRunnable,
 		logger:            logger,
 		waitTimeout:       1 * time.Hour,
+		heartbeatInterval: 1 * time.Minute,
 	}
 	if res.logger == nil {
 		res.logger = os.Stderr
@@ -103,6 +104,35 @@ func (a *MachineAgent) Timeout(t time.Duration) *MachineAgent {
 	return a
 }
 
+// Heartbeat allows you to change how often the Agent tells
+// dr-provision the machine is alive from the default of 1 minute.  A
+// zero or negative interval turns heartbeats off.
+func (a *MachineAgent) Heartbeat(t time.Duration) *MachineAgent {
+	a.heartbeatInterval = t
+	return a
+}
+
+// heartbeat tells dr-provision the machine is alive every
+// heartbeatInterval until stop is closed.
+func (a *MachineAgent) heartbeat(stop <-chan struct{}) {
+	if a.heartbeatInterval <= 0 {
+		return
+	}
+	m := &models.Machine{Uuid: a.machine.Uuid}
+	ticker := time.NewTicker(a.heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := a.client.MachineHeartbeat(m); err != nil {
+				a.Logf("MachineAgent: heartbeat failed: %v\n", err)
+			}
+		}
+	}
+}
+
 func (a *MachineAgent) power(cmdLine string) error {
 	if !a.doPower {
 		return nil
@@ -583,6 +613,9 @@ func (a *MachineAgent) Run() error {
 			return res
 		}
 	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go a.heartbeat(stop)
 	for {
 		switch a.state {
 		case AGENT_INIT:
@@ -304,6 +304,9 @@ func (r *R) Params(args ...string) *R {
 //        to limit the number of results returned
 //    "offset" "number"
 //        to skip <number> of results before returning
+//    "cursor" "cursor"
+//        to return results after the last one of a previous page.  An empty
+//        cursor starts at the beginning.
 //    "indexName" "Eq/Lt/Lte/Gt/Gte/Ne" "value"
 //        to return results Equal, Less Than, Less Than Or Equal, Greater Than, Greater Than Or Equal, or Not Equal to value according to IndexName
 //    "indexName" "Between/Except" "lowerBound" "upperBound"
@@ -320,7 +323,7 @@ func (r *R) Filter(prefix string, filterArgs ...string) *R {
 		case "reverse":
 			finalParams = append(finalParams, filter, "true")
 			i++
-		case "sort", "limit", "offset", "slim":
+		case "sort", "limit", "offset", "slim", "cursor":
 			if len(filterArgs)-i < 2 {
 				r.err.Errorf("Invalid Filter: %s requires exactly one parameter", filter)
 				return r
@@ -426,29 +429,121 @@ func (r *R) FailFast() *R {
 // Otherwise, the response body will be unmarshalled into val as
 // directed by the Content-Type header of the response.
 func (r *R) Do(val interface{}) error {
+	accept := "application/json"
+	switch val.(type) {
+	case io.Writer:
+		accept = "application/octet-stream"
+	}
+	resp, err := r.response(accept)
+	if err != nil {
+		return err
+	}
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if wr, ok := val.(io.Writer); ok && resp.StatusCode < 300 {
+		_, err := io.Copy(wr, resp.Body)
+		r.err.AddError(err)
+		return r.err.HasError()
+	}
+	if r.method == "HEAD" {
+		if resp.StatusCode <= 300 {
+			return nil
+		}
+		r.err.Errorf(http.StatusText(resp.StatusCode))
+		r.err.Code = resp.StatusCode
+		return r.err
+	}
+	var dec Decoder
+	ct := resp.Header.Get("Content-Type")
+	mt, _, _ := mime.ParseMediaType(ct)
+	switch mt {
+	case "application/json":
+		dec = json.NewDecoder(resp.Body)
+	default:
+		r.err.Errorf("Cannot handle content-type %s", ct)
+		dump, _ := httputil.DumpResponse(resp, true)
+		r.err.Errorf("Resp: \n%s", string(dump))
+	}
+	if dec == nil {
+		r.err.Errorf("No decoder for content-type %s", ct)
+		return r.err
+	}
+	if resp.StatusCode >= 400 {
+		res := &models.Error{}
+		if err := dec.Decode(res); err != nil {
+			r.err.Code = resp.StatusCode
+			r.err.AddError(err)
+			return r.err
+		}
+		return res
+	}
+	if val != nil && resp.Body != nil && resp.ContentLength != 0 {
+		r.err.AddError(dec.Decode(val))
+	}
+	if f, ok := val.(models.Filler); ok && err != nil {
+		f.Fill()
+	}
+	return r.err.HasError()
+}
+
+// Stream is like Do, except that it asks the server to send the
+// response as newline delimited JSON and calls fn once for each
+// object as it arrives, so that the whole response never has to be
+// held in memory.  Stream stops at the first error fn returns.
+func (r *R) Stream(fn func(json.RawMessage) error) error {
+	resp, err := r.response("application/x-ndjson")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	dec := json.NewDecoder(resp.Body)
+	if resp.StatusCode >= 400 {
+		res := &models.Error{}
+		if err := dec.Decode(res); err != nil {
+			r.err.Code = resp.StatusCode
+			r.err.AddError(err)
+			return r.err
+		}
+		return res
+	}
+	for {
+		buf := json.RawMessage{}
+		if err := dec.Decode(&buf); err == io.EOF {
+			return nil
+		} else if err != nil {
+			r.err.AddError(err)
+			return r.err
+		}
+		if err := fn(buf); err != nil {
+			return err
+		}
+	}
+}
+
+// response performs the request built up by previous method calls
+// on R, retrying as described in Do, and returns the raw
+// http.Response.  The caller is responsible for closing its Body.
+func (r *R) response(accept string) (*http.Response, error) {
 	if r.uri == nil {
 		r.err.Errorf("No URL to talk to")
-		return r.err
+		return nil, r.err
 	}
 	r.c.mux.Lock()
 	if r.c.closed {
 		r.c.mux.Unlock()
 		r.err.Errorf("Connection Closed")
-		return r.err
+		return nil, r.err
 	}
 	r.c.mux.Unlock()
 	if r.err.ContainsError() {
-		return r.err
+		return nil, r.err
 	}
 	if r.traceLvl != "" {
 		r.Headers("X-Log-Request", r.traceLvl)
 		r.Headers("X-Log-Token", r.traceToken)
 	}
-	r.Headers("Accept", "application/json")
-	switch val.(type) {
-	case io.Writer:
-		r.Headers("Accept", "application/octet-stream")
-	}
+	r.Headers("Accept", accept)
 	timeouts := []time.Duration{
 		time.Second,
 		time.Second,
@@ -464,7 +559,7 @@ func (r *R) Do(val interface{}) error {
 		req, err = http.NewRequest(r.method, r.uri.String(), r.body)
 		if err != nil {
 			r.err.AddError(err)
-			return r.err
+			return nil, r.err
 		}
 		req.Header = r.header
 		r.Req = req
@@ -489,56 +584,10 @@ func (r *R) Do(val interface{}) error {
 	}
 	if err != nil {
 		r.err.AddError(err)
-		return r.err
+		return nil, r.err
 	}
 	r.Resp = resp
-	if resp != nil {
-		defer resp.Body.Close()
-	}
-	if wr, ok := val.(io.Writer); ok && resp.StatusCode < 300 {
-		_, err := io.Copy(wr, resp.Body)
-		r.err.AddError(err)
-		return r.err.HasError()
-	}
-	if r.method == "HEAD" {
-		if resp.StatusCode <= 300 {
-			return nil
-		}
-		r.err.Errorf(http.StatusText(resp.StatusCode))
-		r.err.Code = resp.StatusCode
-		return r.err
-	}
-	var dec Decoder
-	ct := resp.Header.Get("Content-Type")
-	mt, _, _ := mime.ParseMediaType(ct)
-	switch mt {
-	case "application/json":
-		dec = json.NewDecoder(resp.Body)
-	default:
-		r.err.Errorf("Cannot handle content-type %s", ct)
-		dump, _ := httputil.DumpResponse(resp, true)
-		r.err.Errorf("Resp: \n%s", string(dump))
-	}
-	if dec == nil {
-		r.err.Errorf("No decoder for content-type %s", ct)
-		return r.err
-	}
-	if resp.StatusCode >= 400 {
-		res := &models.Error{}
-		if err := dec.Decode(res); err != nil {
-			r.err.Code = resp.StatusCode
-			r.err.AddError(err)
-			return r.err
-		}
-		return res
-	}
-	if val != nil && resp.Body != nil && resp.ContentLength != 0 {
-		r.err.AddError(dec.Decode(val))
-	}
-	if f, ok := val.(models.Filler); ok && err != nil {
-		f.Fill()
-	}
-	return r.err.HasError()
+	return resp, nil
 }
 
 // Close should be called whenever you no longer want to use this
@@ -650,6 +699,46 @@ func (c *Client) ListModel(prefix string, params ...string) ([]models.Model, err
 	return ref.ToModels(res), nil
 }
 
+// ListModelPage returns at most limit objects of type prefix that
+// come after cursor, along with the cursor for the next page.  Pass
+// an empty cursor to fetch the first page.  The returned cursor will
+// be empty once there are no more objects.  Unlike offset and limit,
+// pages fetched with cursors do not shift when objects are created or
+// deleted while paging.
+func (c *Client) ListModelPage(prefix, cursor string, limit int, params ...string) ([]models.Model, string, error) {
+	ref, err := models.New(prefix)
+	if err != nil {
+		return nil, "", err
+	}
+	res := ref.SliceOf()
+	params = append(params, "cursor", cursor, "limit", fmt.Sprintf("%d", limit))
+	req := c.Req().UrlForM(ref).Params(params...)
+	if err := req.Do(&res); err != nil {
+		return nil, "", err
+	}
+	return ref.ToModels(res), req.Resp.Header.Get("X-DRP-LIST-CURSOR"), nil
+}
+
+// StreamModel calls fn once for each object of type prefix that
+// matches params.  The server streams objects as it finds them, so
+// neither side holds the complete list in memory.
+func (c *Client) StreamModel(prefix string, fn func(models.Model) error, params ...string) error {
+	if _, err := models.New(prefix); err != nil {
+		return err
+	}
+	req := c.Req().UrlFor(prefix)
+	if len(params) > 0 {
+		req = req.Params(params...)
+	}
+	return req.Stream(func(buf json.RawMessage) error {
+		obj, _ := models.New(prefix)
+		if err := json.Unmarshal(buf, &obj); err != nil {
+			return err
+		}
+		return fn(obj)
+	})
+}
+
 // GetModel returns an object if type prefix with the unique
 // identifier key, if such an object exists.  Key can be either the
 // unique key for an object, or any field on an object that has an
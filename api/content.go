@@ -3,6 +3,7 @@ package api
 import (
 	"fmt"
 	"io/ioutil"
+	"net/http"
 	"os"
 	"path"
 	"strings"
@@ -21,14 +22,48 @@ func (c *Client) GetContentItem(name string) (*models.Content, error) {
 	return res, c.FillModel(res, name)
 }
 
+// signed adds the detached signature sig to r, if there is one.
+func signed(r *R, sig string) *R {
+	if sig != "" {
+		r.Headers(models.SignatureHeader, sig)
+	}
+	return r
+}
+
 func (c *Client) CreateContent(content *models.Content) (*models.ContentSummary, error) {
+	return c.CreateSignedContent(content, "")
+}
+
+// CreateSignedContent is CreateContent for a content bundle with a
+// detached signature.
+func (c *Client) CreateSignedContent(content *models.Content, sig string) (*models.ContentSummary, error) {
 	res := &models.ContentSummary{}
-	return res, c.Req().Post(content).UrlFor("contents").Do(res)
+	return res, signed(c.Req().Post(content).UrlFor("contents"), sig).Do(res)
 }
 
 func (c *Client) ReplaceContent(content *models.Content) (*models.ContentSummary, error) {
+	return c.ReplaceSignedContent(content, "")
+}
+
+// ReplaceSignedContent is ReplaceContent for a content bundle with a
+// detached signature.
+func (c *Client) ReplaceSignedContent(content *models.Content, sig string) (*models.ContentSummary, error) {
 	res := &models.ContentSummary{}
-	return res, c.Req().Put(content).UrlFor("contents", content.Meta.Name).Do(res)
+	return res, signed(c.Req().Put(content).UrlFor("contents", content.Meta.Name), sig).Do(res)
+}
+
+// DryRunContent reports what uploading content with the detached
+// signature sig (if any) would do without changing anything.  It
+// checks content against the installed version of the content bundle
+// if there is one.
+func (c *Client) DryRunContent(content *models.Content, sig string) (*models.ContentDiff, error) {
+	res := &models.ContentDiff{}
+	err := signed(c.Req().Put(content).UrlFor("contents", content.Meta.Name).Params("dry-run", "true"), sig).Do(res)
+	if e, ok := err.(*models.Error); ok && e.Code == http.StatusNotFound {
+		res = &models.ContentDiff{}
+		err = signed(c.Req().Post(content).UrlFor("contents").Params("dry-run", "true"), sig).Do(res)
+	}
+	return res, err
 }
 
 func (c *Client) DeleteContent(name string) error {
@@ -46,8 +81,8 @@ func findOrFake(src, field string, args map[string]string) string {
 		if field == "Type" {
 			// Default Type should be dynamic
 			s = "dynamic"
-		} else if field == "RequiredFeatures" {
-			// Default RequiredFeatures should be empty string
+		} else if field == "RequiredFeatures" || field == "Prerequisites" {
+			// Default RequiredFeatures and Prerequisites should be empty string
 			s = ""
 		}
 		return s
@@ -56,6 +91,20 @@ func findOrFake(src, field string, args map[string]string) string {
 	}
 }
 
+// bundleItem is anything that can be stored in a content bundle.
+type bundleItem interface {
+	Key() string
+}
+
+// newBundleItem returns an empty item for a section of a content
+// bundle.  Besides the models, bundles can carry TemplateTests.
+func newBundleItem(prefix string) (bundleItem, error) {
+	if prefix == models.ContentTestsSection {
+		return &models.TemplateTest{}, nil
+	}
+	return models.New(prefix)
+}
+
 func (c *Client) BundleContent(src string, dst store.Store, params map[string]string) error {
 	if dm, ok := dst.(store.MetaSaver); ok {
 		meta := map[string]string{
@@ -67,6 +116,9 @@ func (c *Client) BundleContent(src string, dst store.Store, params map[string]st
 			"Source":           findOrFake(src, "Source", params),
 			"Type":             findOrFake(src, "Type", params),
 		}
+		if p := findOrFake(src, "Prerequisites", params); p != "" {
+			meta["Prerequisites"] = p
+		}
 		dm.SetMetaData(meta)
 	}
 
@@ -78,7 +130,7 @@ func (c *Client) BundleContent(src string, dst store.Store, params map[string]st
 		}
 		prefix := f.Name()
 
-		if _, err := models.New(prefix); err != nil {
+		if _, err := newBundleItem(prefix); err != nil {
 			// Skip things we can instantiate
 			continue
 		}
@@ -95,7 +147,7 @@ func (c *Client) BundleContent(src string, dst store.Store, params map[string]st
 				continue
 			}
 			itemName := fileInfo.Name()
-			item, _ := models.New(prefix)
+			item, _ := newBundleItem(prefix)
 			buf, err := ioutil.ReadFile(path.Join(src, prefix, itemName))
 			if err != nil {
 				return fmt.Errorf("Cannot read item %s: %v", path.Join(prefix, itemName), err)
@@ -114,11 +166,11 @@ func (c *Client) BundleContent(src string, dst store.Store, params map[string]st
 					tmpl.ID = itemName
 					tmpl.Contents = string(buf)
 				} else {
-					return fmt.Errorf("No idea how to decode %s into %s", itemName, item.Prefix())
+					return fmt.Errorf("No idea how to decode %s into %s", itemName, prefix)
 				}
 			}
 			if err := sub.Save(item.Key(), item); err != nil {
-				return fmt.Errorf("Failed to save %s:%s: %v", item.Prefix(), item.Key(), err)
+				return fmt.Errorf("Failed to save %s:%s: %v", prefix, item.Key(), err)
 			}
 		}
 	}
@@ -149,7 +201,7 @@ func (c *Client) UnbundleContent(content store.Store, dst string) error {
 		if err := os.MkdirAll(path.Join(dst, prefix), 0750); err != nil {
 			return err
 		}
-		_, err := models.New(prefix)
+		_, err := newBundleItem(prefix)
 		if err != nil {
 			return fmt.Errorf("Store contains model of type %s the we don't know about", prefix)
 		}
@@ -159,7 +211,7 @@ func (c *Client) UnbundleContent(content store.Store, dst string) error {
 		}
 		codec := content.GetCodec()
 		for _, key := range keys {
-			item, _ := models.New(prefix)
+			item, _ := newBundleItem(prefix)
 			if err := sub.Load(key, item); err != nil {
 				return fmt.Errorf("Failed to load %s:%s: %v", prefix, key, err)
 			}
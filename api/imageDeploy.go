@@ -0,0 +1,229 @@
+package api
+
+import (
+	"compress/bzip2"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/digitalrebar/provision/models"
+)
+
+// imageProgress counts the bytes of an image as they are fetched,
+// calling report every tenth of the way through.  If the size of the
+// image is not known, report is called every 64 MiB instead.
+type imageProgress struct {
+	io.Reader
+	total, done, next int64
+	report            func(done, total int64)
+}
+
+func (p *imageProgress) step() int64 {
+	if p.total > 0 {
+		return p.total/10 + 1
+	}
+	return 64 << 20
+}
+
+func (p *imageProgress) Read(buf []byte) (int, error) {
+	n, err := p.Reader.Read(buf)
+	p.done += int64(n)
+	if p.next == 0 {
+		p.next = p.step()
+	}
+	if p.done >= p.next {
+		p.report(p.done, p.total)
+		p.next += p.step()
+	}
+	return n, err
+}
+
+// decompressImage returns a reader for the decompressed contents of
+// src along with a function to call once they have all been read, or
+// once reading them has failed.  gzip and bzip2 are handled directly,
+// and xz and zstd by running the xz and zstd commands.
+func decompressImage(compression string, src io.Reader) (io.Reader, func() error, error) {
+	done := func() error { return nil }
+	switch compression {
+	case "":
+		return src, done, nil
+	case "gz":
+		res, err := gzip.NewReader(src)
+		return res, done, err
+	case "bz2":
+		return bzip2.NewReader(src), done, nil
+	}
+	cmd := exec.Command(map[string]string{"xz": "xz", "zst": "zstd"}[compression], "-dc")
+	cmd.Stdin = src
+	res, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, nil, err
+	}
+	// Closing the pipe first makes a decompressor that is still
+	// writing exit instead of blocking Wait forever.
+	return res, func() error {
+		res.Close()
+		return cmd.Wait()
+	}, nil
+}
+
+// deployImage writes the image described by spec to its Disk.  Raw
+// images without a Sha256 are streamed straight to the disk.  Raw
+// images with one are saved in workDir and only copied to the disk
+// once the checksum is verified, so an image that does not match never
+// touches the disk.  qcow2 images are also saved in workDir, verified,
+// and then converted onto the disk with qemu-img.
+func deployImage(spec *models.ImageDeploy, workDir string, out io.Writer, progress func(done, total int64)) (err error) {
+	if err := spec.Validate(); err != nil {
+		return err
+	}
+	format, compression, _ := models.SplitImageType(spec.Type)
+	resp, err := http.Get(spec.URL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Failed to fetch %s: %s", spec.URL, resp.Status)
+	}
+	hasher := sha256.New()
+	fetched := io.TeeReader(&imageProgress{Reader: resp.Body, total: resp.ContentLength, report: progress}, hasher)
+	image, finish, err := decompressImage(compression, fetched)
+	if err != nil {
+		return fmt.Errorf("Failed to decompress %s: %v", spec.URL, err)
+	}
+	finished := false
+	defer func() {
+		if finished {
+			return
+		}
+		if waitErr := finish(); waitErr != nil {
+			err = fmt.Errorf("%v (decompressing: %v)", err, waitErr)
+		}
+	}()
+	target := spec.Disk
+	staged := format == "qcow2" || spec.Sha256 != ""
+	if staged {
+		target = path.Join(workDir, "image."+format)
+		defer os.Remove(target)
+	}
+	dest, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer dest.Close()
+	if _, err = io.Copy(dest, image); err != nil {
+		return fmt.Errorf("Failed to write %s to %s: %v", spec.URL, target, err)
+	}
+	finished = true
+	if err = finish(); err != nil {
+		return fmt.Errorf("Failed to decompress %s: %v", spec.URL, err)
+	}
+	// Whatever the decompressor did not need still counts for the checksum.
+	io.Copy(ioutil.Discard, fetched)
+	if sum := hex.EncodeToString(hasher.Sum(nil)); spec.Sha256 != "" && !strings.EqualFold(sum, spec.Sha256) {
+		return fmt.Errorf("Checksum mismatch for %s: expected %s, got %s", spec.URL, spec.Sha256, sum)
+	}
+	if err = dest.Sync(); err == nil {
+		err = dest.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to write %s to %s: %v", spec.URL, target, err)
+	}
+	switch {
+	case format == "qcow2":
+		cmd := exec.Command("qemu-img", "convert", "-O", "raw", target, spec.Disk)
+		cmd.Stdout, cmd.Stderr = out, out
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("Failed to convert %s onto %s: %v", spec.URL, spec.Disk, err)
+		}
+	case staged:
+		if err := copyImage(target, spec.Disk); err != nil {
+			return fmt.Errorf("Failed to write %s to %s: %v", spec.URL, spec.Disk, err)
+		}
+	}
+	if spec.GrowPartition > 0 {
+		buf, err := exec.Command("growpart", spec.Disk, strconv.Itoa(spec.GrowPartition)).CombinedOutput()
+		out.Write(buf)
+		// growpart fails with NOCHANGE when the partition already fills the disk.
+		if err != nil && !strings.Contains(string(buf), "NOCHANGE") {
+			return fmt.Errorf("Failed to grow partition %d of %s: %v", spec.GrowPartition, spec.Disk, err)
+		}
+	}
+	return nil
+}
+
+// copyImage copies the raw image saved at src onto disk.
+func copyImage(src, disk string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	dest, err := os.OpenFile(disk, os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer dest.Close()
+	if _, err := io.Copy(dest, in); err != nil {
+		return err
+	}
+	if err := dest.Sync(); err != nil {
+		return err
+	}
+	return dest.Close()
+}
+
+// setParam sets a param on the Machine the TaskRunner is running on,
+// logging any failure to do so.
+func (r *TaskRunner) setParam(key string, val interface{}) {
+	if err := r.c.Req().Post(val).UrlFor("machines", r.m.Key(), "params", key).Do(nil); err != nil {
+		r.Log("Failed to set %s: %v", key, err)
+	}
+}
+
+// DeployImage writes the disk image described by the Content of the
+// action to disk.  Progress is written to the job log, and to the
+// image-deploy/status and image-deploy/progress params of the
+// Machine.
+func (r *TaskRunner) DeployImage(action *models.JobAction, taskDir string) error {
+	spec := &models.ImageDeploy{}
+	if err := json.Unmarshal([]byte(action.Content), spec); err != nil {
+		r.Log("Invalid image deploy %s: %v", action.Name, err)
+		return err
+	}
+	r.Log("Deploying %s image %s to %s", spec.Type, spec.URL, spec.Disk)
+	r.setParam("image-deploy/status", "deploying")
+	r.setParam("image-deploy/progress", 0)
+	err := deployImage(spec, taskDir, r.in, func(done, total int64) {
+		if total <= 0 {
+			r.Log("Fetched %d MiB of %s", done>>20, spec.URL)
+			return
+		}
+		pct := done * 100 / total
+		r.Log("Fetched %d%% (%d MiB) of %s", pct, done>>20, spec.URL)
+		r.setParam("image-deploy/progress", pct)
+	})
+	if err != nil {
+		r.Log("Image deploy failed: %v", err)
+		r.setParam("image-deploy/status", "failed")
+		return err
+	}
+	r.setParam("image-deploy/progress", 100)
+	r.setParam("image-deploy/status", "complete")
+	r.Log("Deployed %s to %s", spec.URL, spec.Disk)
+	return nil
+}
@@ -0,0 +1,83 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/digitalrebar/provision/models"
+)
+
+func TestDeployImage(t *testing.T) {
+	raw := bytes.Repeat([]byte("golden image "), 4096)
+	gz := &bytes.Buffer{}
+	zw := gzip.NewWriter(gz)
+	zw.Write(raw)
+	zw.Close()
+	sum := sha256.Sum256(gz.Bytes())
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/images/golden.img.gz" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(gz.Bytes())
+	}))
+	defer srv.Close()
+	dir, err := ioutil.TempDir("", "image-deploy-")
+	if err != nil {
+		t.Fatalf("Failed to make temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	disk := path.Join(dir, "disk")
+	spec := &models.ImageDeploy{
+		URL:    srv.URL + "/images/golden.img.gz",
+		Type:   models.ImageType("images/golden.img.gz"),
+		Sha256: hex.EncodeToString(sum[:]),
+		Disk:   disk,
+	}
+	if spec.Type != "raw.gz" {
+		t.Errorf("Expected type raw.gz, got %s", spec.Type)
+	}
+	var lastDone int64
+	out := &bytes.Buffer{}
+	if err := deployImage(spec, dir, out, func(done, total int64) { lastDone = done }); err != nil {
+		t.Fatalf("Failed to deploy image: %v", err)
+	}
+	if written, _ := ioutil.ReadFile(disk); !bytes.Equal(written, raw) {
+		t.Errorf("Disk does not have the image on it")
+	}
+	if lastDone == 0 {
+		t.Errorf("Expected progress to be reported")
+	}
+	// A bad checksum stops the deploy before the disk is written or
+	// the partition is grown.
+	ioutil.WriteFile(disk, []byte("old disk"), 0600)
+	spec.Sha256 = strings.Repeat("0", 64)
+	spec.GrowPartition = 1
+	if err := deployImage(spec, dir, out, func(int64, int64) {}); err == nil || !strings.Contains(err.Error(), "Checksum mismatch") {
+		t.Errorf("Expected a checksum mismatch, got %v", err)
+	}
+	if written, _ := ioutil.ReadFile(disk); string(written) != "old disk" {
+		t.Errorf("Expected a checksum mismatch to leave the disk alone")
+	}
+	if _, err := os.Stat(path.Join(dir, "image.raw")); !os.IsNotExist(err) {
+		t.Errorf("Expected the staged image to be removed, got %v", err)
+	}
+	spec.GrowPartition = 0
+	spec.URL = srv.URL + "/images/missing.img"
+	if err := deployImage(spec, dir, out, func(int64, int64) {}); err == nil {
+		t.Errorf("Expected a missing image to fail")
+	}
+	spec.Disk = "sda"
+	if err := deployImage(spec, dir, out, func(int64, int64) {}); err == nil {
+		t.Errorf("Expected a relative disk to be refused")
+	}
+}
@@ -126,9 +126,12 @@ func TestInfo(t *testing.T) {
 					"actions":      {},
 					"create":       {},
 					"delete":       {},
+					"forceUnlock":  {},
 					"get":          {},
 					"getSecure":    {},
+					"heartbeat":    {},
 					"list":         {},
+					"render":       {},
 					"update":       {},
 					"updateSecure": {},
 				},
@@ -161,6 +164,17 @@ func TestInfo(t *testing.T) {
 					"update":       {},
 					"updateSecure": {},
 				},
+				"pools": {
+					"action":   {},
+					"actions":  {},
+					"allocate": {},
+					"create":   {},
+					"delete":   {},
+					"get":      {},
+					"list":     {},
+					"release":  {},
+					"update":   {},
+				},
 				"preferences": {
 					"list": {},
 					"post": {},
@@ -194,6 +208,15 @@ func TestInfo(t *testing.T) {
 					"list":    {},
 					"update":  {},
 				},
+				"rollouts": {
+					"action":  {},
+					"actions": {},
+					"create":  {},
+					"delete":  {},
+					"get":     {},
+					"list":    {},
+					"update":  {},
+				},
 				"stages": {
 					"action":  {},
 					"actions": {},
@@ -212,6 +235,11 @@ func TestInfo(t *testing.T) {
 					"list":    {},
 					"update":  {},
 				},
+				"system": {
+					"actions": {},
+					"backup":  {},
+					"restore": {},
+				},
 				"tasks": {
 					"action":  {},
 					"actions": {},
@@ -26,6 +26,12 @@ func (c *Client) JobLog(j *models.Job, dst io.Writer) error {
 	return c.Req().UrlFor("jobs", j.Key(), "log").Do(dst)
 }
 
+// JobLogFollow streams the log for a specific Job to the passed
+// io.Writer as it is written, returning once the Job is done.
+func (c *Client) JobLogFollow(j *models.Job, dst io.Writer) error {
+	return c.Req().UrlFor("jobs", j.Key(), "log").Params("follow", "true").Do(dst)
+}
+
 // JobActions returns the expanded list of templates that should be
 // written or executed for a specific Job.
 func (c *Client) JobActions(j *models.Job, targetOS string) (models.JobActions, error) {
@@ -348,7 +354,9 @@ func (r *TaskRunner) Run() error {
 		r.reboot = false
 		r.stop = false
 		var err error
-		if action.Path != "" {
+		if action.Meta["ImageDeploy"] == "true" {
+			err = r.DeployImage(action, taskDir)
+		} else if action.Path != "" {
 			err = r.Expand(action, taskDir)
 		} else {
 			if !helperWritten {
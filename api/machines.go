@@ -0,0 +1,35 @@
+package api
+
+import "github.com/digitalrebar/provision/models"
+
+// MachineRender renders the templates of the passed BootEnv, Stage
+// and Task for a specific Machine without changing anything on the
+// server.  Empty bootEnv and stage default to the ones the Machine is
+// currently in, and an empty task renders no Task templates.
+func (c *Client) MachineRender(m *models.Machine, bootEnv, stage, task string) (*models.RenderPreview, error) {
+	res := &models.RenderPreview{}
+	req := c.Req().UrlFor("machines", m.Key(), "render")
+	for _, p := range [][]string{{"bootenv", bootEnv}, {"stage", stage}, {"task", task}} {
+		if p[1] != "" {
+			req.Params(p[0], p[1])
+		}
+	}
+	return res, req.Do(res)
+}
+
+// ExplainParam returns the aggregated value of the param key on the
+// Machine m along with every place it was defined, in order of
+// precedence.  If decode is true, secure values are decrypted.
+func (c *Client) ExplainParam(m *models.Machine, key string, decode bool) (*models.ParamExplanation, error) {
+	res := &models.ParamExplanation{}
+	req := c.Req().UrlFor("machines", m.Key(), "params", key).Params("explain", "true")
+	if decode {
+		req.Params("decode", "true")
+	}
+	return res, req.Do(res)
+}
+
+// MachineHeartbeat tells dr-provision that the Machine m is alive.
+func (c *Client) MachineHeartbeat(m *models.Machine) error {
+	return c.Req().Post(nil).UrlFor("machines", m.Key(), "heartbeat").Do(nil)
+}
@@ -0,0 +1,22 @@
+package api
+
+import "github.com/digitalrebar/provision/models"
+
+// PoolAllocate asks the Pool named pool for Machines.  Either all of
+// the Machines asked for are allocated and returned, or none are.
+func (c *Client) PoolAllocate(pool string, req *models.PoolAllocateRequest) ([]*models.Machine, error) {
+	res := []*models.Machine{}
+	return res, c.Req().Post(req).UrlFor("pools", pool, "allocate").Do(&res)
+}
+
+// PoolRelease gives the Machines in req back to the Pool named pool.
+// If force is true, Machines allocated to someone else are released
+// as well.
+func (c *Client) PoolRelease(pool string, req *models.PoolReleaseRequest, force bool) ([]*models.Machine, error) {
+	res := []*models.Machine{}
+	r := c.Req().Post(req).UrlFor("pools", pool, "release")
+	if force {
+		r.Params("force", "true")
+	}
+	return res, r.Do(&res)
+}
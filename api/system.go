@@ -0,0 +1,21 @@
+package api
+
+import (
+	"io"
+
+	"github.com/digitalrebar/provision/models"
+)
+
+// Backup fetches a consistent backup of the whole endpoint from the
+// server and writes it to dst as a gzipped tar archive.
+func (c *Client) Backup(dst io.Writer) error {
+	return c.Req().UrlFor("system", "backup").Do(dst)
+}
+
+// Restore uploads a backup created by Backup to the server, which
+// replaces all of its data with the contents of the backup.  You are
+// responsible for closing the passed io.Reader.
+func (c *Client) Restore(src io.Reader) (*models.BackupManifest, error) {
+	res := &models.BackupManifest{}
+	return res, c.Req().Post(src).UrlFor("system", "restore").FailFast().Do(res)
+}
@@ -0,0 +1,661 @@
+package backend
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/digitalrebar/provision/models"
+	"github.com/digitalrebar/store"
+)
+
+// storeDump is the form a store takes inside a backup archive.
+type storeDump struct {
+	Meta  map[string]string          `json:",omitempty"`
+	Items map[string]json.RawMessage `json:",omitempty"`
+	Subs  map[string]*storeDump      `json:",omitempty"`
+}
+
+func dumpStore(src store.Store) (*storeDump, error) {
+	res := &storeDump{
+		Items: map[string]json.RawMessage{},
+		Subs:  map[string]*storeDump{},
+	}
+	if smeta, ok := src.(store.MetaSaver); ok {
+		res.Meta = smeta.MetaData()
+	}
+	keys, err := src.Keys()
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		var val json.RawMessage
+		if err := src.Load(key, &val); err != nil {
+			return nil, fmt.Errorf("Failed to load %s: %v", key, err)
+		}
+		res.Items[key] = val
+	}
+	for name, sub := range src.Subs() {
+		subDump, err := dumpStore(sub)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		res.Subs[name] = subDump
+	}
+	return res, nil
+}
+
+func (s *storeDump) load(dst store.Store) error {
+	if s.Meta != nil {
+		if dmeta, ok := dst.(store.MetaSaver); ok {
+			if err := dmeta.SetMetaData(s.Meta); err != nil {
+				return err
+			}
+		}
+	}
+	for key, val := range s.Items {
+		if err := dst.Save(key, val); err != nil {
+			return fmt.Errorf("Failed to save %s: %v", key, err)
+		}
+	}
+	for name, sub := range s.Subs {
+		dSub, err := dst.MakeSub(name)
+		if err != nil {
+			return err
+		}
+		if err := sub.load(dSub); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// clearStore removes every key from st and all of its sub stores.
+func clearStore(st store.Store) error {
+	keys, err := st.Keys()
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := st.Remove(key); err != nil {
+			return fmt.Errorf("Failed to remove %s: %v", key, err)
+		}
+	}
+	for name, sub := range st.Subs() {
+		if err := clearStore(sub); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+type backupWriter struct {
+	tw       *tar.Writer
+	manifest *models.BackupManifest
+}
+
+func (b *backupWriter) write(name string, mode int64, mtime time.Time, size int64, src io.Reader) (string, error) {
+	hdr := &tar.Header{
+		Name:     name,
+		Mode:     mode,
+		Size:     size,
+		ModTime:  mtime,
+		Typeflag: tar.TypeReg,
+	}
+	if err := b.tw.WriteHeader(hdr); err != nil {
+		return "", err
+	}
+	sum := sha256.New()
+	n, err := io.Copy(b.tw, io.TeeReader(src, sum))
+	if err != nil {
+		return "", err
+	}
+	if n != size {
+		return "", fmt.Errorf("%s changed size while being backed up", name)
+	}
+	return hex.EncodeToString(sum.Sum(nil)), nil
+}
+
+func (b *backupWriter) add(name string, mode int64, mtime time.Time, size int64, src io.Reader) error {
+	sum, err := b.write(name, mode, mtime, size, src)
+	if err != nil {
+		return err
+	}
+	b.manifest.Entries = append(b.manifest.Entries, models.BackupEntry{Path: name, Size: size, Sha256: sum})
+	return nil
+}
+
+func (b *backupWriter) addJSON(name string, val interface{}) error {
+	buf, err := json.MarshalIndent(val, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Failed to marshal %s: %v", name, err)
+	}
+	return b.add(name, 0644, b.manifest.Created, int64(len(buf)), bytes.NewReader(buf))
+}
+
+func (b *backupWriter) addStore(name string, st store.Store) error {
+	dump, err := dumpStore(st)
+	if err != nil {
+		return fmt.Errorf("Failed to dump %s: %v", name, err)
+	}
+	return b.addJSON(name, dump)
+}
+
+func (b *backupWriter) addTree(name, root string) error {
+	return filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if p == root && os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		// Directories are recreated from file paths, and
+		// sockets, pipes and symlinks are not backed up.
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return b.add(path.Join("trees", name, filepath.ToSlash(rel)),
+			int64(info.Mode().Perm()), info.ModTime(), info.Size(), f)
+	})
+}
+
+// snapshot writes all the parts of the backup except the manifest.
+// It must be called with all locks held.
+func (p *DataTracker) snapshot(b *backupWriter, trees map[string]string) error {
+	if err := b.addStore("stores/writable.json", p.Backend.writeContent); err != nil {
+		return err
+	}
+	if p.Backend.localContent != nil {
+		if err := b.addStore("stores/local.json", p.Backend.localContent); err != nil {
+			return err
+		}
+	}
+	p.secretsMux.Lock()
+	err := b.addStore("stores/secrets.json", p.Secrets)
+	p.secretsMux.Unlock()
+	if err != nil {
+		return err
+	}
+	names := []string{}
+	for name := range p.Backend.saasContents {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		content := &models.Content{}
+		if err := content.FromStore(p.Backend.saasContents[name]); err != nil {
+			return fmt.Errorf("Failed to load content %s: %v", name, err)
+		}
+		if err := b.addJSON(path.Join("contents", name+".json"), content); err != nil {
+			return err
+		}
+		b.manifest.Contents = append(b.manifest.Contents, name)
+	}
+	names = []string{}
+	for name := range trees {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := b.addTree(name, trees[name]); err != nil {
+			return fmt.Errorf("Failed to back up %s: %v", name, err)
+		}
+		b.manifest.Trees = append(b.manifest.Trees, name)
+	}
+	return nil
+}
+
+// Snapshot writes a gzipped tar archive of the writable store, the
+// local content store, the secrets store, every content layer and
+// the passed file trees to w.  trees maps the name a tree will have
+// in the archive to the directory it is read from.  The snapshot is
+// taken with all locks held, so it is consistent with respect to
+// everything that goes through the API.  The archive ends with a
+// manifest.json containing checksums of everything else in it.
+//
+// Since all locks are held until the archive is written, w should be
+// something fast like a local file rather than a network connection.
+func (p *DataTracker) Snapshot(rt *RequestTracker, version string, trees map[string]string, w io.Writer) (*models.BackupManifest, error) {
+	manifest := &models.BackupManifest{
+		Version:  version,
+		Created:  time.Now(),
+		Contents: []string{},
+		Trees:    []string{},
+		Entries:  []models.BackupEntry{},
+	}
+	gz := gzip.NewWriter(w)
+	b := &backupWriter{tw: tar.NewWriter(gz), manifest: manifest}
+	var err error
+	rt.AllLocked(func(d Stores) {
+		err = p.snapshot(b, trees)
+	})
+	if err != nil {
+		return nil, err
+	}
+	buf, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := b.write("manifest.json", 0644, manifest.Created, int64(len(buf)), bytes.NewReader(buf)); err != nil {
+		return nil, err
+	}
+	if err := b.tw.Close(); err != nil {
+		return nil, err
+	}
+	return manifest, gz.Close()
+}
+
+// ReadBackup extracts a backup archive created by Snapshot into dir
+// and verifies that every file in it is listed in its manifest with
+// a matching size and checksum.  dir should be empty.
+func ReadBackup(r io.Reader, dir string) (*models.BackupManifest, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("Backup is not gzip compressed: %v", err)
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	found := map[string]models.BackupEntry{}
+	var manifest *models.BackupManifest
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read backup: %v", err)
+		}
+		name := path.Clean(hdr.Name)
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			return nil, fmt.Errorf("Backup entry %s is not a regular file", name)
+		}
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return nil, fmt.Errorf("Backup entry %s is outside the backup", name)
+		}
+		if name == "manifest.json" {
+			manifest = &models.BackupManifest{}
+			if err := json.NewDecoder(tr).Decode(manifest); err != nil {
+				return nil, fmt.Errorf("Failed to decode backup manifest: %v", err)
+			}
+			continue
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return nil, err
+		}
+		f, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, os.FileMode(hdr.Mode).Perm())
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.New()
+		n, err := io.Copy(io.MultiWriter(f, sum), tr)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to extract %s: %v", name, err)
+		}
+		found[name] = models.BackupEntry{Path: name, Size: n, Sha256: hex.EncodeToString(sum.Sum(nil))}
+	}
+	if manifest == nil {
+		return nil, fmt.Errorf("Backup has no manifest.json")
+	}
+	for _, want := range manifest.Entries {
+		got, ok := found[want.Path]
+		if !ok {
+			return nil, fmt.Errorf("Backup is missing %s", want.Path)
+		}
+		if got.Size != want.Size || got.Sha256 != want.Sha256 {
+			return nil, fmt.Errorf("Checksum mismatch for %s", want.Path)
+		}
+		delete(found, want.Path)
+	}
+	for name := range found {
+		return nil, fmt.Errorf("Backup contains %s, which is not in the manifest", name)
+	}
+	return manifest, nil
+}
+
+func readStoreDump(dir, name string) (*storeDump, error) {
+	buf, err := ioutil.ReadFile(filepath.Join(dir, "stores", name+".json"))
+	if err != nil {
+		return nil, err
+	}
+	res := &storeDump{}
+	if err := json.Unmarshal(buf, res); err != nil {
+		return nil, fmt.Errorf("Failed to decode %s store: %v", name, err)
+	}
+	return res, nil
+}
+
+func memStore(dump *storeDump) (store.Store, error) {
+	res, err := store.Open("memory:///")
+	if err != nil {
+		return nil, err
+	}
+	return res, dump.load(res)
+}
+
+func copyTree(src, dst string) error {
+	return filepath.Walk(src, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		in, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		out, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode().Perm())
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, in); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	})
+}
+
+// moveEntries moves everything in src into dst.
+func moveEntries(src, dst string) error {
+	ents, err := ioutil.ReadDir(src)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, fi := range ents {
+		s, d := filepath.Join(src, fi.Name()), filepath.Join(dst, fi.Name())
+		if os.Rename(s, d) == nil {
+			continue
+		}
+		// Probably a different filesystem
+		if err := copyTree(s, d); err != nil {
+			return err
+		}
+		if err := os.RemoveAll(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replaceTree replaces everything in dst with the contents of src.
+// The contents of dst are replaced rather than dst itself, as dst may
+// be a mount point.  What dst held is moved into a new directory next
+// to it, which is returned even on failure so that unreplaceTree can
+// put it back.
+func replaceTree(src, dst string) (string, error) {
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return "", err
+	}
+	stash, err := ioutil.TempDir(filepath.Dir(dst), "."+filepath.Base(dst)+"-pre-restore-")
+	if err != nil {
+		return "", err
+	}
+	if err := moveEntries(dst, stash); err != nil {
+		return stash, err
+	}
+	return stash, moveEntries(src, dst)
+}
+
+// unreplaceTree puts what replaceTree moved out of dst into stash
+// back.
+func unreplaceTree(stash, dst string) error {
+	ents, err := ioutil.ReadDir(dst)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, fi := range ents {
+		if err := os.RemoveAll(filepath.Join(dst, fi.Name())); err != nil {
+			return err
+		}
+	}
+	if err := moveEntries(stash, dst); err != nil {
+		return err
+	}
+	return os.RemoveAll(stash)
+}
+
+// restoreTarget is a live store that Restore replaces the contents
+// of, along with a copy of what it held before so that a failed
+// restore can be undone.
+type restoreTarget struct {
+	name  string
+	live  store.Store
+	with  store.Store
+	saved store.Store
+	mux   sync.Locker
+}
+
+func (r *restoreTarget) save() error {
+	if r.mux != nil {
+		r.mux.Lock()
+		defer r.mux.Unlock()
+	}
+	dump, err := dumpStore(r.live)
+	if err != nil {
+		return fmt.Errorf("Failed to save current %s store: %v", r.name, err)
+	}
+	r.saved, err = memStore(dump)
+	return err
+}
+
+func (r *restoreTarget) replace(from store.Store) error {
+	if r.mux != nil {
+		r.mux.Lock()
+		defer r.mux.Unlock()
+	}
+	if err := clearStore(r.live); err != nil {
+		return fmt.Errorf("Failed to clear %s store: %v", r.name, err)
+	}
+	if _, err := copyStore(r.live, from); err != nil {
+		return fmt.Errorf("Failed to fill %s store: %v", r.name, err)
+	}
+	return nil
+}
+
+// Restore replaces the writable store, the local content store, the
+// secrets store, the content layers and the file trees of p with the
+// ones in a backup that ReadBackup has extracted into dir.  trees
+// maps the names of file trees in the backup to the directories they
+// should be restored to; trees in the backup that are not in trees
+// are ignored.  newContent is used to create the persistent store for
+// each restored content layer.
+//
+// The restored data is loaded into a candidate DataStack and
+// validated before anything is changed, so a backup that would not
+// load leaves the current data untouched.  So are the signatures the
+// backup recorded for its content layers and plugin providers, which
+// are held to the contentSigning preference just like uploads are.
+// What the stores and trees held before is kept until the new
+// DataStack is in place, and put back if any step of the restore
+// fails.
+func (p *DataTracker) Restore(rt *RequestTracker,
+	dir string,
+	manifest *models.BackupManifest,
+	trees map[string]string,
+	newContent func(*models.Content) (store.Store, error)) (hard, soft error) {
+	writeDump, err := readStoreDump(dir, "writable")
+	if err != nil {
+		return err, nil
+	}
+	secretsDump, err := readStoreDump(dir, "secrets")
+	if err != nil {
+		return err, nil
+	}
+	localDump, err := readStoreDump(dir, "local")
+	if err != nil && !os.IsNotExist(err) {
+		return err, nil
+	}
+	contents := map[string]*models.Content{}
+	for _, name := range manifest.Contents {
+		buf, err := ioutil.ReadFile(filepath.Join(dir, "contents", name+".json"))
+		if err != nil {
+			return err, nil
+		}
+		content := &models.Content{}
+		if err := json.Unmarshal(buf, content); err != nil {
+			return fmt.Errorf("Failed to decode content %s: %v", name, err), nil
+		}
+		contents[name] = content
+	}
+
+	memWrite, err := memStore(writeDump)
+	if err != nil {
+		return err, nil
+	}
+	memSecrets, err := memStore(secretsDump)
+	if err != nil {
+		return err, nil
+	}
+	var memLocal store.Store
+	if localDump != nil {
+		if memLocal, err = memStore(localDump); err != nil {
+			return err, nil
+		}
+	}
+	memContents := map[string]store.Store{}
+	for name, content := range contents {
+		st, err := store.Open("memory:///")
+		if err != nil {
+			return err, nil
+		}
+		content.Meta.Type = "dynamic"
+		if err := content.ToStore(st); err != nil {
+			return fmt.Errorf("Failed to load content %s: %v", name, err), nil
+		}
+		memContents[name] = st
+	}
+	pluginDir := ""
+	if _, ok := trees["plugins"]; ok {
+		for _, name := range manifest.Trees {
+			if name == "plugins" {
+				pluginDir = filepath.Join(dir, "trees", name)
+			}
+		}
+	}
+	if err := p.checkRestoreSignatures(memSecrets, contents, pluginDir); err != nil {
+		return err, nil
+	}
+
+	rt.AllLocked(func(d Stores) {
+		local := p.Backend.localContent
+		if local == nil {
+			memLocal = nil
+		}
+		if _, hard, _ = p.Backend.replaceLayers(memWrite, memLocal, memContents, memSecrets, p.Logger); hard != nil {
+			return
+		}
+		// The backup is valid.  Save what is there now so that it can
+		// be put back if anything goes wrong from here on.
+		targets := []*restoreTarget{{name: "writable", live: p.Backend.writeContent, with: memWrite}}
+		if memLocal != nil {
+			targets = append(targets, &restoreTarget{name: "local", live: local, with: memLocal})
+		}
+		targets = append(targets, &restoreTarget{name: "secrets", live: p.Secrets, with: memSecrets, mux: p.secretsMux})
+		for _, tgt := range targets {
+			if hard = tgt.save(); hard != nil {
+				return
+			}
+		}
+		oldBackend := p.Backend
+		newContents := map[string]store.Store{}
+		stashes := map[string]string{}
+		defer func() {
+			if hard == nil {
+				for _, stash := range stashes {
+					os.RemoveAll(stash)
+				}
+				for _, st := range oldBackend.saasContents {
+					CleanUpStore(st)
+				}
+				return
+			}
+			// Put everything back the way it was.
+			for target, stash := range stashes {
+				if err := unreplaceTree(stash, target); err != nil {
+					p.Errorf("Restore: failed to put back %s, its old contents are in %s: %v", target, stash, err)
+				}
+			}
+			for _, tgt := range targets {
+				if err := tgt.replace(tgt.saved); err != nil {
+					p.Errorf("Restore: failed to roll back: %v", err)
+				}
+			}
+			for _, st := range newContents {
+				CleanUpStore(st)
+			}
+			if p.Backend != oldBackend {
+				p.Backend = oldBackend
+				if err, _ := p.rebuildCache(rt); err.ContainsError() {
+					p.Errorf("Restore: failed to reload the old data: %v", err)
+				}
+			}
+		}()
+		for _, tgt := range targets {
+			if hard = tgt.replace(tgt.with); hard != nil {
+				return
+			}
+		}
+		for name, content := range contents {
+			st, err := newContent(content)
+			if err != nil {
+				hard = fmt.Errorf("Failed to save content %s: %v", name, err)
+				return
+			}
+			newContents[name] = st
+		}
+		var ds *DataStack
+		if ds, hard, soft = p.Backend.replaceLayers(p.Backend.writeContent, local, newContents, p.Secrets, p.Logger); hard != nil {
+			return
+		}
+		for _, name := range manifest.Trees {
+			target, ok := trees[name]
+			if !ok {
+				continue
+			}
+			stash, err := replaceTree(filepath.Join(dir, "trees", name), target)
+			if stash != "" {
+				stashes[target] = stash
+			}
+			if err != nil {
+				hard = fmt.Errorf("Failed to restore %s: %v", name, err)
+				return
+			}
+		}
+		hard, soft = p.ReplaceBackend(rt, ds)
+	})
+	return
+}
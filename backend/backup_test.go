@@ -0,0 +1,181 @@
+package backend
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/digitalrebar/provision/models"
+	"github.com/digitalrebar/store"
+)
+
+func TestBackupRestore(t *testing.T) {
+	dt := mkDT()
+	rt := dt.Request(dt.Logger, "profiles", "params", "machines", "stages", "workflows", "bootenvs", "tasks", "templates")
+	treeDir, err := ioutil.TempDir(tmpDir, "tree-")
+	if err != nil {
+		t.Fatalf("Failed to create tree dir: %v", err)
+	}
+	ioutil.WriteFile(path.Join(treeDir, "hello"), []byte("world"), 0644)
+	trees := map[string]string{"files": treeDir}
+	rt.Do(func(d Stores) {
+		if ok, err := rt.Create(&models.Profile{Name: "backed-up"}); !ok {
+			t.Fatalf("Failed to create profile: %v", err)
+		}
+	})
+	buf := &bytes.Buffer{}
+	manifest, err := dt.Snapshot(rt, "test", trees, buf)
+	if err != nil {
+		t.Fatalf("Failed to snapshot: %v", err)
+	}
+	if len(manifest.Trees) != 1 || len(manifest.Entries) == 0 {
+		t.Errorf("Unexpected manifest: %#v", manifest)
+	}
+
+	// Change things after the backup.
+	rt.Do(func(d Stores) {
+		rt.Remove(&models.Profile{Name: "backed-up"})
+		rt.Create(&models.Profile{Name: "not-backed-up"})
+	})
+	ioutil.WriteFile(path.Join(treeDir, "hello"), []byte("changed"), 0644)
+	ioutil.WriteFile(path.Join(treeDir, "extra"), []byte("extra"), 0644)
+
+	dir, err := ioutil.TempDir(tmpDir, "restore-")
+	if err != nil {
+		t.Fatalf("Failed to create restore dir: %v", err)
+	}
+	restored, err := ReadBackup(bytes.NewReader(buf.Bytes()), dir)
+	if err != nil {
+		t.Fatalf("Failed to read backup: %v", err)
+	}
+	newContent := func(c *models.Content) (store.Store, error) {
+		st, _ := store.Open("memory:///")
+		return st, c.ToStore(st)
+	}
+	if hard, _ := dt.Restore(rt, dir, restored, trees, newContent); hard != nil {
+		t.Fatalf("Failed to restore: %v", hard)
+	}
+	rt.Do(func(d Stores) {
+		if rt.Find("profiles", "backed-up") == nil {
+			t.Errorf("Profile backed-up was not restored")
+		}
+		if rt.Find("profiles", "not-backed-up") != nil {
+			t.Errorf("Profile not-backed-up survived the restore")
+		}
+	})
+	if data, err := ioutil.ReadFile(path.Join(treeDir, "hello")); err != nil || string(data) != "world" {
+		t.Errorf("File hello was not restored: %v: %s", err, string(data))
+	}
+	if _, err := os.Stat(path.Join(treeDir, "extra")); !os.IsNotExist(err) {
+		t.Errorf("File extra survived the restore")
+	}
+}
+
+func TestReadBackupChecksums(t *testing.T) {
+	dt := mkDT()
+	rt := dt.Request(dt.Logger)
+	buf := &bytes.Buffer{}
+	if _, err := dt.Snapshot(rt, "test", nil, buf); err != nil {
+		t.Fatalf("Failed to snapshot: %v", err)
+	}
+	// Rewrite the archive with the writable store tampered with.
+	gz, _ := gzip.NewReader(buf)
+	tr := tar.NewReader(gz)
+	tampered := &bytes.Buffer{}
+	gw := gzip.NewWriter(tampered)
+	tw := tar.NewWriter(gw)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed to read snapshot: %v", err)
+		}
+		data, _ := ioutil.ReadAll(tr)
+		if hdr.Name == "stores/writable.json" {
+			data = append(data, ' ')
+			hdr.Size = int64(len(data))
+		}
+		tw.WriteHeader(hdr)
+		tw.Write(data)
+	}
+	tw.Close()
+	gw.Close()
+	dir, err := ioutil.TempDir(tmpDir, "restore-")
+	if err != nil {
+		t.Fatalf("Failed to create restore dir: %v", err)
+	}
+	if _, err := ReadBackup(tampered, dir); err == nil {
+		t.Errorf("Reading a tampered backup should have failed")
+	}
+}
+
+func TestRestoreRollsBack(t *testing.T) {
+	dt := mkDT()
+	rt := dt.Request(dt.Logger, "profiles", "params", "machines", "stages", "workflows", "bootenvs", "tasks", "templates")
+	treeDir, err := ioutil.TempDir(tmpDir, "tree-")
+	if err != nil {
+		t.Fatalf("Failed to create tree dir: %v", err)
+	}
+	ioutil.WriteFile(path.Join(treeDir, "hello"), []byte("world"), 0644)
+	rt.Do(func(d Stores) {
+		rt.Create(&models.Profile{Name: "backed-up"})
+	})
+	buf := &bytes.Buffer{}
+	if _, err := dt.Snapshot(rt, "test", map[string]string{"files": treeDir, "isos": treeDir}, buf); err != nil {
+		t.Fatalf("Failed to snapshot: %v", err)
+	}
+	rt.Do(func(d Stores) {
+		rt.Remove(&models.Profile{Name: "backed-up"})
+		rt.Create(&models.Profile{Name: "not-backed-up"})
+	})
+	ioutil.WriteFile(path.Join(treeDir, "hello"), []byte("changed"), 0644)
+
+	dir, err := ioutil.TempDir(tmpDir, "restore-")
+	if err != nil {
+		t.Fatalf("Failed to create restore dir: %v", err)
+	}
+	restored, err := ReadBackup(bytes.NewReader(buf.Bytes()), dir)
+	if err != nil {
+		t.Fatalf("Failed to read backup: %v", err)
+	}
+	newContent := func(c *models.Content) (store.Store, error) {
+		st, _ := store.Open("memory:///")
+		return st, c.ToStore(st)
+	}
+	// The isos tree cannot be restored under a regular file, so the
+	// restore fails after the stores and the files tree were replaced.
+	trees := map[string]string{
+		"files": treeDir,
+		"isos":  path.Join(treeDir, "hello", "isos"),
+	}
+	if hard, _ := dt.Restore(rt, dir, restored, trees, newContent); hard == nil {
+		t.Fatalf("Expected restoring into a bad tree to fail")
+	}
+	var p models.Profile
+	profiles := dt.Backend.writeContent.GetSub("profiles")
+	if profiles.Load("not-backed-up", &p) != nil || profiles.Load("backed-up", &p) == nil {
+		t.Errorf("Expected the writable store to be rolled back")
+	}
+	rt.Do(func(d Stores) {
+		if rt.Find("profiles", "not-backed-up") == nil || rt.Find("profiles", "backed-up") != nil {
+			t.Errorf("Expected the failed restore to leave profiles alone")
+		}
+	})
+	if data, err := ioutil.ReadFile(path.Join(treeDir, "hello")); err != nil || string(data) != "changed" {
+		t.Errorf("Expected the files tree to be rolled back: %v: %s", err, string(data))
+	}
+	ents, _ := ioutil.ReadDir(tmpDir)
+	for _, fi := range ents {
+		if strings.Contains(fi.Name(), "pre-restore") {
+			t.Errorf("Expected %s to be cleaned up", fi.Name())
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package backend
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// bootAttemptWindow is how long after a Machine starts booting that
+// further DHCP requests and bootloader fetches from it are counted
+// as part of the same boot attempt.
+const bootAttemptWindow = 2 * time.Minute
+
+// bootRecord tracks the boot attempts of one Machine into the
+// BootEnv it is in.
+type bootRecord struct {
+	bootEnv  string
+	attempts int
+	// start is when the current boot attempt started.
+	start time.Time
+	// progress is when the Machine first booted into bootEnv, or last
+	// finished a job since then.
+	progress time.Time
+	marked   bool
+}
+
+// bootWatch tracks Machine boot attempts and job progress so that
+// Machines stuck in a boot loop or in a stalled install can be
+// marked not Runnable.  It only lives in memory.
+type bootWatch struct {
+	sync.Mutex
+	machines map[string]*bootRecord
+	kernels  map[string]struct{}
+}
+
+func newBootWatch() *bootWatch {
+	return &bootWatch{
+		machines: map[string]*bootRecord{},
+		kernels:  map[string]struct{}{},
+	}
+}
+
+func (w *bootWatch) addKernel(p string) {
+	w.Lock()
+	w.kernels[p] = struct{}{}
+	w.Unlock()
+}
+
+func (w *bootWatch) isKernel(p string) bool {
+	w.Lock()
+	defer w.Unlock()
+	_, ok := w.kernels[p]
+	return ok
+}
+
+func (w *bootWatch) forget(uuid string) {
+	w.Lock()
+	delete(w.machines, uuid)
+	w.Unlock()
+}
+
+// isBootLoaderTemplate returns whether the TemplateInfo named name
+// is a bootloader config, which a Machine fetches once per boot.
+func isBootLoaderTemplate(name string) bool {
+	for _, prefix := range []string{"pxelinux", "ipxe", "elilo", "grub"} {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *DataTracker) prefInt(name string) int {
+	v, err := strconv.Atoi(p.pref(name))
+	if err != nil || v < 0 {
+		return 0
+	}
+	return v
+}
+
+// bootAttempt records that the Machine uuid is booting bootEnv.  If
+// that takes it past the bootLoopThreshold preference without a job
+// finishing, the Machine is marked not Runnable.
+func (p *DataTracker) bootAttempt(uuid, bootEnv string, now time.Time) {
+	threshold := p.prefInt("bootLoopThreshold")
+	w := p.bootWatch
+	w.Lock()
+	rec := w.machines[uuid]
+	if rec == nil || rec.bootEnv != bootEnv {
+		rec = &bootRecord{bootEnv: bootEnv, progress: now}
+		w.machines[uuid] = rec
+	}
+	if rec.attempts > 0 && now.Sub(rec.start) < bootAttemptWindow {
+		w.Unlock()
+		return
+	}
+	rec.attempts++
+	rec.start = now
+	attempts := rec.attempts
+	loop := threshold > 0 && attempts > threshold && !rec.marked
+	if loop {
+		rec.marked = true
+	}
+	w.Unlock()
+	if loop {
+		go p.markStuck(uuid, bootEnv, "bootloop",
+			fmt.Sprintf("Booted into %s %d times without finishing a job", bootEnv, attempts))
+	}
+}
+
+// bootProgress records that the Machine uuid has finished a job.
+func (p *DataTracker) bootProgress(uuid string, now time.Time) {
+	w := p.bootWatch
+	w.Lock()
+	if rec := w.machines[uuid]; rec != nil {
+		rec.attempts = 0
+		rec.progress = now
+		rec.marked = false
+	}
+	w.Unlock()
+}
+
+// BootAttempt records that m is booting into its BootEnv.
+func (rt *RequestTracker) BootAttempt(m *Machine) {
+	rt.dt.bootAttempt(m.UUID(), m.BootEnv, time.Now())
+}
+
+// staticFetch counts fetches of BootEnv kernels from the static file
+// servers as boot attempts of the Machine with the address they come
+// from.
+func (p *DataTracker) staticFetch(fsPath string, remoteIP net.IP) {
+	if remoteIP == nil || !p.bootWatch.isKernel(fsPath) {
+		return
+	}
+	ref := &Machine{}
+	rt := p.Request(p.Logger, "machines")
+	rt.Do(func(d Stores) {
+		if obj := rt.FindByIndex("machines", ref.Indexes()["Address"], remoteIP.String()); obj != nil {
+			m := AsMachine(obj)
+			p.bootAttempt(m.UUID(), m.BootEnv, time.Now())
+		}
+	})
+}
+
+// markStuck marks the Machine uuid not Runnable with msg as its
+// ProvisioningError, and sends a machines event with action, as long
+// as it is still in bootEnv.
+func (p *DataTracker) markStuck(uuid, bootEnv, action, msg string) bool {
+	ref := &Machine{}
+	rt := p.Request(p.Logger, ref.Locks("update")...)
+	marked := false
+	rt.Do(func(d Stores) {
+		obj := rt.Find("machines", uuid)
+		if obj == nil {
+			return
+		}
+		m := AsMachine(obj)
+		if m.BootEnv != bootEnv {
+			return
+		}
+		rt.Warnf("Machine %s: %s, marking it not Runnable", uuid, msg)
+		m.Runnable = false
+		m.ProvisioningError = msg
+		if saved, err := rt.Save(m); !saved {
+			rt.Errorf("Machine %s: failed to mark it not Runnable: %v", uuid, err)
+			return
+		}
+		rt.Publish("machines", action, uuid, m)
+		marked = true
+	})
+	return marked
+}
+
+// CheckStalled marks Machines that have not finished a job within the
+// provisioningStallTimeout preference of booting into a BootEnv that
+// should be making progress.  Machines in install BootEnvs and
+// Machines with Tasks left to run should be.  It returns the UUIDs of
+// the Machines it marked.
+func (p *DataTracker) CheckStalled(now time.Time) []string {
+	timeout := time.Duration(p.prefInt("provisioningStallTimeout")) * time.Second
+	if timeout == 0 {
+		return nil
+	}
+	w := p.bootWatch
+	candidates := map[string]string{}
+	w.Lock()
+	for uuid, rec := range w.machines {
+		if !rec.marked && now.Sub(rec.progress) > timeout {
+			candidates[uuid] = rec.bootEnv
+		}
+	}
+	w.Unlock()
+	res := []string{}
+	for uuid, bootEnv := range candidates {
+		stalled := false
+		rt := p.Request(p.Logger, "machines")
+		rt.Do(func(d Stores) {
+			if obj := rt.find("machines", uuid); obj != nil {
+				m := AsMachine(obj)
+				stalled = m.BootEnv == bootEnv &&
+					(strings.HasSuffix(bootEnv, "-install") || m.CurrentTask < len(m.Tasks))
+			}
+		})
+		if !stalled {
+			continue
+		}
+		w.Lock()
+		rec := w.machines[uuid]
+		if rec == nil || rec.marked || rec.bootEnv != bootEnv {
+			w.Unlock()
+			continue
+		}
+		rec.marked = true
+		since := rec.progress
+		w.Unlock()
+		msg := fmt.Sprintf("No job has finished in %s since %s", bootEnv, since.Format(time.RFC3339))
+		if p.markStuck(uuid, bootEnv, "stalled", msg) {
+			res = append(res, uuid)
+		}
+	}
+	return res
+}
@@ -0,0 +1,132 @@
+package backend
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/digitalrebar/provision/models"
+	"github.com/pborman/uuid"
+)
+
+type eventCatcher struct {
+	sync.Mutex
+	events []*models.Event
+}
+
+func (e *eventCatcher) Publish(ev *models.Event) error {
+	e.Lock()
+	e.events = append(e.events, ev)
+	e.Unlock()
+	return nil
+}
+
+func (e *eventCatcher) Reserve() error { return nil }
+func (e *eventCatcher) Release()       {}
+func (e *eventCatcher) Unload()        {}
+
+func (e *eventCatcher) saw(action, key string) bool {
+	e.Lock()
+	defer e.Unlock()
+	for _, ev := range e.events {
+		if ev.Type == "machines" && ev.Action == action && ev.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+func TestBootWatch(t *testing.T) {
+	dt := mkDT()
+	rt := dt.Request(dt.Logger)
+	events := &eventCatcher{}
+	dt.publishers.Add(events)
+	loop, stall := &Machine{}, &Machine{}
+	rt.AllLocked(func(d Stores) {
+		if err := dt.SetPrefs(rt, map[string]string{"bootLoopThreshold": "2", "provisioningStallTimeout": "600"}); err != nil {
+			t.Fatalf("Failed to set prefs: %v", err)
+		}
+		for _, obj := range []models.Model{
+			&models.Template{ID: "loop-ipxe", Contents: "#!ipxe\n"},
+			&models.BootEnv{
+				Name:      "loop-install",
+				Templates: []models.TemplateInfo{{Name: "ipxe", Path: "machines/{{.Machine.UUID}}/ipxe", ID: "loop-ipxe"}},
+			},
+		} {
+			if created, err := rt.Create(obj); !created {
+				t.Fatalf("Failed to create %s:%s: %v", obj.Prefix(), obj.Key(), err)
+			}
+		}
+		for name, m := range map[string]*Machine{"loop": loop, "stall": stall} {
+			Fill(m)
+			m.Uuid = uuid.NewRandom()
+			m.Name = name + ".example.com"
+			m.BootEnv = "loop-install"
+			m.Runnable = true
+			if created, err := rt.Create(m); !created {
+				t.Fatalf("Failed to create machine %s: %v", name, err)
+			}
+		}
+	})
+	machine := func(m *Machine) *Machine {
+		var res *Machine
+		rt.AllLocked(func(d Stores) {
+			res = AsMachine(rt.Find("machines", m.UUID()))
+		})
+		return res
+	}
+	// Fetching the bootloader config is the first boot attempt.
+	if out, err := dt.FS.Open("/machines/"+loop.UUID()+"/ipxe", nil); err != nil || out == nil {
+		t.Fatalf("Failed to fetch ipxe config: %v", err)
+	}
+	now := time.Now()
+	for _, offset := range []time.Duration{30 * time.Second, 3 * time.Minute} {
+		dt.bootAttempt(loop.UUID(), "loop-install", now.Add(offset))
+	}
+	if m := machine(loop); !m.Runnable || m.ProvisioningError != "" {
+		t.Errorf("Machine marked after two boot attempts: %s", m.ProvisioningError)
+	}
+	// A finished job resets the count.
+	dt.bootProgress(loop.UUID(), now.Add(4*time.Minute))
+	for _, offset := range []time.Duration{5, 8, 11} {
+		dt.bootAttempt(loop.UUID(), "loop-install", now.Add(offset*time.Minute))
+	}
+	var m *Machine
+	for i := 0; i < 50; i++ {
+		if m = machine(loop); !m.Runnable && events.saw("bootloop", loop.UUID()) {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if m.Runnable || !strings.Contains(m.ProvisioningError, "3 times") {
+		t.Errorf("Expected boot looping machine to be marked, got Runnable %v, error %q", m.Runnable, m.ProvisioningError)
+	}
+	if !events.saw("bootloop", loop.UUID()) {
+		t.Errorf("Expected a bootloop event for %s", loop.UUID())
+	}
+	// Making it Runnable again clears the error.
+	rt.AllLocked(func(d Stores) {
+		m.Runnable = true
+		if _, err := rt.Update(m); err != nil {
+			t.Errorf("Failed to make machine runnable: %v", err)
+		}
+	})
+	if m = machine(loop); m.ProvisioningError != "" {
+		t.Errorf("Expected ProvisioningError to be cleared, not %q", m.ProvisioningError)
+	}
+
+	dt.bootAttempt(stall.UUID(), "loop-install", now)
+	if res := dt.CheckStalled(now.Add(5 * time.Minute)); len(res) != 0 {
+		t.Errorf("Machines marked stalled too soon: %v", res)
+	}
+	if res := dt.CheckStalled(now.Add(11 * time.Minute)); len(res) != 1 || res[0] != stall.UUID() {
+		t.Errorf("Expected %s to be marked stalled, got %v", stall.UUID(), res)
+	}
+	if m = machine(stall); m.Runnable || !strings.HasPrefix(m.ProvisioningError, "No job has finished") {
+		t.Errorf("Expected stalled machine to be marked, got Runnable %v, error %q", m.Runnable, m.ProvisioningError)
+	}
+	if !events.saw("stalled", stall.UUID()) {
+		t.Errorf("Expected a stalled event for %s", stall.UUID())
+	}
+}
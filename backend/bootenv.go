@@ -12,6 +12,7 @@ import (
 	"os/exec"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"text/template"
@@ -24,6 +25,11 @@ import (
 
 var explodeMux = &sync.Mutex{}
 
+// exploding tracks the directories ISOs are being exploded into, so
+// that an ISO is not queued to be exploded again while it waits.
+var exploding = map[string]struct{}{}
+var explodingMux = &sync.Mutex{}
+
 // BootEnv encapsulates the machine-agnostic information needed by the
 // provisioner to set up a boot environment.
 type BootEnv struct {
@@ -34,6 +40,8 @@ type BootEnv struct {
 	installRepo    *Repo
 	kernelVerified bool
 	bootParamsTmpl *template.Template
+	archBootParams map[string]*template.Template
+	ignitionTmpl   *template.Template
 	rootTemplate   *template.Template
 	tmplMux        sync.Mutex
 }
@@ -131,10 +139,21 @@ func (b *BootEnv) Backend() store.Store {
 }
 
 func (b *BootEnv) PathFor(f string) string {
+	return b.archPathFor("", f)
+}
+
+// archPathFor is PathFor for the files of architecture arch.  Files
+// for architectures with their own entry in Arches live in a tree
+// named for the architecture, so that each can have its own ISO.
+func (b *BootEnv) archPathFor(arch, f string) string {
 	res := b.OS.Name
 	if strings.HasSuffix(b.Name, "-install") {
 		res = path.Join(res, "install")
 	}
+	if b.HasArch(arch) {
+		a, _ := models.CanonicalArch(arch)
+		res = path.Join(a, res)
+	}
 	return path.Clean(path.Join("/", res, f))
 }
 
@@ -217,7 +236,21 @@ func (b *BootEnv) AddDynamicTree() {
 }
 
 func (b *BootEnv) localPathFor(f string) string {
-	return path.Join(b.rt.dt.FileRoot, b.PathFor(f))
+	return b.archLocalPathFor("", f)
+}
+
+// archNames returns the architectures in Arches in a stable order.
+func (b *BootEnv) archNames() []string {
+	res := make([]string, 0, len(b.Arches))
+	for arch := range b.Arches {
+		res = append(res, arch)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func (b *BootEnv) archLocalPathFor(arch, f string) string {
+	return path.Join(b.rt.dt.FileRoot, b.archPathFor(arch, f))
 }
 
 func (b *BootEnv) genRoot(commonRoot *template.Template, e models.ErrorAdder) *template.Template {
@@ -235,6 +268,26 @@ func (b *BootEnv) genRoot(commonRoot *template.Template, e models.ErrorAdder) *t
 			b.bootParamsTmpl = tmpl.Option("missingkey=error")
 		}
 	}
+	b.archBootParams = map[string]*template.Template{}
+	for arch, info := range b.Arches {
+		if info.BootParams == "" {
+			continue
+		}
+		tmpl, err := template.New("machine").Funcs(models.DrpSafeFuncMap()).Parse(info.BootParams)
+		if err != nil {
+			e.Errorf("Error compiling boot parameter template for arch %s: %v", arch, err)
+		} else {
+			b.archBootParams[arch] = tmpl.Option("missingkey=error")
+		}
+	}
+	if b.Ignition != "" {
+		tmpl, err := template.New("ignition").Funcs(models.DrpSafeFuncMap()).Parse(b.Ignition)
+		if err != nil {
+			e.Errorf("Error compiling Ignition template: %v", err)
+		} else {
+			b.ignitionTmpl = tmpl.Option("missingkey=error")
+		}
+	}
 	if b.HasError() != nil {
 		return nil
 	}
@@ -245,6 +298,11 @@ func explodeISO(rt *RequestTracker, envName, osName, fileRoot, isoFile, dest, sh
 	p := rt.dt
 	explodeMux.Lock()
 	defer explodeMux.Unlock()
+	defer func() {
+		explodingMux.Lock()
+		delete(exploding, dest)
+		explodingMux.Unlock()
+	}()
 	res := &models.Error{
 		Model: "bootenvs",
 		Key:   envName,
@@ -301,9 +359,14 @@ func explodeISO(rt *RequestTracker, envName, osName, fileRoot, isoFile, dest, sh
 	})
 }
 
-func (b *BootEnv) explodeIso() {
+// explodeIso makes sure the ISO for arch has been exploded into
+// place, and starts exploding it if it has not.  The install repo is
+// only used as a fallback for the architecture of the BootEnv's own
+// fields.
+func (b *BootEnv) explodeIso(arch string) {
+	info := b.ArchInfo(arch)
 	// Only work on things that are requested.
-	if b.OS.IsoFile == "" {
+	if info.IsoFile == "" {
 		b.rt.Infof("Explode ISO: Skipping %s becausing no iso image specified\n", b.Name)
 		return
 	}
@@ -311,29 +374,78 @@ func (b *BootEnv) explodeIso() {
 		b.rt.Errorf("Explode ISO: Skipping because BootEnv %s is missing OS.Name", b.Name)
 		return
 	}
-	b.kernelVerified = false
+	if arch == "" {
+		b.kernelVerified = false
+	}
 	// Have we already exploded this?  If file exists, then good!
-	canaryPath := b.localPathFor("." + strings.Replace(b.OS.Name, "/", "_", -1) + ".rebar_canary")
+	canaryPath := b.archLocalPathFor(arch, "."+strings.Replace(b.OS.Name, "/", "_", -1)+".rebar_canary")
 	buf, err := ioutil.ReadFile(canaryPath)
-	if err == nil && string(bytes.TrimSpace(buf)) == b.OS.IsoSha256 {
+	if err == nil && string(bytes.TrimSpace(buf)) == info.IsoSha256 {
 		b.rt.Infof("Explode ISO: canary file %s, in place and has proper SHA256\n", b.rt.dt.reportPath(canaryPath))
 		return
 	}
-	isoPath := filepath.Join(b.rt.dt.FileRoot, "isos", b.OS.IsoFile)
+	isoPath := filepath.Join(b.rt.dt.FileRoot, "isos", info.IsoFile)
 	if _, err := os.Stat(isoPath); os.IsNotExist(err) {
-		if b.installRepo != nil {
+		if arch == "" && b.installRepo != nil {
 			b.rt.Infof("BootEnv: Explode ISO: ISO does not exist, falling back to install repo at %s", b.installRepo.URL)
 			b.kernelVerified = true
 			return
 		}
 		b.Errorf("Explode ISO: iso does not exist: %s\n", b.rt.dt.reportPath(isoPath))
-		if b.OS.IsoUrl != "" {
-			b.Errorf("You can download the required ISO from %s", b.OS.IsoUrl)
+		if info.IsoUrl != "" {
+			b.Errorf("You can download the required ISO from %s", info.IsoUrl)
 		}
 		return
 	}
 	b.Errorf("Exploding ISO: %s", b.rt.dt.reportPath(isoPath))
-	go explodeISO(b.rt, b.Name, b.OS.Name, b.rt.dt.FileRoot, isoPath, b.localPathFor(""), b.OS.IsoSha256)
+	dest := b.archLocalPathFor(arch, "")
+	explodingMux.Lock()
+	_, busy := exploding[dest]
+	exploding[dest] = struct{}{}
+	explodingMux.Unlock()
+	if !busy {
+		go explodeISO(b.rt, b.Name, b.OS.Name, b.rt.dt.FileRoot, isoPath, dest, info.IsoSha256)
+	}
+}
+
+// checkBootFiles makes sure the kernel and initrds for arch are
+// present.
+func (b *BootEnv) checkBootFiles(arch string) {
+	info := b.ArchInfo(arch)
+	// If we have a non-empty Kernel, make sure it points at something kernel-ish.
+	if info.Kernel != "" {
+		kPath := b.archLocalPathFor(arch, info.Kernel)
+		kernelStat, err := os.Stat(kPath)
+		if err != nil {
+			b.Errorf("bootenv: %s: missing kernel %s (%s)",
+				b.Name,
+				info.Kernel,
+				b.rt.dt.reportPath(kPath))
+		} else if !kernelStat.Mode().IsRegular() {
+			b.Errorf("bootenv: %s: invalid kernel %s (%s)",
+				b.Name,
+				info.Kernel,
+				b.rt.dt.reportPath(kPath))
+		}
+	}
+	// Ditto for all the initrds.
+	for _, initrd := range info.Initrds {
+		iPath := b.archLocalPathFor(arch, initrd)
+		initrdStat, err := os.Stat(iPath)
+		if err != nil {
+			b.Errorf("bootenv: %s: missing initrd %s (%s)",
+				b.Name,
+				initrd,
+				b.rt.dt.reportPath(iPath))
+			continue
+		}
+		if !initrdStat.Mode().IsRegular() {
+			b.Errorf("bootenv: %s: invalid initrd %s (%s)",
+				b.Name,
+				initrd,
+				b.rt.dt.reportPath(iPath))
+		}
+	}
 }
 
 func (b *BootEnv) Validate() {
@@ -374,43 +486,32 @@ func (b *BootEnv) Validate() {
 	// Make sure the ISO for this bootenv has been exploded locally so that
 	// the boot env can use its contents.
 	b.fillInstallRepo()
-	b.explodeIso()
+	b.explodeIso("")
 	if !b.kernelVerified {
-		// If we have a non-empty Kernel, make sure it points at something kernel-ish.
-		if b.Kernel != "" {
-			kPath := b.localPathFor(b.Kernel)
-			kernelStat, err := os.Stat(kPath)
-			if err != nil {
-				b.Errorf("bootenv: %s: missing kernel %s (%s)",
-					b.Name,
-					b.Kernel,
-					b.rt.dt.reportPath(kPath))
-			} else if !kernelStat.Mode().IsRegular() {
-				b.Errorf("bootenv: %s: invalid kernel %s (%s)",
-					b.Name,
-					b.Kernel,
-					b.rt.dt.reportPath(kPath))
-			}
+		b.checkBootFiles("")
+	}
+	for _, arch := range b.archNames() {
+		b.explodeIso(arch)
+		b.checkBootFiles(arch)
+	}
+	// Kernel fetches count as boot attempts for boot loop detection.
+	for _, arch := range append([]string{""}, b.archNames()...) {
+		if kernel := b.ArchInfo(arch).Kernel; kernel != "" {
+			b.rt.dt.bootWatch.addKernel(b.archPathFor(arch, kernel))
 		}
-		// Ditto for all the initrds.
-		if len(b.Initrds) > 0 {
-			for _, initrd := range b.Initrds {
-				iPath := b.localPathFor(initrd)
-				initrdStat, err := os.Stat(iPath)
-				if err != nil {
-					b.Errorf("bootenv: %s: missing initrd %s (%s)",
-						b.Name,
-						initrd,
-						b.rt.dt.reportPath(iPath))
-					continue
-				}
-				if !initrdStat.Mode().IsRegular() {
-					b.Errorf("bootenv: %s: invalid initrd %s (%s)",
-						b.Name,
-						initrd,
-						b.rt.dt.reportPath(iPath))
-				}
-			}
+	}
+	if b.Image != nil {
+		iPath := path.Join(b.rt.dt.FileRoot, b.Image.File)
+		if imageStat, err := os.Stat(iPath); err != nil {
+			b.Errorf("bootenv: %s: missing image %s (%s)",
+				b.Name,
+				b.Image.File,
+				b.rt.dt.reportPath(iPath))
+		} else if !imageStat.Mode().IsRegular() {
+			b.Errorf("bootenv: %s: invalid image %s (%s)",
+				b.Name,
+				b.Image.File,
+				b.rt.dt.reportPath(iPath))
 		}
 	}
 	if b.OnlyUnknown {
@@ -559,6 +660,9 @@ func (b *BootEnv) render(rt *RequestTracker, m *Machine, e models.ErrorAdder) re
 			res = r.addRenderer(e, &toRender[i], res)
 		}
 	}
+	if b.ignitionTmpl != nil {
+		res = append(res, r.ignitionRenderer(e))
+	}
 	return res
 }
 
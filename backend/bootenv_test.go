@@ -1,6 +1,9 @@
 package backend
 
 import (
+	"io/ioutil"
+	"os"
+	"path"
 	"testing"
 
 	"github.com/digitalrebar/provision/models"
@@ -58,3 +61,86 @@ func TestBootEnvCrud(t *testing.T) {
 	crudTest{"Remove nonexistent BootEnv", rt.Remove, &models.BootEnv{Name: "test 1"}, false}.Test(t, rt)
 	crudTest{"Remove BootEnv that is in use", rt.Remove, &models.BootEnv{Name: "available"}, false}.Test(t, rt)
 }
+
+func TestBootEnvArches(t *testing.T) {
+	dt := mkDT()
+	rt := dt.Request(dt.Logger)
+	for _, f := range []string{"multi-os/vmlinuz", "multi-os/initrd", "arm64/multi-os/vmlinuz-arm"} {
+		p := path.Join(tmpDir, f)
+		if err := os.MkdirAll(path.Dir(p), 0755); err != nil {
+			t.Fatalf("Failed to make %s: %v", path.Dir(p), err)
+		}
+		if err := ioutil.WriteFile(p, []byte(f), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", p, err)
+		}
+	}
+	machines := map[string]*Machine{}
+	rt.AllLocked(func(d Stores) {
+		tmpl := &models.Template{
+			ID:       "multi-ipxe",
+			Contents: "{{.Env.Arch}}|{{.Env.PathFor \"tftp\" .Env.Kernel}}|{{.Env.JoinInitrds \"tftp\"}}|{{.BootParams}}",
+		}
+		if created, err := rt.Create(tmpl); !created {
+			t.Fatalf("Failed to create template: %v", err)
+		}
+		env := &models.BootEnv{
+			Name:       "multi",
+			OS:         models.OsInfo{Name: "multi-os"},
+			Templates:  []models.TemplateInfo{{Name: "ipxe", Path: "machines/{{.Machine.UUID}}/ipxe", ID: "multi-ipxe"}},
+			Kernel:     "vmlinuz",
+			Initrds:    []string{"initrd"},
+			BootParams: "console=ttyS0",
+			Arches: map[string]models.ArchInfo{
+				"arm64": {Kernel: "vmlinuz-arm", Initrds: []string{}, BootParams: "console=ttyAMA0"},
+			},
+		}
+		if created, err := rt.Create(env); !created {
+			t.Fatalf("Failed to create bootenv: %v", err)
+		}
+		bad := &models.BootEnv{
+			Name:      "bad-arch",
+			OS:        models.OsInfo{Name: "multi-os"},
+			Templates: []models.TemplateInfo{{Name: "ipxe", Path: "bad/ipxe", ID: "multi-ipxe"}},
+			Kernel:    "vmlinuz",
+			Arches:    map[string]models.ArchInfo{"aarch64": {Kernel: "vmlinuz-arm"}},
+		}
+		if created, _ := rt.Create(bad); created {
+			t.Errorf("Expected bootenv with a non-canonical arch name to be refused")
+		}
+		for _, test := range []struct {
+			name, arch string
+			params     map[string]interface{}
+		}{
+			{"plain", "", nil},
+			{"arm", "arm64", nil},
+			{"override", "amd64", map[string]interface{}{"machine/arch": "aarch64"}},
+		} {
+			m := &Machine{}
+			Fill(m)
+			m.Uuid = uuid.NewRandom()
+			m.Name = test.name + ".example.com"
+			m.BootEnv = "multi"
+			m.Arch = test.arch
+			m.Params = test.params
+			if created, err := rt.Create(m); !created {
+				t.Fatalf("Failed to create machine %s: %v", test.name, err)
+			}
+			machines[test.name] = m
+		}
+	})
+	for name, want := range map[string]string{
+		"plain":    "|multi-os/vmlinuz|multi-os/initrd|console=ttyS0",
+		"arm":      "arm64|arm64/multi-os/vmlinuz-arm||console=ttyAMA0",
+		"override": "arm64|arm64/multi-os/vmlinuz-arm||console=ttyAMA0",
+	} {
+		out, err := dt.FS.Open("/machines/"+machines[name].UUID()+"/ipxe", nil)
+		if err != nil || out == nil {
+			t.Errorf("Failed to render ipxe for %s: %v", name, err)
+			continue
+		}
+		buf, _ := ioutil.ReadAll(out)
+		if string(buf) != want {
+			t.Errorf("Machine %s: expected %q, got %q", name, want, string(buf))
+		}
+	}
+}
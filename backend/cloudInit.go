@@ -0,0 +1,216 @@
+package backend
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/digitalrebar/provision/backend/index"
+	yaml "github.com/ghodss/yaml"
+)
+
+// CloudInitPath is where the cloud-init datasources are served on
+// the static file server.  The NoCloud datasource lives under
+// nocloud, and can be used with a seed URL like
+//
+//	ds=nocloud-net;s=http://<provisioner>:8091/cloud-init/nocloud/
+//
+// The EC2 style metadata service lives under ec2, and answers for
+// any metadata version.
+//
+// The Machine the data is for is picked by the token query parameter
+// (a token made by GenerateToken for the Machine), the mac query
+// parameter, or the IP address the request comes from, in that
+// order.
+const CloudInitPath = "/cloud-init"
+
+// cloudInitFiles are the files the NoCloud datasource can ask for.
+var cloudInitFiles = map[string]bool{
+	"meta-data":      true,
+	"user-data":      true,
+	"vendor-data":    true,
+	"network-config": true,
+}
+
+// cloudInitMachine finds the Machine that req is for, or nil if
+// there is not one.
+func (p *DataTracker) cloudInitMachine(rt *RequestTracker, req *DynamicRequest) *Machine {
+	if tok := req.Query.Get("token"); tok != "" {
+		claims, err := p.GetToken(tok)
+		if err != nil || !claims.HasMachineUuid() {
+			rt.Warnf("cloud-init: Invalid token from %s", req.RemoteIP)
+			return nil
+		}
+		obj := rt.find("machines", claims.MachineUuid())
+		if obj == nil {
+			return nil
+		}
+		m := AsMachine(obj)
+		grantorSecret := ""
+		switch claims.GrantorId() {
+		case "system", "secret":
+			grantorSecret = p.pref("systemGrantorSecret")
+		default:
+			if u := rt.find("users", claims.GrantorId()); u != nil {
+				grantorSecret = AsUser(u).Secret
+			}
+		}
+		if !claims.ValidateSecrets(grantorSecret, "", m.Secret) {
+			rt.Warnf("cloud-init: Revoked token for %s from %s", m.UUID(), req.RemoteIP)
+			return nil
+		}
+		return m
+	}
+	if mac := req.Query.Get("mac"); mac != "" {
+		key := p.MacToMachineUUID(mac)
+		if key == "" {
+			if hw, err := net.ParseMAC(mac); err == nil {
+				key = p.MacToMachineUUID(hw.String())
+			}
+		}
+		if obj := rt.find("machines", key); obj != nil {
+			return AsMachine(obj)
+		}
+		return nil
+	}
+	if req.RemoteIP == nil {
+		return nil
+	}
+	found, err := index.All(
+		index.Sort((&Machine{}).Indexes()["Address"]),
+		index.Eq(req.RemoteIP.String()))(rt.Index("machines"))
+	if err != nil || found.Count() != 1 {
+		return nil
+	}
+	return AsMachine(found.Items()[0])
+}
+
+// cloudInitData returns the contents of the user-data, vendor-data
+// or network-config for the Machine that rd is for, or nil if there
+// is not one.  The cloud-init/<name>-template param names a template
+// to render for it, and failing that the cloud-init/<name> param has
+// it.  Values of the param that are not strings are turned into
+// YAML.
+func (p *DataTracker) cloudInitData(rd *RenderData, name string) ([]byte, error) {
+	if v, err := rd.Param("cloud-init/" + name + "-template"); err == nil {
+		id, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("cloud-init/%s-template must be the name of a template", name)
+		}
+		p.tmplMux.Lock()
+		root := p.rootTemplate
+		p.tmplMux.Unlock()
+		if root == nil || root.Lookup(id) == nil {
+			return nil, fmt.Errorf("Missing template: %s", id)
+		}
+		buf := &bytes.Buffer{}
+		if err := root.Lookup(id).Execute(buf, rd); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	v, err := rd.Param("cloud-init/" + name)
+	if err != nil {
+		if name == "user-data" {
+			return []byte("#cloud-config\n"), nil
+		}
+		return nil, nil
+	}
+	if s, ok := v.(string); ok {
+		return []byte(s), nil
+	}
+	buf, err := yaml.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if name != "network-config" {
+		buf = append([]byte("#cloud-config\n"), buf...)
+	}
+	return buf, nil
+}
+
+// cloudInitMetaData returns the meta-data for the Machine that rd is
+// for.  Anything in the cloud-init/meta-data param is added to it.
+func cloudInitMetaData(rd *RenderData) map[string]interface{} {
+	m := rd.Machine
+	res := map[string]interface{}{
+		"instance-id":    m.UUID(),
+		"hostname":       m.Name,
+		"local-hostname": m.ShortName(),
+	}
+	if len(m.Address) > 0 && !m.Address.IsUnspecified() {
+		res["local-ipv4"] = m.Address.String()
+	}
+	if len(m.HardwareAddrs) > 0 {
+		res["mac"] = m.HardwareAddrs[0]
+	}
+	if v, err := rd.Param("cloud-init/meta-data"); err == nil {
+		if extra, ok := v.(map[string]interface{}); ok {
+			for k, val := range extra {
+				res[k] = val
+			}
+		}
+	}
+	return res
+}
+
+// ec2MetaData answers EC2 style meta-data requests.  An empty key
+// lists the keys, and any other key returns its value.
+func ec2MetaData(md map[string]interface{}, key string) []byte {
+	if key == "" {
+		keys := make([]string, 0, len(md))
+		for k := range md {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		return []byte(strings.Join(keys, "\n"))
+	}
+	v, ok := md[key]
+	if !ok {
+		return nil
+	}
+	if s, ok := v.(string); ok {
+		return []byte(s)
+	}
+	buf, _ := yaml.Marshal(v)
+	return buf
+}
+
+// cloudInit is the dynamic request tree that serves CloudInitPath.
+func (p *DataTracker) cloudInit(req *DynamicRequest) (io.Reader, error) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(req.Path, CloudInitPath), "/"), "/")
+	locks := append([]string{"users"}, RenderPreviewLocks...)
+	rt := p.Request(p.Logger.Switch("bootenv"), locks...)
+	var res []byte
+	var err error
+	rt.Do(func(d Stores) {
+		m := p.cloudInitMachine(rt, req)
+		if m == nil {
+			return
+		}
+		rd := newRenderData(rt, m, nil)
+		rd.remoteIP = req.RemoteIP
+		switch {
+		case parts[0] == "nocloud" && len(parts) == 2 && cloudInitFiles[parts[1]]:
+			if parts[1] == "meta-data" {
+				res, err = yaml.Marshal(cloudInitMetaData(rd))
+			} else {
+				res, err = p.cloudInitData(rd, parts[1])
+			}
+		case parts[0] == "ec2" && len(parts) == 3 && parts[2] == "user-data":
+			res, err = p.cloudInitData(rd, "user-data")
+		case parts[0] == "ec2" && len(parts) >= 3 && parts[2] == "meta-data":
+			res = ec2MetaData(cloudInitMetaData(rd), strings.Join(parts[3:], "/"))
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if res == nil {
+		return nil, nil
+	}
+	return bytes.NewReader(res), nil
+}
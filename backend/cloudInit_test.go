@@ -0,0 +1,90 @@
+package backend
+
+import (
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/digitalrebar/provision/models"
+	"github.com/pborman/uuid"
+)
+
+func TestCloudInit(t *testing.T) {
+	dt := mkDT()
+	rt := dt.Request(dt.Logger)
+	m := &Machine{}
+	var token string
+	rt.AllLocked(func(d Stores) {
+		for _, obj := range []models.Model{
+			&models.Template{ID: "ci-vendor", Contents: "#cloud-config\nhostname: {{.Machine.ShortName}}\n"},
+		} {
+			if created, err := rt.Create(obj); !created {
+				t.Fatalf("Failed to create %s:%s: %v", obj.Prefix(), obj.Key(), err)
+			}
+		}
+		Fill(m)
+		m.Uuid = uuid.NewRandom()
+		m.Name = "ci.example.com"
+		m.Address = net.ParseIP("192.168.124.20")
+		m.HardwareAddrs = []string{"52:54:00:00:00:20"}
+		m.Params = map[string]interface{}{
+			"cloud-init/user-data":            map[string]interface{}{"packages": []interface{}{"vim"}},
+			"cloud-init/network-config":       "version: 2\n",
+			"cloud-init/vendor-data-template": "ci-vendor",
+			"cloud-init/meta-data":            map[string]interface{}{"rack": "r1"},
+		}
+		if created, err := rt.Create(m); !created {
+			t.Fatalf("Failed to create machine: %v", err)
+		}
+		token = newRenderData(rt, m, nil).GenerateToken()
+	})
+	get := func(p string, ip string, query url.Values) string {
+		out, err := dt.FS.open(&DynamicRequest{Path: CloudInitPath + p, RemoteIP: net.ParseIP(ip), Query: query})
+		if err != nil {
+			t.Errorf("Error getting %s: %v", p, err)
+			return ""
+		}
+		if out == nil {
+			return ""
+		}
+		buf, _ := ioutil.ReadAll(out)
+		return string(buf)
+	}
+	for _, test := range []struct {
+		name, path, ip string
+		query          url.Values
+		want           []string
+	}{
+		{"meta-data by IP", "/nocloud/meta-data", "192.168.124.20", nil, []string{"instance-id: " + m.UUID(), "local-hostname: ci", "rack: r1"}},
+		{"meta-data by MAC", "/nocloud/meta-data", "10.0.0.1", url.Values{"mac": {"52:54:00:00:00:20"}}, []string{"instance-id: " + m.UUID()}},
+		{"meta-data by token", "/nocloud/meta-data", "10.0.0.1", url.Values{"token": {token}}, []string{"instance-id: " + m.UUID()}},
+		{"user-data", "/nocloud/user-data", "192.168.124.20", nil, []string{"#cloud-config\n", "- vim"}},
+		{"network-config", "/nocloud/network-config", "192.168.124.20", nil, []string{"version: 2"}},
+		{"vendor-data", "/nocloud/vendor-data", "192.168.124.20", nil, []string{"hostname: ci"}},
+		{"ec2 meta-data keys", "/ec2/latest/meta-data/", "192.168.124.20", nil, []string{"instance-id\n", "local-ipv4\n"}},
+		{"ec2 meta-data value", "/ec2/2009-04-04/meta-data/local-ipv4", "192.168.124.20", nil, []string{"192.168.124.20"}},
+		{"ec2 user-data", "/ec2/latest/user-data", "192.168.124.20", nil, []string{"- vim"}},
+	} {
+		got := get(test.path, test.ip, test.query)
+		for _, want := range test.want {
+			if !strings.Contains(got, want) {
+				t.Errorf("%s: expected %q in:\n%s", test.name, want, got)
+			}
+		}
+	}
+	for _, test := range []struct {
+		name, path, ip string
+		query          url.Values
+	}{
+		{"unknown IP", "/nocloud/meta-data", "10.0.0.1", nil},
+		{"unknown MAC", "/nocloud/meta-data", "192.168.124.20", url.Values{"mac": {"52:54:00:00:00:21"}}},
+		{"bad token", "/nocloud/meta-data", "192.168.124.20", url.Values{"token": {"garbage"}}},
+		{"unknown file", "/nocloud/other-data", "192.168.124.20", nil},
+	} {
+		if got := get(test.path, test.ip, test.query); got != "" {
+			t.Errorf("%s: expected nothing, got:\n%s", test.name, got)
+		}
+	}
+}
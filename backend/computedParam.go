@@ -0,0 +1,154 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"text/template"
+
+	"github.com/digitalrebar/provision/models"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// computedValue turns the output of the template of a computed param
+// into its value.  Unless the Schema says the param is a string, the
+// output is parsed as JSON.  If the Schema does not say what type the
+// param is, output that is not JSON is used as a string.
+func (p *Param) computedValue(out string) (interface{}, error) {
+	var res interface{} = out
+	tv, hasType := p.TypeValue()
+	if tv != "string" {
+		var parsed interface{}
+		if err := json.Unmarshal([]byte(out), &parsed); err == nil {
+			res = parsed
+		} else if hasType {
+			return nil, fmt.Errorf("Computed value %q is not valid JSON: %v", out, err)
+		}
+	}
+	if p.Schema == nil {
+		return res, nil
+	}
+	if p.validator == nil {
+		p.validator, _ = gojsonschema.NewSchema(gojsonschema.NewGoLoader(p.Schema))
+	}
+	if err := validateAgainstSchema(res, p.validator); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// computedFilterValue turns s, the value a Machine parameter index is
+// filtered by, into a template that computes to s.  Machines are
+// compared by what their computed params compute to, and this is
+// compared the same way.
+func (p *Param) computedFilterValue(s string) (interface{}, error) {
+	if _, err := p.computedValue(s); err != nil {
+		return nil, err
+	}
+	return "{{" + strconv.Quote(s) + "}}", nil
+}
+
+// renderDataFor returns the RenderData that computed params of obj
+// are rendered with, or nil if obj is not a Machine.  Outside of
+// rendering, only the Machine and its params are available to the
+// templates of computed params.
+func (rt *RequestTracker) renderDataFor(obj models.Paramer) *RenderData {
+	var m *Machine
+	switch ref := obj.(type) {
+	case *rMachine:
+		return ref.renderData
+	case *Machine:
+		m = ref
+	case *models.Machine:
+		m = &Machine{Machine: ref}
+	default:
+		return nil
+	}
+	res := &RenderData{rt: rt}
+	res.Machine = &rMachine{Machine: m, renderData: res}
+	return res
+}
+
+// computeData is what the template of a computed param is rendered
+// against.  It only offers lookups of the Machine, its BootEnv, its
+// params and its peers, so that a computed param cannot generate
+// tokens or reach anything else that would then be handed out to
+// whoever can read the params of the Machine.
+type computeData struct {
+	Machine *rMachine
+	Env     *rBootEnv
+	r       *RenderData
+}
+
+// Param is the same as RenderData.Param.
+func (c *computeData) Param(key string) (interface{}, error) {
+	return c.r.Param(key)
+}
+
+// ParamAsJSON is the same as RenderData.ParamAsJSON.
+func (c *computeData) ParamAsJSON(key string) (string, error) {
+	return c.r.ParamAsJSON(key)
+}
+
+// ParamAsYAML is the same as RenderData.ParamAsYAML.
+func (c *computeData) ParamAsYAML(key string) (string, error) {
+	return c.r.ParamAsYAML(key)
+}
+
+// ParamExists is the same as RenderData.ParamExists.
+func (c *computeData) ParamExists(key string) bool {
+	return c.r.ParamExists(key)
+}
+
+// MachinesWithProfile is the same as RenderData.MachinesWithProfile.
+func (c *computeData) MachinesWithProfile(profile string) ([]*models.Machine, error) {
+	return c.r.MachinesWithProfile(profile)
+}
+
+// MachinesWithParam is the same as RenderData.MachinesWithParam.
+func (c *computeData) MachinesWithParam(key string, value interface{}) ([]*models.Machine, error) {
+	return c.r.MachinesWithParam(key, value)
+}
+
+// computeParam returns the value of the param key for the Machine r
+// is for.  val is returned as is unless the param is Computed, in
+// which case it is rendered as a template against the computeData
+// for r.  A computed param that ends up depending on itself is an
+// error.
+func (rt *RequestTracker) computeParam(r *RenderData, key string, val interface{}) (interface{}, error) {
+	pobj := rt.find("params", key)
+	if pobj == nil {
+		return val, nil
+	}
+	param := AsParam(pobj)
+	if !param.Computed {
+		return val, nil
+	}
+	if r.computing == nil {
+		r.computing = map[string]struct{}{}
+	}
+	if _, ok := r.computing[key]; ok {
+		return nil, fmt.Errorf("Computed param %s depends on itself", key)
+	}
+	r.computing[key] = struct{}{}
+	defer delete(r.computing, key)
+	src, ok := val.(string)
+	if !ok {
+		return nil, fmt.Errorf("Computed param %s must be a template, not %T", key, val)
+	}
+	tmpl, err := template.New(key).Funcs(models.DrpSafeFuncMap()).Parse(src)
+	if err != nil {
+		return nil, fmt.Errorf("Computed param %s: %v", key, err)
+	}
+	buf := &bytes.Buffer{}
+	data := &computeData{Machine: r.Machine, Env: r.Env, r: r}
+	if err := tmpl.Execute(buf, data); err != nil {
+		return nil, fmt.Errorf("Computed param %s: %v", key, err)
+	}
+	res, err := param.computedValue(buf.String())
+	if err != nil {
+		return nil, fmt.Errorf("Computed param %s: %v", key, err)
+	}
+	return res, nil
+}
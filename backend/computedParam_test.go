@@ -0,0 +1,133 @@
+package backend
+
+import (
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/digitalrebar/logger"
+	"github.com/digitalrebar/provision/backend/index"
+	"github.com/digitalrebar/provision/models"
+	"github.com/pborman/uuid"
+)
+
+func TestComputedParams(t *testing.T) {
+	param := func(name, typ string, computed bool, def interface{}) *models.Param {
+		schema := map[string]interface{}{"type": typ}
+		if def != nil {
+			schema["default"] = def
+		}
+		return &models.Param{Name: name, Schema: schema, Computed: computed}
+	}
+	site := &models.Content{
+		Meta: models.ContentMetaData{Name: "site", Version: "v1.0.0"},
+		Sections: models.Sections{
+			"params": models.Section{
+				"rack":      param("rack", "string", false, nil),
+				"slot":      param("slot", "integer", false, nil),
+				"hostname":  param("hostname", "string", true, `{{.Param "rack"}}-{{.Param "slot"}}`),
+				"next-slot": param("next-slot", "integer", true, nil),
+				"loop-a":    param("loop-a", "string", true, nil),
+				"loop-b":    param("loop-b", "string", true, nil),
+				"token":     param("token", "string", true, nil),
+			},
+			"profiles": models.Section{
+				"rack": &models.Profile{
+					Name: "rack",
+					Params: map[string]interface{}{
+						"rack":      "r1",
+						"next-slot": `{{add (.Param "slot") 1}}`,
+					},
+				},
+			},
+		},
+	}
+	l := logger.New(log.New(os.Stdout, "computed", 0)).Log("backend")
+	dt, err := contentTestTracker(tmpDir, l, []*models.Content{site})
+	if err != nil {
+		t.Fatalf("Failed to load content: %v", err)
+	}
+	rt := dt.Request(dt.Logger)
+	rt.AllLocked(func(d Stores) {
+		if created, _ := rt.Create(&models.Param{Name: "secret", Computed: true, Secure: true}); created {
+			t.Errorf("Expected computed secure param to be refused")
+		}
+		bad := &Machine{}
+		Fill(bad)
+		bad.Uuid = uuid.NewRandom()
+		bad.Name = "bad.example.com"
+		bad.Params = map[string]interface{}{"hostname": 5}
+		if created, _ := rt.Create(bad); created {
+			t.Errorf("Expected machine with a computed param that is not a template to be refused")
+		}
+		m := &Machine{}
+		Fill(m)
+		m.Uuid = uuid.NewRandom()
+		m.Name = "computed.example.com"
+		m.Profiles = []string{"rack"}
+		m.Params = map[string]interface{}{
+			"slot":   5,
+			"loop-a": `{{.Param "loop-b"}}`,
+			"loop-b": `{{.Param "loop-a"}}`,
+		}
+		if created, err := rt.Create(m); !created {
+			t.Fatalf("Failed to create machine: %v", err)
+		}
+		if v, _ := rt.GetParam(m, "hostname", true, false); v != "r1-5" {
+			t.Errorf("Expected hostname r1-5, got %v", v)
+		}
+		if v, _ := rt.GetParam(m, "next-slot", true, false); v != float64(6) {
+			t.Errorf("Expected next-slot 6, got %#v", v)
+		}
+		if v, _ := rt.GetParam(AsProfile(rt.Find("profiles", "rack")), "next-slot", true, false); v != `{{add (.Param "slot") 1}}` {
+			t.Errorf("Expected the profile to have the template for next-slot, got %v", v)
+		}
+		if _, _, err := rt.getParam(m, "loop-a", true, false); err == nil || !strings.Contains(err.Error(), "depends on itself") {
+			t.Errorf("Expected loop-a to depend on itself, got %v", err)
+		}
+		// Computed params can be read by anyone who can read the
+		// Machine, so they must not be able to make tokens.
+		for _, tmpl := range []string{
+			`{{.GenerateToken}}`,
+			`{{.GenerateInfiniteToken}}`,
+			`{{.GenerateProfileToken "global" 0}}`,
+		} {
+			v, _, err := rt.getParam(&models.Machine{Uuid: m.Uuid, Params: map[string]interface{}{"token": tmpl}}, "token", true, false)
+			if err == nil || v != nil {
+				t.Errorf("Expected %s to fail in a computed param, got %v", tmpl, v)
+			}
+		}
+		params := rt.GetParams(m, true, false)
+		if params["next-slot"] != float64(6) {
+			t.Errorf("Expected aggregated next-slot 6, got %v", params["next-slot"])
+		}
+		if _, ok := params["loop-a"]; ok {
+			t.Errorf("Expected loop-a to be left out of the aggregated params")
+		}
+		if v, err := newRenderData(rt, m, nil).Param("hostname"); err != nil || v != "r1-5" {
+			t.Errorf("Expected templates to see hostname r1-5, got %v: %v", v, err)
+		}
+		if _, err := newRenderData(rt, m, nil).Param("loop-b"); err == nil {
+			t.Errorf("Expected templates to get an error for loop-b")
+		}
+		if explained := rt.ExplainParam(m, "hostname", false); explained.Value != "r1-5" || explained.Sources[0].Via != "default" {
+			t.Errorf("Unexpected explanation %#v", explained)
+		}
+		maker, err := m.ParameterMaker(rt, "next-slot")
+		if err != nil {
+			t.Fatalf("Failed to make next-slot index: %v", err)
+		}
+		if res, err := index.All(index.Sort(maker), index.Eq("6"))(rt.Index("machines")); err != nil || res.Count() != 1 {
+			t.Errorf("Expected to find the machine by its computed next-slot: %v", err)
+		}
+		// Profiles are compared by the template itself.
+		maker, err = AsProfile(rt.Find("profiles", "rack")).ParameterMaker(rt, "next-slot")
+		if err != nil {
+			t.Fatalf("Failed to make profile next-slot index: %v", err)
+		}
+		if res, err := index.All(index.Sort(maker), index.Eq(`"{{add (.Param \"slot\") 1}}"`))(rt.Index("profiles")); err != nil || res.Count() != 1 {
+			t.Errorf("Expected to find the rack profile by its next-slot template: %v", err)
+		}
+	})
+}
@@ -0,0 +1,191 @@
+package backend
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/digitalrebar/provision/models"
+	"github.com/digitalrebar/store"
+)
+
+// diffIgnoredFields are the fields that are recalculated whenever an
+// object is loaded, so changes to them are not interesting.
+var diffIgnoredFields = []string{"Validated", "Available", "Errors", "ReadOnly"}
+
+// contentObjects returns the objects in a content bundle as generic
+// maps, by prefix and key.
+func contentObjects(c *models.Content) map[string]map[string]map[string]interface{} {
+	res := map[string]map[string]map[string]interface{}{}
+	if c == nil {
+		return res
+	}
+	for section, vals := range c.Sections {
+		if _, err := models.New(section); err != nil {
+			continue
+		}
+		res[section] = map[string]map[string]interface{}{}
+		for key, val := range vals {
+			// Go through the model so that missing and empty fields
+			// compare equal.
+			m, _ := models.New(section)
+			if err := models.Remarshal(val, m); err != nil {
+				continue
+			}
+			obj := map[string]interface{}{}
+			if err := models.Remarshal(m, &obj); err != nil {
+				continue
+			}
+			for _, field := range diffIgnoredFields {
+				delete(obj, field)
+			}
+			res[section][key] = obj
+		}
+	}
+	return res
+}
+
+// DiffContent compares two versions of a content bundle, returning
+// the objects that were added, removed, or changed, sorted by prefix
+// and key.  old may be nil, in which case everything was added.
+func DiffContent(old, updated *models.Content) []*models.ContentObjectDiff {
+	res := []*models.ContentObjectDiff{}
+	oldObjs, newObjs := contentObjects(old), contentObjects(updated)
+	for prefix, objs := range newObjs {
+		for key, obj := range objs {
+			prev, ok := oldObjs[prefix][key]
+			if !ok {
+				res = append(res, &models.ContentObjectDiff{Prefix: prefix, Key: key, Change: "added"})
+				continue
+			}
+			fields := []string{}
+			for field, val := range obj {
+				if !reflect.DeepEqual(val, prev[field]) {
+					fields = append(fields, field)
+				}
+			}
+			for field := range prev {
+				if _, ok := obj[field]; !ok {
+					fields = append(fields, field)
+				}
+			}
+			if len(fields) > 0 {
+				sort.Strings(fields)
+				res = append(res, &models.ContentObjectDiff{Prefix: prefix, Key: key, Change: "changed", Fields: fields})
+			}
+		}
+	}
+	for prefix, objs := range oldObjs {
+		for key := range objs {
+			if _, ok := newObjs[prefix][key]; !ok {
+				res = append(res, &models.ContentObjectDiff{Prefix: prefix, Key: key, Change: "removed"})
+			}
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Prefix != res[j].Prefix {
+			return res[i].Prefix < res[j].Prefix
+		}
+		return res[i].Key < res[j].Key
+	})
+	return res
+}
+
+func errorMessages(err error) []string {
+	switch e := err.(type) {
+	case nil:
+		return []string{}
+	case *models.Error:
+		if e.Messages == nil {
+			return []string{}
+		}
+		return e.Messages
+	default:
+		return []string{err.Error()}
+	}
+}
+
+// contentImpact returns the Machines whose current BootEnv, Stage, or
+// Tasks are changed or removed by diffs.
+func contentImpact(rt *RequestTracker, diffs []*models.ContentObjectDiff) []*models.ContentMachineImpact {
+	res := []*models.ContentMachineImpact{}
+	changed := map[string]string{}
+	for _, d := range diffs {
+		if d.Change != "added" {
+			changed[d.Prefix+":"+d.Key] = d.Change
+		}
+	}
+	machines := rt.stores("machines")
+	if machines == nil {
+		return res
+	}
+	for _, i := range machines.Items() {
+		m := AsMachine(i)
+		reasons := []string{}
+		seen := map[string]struct{}{}
+		check := func(prefix, label, key string) {
+			ref := prefix + ":" + key
+			if _, ok := seen[ref]; ok {
+				return
+			}
+			seen[ref] = struct{}{}
+			if change, ok := changed[ref]; ok {
+				reasons = append(reasons, fmt.Sprintf("%s %s would be %s", label, key, change))
+			}
+		}
+		check("bootenvs", "BootEnv", m.BootEnv)
+		check("stages", "Stage", m.Stage)
+		for _, task := range m.Tasks {
+			switch {
+			case strings.HasPrefix(task, "stage:"):
+				check("stages", "Stage", strings.TrimPrefix(task, "stage:"))
+			case strings.HasPrefix(task, "bootenv:"):
+				check("bootenvs", "BootEnv", strings.TrimPrefix(task, "bootenv:"))
+			case strings.Contains(task, ":"):
+				// Actions and the like are not content.
+			default:
+				check("tasks", "Task", task)
+			}
+		}
+		if len(reasons) > 0 {
+			res = append(res, &models.ContentMachineImpact{
+				UUID:    m.UUID(),
+				Name:    m.Name,
+				Reasons: reasons,
+			})
+		}
+	}
+	return res
+}
+
+// ContentDryRun reports what adding content, or replacing old with it
+// if old is not nil, would do without changing anything.  The
+// candidate DataStack is built and validated the same way it would be
+// for the real thing, and the Machines whose current BootEnv, Stage,
+// or Tasks would change are listed.  rt must have all its stores
+// locked.
+func (p *DataTracker) ContentDryRun(rt *RequestTracker, old, content *models.Content) (*models.ContentDiff, error) {
+	res := &models.ContentDiff{
+		Content:    content.Meta.Name,
+		NewVersion: content.Meta.Version,
+		Objects:    DiffContent(old, content),
+	}
+	if old != nil {
+		res.OldVersion = old.Meta.Version
+	}
+	newStore, err := store.Open("memory:///")
+	if err != nil {
+		return nil, err
+	}
+	candidate := *content
+	candidate.Meta.Type = "dynamic"
+	if err := candidate.ToStore(newStore); err != nil {
+		return nil, err
+	}
+	_, hard, soft := p.Backend.DryRunAddReplaceSAAS(content.Meta.Name, newStore, p.Secrets, rt.Logger)
+	res.Errors = errorMessages(hard)
+	res.Warnings = errorMessages(soft)
+	res.Machines = contentImpact(rt, res.Objects)
+	return res, nil
+}
@@ -0,0 +1,87 @@
+package backend
+
+import (
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/digitalrebar/logger"
+	"github.com/digitalrebar/provision/models"
+	"github.com/pborman/uuid"
+)
+
+func TestContentDryRun(t *testing.T) {
+	mk := func(version, description string, extra bool) *models.Content {
+		c := &models.Content{
+			Meta: models.ContentMetaData{Name: "greeter", Version: version},
+			Sections: models.Sections{
+				"tasks": models.Section{
+					"greet": &models.Task{Name: "greet", Description: description},
+				},
+				"templates": models.Section{},
+			},
+		}
+		if extra {
+			c.Sections["templates"]["old.tmpl"] = &models.Template{ID: "old.tmpl", Contents: "old"}
+		} else {
+			c.Sections["params"] = models.Section{"new": &models.Param{Name: "new"}}
+		}
+		return c
+	}
+	v1, v2 := mk("v1.0.0", "Greets", true), mk("v2.0.0", "Greets better", false)
+	l := logger.New(log.New(os.Stdout, "contentDiff", 0)).Log("backend")
+	dt, err := contentTestTracker(tmpDir, l, []*models.Content{v1})
+	if err != nil {
+		t.Fatalf("Failed to load content: %v", err)
+	}
+	rt := dt.Request(dt.Logger)
+	var diff *models.ContentDiff
+	rt.AllLocked(func(d Stores) {
+		m := &Machine{}
+		Fill(m)
+		m.Uuid = uuid.NewRandom()
+		m.Name = "greeted.example.com"
+		m.Tasks = []string{"greet"}
+		if created, err := rt.Create(m); !created {
+			t.Fatalf("Failed to create machine: %v", err)
+		}
+		diff, err = dt.ContentDryRun(rt, v1, v2)
+	})
+	if err != nil {
+		t.Fatalf("Failed to dry run content: %v", err)
+	}
+	if diff.OldVersion != "v1.0.0" || diff.NewVersion != "v2.0.0" {
+		t.Errorf("Expected versions v1.0.0 and v2.0.0, got %s and %s", diff.OldVersion, diff.NewVersion)
+	}
+	changes := []string{}
+	for _, o := range diff.Objects {
+		changes = append(changes, o.Change+" "+o.Prefix+":"+o.Key+" "+strings.Join(o.Fields, ","))
+	}
+	expect := []string{
+		"added params:new ",
+		"changed tasks:greet Description",
+		"removed templates:old.tmpl ",
+	}
+	if strings.Join(changes, "\n") != strings.Join(expect, "\n") {
+		t.Errorf("Expected changes:\n%s\nGot:\n%s", strings.Join(expect, "\n"), strings.Join(changes, "\n"))
+	}
+	if len(diff.Errors) != 0 {
+		t.Errorf("Expected no errors, got %v", diff.Errors)
+	}
+	if len(diff.Machines) != 1 || diff.Machines[0].Name != "greeted.example.com" {
+		t.Fatalf("Expected machine greeted.example.com to be affected, got %v", diff.Machines)
+	}
+	if r := diff.Machines[0].Reasons; len(r) != 1 || r[0] != "Task greet would be changed" {
+		t.Errorf("Unexpected reasons %v", r)
+	}
+	// The dry run must not have changed anything.
+	rt.AllLocked(func(d Stores) {
+		if rt.find("params", "new") != nil {
+			t.Errorf("Dry run created params:new")
+		}
+		if rt.find("templates", "old.tmpl") == nil {
+			t.Errorf("Dry run removed templates:old.tmpl")
+		}
+	})
+}
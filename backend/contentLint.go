@@ -0,0 +1,337 @@
+package backend
+
+import (
+	"fmt"
+	"sort"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/digitalrebar/provision/models"
+)
+
+// paramFuncs are the RenderData methods that take the name of a param
+// as their first argument.
+var paramFuncs = map[string]struct{}{
+	"Param":       {},
+	"ParamExists": {},
+	"ParamAsJSON": {},
+	"ParamAsYAML": {},
+}
+
+// contentLinter holds the state of a single LintContent call.
+type contentLinter struct {
+	res *models.ContentLintResults
+	// known are the keys of all the models that can be referred to,
+	// by prefix.  This includes the basic content and dependencies.
+	known map[string]map[string]struct{}
+	// tmplNames are all the names that can be used in a template
+	// action, along with whether they are used.
+	tmplNames map[string]bool
+}
+
+func (l *contentLinter) add(severity, code, object, f string, args ...interface{}) {
+	l.res.Findings = append(l.res.Findings, &models.ContentLintFinding{
+		Severity: severity,
+		Code:     code,
+		Object:   object,
+		Message:  fmt.Sprintf(f, args...),
+	})
+	if severity == "error" {
+		l.res.Errors++
+	} else {
+		l.res.Warnings++
+	}
+}
+
+func (l *contentLinter) has(prefix, key string) bool {
+	_, ok := l.known[prefix][key]
+	return ok
+}
+
+// decode turns the sections of a content bundle into models, adding
+// them to known.  Problems are only reported if report is set, so
+// that dependencies are not linted along with the content.
+func (l *contentLinter) decode(content *models.Content, report bool) map[string]map[string]models.Model {
+	res := map[string]map[string]models.Model{}
+	for section, vals := range content.Sections {
+		if section == models.ContentTestsSection {
+			continue
+		}
+		if _, err := models.New(section); err != nil {
+			if report {
+				l.add("warning", "unknown-section", section, "Section %s is not a known model and will be ignored", section)
+			}
+			continue
+		}
+		res[section] = map[string]models.Model{}
+		if l.known[section] == nil {
+			l.known[section] = map[string]struct{}{}
+		}
+		for key, val := range vals {
+			obj, _ := models.New(section)
+			if err := models.Remarshal(val, obj); err != nil {
+				if report {
+					l.add("error", "decode-failed", section+":"+key, "Cannot decode: %v", err)
+				}
+				continue
+			}
+			if report && obj.Key() != key {
+				l.add("error", "key-mismatch", section+":"+key, "Stored as %s but its key is %s", key, obj.Key())
+			}
+			res[section][obj.Key()] = obj
+			l.known[section][obj.Key()] = struct{}{}
+		}
+	}
+	return res
+}
+
+// checkRefs reports the references from object to missing models.
+func (l *contentLinter) checkRefs(object, prefix, code, severity string, keys ...string) {
+	for _, key := range keys {
+		if key != "" && !l.has(prefix, key) {
+			l.add(severity, code, object, "Refers to %s %s, which does not exist", prefix, key)
+		}
+	}
+}
+
+func (l *contentLinter) checkParams(object string, params ...string) {
+	for _, p := range params {
+		if !l.has("params", p) {
+			l.add("warning", "undefined-param", object, "Uses param %s, which has no Param definition", p)
+		}
+	}
+}
+
+// walk calls fn for every node in the parse tree rooted at node.
+func walk(node parse.Node, fn func(parse.Node)) {
+	if node == nil {
+		return
+	}
+	fn(node)
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, sub := range n.Nodes {
+			walk(sub, fn)
+		}
+	case *parse.ActionNode:
+		walk(n.Pipe, fn)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			walk(cmd, fn)
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			walk(arg, fn)
+		}
+	case *parse.IfNode:
+		walk(n.Pipe, fn)
+		walk(n.List, fn)
+		walk(n.ElseList, fn)
+	case *parse.RangeNode:
+		walk(n.Pipe, fn)
+		walk(n.List, fn)
+		walk(n.ElseList, fn)
+	case *parse.WithNode:
+		walk(n.Pipe, fn)
+		walk(n.List, fn)
+		walk(n.ElseList, fn)
+	case *parse.TemplateNode:
+		walk(n.Pipe, fn)
+	}
+}
+
+// parseTemplate parses a template the way the server does, returning
+// the templates it uses and the params it asks for by name.
+func parseTemplate(name, contents string) (tmpls, params []string, err error) {
+	t, err := template.New(name).Funcs(models.DrpSafeFuncMap()).Parse(contents)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, sub := range t.Templates() {
+		if sub.Tree == nil {
+			continue
+		}
+		walk(sub.Tree.Root, func(node parse.Node) {
+			switch n := node.(type) {
+			case *parse.TemplateNode:
+				tmpls = append(tmpls, n.Name)
+			case *parse.CommandNode:
+				if len(n.Args) < 2 {
+					return
+				}
+				field, ok := n.Args[0].(*parse.FieldNode)
+				if !ok || len(field.Ident) == 0 {
+					return
+				}
+				arg, ok := n.Args[1].(*parse.StringNode)
+				if !ok {
+					return
+				}
+				method := field.Ident[len(field.Ident)-1]
+				if _, ok := paramFuncs[method]; ok {
+					params = append(params, arg.Text)
+				} else if method == "CallTemplate" {
+					tmpls = append(tmpls, arg.Text)
+				}
+			}
+		})
+	}
+	return
+}
+
+// definedTemplates returns the names a template defines with
+// {{define}}, or nothing if it does not parse.
+func definedTemplates(name, contents string) []string {
+	t, err := template.New(name).Funcs(models.DrpSafeFuncMap()).Parse(contents)
+	if err != nil {
+		return nil
+	}
+	res := []string{}
+	for _, sub := range t.Templates() {
+		res = append(res, sub.Name())
+	}
+	return res
+}
+
+// lintTemplate reports the problems with a single template.
+func (l *contentLinter) lintTemplate(object, name, contents string) {
+	tmpls, params, err := parseTemplate(name, contents)
+	if err != nil {
+		l.add("error", "template-parse", object, "Template %s does not parse: %v", name, err)
+		return
+	}
+	for _, t := range tmpls {
+		if _, ok := l.tmplNames[t]; !ok {
+			l.add("error", "undefined-template", object, "Template %s uses template %s, which is not defined", name, t)
+			continue
+		}
+		l.tmplNames[t] = true
+	}
+	l.checkParams(object, params...)
+}
+
+// lintTemplateInfos reports the problems with the templates of a
+// BootEnv, Stage or Task.
+func (l *contentLinter) lintTemplateInfos(object string, tis []models.TemplateInfo) {
+	for i := range tis {
+		ti := &tis[i]
+		if ti.Contents != "" {
+			l.lintTemplate(object, ti.Name, ti.Contents)
+			continue
+		}
+		if ti.ID == "" {
+			continue
+		}
+		if _, ok := l.tmplNames[ti.ID]; !ok {
+			l.add("error", "missing-template", object, "Template %s refers to template %s, which does not exist", ti.Name, ti.ID)
+			continue
+		}
+		l.tmplNames[ti.ID] = true
+	}
+}
+
+// LintContent statically analyses content without loading it into a
+// DataTracker.  Every object is validated on its own, references
+// between objects are checked, and every template is parsed with the
+// same functions the server renders them with.  The basic content
+// and the content bundles in deps can be referred to, but are not
+// linted themselves.
+func LintContent(content *models.Content, deps []*models.Content) *models.ContentLintResults {
+	l := &contentLinter{
+		res: &models.ContentLintResults{
+			Content:  content.Meta.Name,
+			Findings: []*models.ContentLintFinding{},
+		},
+		known:     map[string]map[string]struct{}{},
+		tmplNames: map[string]bool{},
+	}
+	basic := &models.Content{}
+	basic.FromStore(BasicContent())
+	for _, dep := range append([]*models.Content{basic}, deps...) {
+		for _, obj := range l.decode(dep, false)["templates"] {
+			tmpl := obj.(*models.Template)
+			for _, name := range definedTemplates(tmpl.ID, tmpl.Contents) {
+				// Templates from elsewhere count as used.
+				l.tmplNames[name] = true
+			}
+		}
+	}
+	objs := l.decode(content, true)
+	for _, obj := range objs["templates"] {
+		tmpl := obj.(*models.Template)
+		for _, name := range definedTemplates(tmpl.ID, tmpl.Contents) {
+			if _, ok := l.tmplNames[name]; !ok {
+				l.tmplNames[name] = false
+			}
+		}
+	}
+	for prefix, items := range objs {
+		for key, obj := range items {
+			object := prefix + ":" + key
+			if v, ok := obj.(models.Validator); ok {
+				v.ClearValidation()
+				v.Validate()
+				if err := v.HasError(); err != nil {
+					for _, msg := range err.(*models.Validation).Errors {
+						l.add("error", "invalid", object, "%s", msg)
+					}
+				}
+			}
+			switch o := obj.(type) {
+			case *models.Template:
+				l.lintTemplate(object, o.ID, o.Contents)
+			case *models.BootEnv:
+				l.lintTemplateInfos(object, o.Templates)
+				l.checkParams(object, o.RequiredParams...)
+				l.checkParams(object, o.OptionalParams...)
+			case *models.Stage:
+				l.lintTemplateInfos(object, o.Templates)
+				l.checkParams(object, o.RequiredParams...)
+				l.checkParams(object, o.OptionalParams...)
+				l.checkRefs(object, "bootenvs", "missing-bootenv", "error", o.BootEnv)
+				l.checkRefs(object, "tasks", "missing-task", "error", o.Tasks...)
+				l.checkRefs(object, "profiles", "missing-profile", "error", o.Profiles...)
+			case *models.Task:
+				l.lintTemplateInfos(object, o.Templates)
+				l.checkParams(object, o.RequiredParams...)
+				l.checkParams(object, o.OptionalParams...)
+			case *models.Workflow:
+				l.checkRefs(object, "stages", "missing-stage", "error", o.Stages...)
+			case *models.Profile:
+				for p := range o.Params {
+					l.checkParams(object, p)
+				}
+				l.checkRefs(object, "profiles", "missing-profile", "error", o.Profiles...)
+			}
+		}
+	}
+	// A template is used if it or any of the templates it defines
+	// are used.
+	for key, obj := range objs["templates"] {
+		tmpl := obj.(*models.Template)
+		used := false
+		for _, name := range definedTemplates(tmpl.ID, tmpl.Contents) {
+			used = used || l.tmplNames[name]
+		}
+		if !used {
+			l.add("warning", "unused-template", "templates:"+key, "Template %s is not used by anything", key)
+		}
+	}
+	sort.SliceStable(l.res.Findings, func(i, j int) bool {
+		a, b := l.res.Findings[i], l.res.Findings[j]
+		if a.Object != b.Object {
+			return a.Object < b.Object
+		}
+		if a.Code != b.Code {
+			return a.Code < b.Code
+		}
+		return a.Message < b.Message
+	})
+	return l.res
+}
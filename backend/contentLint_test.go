@@ -0,0 +1,102 @@
+package backend
+
+import (
+	"testing"
+
+	"github.com/digitalrebar/provision/models"
+)
+
+func TestLintContent(t *testing.T) {
+	content := &models.Content{
+		Meta: models.ContentMetaData{Name: "linted", Version: "v1.0.0"},
+		Sections: models.Sections{
+			"params": models.Section{
+				"greeting": &models.Param{Name: "greeting"},
+			},
+			"templates": models.Section{
+				"hello.tmpl": &models.Template{
+					ID:       "hello.tmpl",
+					Contents: `Hello {{.Param "greeting"}} {{.Param "target"}}{{template "footer.tmpl" .}}`,
+				},
+				"footer.tmpl": &models.Template{
+					ID:       "footer.tmpl",
+					Contents: `Bye`,
+				},
+				"unused.tmpl": &models.Template{
+					ID:       "unused.tmpl",
+					Contents: `Nobody renders me`,
+				},
+				"broken.tmpl": &models.Template{
+					ID:       "broken.tmpl",
+					Contents: `{{ if }`,
+				},
+				"missing.tmpl": &models.Template{
+					ID:       "missing.tmpl",
+					Contents: `{{template "nowhere.tmpl" .}}`,
+				},
+			},
+			"bootenvs": models.Section{
+				"greeter": &models.BootEnv{
+					Name: "greeter",
+					Templates: []models.TemplateInfo{
+						{Name: "hello", Path: "greeting", ID: "hello.tmpl"},
+						{Name: "broken", Path: "broken", ID: "broken.tmpl"},
+						{Name: "missing", Path: "missing", ID: "missing.tmpl"},
+					},
+				},
+			},
+			"stages": models.Section{
+				"greeter": &models.Stage{
+					Name:    "greeter",
+					BootEnv: "nowhere",
+					Tasks:   []string{"nothing"},
+				},
+				"local": &models.Stage{
+					Name:    "local",
+					BootEnv: "local",
+				},
+			},
+			models.ContentTestsSection: models.Section{},
+		},
+	}
+	res := LintContent(content, nil)
+	expect := map[string]string{
+		"templates:hello.tmpl":   "undefined-param",
+		"templates:unused.tmpl":  "unused-template",
+		"templates:broken.tmpl":  "template-parse",
+		"templates:missing.tmpl": "undefined-template",
+		"stages:greeter":         "missing-bootenv",
+	}
+	for object, code := range expect {
+		found := false
+		for _, f := range res.Findings {
+			found = found || (f.Object == object && f.Code == code)
+		}
+		if !found {
+			t.Errorf("Expected a %s finding for %s, got %v", code, object, res.Findings)
+		}
+	}
+	for _, f := range res.Findings {
+		switch f.Object {
+		case "templates:footer.tmpl", "stages:local", "params:greeting":
+			t.Errorf("Expected no findings for %s, got %s: %s", f.Object, f.Code, f.Message)
+		case "templates:hello.tmpl":
+			if f.Code == "undefined-param" && f.Severity != "warning" {
+				t.Errorf("Expected undefined params to be warnings")
+			}
+		}
+	}
+	found := false
+	for _, f := range res.Findings {
+		found = found || (f.Object == "stages:greeter" && f.Code == "missing-task")
+	}
+	if !found {
+		t.Errorf("Expected a missing-task finding for stages:greeter")
+	}
+	if res.Errors == 0 || res.Warnings == 0 {
+		t.Errorf("Expected both errors and warnings, got %d and %d", res.Errors, res.Warnings)
+	}
+	if res.Errors+res.Warnings != len(res.Findings) {
+		t.Errorf("Expected %d findings, got %d", res.Errors+res.Warnings, len(res.Findings))
+	}
+}
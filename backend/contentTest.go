@@ -0,0 +1,252 @@
+package backend
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+
+	"github.com/digitalrebar/logger"
+	"github.com/digitalrebar/provision/models"
+	"github.com/digitalrebar/store"
+	"github.com/pborman/uuid"
+)
+
+// contentTestLocks are the locks a TemplateTest runs under.
+var contentTestLocks = []string{
+	"stages",
+	"bootenvs",
+	"templates",
+	"machines",
+	"profiles",
+	"params",
+	"tasks",
+	"preferences",
+	"workflows",
+}
+
+// ContentTests returns the TemplateTests carried by content, sorted
+// by name.
+func ContentTests(content *models.Content) ([]*models.TemplateTest, error) {
+	res := []*models.TemplateTest{}
+	for key, val := range content.Sections[models.ContentTestsSection] {
+		test := &models.TemplateTest{}
+		if err := models.Remarshal(val, test); err != nil {
+			return nil, fmt.Errorf("Invalid test %s: %v", key, err)
+		}
+		if test.Name == "" {
+			test.Name = key
+		}
+		res = append(res, test)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res, nil
+}
+
+// contentTestTracker builds a scratch DataTracker that holds the basic
+// content and the passed content bundles, all in memory.
+func contentTestTracker(fileRoot string, l logger.Logger, contents []*models.Content) (*DataTracker, error) {
+	ds, err := DefaultDataStack("", "memory:///", "", "", "", fileRoot, l)
+	if err != nil {
+		return nil, err
+	}
+	secrets, _ := store.Open("memory:///")
+	for _, content := range contents {
+		st, _ := store.Open("memory:///")
+		if err := content.ToStore(st); err != nil {
+			return nil, fmt.Errorf("Cannot load content %s: %v", content.Meta.Name, err)
+		}
+		nds, hard, _ := ds.AddReplaceSAAS(content.Meta.Name, st, secrets, l, nil)
+		if hard != nil {
+			return nil, fmt.Errorf("Content %s does not load: %v", content.Meta.Name, hard)
+		}
+		ds = nds
+	}
+	return NewDataTracker(ds,
+		secrets,
+		fileRoot,
+		fileRoot,
+		"127.0.0.1",
+		false,
+		8091,
+		8092,
+		l,
+		map[string]string{"defaultStage": "none", "defaultBootEnv": "local", "unknownBootEnv": "ignore"},
+		NewPublishers(log.New(ioutil.Discard, "", 0))), nil
+}
+
+// RunContentTests runs the TemplateTests carried by content.  Each
+// test gets its own scratch, in-memory DataTracker holding the basic
+// content, the content bundles in deps and content itself, so tests
+// cannot affect each other or anything outside of them.
+//
+// The returned error is only set if the tests could not be run at
+// all, such as when the content does not load.
+func RunContentTests(content *models.Content,
+	deps []*models.Content,
+	l logger.Logger) (*models.ContentTestResults, error) {
+	tests, err := ContentTests(content)
+	if err != nil {
+		return nil, err
+	}
+	fileRoot, err := ioutil.TempDir("", "drp-content-test-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(fileRoot)
+	contents := make([]*models.Content, 0, len(deps)+1)
+	contents = append(contents, deps...)
+	contents = append(contents, content)
+	res := &models.ContentTestResults{
+		Content: content.Meta.Name,
+		Results: []*models.TemplateTestResult{},
+	}
+	for _, test := range tests {
+		dt, err := contentTestTracker(fileRoot, l, contents)
+		if err != nil {
+			return nil, err
+		}
+		result := dt.runTemplateTest(test)
+		if result.Passed {
+			res.Passed++
+		} else {
+			res.Failed++
+		}
+		res.Results = append(res.Results, result)
+	}
+	return res, nil
+}
+
+func (p *DataTracker) runTemplateTest(test *models.TemplateTest) *models.TemplateTestResult {
+	res := &models.TemplateTestResult{Name: test.Name, Failures: []string{}}
+	fail := func(f string, args ...interface{}) {
+		res.Failures = append(res.Failures, fmt.Sprintf(f, args...))
+	}
+	m := &Machine{Machine: test.Machine}
+	if m.Machine == nil {
+		m.Machine = &models.Machine{}
+	}
+	m.Fill()
+	if m.Uuid == nil {
+		m.Uuid = uuid.NewRandom()
+	}
+	if m.Name == "" {
+		m.Name = "test.example.com"
+	}
+	for k, v := range test.Params {
+		m.Params[k] = v
+	}
+	var preview *models.RenderPreview
+	rt := p.Request(p.Logger, contentTestLocks...)
+	rt.Do(func(d Stores) {
+		for _, profile := range test.Profiles {
+			if _, err := rt.Create(profile); err != nil {
+				fail("Cannot create profile %s: %v", profile.Name, err)
+				return
+			}
+			found := false
+			for _, name := range m.Profiles {
+				found = found || name == profile.Name
+			}
+			if !found {
+				m.Profiles = append(m.Profiles, profile.Name)
+			}
+		}
+		if _, err := rt.Create(m); err != nil {
+			fail("Cannot create machine %s: %v", m.Name, err)
+			return
+		}
+		var err error
+		preview, err = m.RenderPreview(rt, test.BootEnv, test.Stage, test.Task, m.Address)
+		if err != nil {
+			fail("%v", err)
+		}
+	})
+	if preview == nil {
+		return res
+	}
+	for _, msg := range preview.Errors {
+		fail("%s", msg)
+	}
+	for _, exp := range test.Expect {
+		res.Failures = append(res.Failures, checkTemplateExpectation(exp, preview.Templates)...)
+	}
+	res.Passed = len(res.Failures) == 0
+	if !res.Passed {
+		res.Rendered = preview.Templates
+	}
+	return res
+}
+
+// checkTemplateExpectation returns the ways the rendered templates
+// fail to live up to exp.
+func checkTemplateExpectation(exp *models.TemplateExpectation, tmpls []*models.RenderedTemplate) []string {
+	res := []string{}
+	desc := exp.Name
+	if exp.Source != "" {
+		desc = exp.Source + " " + desc
+	}
+	if exp.Path != "" {
+		desc += " at " + exp.Path
+	}
+	compile := func(exprs []string) []*regexp.Regexp {
+		out := []*regexp.Regexp{}
+		for _, expr := range exprs {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				res = append(res, fmt.Sprintf("%s: invalid regular expression %q: %v", desc, expr, err))
+				continue
+			}
+			out = append(out, re)
+		}
+		return out
+	}
+	matches, notMatches := compile(exp.Matches), compile(exp.NotMatches)
+	var errMatch *regexp.Regexp
+	if exp.Error != "" {
+		if re := compile([]string{exp.Error}); len(re) == 1 {
+			errMatch = re[0]
+		}
+	}
+	found := false
+	for _, tmpl := range tmpls {
+		if tmpl.TemplateInfo != exp.Name ||
+			(exp.Source != "" && tmpl.Source != exp.Source) ||
+			(exp.Path != "" && tmpl.Path != exp.Path) {
+			continue
+		}
+		found = true
+		label := fmt.Sprintf("%s %s (%s)", tmpl.Source, tmpl.TemplateInfo, tmpl.Path)
+		if exp.Error != "" {
+			if tmpl.Error == "" {
+				res = append(res, fmt.Sprintf("%s: expected an error matching %q", label, exp.Error))
+			} else if errMatch != nil && !errMatch.MatchString(tmpl.Error) {
+				res = append(res, fmt.Sprintf("%s: error %q does not match %q", label, tmpl.Error, exp.Error))
+			}
+			continue
+		}
+		if tmpl.Error != "" {
+			res = append(res, fmt.Sprintf("%s: %s", label, tmpl.Error))
+			continue
+		}
+		if exp.Output != "" && tmpl.Content != exp.Output {
+			res = append(res, fmt.Sprintf("%s: output does not match the expected output", label))
+		}
+		for _, re := range matches {
+			if !re.MatchString(tmpl.Content) {
+				res = append(res, fmt.Sprintf("%s: output does not match %q", label, re.String()))
+			}
+		}
+		for _, re := range notMatches {
+			if re.MatchString(tmpl.Content) {
+				res = append(res, fmt.Sprintf("%s: output matches %q", label, re.String()))
+			}
+		}
+	}
+	if !found {
+		res = append(res, fmt.Sprintf("%s: no such template was rendered", desc))
+	}
+	return res
+}
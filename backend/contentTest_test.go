@@ -0,0 +1,89 @@
+package backend
+
+import (
+	"log"
+	"os"
+	"testing"
+
+	"github.com/digitalrebar/logger"
+	"github.com/digitalrebar/provision/models"
+)
+
+func TestRunContentTests(t *testing.T) {
+	content := &models.Content{
+		Meta: models.ContentMetaData{Name: "greeter", Version: "v1.0.0"},
+		Sections: models.Sections{
+			"templates": models.Section{
+				"hello.tmpl": &models.Template{
+					ID:       "hello.tmpl",
+					Contents: `Hello {{.Param "greeting"}} from {{.Machine.Name}}`,
+				},
+			},
+			"bootenvs": models.Section{
+				"greeter": &models.BootEnv{
+					Name: "greeter",
+					Templates: []models.TemplateInfo{
+						{Name: "hello", Path: "greeting/{{.Machine.UUID}}", ID: "hello.tmpl"},
+					},
+				},
+			},
+			models.ContentTestsSection: models.Section{
+				"exact": &models.TemplateTest{
+					Name:    "exact",
+					BootEnv: "greeter",
+					Params:  map[string]interface{}{"greeting": "world"},
+					Expect: []*models.TemplateExpectation{
+						{Source: "bootenvs:greeter", Name: "hello", Output: "Hello world from test.example.com"},
+					},
+				},
+				"profile": &models.TemplateTest{
+					Name:     "profile",
+					BootEnv:  "greeter",
+					Profiles: []*models.Profile{{Name: "greet", Params: map[string]interface{}{"greeting": "there"}}},
+					Expect: []*models.TemplateExpectation{
+						{Name: "hello", Matches: []string{`^Hello there`}, NotMatches: []string{`world`}},
+					},
+				},
+				"missing": &models.TemplateTest{
+					Name:    "missing",
+					BootEnv: "greeter",
+					Expect: []*models.TemplateExpectation{
+						{Name: "hello", Error: `No such machine parameter greeting`},
+					},
+				},
+				"wrong": &models.TemplateTest{
+					Name:    "wrong",
+					BootEnv: "greeter",
+					Params:  map[string]interface{}{"greeting": "world"},
+					Expect: []*models.TemplateExpectation{
+						{Name: "hello", Matches: []string{`Goodbye`}},
+						{Name: "nope"},
+					},
+				},
+			},
+		},
+	}
+	l := logger.New(log.New(os.Stdout, "contentTest", 0)).Log("backend")
+	res, err := RunContentTests(content, nil, l)
+	if err != nil {
+		t.Fatalf("Failed to run content tests: %v", err)
+	}
+	if res.Passed != 3 || res.Failed != 1 {
+		t.Errorf("Expected 3 tests to pass and 1 to fail, got %d and %d", res.Passed, res.Failed)
+	}
+	for _, r := range res.Results {
+		switch r.Name {
+		case "wrong":
+			if r.Passed || len(r.Failures) != 2 {
+				t.Errorf("Expected test wrong to fail twice, got %v", r.Failures)
+			}
+			if len(r.Rendered) == 0 {
+				t.Errorf("Expected test wrong to report what it rendered")
+			}
+		default:
+			if !r.Passed {
+				t.Errorf("Expected test %s to pass: %v", r.Name, r.Failures)
+			}
+		}
+	}
+}
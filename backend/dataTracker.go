@@ -123,6 +123,14 @@ func Fill(t store.KeySaver) {
 		if obj.Tenant == nil {
 			obj.Tenant = &models.Tenant{}
 		}
+	case *Pool:
+		if obj.Pool == nil {
+			obj.Pool = &models.Pool{}
+		}
+	case *Rollout:
+		if obj.Rollout == nil {
+			obj.Rollout = &models.Rollout{}
+		}
 	default:
 		panic(fmt.Sprintf("Unknown backend model %T", t))
 	}
@@ -166,6 +174,10 @@ func ModelToBackend(m models.Model) store.KeySaver {
 		return &Role{Role: obj}
 	case *models.Tenant:
 		return &Tenant{Tenant: obj}
+	case *models.Pool:
+		return &Pool{Pool: obj}
+	case *models.Rollout:
+		return &Rollout{Rollout: obj}
 	default:
 		return nil
 	}
@@ -361,6 +373,26 @@ func toBackend(m models.Model, rt *RequestTracker) store.KeySaver {
 		res.Tenant = obj
 		res.rt = rt
 		return &res
+	case *models.Pool:
+		var res Pool
+		if ours != nil {
+			res = *ours.(*Pool)
+		} else {
+			res = Pool{}
+		}
+		res.Pool = obj
+		res.rt = rt
+		return &res
+	case *models.Rollout:
+		var res Rollout
+		if ours != nil {
+			res = *ours.(*Rollout)
+		} else {
+			res = Rollout{}
+		}
+		res.Rollout = obj
+		res.rt = rt
+		return &res
 
 	default:
 		log.Panicf("Unknown model %T", m)
@@ -417,6 +449,7 @@ type DataTracker struct {
 	logger.Logger
 	FileRoot            string
 	LogRoot             string
+	RepoCacheRoot       string
 	OurAddress          string
 	ForceOurAddress     bool
 	Cleanup             bool
@@ -440,6 +473,11 @@ type DataTracker struct {
 	macAddrMap          map[string]string
 	macAddrMux          *sync.RWMutex
 	licenses            models.LicenseBundle
+	logWatchers         map[string]map[chan struct{}]struct{}
+	logWatchMux         *sync.Mutex
+	repoCache           *repoCache
+	bootWatch           *bootWatch
+	seen                *seenTracker
 }
 
 func (p *DataTracker) LogFor(s string) logger.Logger {
@@ -471,6 +509,8 @@ func allKeySavers() []models.Model {
 		&Plugin{},
 		&Job{},
 		&Tenant{},
+		&Pool{},
+		&Rollout{},
 	}
 }
 
@@ -699,6 +739,25 @@ func (p *DataTracker) rebuildCache(loadRT *RequestTracker) (hard, soft *models.E
 		}
 
 		p.objs[prefix].Index = *index.Create(res)
+		if prefix == "profiles" {
+			// Profiles can include each other, so what they include
+			// can only be checked once they are all loaded.
+			profiles := p.objs[prefix]
+			find := func(name string) *Profile {
+				if o := profiles.Find(name); o != nil {
+					return AsProfile(o)
+				}
+				return nil
+			}
+			for _, thing := range profiles.Items() {
+				prof := AsProfile(thing)
+				before := len(prof.Errors)
+				prof.checkProfiles(find)
+				for _, msg := range prof.Errors[before:] {
+					soft.Errorf("Profile %s: %s", prof.Name, msg)
+				}
+			}
+		}
 		if prefix == "bootenvs" {
 			for _, thing := range p.objs[prefix].Items() {
 				benv := AsBootEnv(thing)
@@ -765,6 +824,11 @@ func ValidateDataTrackerStore(fileRoot string,
 		macAddrMap:        map[string]string{},
 		macAddrMux:        &sync.RWMutex{},
 		secretsMux:        &sync.Mutex{},
+		logWatchers:       map[string]map[chan struct{}]struct{}{},
+		logWatchMux:       &sync.Mutex{},
+		repoCache:         newRepoCache(),
+		bootWatch:         newBootWatch(),
+		seen:              newSeenTracker(),
 	}
 
 	// Load stores.
@@ -808,6 +872,11 @@ func NewDataTracker(backend *DataStack,
 		macAddrMap:        map[string]string{},
 		macAddrMux:        &sync.RWMutex{},
 		secretsMux:        &sync.Mutex{},
+		logWatchers:       map[string]map[chan struct{}]struct{}{},
+		logWatchMux:       &sync.Mutex{},
+		repoCache:         newRepoCache(),
+		bootWatch:         newBootWatch(),
+		seen:              newSeenTracker(),
 	}
 
 	// Make sure incoming writable backend has all stores created
@@ -933,6 +1002,10 @@ func NewDataTracker(backend *DataStack,
 			logger.Fatalf("Failed to render unknown bootenv: %v", err)
 		}
 	})
+	res.FS.AddDynamicRequestTree(CloudInitPath, res.cloudInit)
+	res.FS.AddDynamicRequestTree(RepoCachePath, res.repoCacheServe)
+	res.FS.SetStaticFetchHook(res.staticFetch)
+	res.FS.SetFetchHook(res.addressSeen)
 	return res
 }
 
@@ -1047,10 +1120,35 @@ func (p *DataTracker) SetPrefs(rt *RequestTracker, prefs map[string]string) erro
 				err.AddError(p.RenderUnknown(rt))
 			}
 		case "unknownTokenTimeout",
-			"knownTokenTimeout":
+			"knownTokenTimeout",
+			"jobRetentionCount",
+			"jobRetentionMaxAge",
+			"jobLogCompressAge",
+			"repoCacheSize",
+			"repoCacheMetadataAge",
+			"bootLoopThreshold",
+			"provisioningStallTimeout":
 			if intCheck(name, val) {
 				savePref(name, val)
 			}
+		case "jobRetentionKeepFailed":
+			if _, e := strconv.ParseBool(val); e != nil {
+				err.Errorf("%s: %s", name, e.Error())
+			} else {
+				savePref(name, val)
+			}
+		case "contentSigning":
+			if _, ok := signingModes[val]; !ok {
+				err.Errorf("%s: Must be one of off, warn, or require: %s", name, val)
+			} else {
+				savePref(name, val)
+			}
+		case "trustedKeys":
+			if _, e := models.ParsePublicKeys(val); e != nil {
+				err.Errorf("%s: %s", name, e.Error())
+			} else {
+				savePref(name, val)
+			}
 		case "debugDhcp",
 			"debugRenderer",
 			"debugBootEnv",
@@ -1134,7 +1232,8 @@ func (p *DataTracker) Backup() ([]byte, error) {
 func (p *DataTracker) ReplaceBackend(rt *RequestTracker, st *DataStack) (hard, soft error) {
 	p.Debugf("Replacing backend data store")
 	p.Backend = st
-	return p.rebuildCache(rt)
+	h, s := p.rebuildCache(rt)
+	return h.HasError(), s.HasError()
 }
 
 func (p *DataTracker) MacToMachineUUID(mac string) string {
@@ -4,6 +4,7 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"strconv"
@@ -13,6 +14,14 @@ import (
 	"github.com/digitalrebar/logger"
 )
 
+// DynamicRequest is what a dynamic request tree is told about a
+// read request.  Query is nil for requests that come in over TFTP.
+type DynamicRequest struct {
+	Path     string
+	RemoteIP net.IP
+	Query    url.Values
+}
+
 // FileSystem provides the routines to allow the static HTTP and TFTP services to render
 // templates on demand..
 type FileSystem struct {
@@ -21,6 +30,9 @@ type FileSystem struct {
 	logger       logger.Logger
 	dynamicFiles map[string]func(net.IP) (io.Reader, error)
 	dynamicTrees map[string]func(string) (io.Reader, error)
+	requestTrees map[string]func(*DynamicRequest) (io.Reader, error)
+	staticHook   func(string, net.IP)
+	fetchHook    func(string, net.IP)
 }
 
 // NewFS creates a new initialized filesystem that will fall back to
@@ -32,23 +44,43 @@ func NewFS(backingFSPath string, logger logger.Logger) *FileSystem {
 		logger:       logger,
 		dynamicFiles: map[string]func(net.IP) (io.Reader, error){},
 		dynamicTrees: map[string]func(string) (io.Reader, error){},
+		requestTrees: map[string]func(*DynamicRequest) (io.Reader, error){},
 	}
 }
 
-func (fs *FileSystem) findTree(p string) func(string) (io.Reader, error) {
-	if len(fs.dynamicTrees) == 0 {
-		return nil
-	}
+// treeRoot walks up from p until found says a tree is rooted there.
+func treeRoot(p string, found func(string) bool) bool {
 	for {
-		if r, ok := fs.dynamicTrees[p]; ok {
-			return r
+		if found(p) {
+			return true
 		}
 		if p == "" || p == "/" {
-			break
+			return false
 		}
 		p = path.Dir(p)
 	}
-	return nil
+}
+
+func (fs *FileSystem) findTree(p string) (r func(string) (io.Reader, error)) {
+	if len(fs.dynamicTrees) == 0 {
+		return nil
+	}
+	treeRoot(p, func(root string) bool {
+		r = fs.dynamicTrees[root]
+		return r != nil
+	})
+	return
+}
+
+func (fs *FileSystem) findRequestTree(p string) (r func(*DynamicRequest) (io.Reader, error)) {
+	if len(fs.requestTrees) == 0 {
+		return nil
+	}
+	treeRoot(p, func(root string) bool {
+		r = fs.requestTrees[root]
+		return r != nil
+	})
+	return
 }
 
 // Open tests for the existence of a lookaside for file read request.
@@ -56,17 +88,26 @@ func (fs *FileSystem) findTree(p string) func(string) (io.Reader, error) {
 // lookaside function if one is present. If both the reader and error
 // are nil, FileSystem should fall back to serving a static file.
 func (fs *FileSystem) Open(p string, remoteIP net.IP) (io.Reader, error) {
-	p = path.Clean(p)
+	return fs.open(&DynamicRequest{Path: p, RemoteIP: remoteIP})
+}
+
+func (fs *FileSystem) open(req *DynamicRequest) (io.Reader, error) {
+	p := path.Clean(req.Path)
+	req.Path = p
 	fs.Lock()
 	dynFile := fs.dynamicFiles[p]
 	dynTree := fs.findTree(p)
+	reqTree := fs.findRequestTree(p)
 	fs.Unlock()
 	if dynFile != nil {
-		return dynFile(remoteIP)
+		return dynFile(req.RemoteIP)
 	}
 	if dynTree != nil {
 		return dynTree(p)
 	}
+	if reqTree != nil {
+		return reqTree(req)
+	}
 	return nil, nil
 }
 
@@ -85,7 +126,8 @@ func (fs *FileSystem) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	} else {
 		raddr = net.ParseIP(raddrStr)
 	}
-	out, err := fs.Open(p, raddr)
+	fs.fetched("http", raddr)
+	out, err := fs.open(&DynamicRequest{Path: p, RemoteIP: raddr, Query: r.URL.Query()})
 	if err != nil {
 		fs.logger.Errorf("Static FS: Dynamic file error for %s: %v", p, err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -98,6 +140,7 @@ func (fs *FileSystem) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			cl.Close()
 		}
 	} else {
+		fs.staticFetch(p, raddr)
 		http.ServeFile(w, r, path.Join(fs.lower, p))
 	}
 }
@@ -107,6 +150,7 @@ func (fs *FileSystem) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 func (fs *FileSystem) TftpResponder() func(string, net.IP) (io.Reader, error) {
 	return func(toSend string, remoteIP net.IP) (io.Reader, error) {
 		p := path.Clean("/" + toSend)
+		fs.fetched("tftp", remoteIP)
 		out, err := fs.Open(p, remoteIP)
 		if err != nil {
 			fs.logger.Errorf("Static FS: Dynamic file error for %s: %v", p, err)
@@ -115,10 +159,47 @@ func (fs *FileSystem) TftpResponder() func(string, net.IP) (io.Reader, error) {
 		if out != nil {
 			return out, nil
 		}
+		fs.staticFetch(p, remoteIP)
 		return os.Open(path.Join(fs.lower, p))
 	}
 }
 
+// SetStaticFetchHook sets a function that is called with the path
+// and the IP address of the system making the request whenever a
+// static file is served.
+func (fs *FileSystem) SetStaticFetchHook(hook func(string, net.IP)) {
+	fs.Lock()
+	fs.staticHook = hook
+	fs.Unlock()
+}
+
+func (fs *FileSystem) staticFetch(p string, remoteIP net.IP) {
+	fs.Lock()
+	hook := fs.staticHook
+	fs.Unlock()
+	if hook != nil {
+		hook(p, remoteIP)
+	}
+}
+
+// SetFetchHook sets a function that is called with the protocol
+// ("http" or "tftp") and the IP address of the system making the
+// request whenever any file is requested.
+func (fs *FileSystem) SetFetchHook(hook func(string, net.IP)) {
+	fs.Lock()
+	fs.fetchHook = hook
+	fs.Unlock()
+}
+
+func (fs *FileSystem) fetched(proto string, remoteIP net.IP) {
+	fs.Lock()
+	hook := fs.fetchHook
+	fs.Unlock()
+	if hook != nil && remoteIP != nil {
+		hook(proto, remoteIP)
+	}
+}
+
 // AddDynamicFile adds a lookaside that handles rendering a file that should be generated on
 // the fly.  fsPath is the path where the dynamic lookaside lives, and the passed-in function
 // will be called with the IP address of the system making the request.
@@ -153,3 +234,20 @@ func (fs *FileSystem) DelDynamicTree(fsPath string) {
 	delete(fs.dynamicTrees, path.Join("/", fsPath))
 	fs.Unlock()
 }
+
+// AddDynamicRequestTree adds a lookaside like AddDynamicTree, except
+// that the passed-in function is also told the IP address of the
+// system making the request and the query parameters of the request.
+func (fs *FileSystem) AddDynamicRequestTree(fsPath string, t func(*DynamicRequest) (io.Reader, error)) {
+	fs.Lock()
+	fs.requestTrees[path.Join("/", fsPath)] = t
+	fs.Unlock()
+}
+
+// DelDynamicRequestTree removes a lookaside added with
+// AddDynamicRequestTree.
+func (fs *FileSystem) DelDynamicRequestTree(fsPath string) {
+	fs.Lock()
+	delete(fs.requestTrees, path.Join("/", fsPath))
+	fs.Unlock()
+}
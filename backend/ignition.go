@@ -0,0 +1,146 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"path"
+
+	"github.com/digitalrebar/provision/models"
+)
+
+// ignitionFragments turns a value of the ignition/fragments param
+// into the fragments in it.  The value can be a fragment, a list of
+// them, or either of those as a JSON string.
+func ignitionFragments(v interface{}) ([]map[string]interface{}, error) {
+	if s, ok := v.(string); ok {
+		if err := json.Unmarshal([]byte(s), &v); err != nil {
+			return nil, fmt.Errorf("Invalid JSON: %v", err)
+		}
+	}
+	switch val := v.(type) {
+	case map[string]interface{}:
+		return []map[string]interface{}{val}, nil
+	case []interface{}:
+		res := make([]map[string]interface{}, len(val))
+		for i := range val {
+			frag, ok := val[i].(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("Fragment %d is not an object", i)
+			}
+			res[i] = frag
+		}
+		return res, nil
+	}
+	return nil, fmt.Errorf("%T is not an Ignition fragment", v)
+}
+
+// ignitionConfig merges the Ignition fragment from the BootEnv with
+// the ones from the ignition/fragments params of the Machine into
+// one config.  Fragments from the global profile come first, then
+// the ones from the profiles of the Stage and the Machine, and those
+// on the Machine itself come last.  Problems with the config are
+// added to e.
+func (r *RenderData) ignitionConfig(e models.ErrorAdder) map[string]interface{} {
+	frags := []map[string]interface{}{}
+	if r.Env != nil && r.Env.ignitionTmpl != nil {
+		buf := &bytes.Buffer{}
+		if err := r.Env.ignitionTmpl.Execute(buf, r); err != nil {
+			e.Errorf("Error rendering Ignition for bootenv %s: %v", r.Env.Name, err)
+			return nil
+		}
+		frag := map[string]interface{}{}
+		if err := json.Unmarshal(buf.Bytes(), &frag); err != nil {
+			e.Errorf("Ignition for bootenv %s is not a JSON object: %v", r.Env.Name, err)
+			return nil
+		}
+		frags = append(frags, frag)
+	}
+	links := r.rt.paramChain(r.Machine)
+	sources := make([]models.Paramer, 0, len(links)+1)
+	for i := len(links) - 1; i >= 0; i-- {
+		sources = append(sources, links[i].obj)
+	}
+	sources = append(sources, r.Machine)
+	failed := false
+	for _, src := range sources {
+		v, ok := src.GetParams()["ignition/fragments"]
+		if !ok {
+			continue
+		}
+		v, err := r.rt.computeParam(r, "ignition/fragments", v)
+		if err == nil {
+			var found []map[string]interface{}
+			if found, err = ignitionFragments(v); err == nil {
+				frags = append(frags, found...)
+				continue
+			}
+		}
+		e.Errorf("ignition/fragments from %s %s: %v", src.Prefix(), src.Key(), err)
+		failed = true
+	}
+	if failed {
+		return nil
+	}
+	cfg, err := models.MergeIgnition(frags...)
+	if err != nil {
+		e.Errorf("Error merging Ignition fragments: %v", err)
+		return nil
+	}
+	models.ValidateIgnition(cfg, e)
+	return cfg
+}
+
+// ignitionJSON builds the Ignition config for the Machine r is for
+// and marshals it.
+func (r *RenderData) ignitionJSON() ([]byte, error) {
+	res := &models.Error{Code: http.StatusUnprocessableEntity, Type: ValidationError, Model: "machines", Key: r.Machine.Key()}
+	cfg := r.ignitionConfig(res)
+	if res.ContainsError() {
+		return nil, res
+	}
+	return json.Marshal(cfg)
+}
+
+// ignitionRenderer makes the renderer that serves the Ignition config
+// for the Machine r is for.  The config is also built right away, so
+// that problems with it are added to e like other render errors.
+func (r *RenderData) ignitionRenderer(e models.ErrorAdder) renderer {
+	check := &models.Error{}
+	r.ignitionConfig(check)
+	for _, msg := range check.Messages {
+		e.Errorf("Machine %s: %s", r.Machine.UUID(), msg)
+	}
+	envName, key := r.Env.Name, r.Machine.Key()
+	dt := r.rt.dt
+	return renderer{
+		path: path.Join("/", r.Machine.Path(), "ignition.json"),
+		name: "ignition",
+		write: func(remoteIP net.IP) (io.Reader, error) {
+			var buf []byte
+			var err error
+			rt := dt.Request(r.rt.Logger.Switch("bootenv"), RenderPreviewLocks...)
+			rt.Do(func(d Stores) {
+				mobj, eobj := rt.find("machines", key), rt.find("bootenvs", envName)
+				if mobj == nil || eobj == nil {
+					err = fmt.Errorf("machines:%s or bootenvs:%s has vanished", key, envName)
+					return
+				}
+				rd := newRenderData(rt, AsMachine(mobj), AsBootEnv(eobj))
+				rd.remoteIP = remoteIP
+				buf, err = rd.ignitionJSON()
+			})
+			if err != nil {
+				return nil, err
+			}
+			return bytes.NewReader(buf), nil
+		},
+		preview: func(rd *RenderData) (string, error) {
+			buf, err := rd.ignitionJSON()
+			return string(buf), err
+		},
+	}
+}
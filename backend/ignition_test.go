@@ -0,0 +1,99 @@
+package backend
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/digitalrebar/provision/models"
+	"github.com/pborman/uuid"
+)
+
+func TestIgnition(t *testing.T) {
+	dt := mkDT()
+	rt := dt.Request(dt.Logger)
+	m := &Machine{}
+	rt.AllLocked(func(d Stores) {
+		for _, obj := range []models.Model{
+			&models.Template{ID: "default"},
+			&models.Profile{
+				Name: "etcd",
+				Params: map[string]interface{}{
+					"ignition/fragments": []interface{}{
+						map[string]interface{}{"systemd": map[string]interface{}{"units": []interface{}{
+							map[string]interface{}{"name": "etcd.service", "enabled": true},
+						}}},
+					},
+				},
+			},
+			&models.BootEnv{
+				Name:      "flatcar",
+				Templates: []models.TemplateInfo{{Name: "ipxe", Path: "machines/{{.Machine.UUID}}/ipxe", ID: "default"}},
+				Ignition:  `{"ignition":{"version":"3.1.0"},"storage":{"files":[{"path":"/etc/hostname","contents":{"source":"data:,{{.Machine.ShortName}}"}}]}}`,
+			},
+		} {
+			if created, err := rt.Create(obj); !created {
+				t.Fatalf("Failed to create %s:%s: %v", obj.Prefix(), obj.Key(), err)
+			}
+		}
+		if created, _ := rt.Create(&models.BootEnv{
+			Name:      "broken",
+			Templates: []models.TemplateInfo{{Name: "ipxe", Path: "machines/{{.Machine.UUID}}/ipxe", ID: "default"}},
+			Ignition:  `{{`,
+		}); created {
+			t.Errorf("Expected bootenv with a bad Ignition template to be refused")
+		}
+		Fill(m)
+		m.Uuid = uuid.NewRandom()
+		m.Name = "etcd1.example.com"
+		m.BootEnv = "flatcar"
+		m.Profiles = []string{"etcd"}
+		m.Params = map[string]interface{}{
+			"ignition/fragments": `{"storage":{"files":[{"path":"/etc/hostname","mode":420}]}}`,
+		}
+		if created, err := rt.Create(m); !created {
+			t.Fatalf("Failed to create machine: %v", err)
+		}
+	})
+	out, err := dt.FS.Open("/machines/"+m.UUID()+"/ignition.json", nil)
+	if err != nil || out == nil {
+		t.Fatalf("Failed to get Ignition config: %v", err)
+	}
+	buf, _ := ioutil.ReadAll(out)
+	cfg := map[string]interface{}{}
+	if err := json.Unmarshal(buf, &cfg); err != nil {
+		t.Fatalf("Ignition config is not JSON: %v\n%s", err, buf)
+	}
+	want := `{"ignition":{"version":"3.1.0"},"storage":{"files":[{"contents":{"source":"data:,etcd1"},"mode":420,"path":"/etc/hostname"}]},"systemd":{"units":[{"enabled":true,"name":"etcd.service"}]}}`
+	if got, _ := json.Marshal(cfg); string(got) != want {
+		t.Errorf("Expected Ignition config:\n%s\ngot:\n%s", want, got)
+	}
+	rt.AllLocked(func(d Stores) {
+		preview, err := AsMachine(rt.Find("machines", m.Key())).RenderPreview(rt, "", "", "", nil)
+		if err != nil {
+			t.Fatalf("Failed to preview templates: %v", err)
+		}
+		found := false
+		for _, tmpl := range preview.Templates {
+			if tmpl.Path == "/machines/"+m.UUID()+"/ignition.json" {
+				found = true
+				if tmpl.Error != "" || tmpl.Content != string(buf) {
+					t.Errorf("Expected the preview to show the Ignition config, got %q: %s", tmpl.Content, tmpl.Error)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("Expected the preview to include the Ignition config")
+		}
+	})
+	rt.AllLocked(func(d Stores) {
+		bad := models.Clone(m.Machine).(*models.Machine)
+		bad.Params["ignition/fragments"] = map[string]interface{}{"storage": map[string]interface{}{"files": []interface{}{
+			map[string]interface{}{"path": "etc/motd"},
+		}}}
+		if _, err := rt.Update(bad); err == nil || !strings.Contains(err.Error(), "must be absolute") {
+			t.Errorf("Expected a bad Ignition fragment to be refused, got %v", err)
+		}
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	s "sort"
+	"strings"
 
 	"github.com/digitalrebar/provision/models"
 )
@@ -617,6 +618,62 @@ func Ne(ref string) Filter {
 	}
 }
 
+// ParseFilter turns a filter expression from a list query into a
+// Filter.  Values not in one of the forms below are treated as Eq.
+// Supported Forms:
+//
+//   Eq(value)
+//   Lt(value)
+//   Lte(value)
+//   Gt(value)
+//   Gte(value)
+//   Ne(value)
+//   Between(valueLower, valueHigher)
+//   Except(valueLower, valueHigher)
+//
+func ParseFilter(v string) (Filter, error) {
+	args := strings.SplitN(v, "(", 2)
+	if len(args) == 1 {
+		return Eq(v), nil
+	}
+	switch args[0] {
+	case "Eq":
+		subargs := strings.SplitN(args[1], ")", 2)
+		return Eq(subargs[0]), nil
+	case "Lt":
+		subargs := strings.SplitN(args[1], ")", 2)
+		return Lt(subargs[0]), nil
+	case "Lte":
+		subargs := strings.SplitN(args[1], ")", 2)
+		return Lte(subargs[0]), nil
+	case "Gt":
+		subargs := strings.SplitN(args[1], ")", 2)
+		return Gt(subargs[0]), nil
+	case "Gte":
+		subargs := strings.SplitN(args[1], ")", 2)
+		return Gte(subargs[0]), nil
+	case "Ne":
+		subargs := strings.SplitN(args[1], ")", 2)
+		return Ne(subargs[0]), nil
+	case "Between":
+		subargs := strings.SplitN(args[1], ")", 2)
+		parts := strings.Split(subargs[0], ",")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Between needs 2 values, not %d", len(parts))
+		}
+		return Between(parts[0], parts[1]), nil
+	case "Except":
+		subargs := strings.SplitN(args[1], ")", 2)
+		parts := strings.Split(subargs[0], ",")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Except needs 2 values, not %d", len(parts))
+		}
+		return Except(parts[0], parts[1]), nil
+	default:
+		return Eq(v), nil
+	}
+}
+
 // Select returns a filter that picks all items that match the passed
 // Test.  It does not rely on the Index being sorted in any particular
 // order.
@@ -669,3 +726,18 @@ func Reverse() Filter {
 		return res, nil
 	}
 }
+
+// After returns a filter that keeps all the items whose keys sort
+// after key.  It relies on the index being in native key order, or
+// in reverse native key order if reverse is true.  It is used to
+// implement cursor based pagination, which unlike Offset does not
+// shift when items are added or removed ahead of the cursor.
+func After(key string, reverse bool) Filter {
+	return func(i *Index) (*Index, error) {
+		test := func(j int) bool { return i.objs[j].Key() > key }
+		if reverse {
+			test = func(j int) bool { return i.objs[j].Key() < key }
+		}
+		return i.cp(i.objs[s.Search(len(i.objs), test):]), nil
+	}
+}
@@ -232,3 +232,38 @@ func TestIndexes(t *testing.T) {
 	}
 	matchIdx(t, sub, 6)
 }
+
+func TestAfter(t *testing.T) {
+	objs := make([]models.Model, 20)
+	for i := range objs {
+		objs[i] = testThing(len(objs) - i)
+	}
+	idx, err := Native()(New(objs))
+	if err != nil {
+		t.Errorf("Unexpected error sorting: %v", err)
+	}
+	page, err := All(After("", false), Limit(5))(idx)
+	if err != nil {
+		t.Errorf("Unexpected error running After: %v", err)
+	}
+	matchIdx(t, page, 1, 2, 3, 4, 5)
+	page, err = All(After(page.objs[4].Key(), false), Limit(5))(idx)
+	if err != nil {
+		t.Errorf("Unexpected error running After: %v", err)
+	}
+	matchIdx(t, page, 6, 7, 8, 9, 10)
+	page, err = All(After("0018", false), Limit(5))(idx)
+	if err != nil {
+		t.Errorf("Unexpected error running After: %v", err)
+	}
+	matchIdx(t, page, 19, 20)
+	rev, err := Reverse()(idx)
+	if err != nil {
+		t.Errorf("Unexpected error reversing: %v", err)
+	}
+	page, err = All(After("0005", true), Limit(3))(rev)
+	if err != nil {
+		t.Errorf("Unexpected error running reversed After: %v", err)
+	}
+	matchIdx(t, page, 4, 3, 2)
+}
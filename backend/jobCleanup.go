@@ -0,0 +1,211 @@
+package backend
+
+import (
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/digitalrebar/provision/backend/index"
+	"github.com/digitalrebar/provision/models"
+)
+
+// defaultJobLogCompressAge is how long after a job ends its log is
+// compressed when the jobLogCompressAge preference is not set.
+const defaultJobLogCompressAge = time.Hour
+
+// JobCleanup records what a single pass of CleanupJobs did.
+type JobCleanup struct {
+	// Pruned counts the jobs removed, by the retention rule that
+	// removed them ("count" or "age").
+	Pruned map[string]int
+	// LogsCompressed is the number of job logs compressed.
+	LogsCompressed int
+	// BytesReclaimed is the disk space freed by removing and
+	// compressing job logs.
+	BytesReclaimed int64
+}
+
+// JobRetention is the job retention policy, as set by the
+// jobRetentionCount, jobRetentionMaxAge, jobRetentionKeepFailed and
+// jobLogCompressAge preferences.
+type JobRetention struct {
+	// Count is the number of jobs to keep per machine.  0 keeps
+	// them all.
+	Count int
+	// MaxAge is how long to keep jobs after they end.  0 keeps them
+	// forever.
+	MaxAge time.Duration
+	// KeepFailed keeps the most recent failed job of each machine
+	// regardless of Count and MaxAge.
+	KeepFailed bool
+	// CompressAge is how long after a job ends its log is
+	// compressed.  0 disables compression.
+	CompressAge time.Duration
+}
+
+// JobRetention returns the job retention policy from the current
+// preferences.
+func (p *DataTracker) JobRetention() *JobRetention {
+	res := &JobRetention{KeepFailed: true, CompressAge: defaultJobLogCompressAge}
+	prefs := p.Prefs()
+	if v, err := strconv.Atoi(prefs["jobRetentionCount"]); err == nil && v > 0 {
+		res.Count = v
+	}
+	if v, err := strconv.Atoi(prefs["jobRetentionMaxAge"]); err == nil && v > 0 {
+		res.MaxAge = time.Duration(v) * time.Second
+	}
+	if v, err := strconv.ParseBool(prefs["jobRetentionKeepFailed"]); err == nil {
+		res.KeepFailed = v
+	}
+	if v, err := strconv.Atoi(prefs["jobLogCompressAge"]); err == nil && v >= 0 {
+		res.CompressAge = time.Duration(v) * time.Second
+	}
+	return res
+}
+
+func jobDone(j *Job) bool {
+	return j.State == "finished" || j.State == "failed"
+}
+
+func logSize(paths ...string) int64 {
+	var res int64
+	for _, p := range paths {
+		if fi, err := os.Stat(p); err == nil {
+			res += fi.Size()
+		}
+	}
+	return res
+}
+
+// prunable returns the jobs that policy says should be removed, keyed
+// by UUID, along with the rule that selected each one.  Jobs that are
+// not done or that are still current for their machine are never
+// pruned.
+func (policy *JobRetention) prunable(jobs []*Job, now time.Time) map[string]string {
+	res := map[string]string{}
+	if policy.Count == 0 && policy.MaxAge == 0 {
+		return res
+	}
+	byMachine := map[string][]*Job{}
+	for _, j := range jobs {
+		m := j.Machine.String()
+		byMachine[m] = append(byMachine[m], j)
+	}
+	for _, mJobs := range byMachine {
+		sort.SliceStable(mJobs, func(i, j int) bool {
+			return mJobs[i].StartTime.After(mJobs[j].StartTime)
+		})
+		keptFailed := !policy.KeepFailed
+		for i, j := range mJobs {
+			if !keptFailed && j.State == "failed" {
+				keptFailed = true
+				continue
+			}
+			if j.Current || !jobDone(j) {
+				continue
+			}
+			if policy.Count > 0 && i >= policy.Count {
+				res[j.UUID()] = "count"
+			} else if policy.MaxAge > 0 && !j.EndTime.IsZero() && now.Sub(j.EndTime) > policy.MaxAge {
+				res[j.UUID()] = "age"
+			}
+		}
+	}
+	return res
+}
+
+// recheck returns whether policy still says j should be removed,
+// judged against the jobs of j's machine as they are now.  The jobs
+// lock must be held.
+func (policy *JobRetention) recheck(rt *RequestTracker, j *Job, now time.Time) (string, bool) {
+	var sameMachine index.Filter
+	if j.Machine == nil {
+		sameMachine = index.Select(func(m models.Model) bool { return AsJob(m).Machine == nil })
+	} else {
+		ref := &Job{}
+		sameMachine = index.All(index.Sort(ref.Indexes()["Machine"]), index.Eq(j.Machine.String()))
+	}
+	mJobs, err := sameMachine(rt.Index("jobs"))
+	if err != nil {
+		return "", false
+	}
+	items := mJobs.Items()
+	cur := make([]*Job, len(items))
+	for i := range items {
+		cur[i] = AsJob(items[i])
+	}
+	reason, ok := policy.prunable(cur, now)[j.UUID()]
+	return reason, ok
+}
+
+// CleanupJobs applies the job retention policy from the preferences.
+// Jobs that are no longer wanted are removed along with their logs,
+// and the logs of the remaining jobs that ended long enough ago are
+// compressed, at which point the job is marked as Archived.  Each job
+// is handled in its own lock cycle, so that a pass over a large
+// number of jobs does not hold up everything else.
+func (p *DataTracker) CleanupJobs(now time.Time) *JobCleanup {
+	res := &JobCleanup{Pruned: map[string]int{}}
+	rt := p.Request(p.Logger, jobLockMap["update"]...)
+	policy := p.JobRetention()
+	var jobs []*Job
+	rt.Do(func(d Stores) {
+		items := d("jobs").Items()
+		jobs = make([]*Job, len(items))
+		for i := range items {
+			jobs[i] = AsJob(ModelToBackend(models.Clone(items[i])))
+		}
+	})
+	toPrune := policy.prunable(jobs, now)
+	for _, j := range jobs {
+		if _, ok := toPrune[j.UUID()]; ok {
+			rt.Do(func(d Stores) {
+				// Jobs may have finished since the snapshot was taken, so
+				// decide again from what the machine's jobs are now.
+				reason, ok := policy.recheck(rt, j, now)
+				if !ok {
+					return
+				}
+				size := logSize(j.LogPath(rt), j.CompressedLogPath(rt))
+				if _, err := rt.Remove(j); err != nil {
+					rt.Debugf("Job janitor: cannot remove job %s: %v", j.UUID(), err)
+					return
+				}
+				res.Pruned[reason]++
+				res.BytesReclaimed += size
+			})
+			continue
+		}
+		if policy.CompressAge == 0 || !jobDone(j) || j.EndTime.IsZero() || now.Sub(j.EndTime) < policy.CompressAge {
+			continue
+		}
+		if _, err := os.Stat(j.LogPath(rt)); err != nil {
+			continue
+		}
+		rt.Do(func(d Stores) {
+			obj := d("jobs").Find(j.UUID())
+			if obj == nil {
+				return
+			}
+			cur := AsJob(obj)
+			if !jobDone(cur) {
+				return
+			}
+			saved, err := cur.compressLog(rt)
+			if err != nil {
+				rt.Errorf("Job janitor: cannot compress log for job %s: %v", j.UUID(), err)
+				return
+			}
+			res.LogsCompressed++
+			res.BytesReclaimed += saved
+			if !cur.Archived {
+				cur.Archived = true
+				if _, err := rt.Save(cur); err != nil {
+					rt.Debugf("Job janitor: cannot mark job %s archived: %v", j.UUID(), err)
+				}
+			}
+		})
+	}
+	return res
+}
@@ -0,0 +1,129 @@
+package backend
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/digitalrebar/provision/models"
+	"github.com/pborman/uuid"
+)
+
+func TestJobRetentionPrunable(t *testing.T) {
+	now := time.Now()
+	machine := uuid.NewRandom()
+	mkJob := func(state string, age int, current bool) *Job {
+		return &Job{Job: &models.Job{
+			Uuid:      uuid.NewRandom(),
+			Machine:   machine,
+			State:     state,
+			Current:   current,
+			StartTime: now.Add(-time.Duration(age+1) * time.Hour),
+			EndTime:   now.Add(-time.Duration(age) * time.Hour),
+		}}
+	}
+	jobs := []*Job{
+		mkJob("running", 0, true),
+		mkJob("finished", 1, false),
+		mkJob("failed", 2, false),
+		mkJob("finished", 3, false),
+		mkJob("failed", 4, false),
+		mkJob("finished", 50, false),
+	}
+	policy := &JobRetention{Count: 2, KeepFailed: true}
+	res := policy.prunable(jobs, now)
+	for i, want := range []string{"", "", "", "count", "count", "count"} {
+		if got := res[jobs[i].UUID()]; got != want {
+			t.Errorf("count: job %d: expected %q, got %q", i, want, got)
+		}
+	}
+	policy = &JobRetention{MaxAge: 24 * time.Hour, KeepFailed: false}
+	res = policy.prunable(jobs, now)
+	if len(res) != 1 || res[jobs[5].UUID()] != "age" {
+		t.Errorf("age: expected only the oldest job to be pruned, got %v", res)
+	}
+	policy = &JobRetention{}
+	if res = policy.prunable(jobs, now); len(res) != 0 {
+		t.Errorf("Empty policy should not prune anything, got %v", res)
+	}
+}
+
+func TestJobLogCompression(t *testing.T) {
+	dt := mkDT()
+	rt := dt.Request(dt.Logger, "jobs")
+	j := &Job{Job: &models.Job{Uuid: uuid.NewRandom()}}
+	line := []byte("A line that will compress rather well\n")
+	if err := j.Log(rt, bytes.NewReader(bytes.Repeat(line, 100))); err != nil {
+		t.Fatalf("Failed to write log: %v", err)
+	}
+	saved, err := j.compressLog(rt)
+	if err != nil {
+		t.Fatalf("Failed to compress log: %v", err)
+	}
+	if saved <= 0 {
+		t.Errorf("Compression should have saved space, not %d bytes", saved)
+	}
+	if _, err := os.Stat(j.LogPath(rt)); !os.IsNotExist(err) {
+		t.Errorf("Uncompressed log was not removed")
+	}
+	if err := j.Log(rt, bytes.NewReader([]byte("appended\n"))); err != nil {
+		t.Fatalf("Failed to append to compressed log: %v", err)
+	}
+	src, err := j.LogReader(rt)
+	if err != nil {
+		t.Fatalf("Failed to open log: %v", err)
+	}
+	defer src.Close()
+	buf, _ := ioutil.ReadAll(src)
+	if want := append(bytes.Repeat(line, 100), []byte("appended\n")...); !bytes.Equal(buf, want) {
+		t.Errorf("Log did not survive compression intact")
+	}
+	os.Remove(j.LogPath(rt))
+}
+
+func TestJobRetentionRecheck(t *testing.T) {
+	dt := mkDT()
+	rt := dt.Request(dt.Logger, jobLockMap["update"]...)
+	now := time.Now()
+	machine := uuid.NewRandom()
+	jobs := map[string]*models.Job{}
+	rt.Do(func(d Stores) {
+		for name, age := range map[string]int{"old-failed": 4, "older": 2, "newest": 1} {
+			j := &models.Job{
+				Uuid:      uuid.NewRandom(),
+				Previous:  uuid.NewRandom(),
+				Machine:   machine,
+				State:     "finished",
+				StartTime: now.Add(-time.Duration(age+1) * time.Hour),
+				EndTime:   now.Add(-time.Duration(age) * time.Hour),
+			}
+			if name == "old-failed" {
+				j.State = "failed"
+			}
+			if saved, err := rt.Save(j); !saved {
+				t.Fatalf("Failed to save job %s: %v", name, err)
+			}
+			jobs[name] = j
+		}
+	})
+	policy := &JobRetention{Count: 1, KeepFailed: true}
+	rt.Do(func(d Stores) {
+		if reason, ok := policy.recheck(rt, AsJob(rt.Find("jobs", jobs["older"].Key())), now); !ok || reason != "count" {
+			t.Errorf("Expected older to be pruned by count, got %q %v", reason, ok)
+		}
+		if _, ok := policy.recheck(rt, AsJob(rt.Find("jobs", jobs["old-failed"].Key())), now); ok {
+			t.Errorf("Expected the newest failed job to be kept")
+		}
+		// A newer failure makes old-failed prunable.
+		j := AsJob(rt.Find("jobs", jobs["newest"].Key()))
+		j.State = "failed"
+		if saved, err := rt.Save(j); !saved {
+			t.Fatalf("Failed to save job: %v", err)
+		}
+		if _, ok := policy.recheck(rt, AsJob(rt.Find("jobs", jobs["old-failed"].Key())), now); !ok {
+			t.Errorf("Expected old-failed to be pruned once a newer job failed")
+		}
+	})
+}
@@ -0,0 +1,40 @@
+package backend
+
+// WatchLog lets a caller follow the log of the job with the passed
+// UUID.  The returned channel receives a value whenever the log is
+// appended to or the job is saved or removed, and the returned
+// function must be called to stop watching.
+//
+// Notifications never block the writer: if a watcher has not picked
+// up the previous notification yet, it will only see one.  Watchers
+// are expected to keep track of how much of the log they have seen
+// and read whatever is new when notified, so a slow watcher just
+// reads bigger chunks.
+func (p *DataTracker) WatchLog(uuid string) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	p.logWatchMux.Lock()
+	if p.logWatchers[uuid] == nil {
+		p.logWatchers[uuid] = map[chan struct{}]struct{}{}
+	}
+	p.logWatchers[uuid][ch] = struct{}{}
+	p.logWatchMux.Unlock()
+	return ch, func() {
+		p.logWatchMux.Lock()
+		delete(p.logWatchers[uuid], ch)
+		if len(p.logWatchers[uuid]) == 0 {
+			delete(p.logWatchers, uuid)
+		}
+		p.logWatchMux.Unlock()
+	}
+}
+
+func (p *DataTracker) notifyLogWatchers(uuid string) {
+	p.logWatchMux.Lock()
+	defer p.logWatchMux.Unlock()
+	for ch := range p.logWatchers[uuid] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package backend
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/digitalrebar/provision/models"
+	"github.com/pborman/uuid"
+)
+
+func TestWatchLog(t *testing.T) {
+	dt := mkDT()
+	notify, stop := dt.WatchLog("job1")
+	other, stopOther := dt.WatchLog("job2")
+	defer stopOther()
+	dt.notifyLogWatchers("job1")
+	dt.notifyLogWatchers("job1")
+	select {
+	case <-notify:
+	default:
+		t.Fatalf("Expected a notification for job1")
+	}
+	select {
+	case <-notify:
+		t.Errorf("Expected notifications for job1 to be coalesced")
+	default:
+	}
+	select {
+	case <-other:
+		t.Errorf("Did not expect a notification for job2")
+	default:
+	}
+	stop()
+	dt.notifyLogWatchers("job1")
+	select {
+	case <-notify:
+		t.Errorf("Did not expect a notification after stopping")
+	default:
+	}
+	if _, ok := dt.logWatchers["job1"]; ok {
+		t.Errorf("Expected job1 watchers to be cleaned up")
+	}
+}
+
+func TestWaitLogReader(t *testing.T) {
+	dt := mkDT()
+	rt := dt.Request(dt.Logger, jobLockMap["update"]...)
+	running := &models.Job{Uuid: uuid.NewRandom(), Machine: uuid.NewRandom(), State: "running", Current: true}
+	finished := &models.Job{Uuid: uuid.NewRandom(), Machine: uuid.NewRandom(), State: "finished"}
+	var job, old *Job
+	rt.Do(func(d Stores) {
+		for _, j := range []*models.Job{running, finished} {
+			if saved, err := rt.Save(j); !saved {
+				t.Fatalf("Failed to save job: %v", err)
+			}
+		}
+		job, old = AsJob(rt.Find("jobs", running.Key())), AsJob(rt.Find("jobs", finished.Key()))
+	})
+	if _, err := old.WaitLogReader(rt, nil); err == nil {
+		t.Errorf("Expected a finished job without a log to fail right away")
+	}
+	type result struct {
+		src io.ReadCloser
+		err error
+	}
+	res := make(chan result, 1)
+	go func() {
+		src, err := job.WaitLogReader(rt, nil)
+		res <- result{src, err}
+	}()
+	select {
+	case r := <-res:
+		t.Fatalf("Expected to wait for the log of a running job, got %v", r.err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	writer := dt.Request(dt.Logger, "jobs")
+	if err := (&Job{Job: job.Job}).Log(writer, bytes.NewReader([]byte("started\n"))); err != nil {
+		t.Fatalf("Failed to write log: %v", err)
+	}
+	select {
+	case r := <-res:
+		if r.err != nil {
+			t.Fatalf("Failed to open log once it was written: %v", r.err)
+		}
+		buf, _ := ioutil.ReadAll(r.src)
+		r.src.Close()
+		if string(buf) != "started\n" {
+			t.Errorf("Expected the log to be read, got %q", buf)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected writing the log to stop the wait")
+	}
+	// Closing done stops waiting.
+	waiting := &Job{Job: models.Clone(job.Job).(*models.Job)}
+	waiting.Uuid = uuid.NewRandom()
+	rt.Do(func(d Stores) {
+		if saved, err := rt.Save(waiting.Job); !saved {
+			t.Fatalf("Failed to save job: %v", err)
+		}
+	})
+	done := make(chan struct{})
+	close(done)
+	if _, err := waiting.WaitLogReader(rt, done); err == nil {
+		t.Errorf("Expected closing done to stop the wait")
+	}
+}
@@ -2,6 +2,7 @@ package backend
 
 import (
 	"bytes"
+	"compress/gzip"
 	"errors"
 	"fmt"
 	"io"
@@ -70,6 +71,141 @@ func (j *Job) LogPath(rt *RequestTracker) string {
 	return filepath.Join(j.rt.dt.LogRoot, j.Uuid.String())
 }
 
+// CompressedLogPath is where the log of the job lives once the job
+// janitor has compressed it.
+func (j *Job) CompressedLogPath(rt *RequestTracker) string {
+	return j.LogPath(rt) + ".gz"
+}
+
+type gzipFileReader struct {
+	*gzip.Reader
+	f *os.File
+}
+
+func (g *gzipFileReader) Close() error {
+	g.Reader.Close()
+	return g.f.Close()
+}
+
+// LogReader opens the log of the job for reading, decompressing it
+// if needed.
+func (j *Job) LogReader(rt *RequestTracker) (io.ReadCloser, error) {
+	f, err := os.Open(j.LogPath(rt))
+	if err == nil {
+		return f, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	f, err = os.Open(j.CompressedLogPath(rt))
+	if err != nil {
+		return nil, err
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &gzipFileReader{Reader: gz, f: f}, nil
+}
+
+// WaitLogReader is LogReader for callers that follow the log.  A job
+// that is still running may not have written its log yet, so it waits
+// for the log to appear until the job ends or done is closed.
+func (j *Job) WaitLogReader(rt *RequestTracker, done <-chan struct{}) (io.ReadCloser, error) {
+	notify, stop := rt.dt.WatchLog(j.UUID())
+	defer stop()
+	for {
+		src, err := j.LogReader(rt)
+		if err == nil || !os.IsNotExist(err) {
+			return src, err
+		}
+		running := false
+		rt.Do(func(d Stores) {
+			if jo := d("jobs").Find(j.UUID()); jo != nil {
+				cur := AsJob(jo)
+				running = cur.Current && cur.State != "finished" && cur.State != "failed"
+			}
+		})
+		if !running {
+			return nil, err
+		}
+		select {
+		case <-notify:
+		case <-done:
+			return nil, err
+		}
+	}
+}
+
+// compressLog replaces the log of the job with a gzip compressed
+// copy, and returns the number of bytes saved by doing so.
+func (j *Job) compressLog(rt *RequestTracker) (int64, error) {
+	src, err := os.Open(j.LogPath(rt))
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+	fi, err := src.Stat()
+	if err != nil {
+		return 0, err
+	}
+	tmpName := j.CompressedLogPath(rt) + ".part"
+	dst, err := os.OpenFile(tmpName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return 0, err
+	}
+	gz := gzip.NewWriter(dst)
+	_, err = io.Copy(gz, src)
+	if err == nil {
+		err = gz.Close()
+	}
+	if err == nil {
+		err = dst.Sync()
+	}
+	dst.Close()
+	if err == nil {
+		err = os.Rename(tmpName, j.CompressedLogPath(rt))
+	}
+	if err != nil {
+		os.Remove(tmpName)
+		return 0, err
+	}
+	os.Remove(j.LogPath(rt))
+	cfi, err := os.Stat(j.CompressedLogPath(rt))
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size() - cfi.Size(), nil
+}
+
+// uncompressLog turns a compressed log back into a plain one so that
+// it can be appended to.
+func (j *Job) uncompressLog(rt *RequestTracker) error {
+	src, err := j.LogReader(rt)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if _, ok := src.(*os.File); ok {
+		// Not compressed
+		return nil
+	}
+	dst, err := os.OpenFile(j.LogPath(rt), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(j.LogPath(rt))
+		return err
+	}
+	return os.Remove(j.CompressedLogPath(rt))
+}
+
 func (j *Job) SaveClean() store.KeySaver {
 	mod := *j.Job
 	mod.ClearValidation()
@@ -462,6 +598,10 @@ func (j *Job) BeforeSave() error {
 }
 
 func (j *Job) AfterSave() {
+	j.rt.dt.notifyLogWatchers(j.UUID())
+	if j.State == "finished" && j.oldState != j.State {
+		j.rt.dt.bootProgress(j.Machine.String(), time.Now())
+	}
 	if !j.Current {
 		return
 	}
@@ -572,6 +712,8 @@ func writePanicSafe(name string, writefn func(net.IP) (io.Reader, error), addr n
 
 func (j *Job) AfterDelete() {
 	os.Remove(j.LogPath(j.rt))
+	os.Remove(j.CompressedLogPath(j.rt))
+	j.rt.dt.notifyLogWatchers(j.UUID())
 }
 
 func (j *Job) Log(rt *RequestTracker, src io.Reader) error {
@@ -579,17 +721,25 @@ func (j *Job) Log(rt *RequestTracker, src io.Reader) error {
 		j.setRT(rt)
 		defer j.clearRT()
 	}
+	if _, err := os.Stat(j.CompressedLogPath(rt)); err == nil {
+		if err := j.uncompressLog(rt); err != nil {
+			j.rt.Errorf("Job %s: error uncompressing log: %v", j.UUID(), err)
+			return err
+		}
+	}
 	f, err := os.OpenFile(j.LogPath(rt), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
 	if err != nil {
 		fmt.Printf("Umm err: %v\n", err)
 		return err
 	}
+	defer f.Close()
 	cnt, err := io.Copy(f, src)
 	if err != nil {
 		j.rt.Errorf("Job %s: error writing log: %v", j.UUID(), err)
 		return err
 	}
 	j.rt.Debugf("Job %s: %d bytes appended to log", j.UUID(), cnt)
+	j.rt.dt.notifyLogWatchers(j.UUID())
 	return nil
 }
 
@@ -599,7 +749,7 @@ var jobLockMap = map[string][]string{
 	"update":  {"stages", "bootenvs", "jobs", "machines", "tasks", "profiles", "workflows", "params"},
 	"patch":   {"stages", "bootenvs", "jobs", "machines", "tasks", "profiles", "workflows", "params"},
 	"delete":  {"machines", "jobs"},
-	"actions": {"stages", "jobs", "machines", "tasks", "profiles", "bootenvs", "params", "workflows"},
+	"actions": {"stages", "jobs", "machines", "tasks", "profiles", "bootenvs", "params", "workflows", "subnets", "reservations", "tenants"},
 }
 
 func (j *Job) Locks(action string) []string {
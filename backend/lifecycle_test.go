@@ -0,0 +1,100 @@
+package backend
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/digitalrebar/provision/models"
+	"github.com/pborman/uuid"
+)
+
+func TestMachineLifecycleAndLock(t *testing.T) {
+	dt := mkDT()
+	alice := dt.Request(dt.Logger.Fork().SetPrincipal("user:alice"))
+	bob := dt.Request(dt.Logger.Fork().SetPrincipal("user:bob"))
+	m := &Machine{}
+	alice.AllLocked(func(d Stores) {
+		Fill(m)
+		m.Uuid = uuid.NewRandom()
+		m.Name = "lifecycle.example.com"
+		if created, err := alice.Create(m); !created {
+			t.Fatalf("Failed to create machine: %v", err)
+		}
+	})
+	machine := func() *Machine {
+		var res *Machine
+		alice.AllLocked(func(d Stores) {
+			res = AsMachine(alice.Find("machines", m.UUID()))
+		})
+		return res
+	}
+	update := func(rt *RequestTracker, desc string, pass, force bool, change func(*Machine)) {
+		t.Helper()
+		rt.AllLocked(func(d Stores) {
+			c := AsMachine(rt.Find("machines", m.UUID()))
+			change(c)
+			if force {
+				c.ForceChange()
+			}
+			saved, err := rt.Update(c)
+			if saved != pass {
+				t.Errorf("%s: expected success %v, got %v: %v", desc, pass, saved, err)
+			}
+		})
+	}
+	if l := machine().LifecycleState(); l != models.LifecycleDiscovered {
+		t.Errorf("Expected new machine to be discovered, not %s", l)
+	}
+	update(alice, "Move straight to allocated", false, false, func(c *Machine) { c.Lifecycle = models.LifecycleAllocated })
+	update(alice, "Move to an invalid state", false, true, func(c *Machine) { c.Lifecycle = "bogus" })
+	update(alice, "Move to ready", true, false, func(c *Machine) { c.Lifecycle = models.LifecycleReady })
+	update(alice, "Move to allocated", true, false, func(c *Machine) { c.Lifecycle = models.LifecycleAllocated })
+	update(alice, "Retire while allocated", false, false, func(c *Machine) { c.Lifecycle = models.LifecycleRetired })
+	update(alice, "Force retirement", true, true, func(c *Machine) { c.Lifecycle = models.LifecycleRetired })
+
+	update(alice, "Lock machine", true, false, func(c *Machine) {
+		c.Lock = &models.MachineLock{Owner: "user:bob", Reason: "firmware update"}
+	})
+	if l := machine().Lock; l == nil || l.Owner != "user:alice" {
+		t.Fatalf("Expected lock owned by user:alice, got %v", l)
+	}
+	update(bob, "Change BootEnv while locked", false, false, func(c *Machine) { c.BootEnv = "discovery" })
+	update(bob, "Unlock someone else's lock", false, false, func(c *Machine) { c.Lock = nil })
+	update(bob, "Change Description while locked", true, false, func(c *Machine) { c.Description = "still editable" })
+	update(bob, "Force unlock", false, true, func(c *Machine) { c.Lock = nil })
+	update(bob, "Force BootEnv change", false, true, func(c *Machine) { c.BootEnv = "discovery" })
+	update(bob, "Force unlock with the claim", true, true, func(c *Machine) {
+		c.Lock = nil
+		c.ForceUnlock()
+	})
+	if l := machine().Lock; l != nil {
+		t.Errorf("Expected forced unlock to remove the lock, got %v", l)
+	}
+
+	update(alice, "Lock machine briefly", true, false, func(c *Machine) {
+		c.Lock = &models.MachineLock{Reason: "reboot", Expires: time.Now().Add(-time.Second)}
+	})
+	update(bob, "Replace expired lock", true, false, func(c *Machine) {
+		c.Lock = &models.MachineLock{Reason: "rack move"}
+	})
+	if l := machine().Lock; l == nil || l.Owner != "user:bob" {
+		t.Errorf("Expected lock taken over by user:bob, got %v", l)
+	}
+	bob.AllLocked(func(d Stores) {
+		ref := &Machine{}
+		locked, err := ref.Indexes()["Locked"].Fill("true")
+		if err != nil {
+			t.Fatalf("Failed to fill Locked: %v", err)
+		}
+		items := d("machines").Items()
+		idx := ref.Indexes()["Locked"]
+		gte, _ := idx.Tests(locked)
+		if len(items) != 1 || !gte(items[0]) {
+			t.Errorf("Expected machine to be in the Locked index")
+		}
+		if _, err := ref.Indexes()["Lifecycle"].Fill("bogus"); err == nil || !strings.Contains(err.Error(), "Invalid Lifecycle") {
+			t.Errorf("Expected bogus Lifecycle to be rejected, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,135 @@
+package backend
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/digitalrebar/provision/models"
+)
+
+// seenInterval is how long hearing from a Machine again on the same
+// channel is not worth saving.  It keeps busy channels like the file
+// servers from saving a Machine for every file it fetches.
+const seenInterval = time.Minute
+
+// seenTracker remembers when channel/key pairs were last recorded.
+type seenTracker struct {
+	sync.Mutex
+	last map[string]time.Time
+}
+
+func newSeenTracker() *seenTracker {
+	return &seenTracker{last: map[string]time.Time{}}
+}
+
+// due returns whether key has not been recorded within seenInterval
+// of now, and if so notes that it has been now.
+func (s *seenTracker) due(key string, now time.Time) bool {
+	s.Lock()
+	defer s.Unlock()
+	if last, ok := s.last[key]; ok && now.Sub(last) < seenInterval {
+		return false
+	}
+	if len(s.last) >= 8192 {
+		for k, v := range s.last {
+			if now.Sub(v) >= seenInterval {
+				delete(s.last, k)
+			}
+		}
+	}
+	s.last[key] = now
+	return true
+}
+
+// lastSeen returns when m was last heard from, or the zero time if it
+// never has been.
+func lastSeen(m *Machine) time.Time {
+	if m.LastSeen == nil {
+		return time.Time{}
+	}
+	return *m.LastSeen
+}
+
+// saveSeen records that the Machine uuid was heard from on channel
+// via from addr at now.  It uses Save so that the Machine's OnChange
+// checks do not run.
+func (p *DataTracker) saveSeen(uuid, via string, addr net.IP, now time.Time) {
+	ref := &Machine{}
+	rt := p.Request(p.Logger, ref.Locks("update")...)
+	rt.Do(func(d Stores) {
+		obj := rt.Find("machines", uuid)
+		if obj == nil {
+			return
+		}
+		m := AsMachine(obj)
+		if m.Seen == nil {
+			m.Seen = map[string]models.MachineSeen{}
+		}
+		m.Seen[via] = models.MachineSeen{Time: now, Address: addr}
+		if !now.Before(lastSeen(m)) {
+			m.LastSeen = &now
+			m.LastSeenVia = via
+		}
+		if saved, err := rt.Save(m); !saved {
+			rt.Debugf("Machine %s: failed to record it was seen via %s: %v", uuid, via, err)
+		}
+	})
+}
+
+// MachineSeen records that the Machine uuid was heard from on channel
+// via from addr.  It must not be called with any locks held.
+func (p *DataTracker) MachineSeen(uuid, via string, addr net.IP) {
+	now := time.Now()
+	if uuid == "" || !p.seen.due(via+"/"+uuid, now) {
+		return
+	}
+	p.saveSeen(uuid, via, addr, now)
+}
+
+// MacSeen records that the Machine with the MAC address mac was heard
+// from on channel via from addr.  It must not be called with any
+// locks held.
+func (p *DataTracker) MacSeen(via, mac string, addr net.IP) {
+	p.MachineSeen(p.MacToMachineUUID(mac), via, addr)
+}
+
+// addressSeen records that the Machine with the address addr was
+// heard from on channel via.
+func (p *DataTracker) addressSeen(via string, addr net.IP) {
+	if addr == nil || addr.IsUnspecified() || addr.IsLoopback() {
+		return
+	}
+	now := time.Now()
+	if !p.seen.due(via+"/"+addr.String(), now) {
+		return
+	}
+	ref := &Machine{}
+	var uuid string
+	rt := p.Request(p.Logger, "machines")
+	rt.Do(func(d Stores) {
+		if obj := rt.FindByIndex("machines", ref.Indexes()["Address"], addr.String()); obj != nil {
+			uuid = obj.Key()
+		}
+	})
+	if uuid != "" {
+		p.saveSeen(uuid, via, addr, now)
+	}
+}
+
+// MachinesNotSeen returns the addresses of the Machines with an
+// address that have not been heard from since cutoff, keyed by UUID.
+func (p *DataTracker) MachinesNotSeen(cutoff time.Time) map[string]net.IP {
+	res := map[string]net.IP{}
+	rt := p.Request(p.Logger, "machines")
+	rt.Do(func(d Stores) {
+		for _, obj := range d("machines").Items() {
+			m := AsMachine(obj)
+			if m.Address == nil || m.Address.IsUnspecified() || lastSeen(m).After(cutoff) {
+				continue
+			}
+			res[m.UUID()] = m.Address
+		}
+	})
+	return res
+}
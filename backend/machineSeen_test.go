@@ -0,0 +1,91 @@
+package backend
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/digitalrebar/provision/backend/index"
+	"github.com/pborman/uuid"
+)
+
+func TestMachineSeen(t *testing.T) {
+	dt := mkDT()
+	rt := dt.Request(dt.Logger)
+	seen, unseen := &Machine{}, &Machine{}
+	rt.AllLocked(func(d Stores) {
+		for name, m := range map[string]*Machine{"seen": seen, "unseen": unseen} {
+			Fill(m)
+			m.Uuid = uuid.NewRandom()
+			m.Name = name + ".example.com"
+			if m == seen {
+				m.Address = net.ParseIP("192.168.124.31")
+				m.HardwareAddrs = []string{"52:54:00:12:34:56"}
+			}
+			if created, err := rt.Create(m); !created {
+				t.Fatalf("Failed to create machine %s: %v", name, err)
+			}
+		}
+	})
+	machine := func(m *Machine) *Machine {
+		var res *Machine
+		rt.AllLocked(func(d Stores) {
+			res = AsMachine(rt.Find("machines", m.UUID()))
+		})
+		return res
+	}
+	start := time.Now()
+	dt.MacSeen("dhcp", "52:54:00:12:34:56", seen.Address)
+	dt.addressSeen("tftp", seen.Address)
+	m := machine(seen)
+	if m.LastSeen == nil || m.LastSeen.Before(start) || m.LastSeenVia != "tftp" {
+		t.Errorf("Expected machine last seen via tftp, got %v via %s", m.LastSeen, m.LastSeenVia)
+	}
+	for _, via := range []string{"dhcp", "tftp"} {
+		if s, ok := m.Seen[via]; !ok || !s.Address.Equal(seen.Address) {
+			t.Errorf("Expected machine seen via %s from %s, got %v", via, seen.Address, m.Seen)
+		}
+	}
+	// Hearing from it again on the same channel right away is not saved.
+	last := *m.LastSeen
+	dt.MachineSeen(seen.UUID(), "tftp", seen.Address)
+	if m = machine(seen); !m.LastSeen.Equal(last) {
+		t.Errorf("Expected repeated tftp contact to be ignored")
+	}
+	dt.MachineSeen(seen.UUID(), "heartbeat", nil)
+	if m = machine(seen); m.LastSeenVia != "heartbeat" {
+		t.Errorf("Expected machine last seen via heartbeat, not %s", m.LastSeenVia)
+	}
+	// Updates from the API cannot change what dr-provision saw.
+	rt.AllLocked(func(d Stores) {
+		m.LastSeen = nil
+		m.Seen = nil
+		m.Description = "updated"
+		if saved, err := rt.Update(m); !saved {
+			t.Errorf("Failed to update machine: %v", err)
+		}
+	})
+	if m = machine(seen); m.LastSeen == nil || len(m.Seen) != 3 {
+		t.Errorf("Expected update to keep seen data, got %v, %v", m.LastSeen, m.Seen)
+	}
+
+	rt.AllLocked(func(d Stores) {
+		ref := &Machine{}
+		res, err := index.All(
+			index.Sort(ref.Indexes()["LastSeen"]),
+			index.Lt(start.Format(time.RFC3339)))(rt.Index("machines"))
+		if err != nil {
+			t.Fatalf("Failed to filter on LastSeen: %v", err)
+		}
+		if res.Count() != 1 || res.Items()[0].Key() != unseen.UUID() {
+			t.Errorf("Expected only %s to not be seen since %s", unseen.UUID(), start)
+		}
+	})
+	notSeen := dt.MachinesNotSeen(time.Now().Add(-time.Hour))
+	if len(notSeen) != 0 {
+		t.Errorf("Expected no machines with addresses to need pinging, got %v", notSeen)
+	}
+	if notSeen = dt.MachinesNotSeen(time.Now().Add(time.Hour)); len(notSeen) != 1 || !notSeen[seen.UUID()].Equal(seen.Address) {
+		t.Errorf("Expected %s to need pinging, got %v", seen.UUID(), notSeen)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"path"
 	"reflect"
 	"strings"
+	"time"
 
 	"github.com/digitalrebar/provision/backend/index"
 	"github.com/digitalrebar/provision/models"
@@ -24,6 +25,11 @@ type Machine struct {
 	// used during AfterSave() and AfterRemove() to handle boot environment changes.
 	oldBootEnv, oldStage, oldWorkflow      string
 	changeStageAllowed, inCreate, inRunner bool
+	// set by Pools, which are the only way to change Allocation.
+	allocating bool
+	// set by callers allowed to change what someone else's Lock
+	// protects.
+	unlockForced bool
 
 	toDeRegister, toRegister renderers
 }
@@ -40,6 +46,25 @@ func (n *Machine) AllowStageChange() {
 	n.changeStageAllowed = true
 }
 
+// unlockForcer is implemented by models that can be forced past a
+// Lock.
+type unlockForcer interface {
+	ForceUnlock()
+	UnlockForced() bool
+}
+
+// ForceUnlock lets a forced change go through even when the Machine
+// is locked by someone else.  Only callers holding the
+// machines:forceUnlock claim may use it.
+func (n *Machine) ForceUnlock() {
+	n.unlockForced = true
+}
+
+// UnlockForced returns whether ForceUnlock was called.
+func (n *Machine) UnlockForced() bool {
+	return n.unlockForced
+}
+
 func (n *Machine) SaveClean() store.KeySaver {
 	mod := *n.Machine
 	mod.ClearValidation()
@@ -212,6 +237,81 @@ func (n *Machine) Indexes() map[string]index.Maker {
 			}
 			return res, nil
 		})
+	res["Lifecycle"] = index.Make(
+		false,
+		"string",
+		func(i, j models.Model) bool { return fix(i).LifecycleState() < fix(j).LifecycleState() },
+		func(ref models.Model) (gte, gt index.Test) {
+			refLifecycle := fix(ref).LifecycleState()
+			return func(s models.Model) bool {
+					return fix(s).LifecycleState() >= refLifecycle
+				},
+				func(s models.Model) bool {
+					return fix(s).LifecycleState() > refLifecycle
+				}
+		},
+		func(s string) (models.Model, error) {
+			if !models.ValidLifecycle(s) {
+				return nil, fmt.Errorf("Invalid Lifecycle: %s", s)
+			}
+			m := fix(n.New())
+			m.Lifecycle = s
+			return m, nil
+		})
+	res["Locked"] = index.Make(
+		false,
+		"boolean",
+		func(i, j models.Model) bool {
+			now := time.Now()
+			return !fix(i).Lock.Active(now) && fix(j).Lock.Active(now)
+		},
+		func(ref models.Model) (gte, gt index.Test) {
+			locked := fix(ref).Lock.Active(time.Now())
+			return func(s models.Model) bool {
+					v := fix(s).Lock.Active(time.Now())
+					return v || (v == locked)
+				},
+				func(s models.Model) bool {
+					return fix(s).Lock.Active(time.Now()) && !locked
+				}
+		},
+		func(s string) (models.Model, error) {
+			res := fix(n.New())
+			switch s {
+			case "true":
+				res.Lock = &models.MachineLock{}
+			case "false":
+				res.Lock = nil
+			default:
+				return nil, errors.New("Locked must be true or false")
+			}
+			return res, nil
+		})
+	res["LastSeen"] = index.Make(
+		false,
+		"dateTime",
+		func(i, j models.Model) bool {
+			return lastSeen(fix(i)).Before(lastSeen(fix(j)))
+		},
+		func(ref models.Model) (gte, gt index.Test) {
+			refTime := lastSeen(fix(ref))
+			return func(s models.Model) bool {
+					cmpTime := lastSeen(fix(s))
+					return refTime.Equal(cmpTime) || cmpTime.After(refTime)
+				},
+				func(s models.Model) bool {
+					return lastSeen(fix(s)).After(refTime)
+				}
+		},
+		func(s string) (models.Model, error) {
+			parsedTime, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				return nil, err
+			}
+			m := fix(n.New())
+			m.LastSeen = &parsedTime
+			return m, nil
+		})
 	return res
 }
 
@@ -243,7 +343,13 @@ func (n *Machine) ParameterMaker(rt *RequestTracker, parameter string) (index.Ma
 				}
 		},
 		func(s string) (models.Model, error) {
-			obj, err := GeneralValidateParam(param, s)
+			var obj interface{}
+			var err error
+			if param.Computed {
+				obj, err = param.computedFilterValue(s)
+			} else {
+				obj, err = GeneralValidateParam(param, s)
+			}
 			if err != nil {
 				return nil, err
 			}
@@ -269,6 +375,23 @@ func (n *Machine) ShortName() string {
 	return n.Name[:idx]
 }
 
+// arch returns the architecture of the Machine.  The machine/arch
+// param wins over the Arch field, which is filled in from DHCP.
+func (n *Machine) arch(rt *RequestTracker) string {
+	if v, ok := rt.GetParam(n, "machine/arch", true, false); ok {
+		if s, ok := v.(string); ok && s != "" {
+			if a, ok := models.CanonicalArch(s); ok {
+				return a
+			}
+			return s
+		}
+	}
+	if a, ok := models.CanonicalArch(n.Arch); ok {
+		return a
+	}
+	return n.Arch
+}
+
 func (n *Machine) Path() string {
 	return path.Join(n.Prefix(), n.UUID())
 }
@@ -291,6 +414,7 @@ func (n *Machine) New() store.KeySaver {
 		if n.Machine != nil && n.ChangeForced() {
 			res.ForceChange()
 		}
+		res.unlockForced = n.unlockForced
 	}
 	return res
 }
@@ -327,6 +451,9 @@ func (n *Machine) OnCreate() error {
 	}
 	n.validateChangeStage(oldm, e)
 	n.validateChangeEnv(oldm, e)
+	if n.Lock != nil {
+		n.Lock.Owner = n.rt.Principal()
+	}
 	if e.ContainsError() {
 		return e
 	}
@@ -777,8 +904,36 @@ func (n *Machine) resetCurrentTask(oldm *Machine, e *models.Error) {
 	n.rt.Infof("Resetting CurrentTask from %d to %d", oldm.CurrentTask, n.CurrentTask)
 }
 
+// validateLock keeps anyone but the holder of an active lock on oldm
+// from changing what the lock protects, and makes whoever sets a lock
+// its owner.  Forcing the change is not enough to get past the lock;
+// it must also have been allowed with ForceUnlock.
+func (n *Machine) validateLock(oldm *Machine, e *models.Error) {
+	principal := n.rt.Principal()
+	if oldm.LockedAgainst(principal, time.Now()) && !(n.ChangeForced() && n.unlockForced) {
+		for _, f := range []struct{ name, old, new string }{
+			{"Workflow", oldm.Workflow, n.Workflow},
+			{"Stage", oldm.Stage, n.Stage},
+			{"BootEnv", oldm.BootEnv, n.BootEnv},
+		} {
+			if f.old != f.new {
+				e.Errorf("Cannot change %s: locked by %s: %s", f.name, oldm.Lock.Owner, oldm.Lock.Reason)
+			}
+		}
+		if !oldm.Lock.Equal(n.Lock) {
+			e.Errorf("Cannot change Lock: locked by %s: %s", oldm.Lock.Owner, oldm.Lock.Reason)
+		}
+		return
+	}
+	if n.Lock != nil && !oldm.Lock.Equal(n.Lock) {
+		n.Lock.Owner = principal
+	}
+}
+
 func (n *Machine) OnChange(oldThing store.KeySaver) error {
 	oldm := AsMachine(oldThing)
+	// dr-provision keeps track of when it last saw the Machine.
+	n.LastSeen, n.LastSeenVia, n.Seen = oldm.LastSeen, oldm.LastSeenVia, oldm.Seen
 	n.oldBootEnv = oldm.BootEnv
 	n.oldStage = oldm.Stage
 	n.oldWorkflow = oldm.Workflow
@@ -796,6 +951,23 @@ func (n *Machine) OnChange(oldThing store.KeySaver) error {
 		e.Errorf("Cannot change CurrentTask from %d to %d", oldm.CurrentTask, n.CurrentTask)
 		return e
 	}
+	n.validateLock(oldm, e)
+	if !n.allocating {
+		n.Allocation = oldm.Allocation
+		if n.Allocation != nil && n.LifecycleState() != models.LifecycleAllocated {
+			if n.ChangeForced() {
+				n.Allocation = nil
+			} else {
+				e.Errorf("Cannot change Lifecycle: allocated from pool %s to %s", n.Allocation.Pool, n.Allocation.Owner)
+			}
+		}
+	}
+	if from, to := oldm.LifecycleState(), n.LifecycleState(); !models.LifecycleTransition(from, to) && !n.ChangeForced() {
+		e.Errorf("Cannot change Lifecycle from %s to %s", from, to)
+	}
+	if e.ContainsError() {
+		return e
+	}
 	newStage, newEnv := n.validateChangeWorkflow(oldm, e)
 	if newStage != "" {
 		n.Stage = newStage
@@ -805,6 +977,10 @@ func (n *Machine) OnChange(oldThing store.KeySaver) error {
 	}
 	n.validateChangeStage(oldm, e)
 	n.validateChangeEnv(oldm, e)
+	if oldm.BootEnv != n.BootEnv || (!oldm.Runnable && n.Runnable) {
+		n.ProvisioningError = ""
+		n.rt.dt.bootWatch.forget(n.UUID())
+	}
 	if n.Workflow == "" {
 		n.oldOnChange(oldm, e)
 	} else {
@@ -880,7 +1056,7 @@ func AsMachines(o []models.Model) []*Machine {
 }
 
 var machineLockMap = map[string][]string{
-	"get":     {"stages", "bootenvs", "machines", "profiles", "params", "workflows"},
+	"get":     {"stages", "bootenvs", "machines", "profiles", "params", "workflows", "subnets", "reservations", "tenants"},
 	"create":  {"stages", "bootenvs", "machines", "tasks", "profiles", "templates", "params", "workflows"},
 	"update":  {"stages", "bootenvs", "machines", "tasks", "profiles", "templates", "params", "workflows"},
 	"patch":   {"stages", "bootenvs", "machines", "tasks", "profiles", "templates", "params", "workflows"},
@@ -0,0 +1,83 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/digitalrebar/store"
+)
+
+// storeEmpty returns true if st and all of its sub stores have no
+// keys.
+func storeEmpty(st store.Store) (bool, error) {
+	keys, err := st.Keys()
+	if err != nil {
+		return false, err
+	}
+	if len(keys) > 0 {
+		return false, nil
+	}
+	for _, sub := range st.Subs() {
+		if empty, err := storeEmpty(sub); err != nil || !empty {
+			return empty, err
+		}
+	}
+	return true, nil
+}
+
+// CopyStore copies every key of src and of all its sub stores into
+// dst, creating sub stores in dst as needed, and returns the number
+// of items copied.  Values are copied as the JSON they encode to, so
+// they survive a change of codec between the stores without numbers
+// losing precision.  dst must be empty,
+// to keep a half finished copy from being mixed with live data.
+//
+// CopyStore is meant to be used to migrate the writable store or the
+// secrets store of a stopped dr-provision to a different backend.
+func CopyStore(dst, src store.Store) (int, error) {
+	if empty, err := storeEmpty(dst); err != nil {
+		return 0, err
+	} else if !empty {
+		return 0, fmt.Errorf("Refusing to copy into %s store that already has data", dst.Type())
+	}
+	if smeta, ok := src.(store.MetaSaver); ok {
+		if dmeta, ok := dst.(store.MetaSaver); ok {
+			if err := dmeta.SetMetaData(smeta.MetaData()); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return copyStore(dst, src)
+}
+
+func copyStore(dst, src store.Store) (int, error) {
+	count := 0
+	keys, err := src.Keys()
+	if err != nil {
+		return count, err
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		var val json.RawMessage
+		if err := src.Load(key, &val); err != nil {
+			return count, fmt.Errorf("Failed to load %s: %v", key, err)
+		}
+		if err := dst.Save(key, val); err != nil {
+			return count, fmt.Errorf("Failed to save %s: %v", key, err)
+		}
+		count++
+	}
+	for name, sub := range src.Subs() {
+		dSub, err := dst.MakeSub(name)
+		if err != nil {
+			return count, err
+		}
+		subCount, err := copyStore(dSub, sub)
+		count += subCount
+		if err != nil {
+			return count, fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	return count, nil
+}
@@ -0,0 +1,65 @@
+package backend
+
+import (
+	"testing"
+
+	"github.com/digitalrebar/provision/models"
+	"github.com/digitalrebar/store"
+)
+
+func TestCopyStore(t *testing.T) {
+	src, _ := store.Open("memory:///")
+	dst, _ := store.Open("memory:///")
+	machines, _ := src.MakeSub("machines")
+	profiles, _ := src.MakeSub("profiles")
+	machines.Save("m1", &models.Machine{Name: "m1"})
+	profiles.Save("p1", &models.Profile{Name: "p1", Params: map[string]interface{}{"a": 1}})
+	profiles.Save("p2", &models.Profile{Name: "p2"})
+	src.Save("machines-m1", []byte("secret"))
+	count, err := CopyStore(dst, src)
+	if err != nil {
+		t.Fatalf("Unexpected error copying store: %v", err)
+	}
+	if count != 4 {
+		t.Errorf("Expected 4 items to be copied, not %d", count)
+	}
+	p1 := &models.Profile{}
+	if sub := dst.GetSub("profiles"); sub == nil {
+		t.Errorf("profiles were not copied")
+	} else if err := sub.Load("p1", p1); err != nil || p1.Name != "p1" || p1.Params["a"] == nil {
+		t.Errorf("p1 was not copied intact: %v: %#v", err, p1)
+	}
+	var secret []byte
+	if err := dst.Load("machines-m1", &secret); err != nil || string(secret) != "secret" {
+		t.Errorf("Secret was not copied intact: %v: %s", err, string(secret))
+	}
+	if _, err := CopyStore(dst, src); err == nil {
+		t.Errorf("Copying into a store that has data should have failed")
+	}
+}
+
+func TestCopyStoreKeepsBigNumbers(t *testing.T) {
+	src, _ := store.Open("memory:///")
+	dst, _ := store.Open("memory:///")
+	const big = int64(1<<53 + 1)
+	src.Save("p1", &models.Profile{Name: "p1", Params: map[string]interface{}{"big": big}})
+	if _, err := CopyStore(dst, src); err != nil {
+		t.Fatalf("Unexpected error copying store: %v", err)
+	}
+	dump, err := dumpStore(src)
+	if err != nil {
+		t.Fatalf("Unexpected error dumping store: %v", err)
+	}
+	for name, st := range map[string]store.Store{"copy": dst, "dump": nil} {
+		if st == nil {
+			st, _ = store.Open("memory:///")
+			if err := dump.load(st); err != nil {
+				t.Fatalf("Unexpected error loading dump: %v", err)
+			}
+		}
+		var p1 struct{ Params map[string]int64 }
+		if err := st.Load("p1", &p1); err != nil || p1.Params["big"] != big {
+			t.Errorf("%s: expected %d to survive, got %d: %v", name, big, p1.Params["big"], err)
+		}
+	}
+}
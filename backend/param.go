@@ -123,6 +123,10 @@ func (p *Param) ValidateValue(val interface{}, key []byte) error {
 	if !p.Useable() {
 		return p.MakeError(422, ValidationError, p)
 	}
+	if p.Computed {
+		// The value is checked against the Schema when it is computed.
+		return models.ParseComputedValue(p.Name, val)
+	}
 	rv := val
 	if p.Secure {
 		sd := &models.SecureData{}
@@ -1,9 +1,15 @@
 package backend
 
 import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
 	"testing"
 
+	"github.com/digitalrebar/logger"
 	"github.com/digitalrebar/provision/models"
+	"github.com/pborman/uuid"
 )
 
 func TestParamsCrud(t *testing.T) {
@@ -41,3 +47,74 @@ func TestParamsCrud(t *testing.T) {
 		}
 	})
 }
+
+func TestExplainParam(t *testing.T) {
+	site := &models.Content{
+		Meta: models.ContentMetaData{Name: "site", Version: "v1.0.0"},
+		Sections: models.Sections{
+			"params": models.Section{
+				"ntp": &models.Param{
+					Name:   "ntp",
+					Schema: map[string]interface{}{"type": "string", "default": "pool.ntp.org"},
+				},
+			},
+			"profiles": models.Section{
+				"site": &models.Profile{Name: "site", Params: map[string]interface{}{"ntp": "ntp.site"}},
+				"rack": &models.Profile{Name: "rack", Params: map[string]interface{}{"ntp": "ntp.rack"}},
+			},
+			"stages": models.Section{
+				"install": &models.Stage{Name: "install", Profiles: []string{"rack"}},
+			},
+		},
+	}
+	l := logger.New(log.New(os.Stdout, "explain", 0)).Log("backend")
+	dt, err := contentTestTracker(tmpDir, l, []*models.Content{site})
+	if err != nil {
+		t.Fatalf("Failed to load content: %v", err)
+	}
+	rt := dt.Request(dt.Logger)
+	var res *models.ParamExplanation
+	rt.AllLocked(func(d Stores) {
+		global := AsProfile(rt.Find("profiles", "global"))
+		global.Params = map[string]interface{}{"ntp": "ntp.global"}
+		if _, err := rt.Update(global); err != nil {
+			t.Fatalf("Failed to update global profile: %v", err)
+		}
+		m := &Machine{}
+		Fill(m)
+		m.Uuid = uuid.NewRandom()
+		m.Name = "explained.example.com"
+		m.Profiles = []string{"site"}
+		m.Stage = "install"
+		m.Params = map[string]interface{}{"ntp": "ntp.machine"}
+		if created, err := rt.Create(m); !created {
+			t.Fatalf("Failed to create machine: %v", err)
+		}
+		res = rt.ExplainParam(m, "ntp", false)
+		val, _ := rt.GetParam(m, "ntp", true, false)
+		if val != res.Value {
+			t.Errorf("Explained value %v does not match GetParam value %v", res.Value, val)
+		}
+	})
+	if !res.Found || res.Value != "ntp.machine" {
+		t.Errorf("Expected ntp.machine to be found, got %v", res.Value)
+	}
+	expect := []string{
+		"machines object writable ntp.machine true",
+		"profiles:site profile content-site ntp.site false",
+		"profiles:rack stage:install content-site ntp.rack false",
+		"profiles:global global writable ntp.global false",
+		"params:ntp default content-site pool.ntp.org false",
+	}
+	got := []string{}
+	for _, src := range res.Sources {
+		prefix := src.Prefix
+		if prefix != "machines" {
+			prefix += ":" + src.Key
+		}
+		got = append(got, fmt.Sprintf("%s %s %s %v %v", prefix, src.Via, src.Layer, src.Value, src.Used))
+	}
+	if strings.Join(got, "\n") != strings.Join(expect, "\n") {
+		t.Errorf("Expected sources:\n%s\nGot:\n%s", strings.Join(expect, "\n"), strings.Join(got, "\n"))
+	}
+}
@@ -0,0 +1,441 @@
+package backend
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/digitalrebar/provision/backend/index"
+	"github.com/digitalrebar/provision/models"
+	"github.com/digitalrebar/store"
+)
+
+// Pool is the backend model wrapper for Pool.
+// This struct also includes validation helpers.
+type Pool struct {
+	*models.Pool
+	validate
+}
+
+// SetReadOnly is a helper function to set the ReadOnly flag.
+func (p *Pool) SetReadOnly(b bool) {
+	p.ReadOnly = b
+}
+
+// SaveClean is a helper function to run the model version's
+// ClearValidation function before converting back to
+// an object that can be stored in the backend.
+func (p *Pool) SaveClean() store.KeySaver {
+	mod := *p.Pool
+	mod.ClearValidation()
+	return toBackend(&mod, p.rt)
+}
+
+// AsPool casts a models.Model interface to *Pool (helper function)
+func AsPool(o models.Model) *Pool {
+	return o.(*Pool)
+}
+
+// AsPools converts a list of models.Model to a list of *Pool
+// (helper function)
+func AsPools(o []models.Model) []*Pool {
+	res := make([]*Pool, len(o))
+	for i := range o {
+		res[i] = AsPool(o[i])
+	}
+	return res
+}
+
+// New creates a new empty instance of Pool.
+// The ForceChanged and RT fields are propogated.
+func (p *Pool) New() store.KeySaver {
+	res := &Pool{Pool: &models.Pool{}}
+	if p.Pool != nil && p.ChangeForced() {
+		res.ForceChange()
+	}
+	res.rt = p.rt
+	res.Fill()
+	return res
+}
+
+// Indexes returns a map of the indexes allowed for Pool objects.
+func (p *Pool) Indexes() map[string]index.Maker {
+	fix := AsPool
+	res := index.MakeBaseIndexes(p)
+	res["Name"] = index.Make(
+		true,
+		"string",
+		func(i, j models.Model) bool {
+			return fix(i).Name < fix(j).Name
+		},
+		func(ref models.Model) (gte, gt index.Test) {
+			name := fix(ref).Name
+			return func(s models.Model) bool {
+					return fix(s).Name >= name
+				},
+				func(s models.Model) bool {
+					return fix(s).Name > name
+				}
+		},
+		func(s string) (models.Model, error) {
+			res := fix(p.New())
+			res.Name = s
+			return res, nil
+		})
+	return res
+}
+
+// Validate sets the valid and available flags for the Pool.  A Pool
+// is only available if the Workflows it uses are.
+func (p *Pool) Validate() {
+	p.Pool.Validate()
+	p.AddError(index.CheckUnique(p, p.rt.stores("pools").Items()))
+	if !p.SetValid() {
+		return
+	}
+	for _, name := range []string{p.AllocateWorkflow, p.ReleaseWorkflow} {
+		if name == "" {
+			continue
+		}
+		if wf := p.rt.find("workflows", name); wf == nil {
+			p.Errorf("Workflow %s does not exist", name)
+		} else if !AsWorkflow(wf).Available {
+			p.Errorf("Workflow %s is not available", name)
+		}
+	}
+	p.SetAvailable()
+}
+
+// BeforeSave validates the state of the Pool.
+func (p *Pool) BeforeSave() error {
+	p.Fill()
+	p.Validate()
+	if !p.Validated {
+		return p.MakeError(422, ValidationError, p)
+	}
+	return nil
+}
+
+// OnLoad initializes the Pool when loaded from the data store.
+func (p *Pool) OnLoad() error {
+	defer func() { p.rt = nil }()
+	p.Fill()
+	return p.BeforeSave()
+}
+
+// BeforeDelete refuses to delete a Pool that still has Machines
+// allocated from it.
+func (p *Pool) BeforeDelete() error {
+	e := &models.Error{Code: 409, Type: StillInUseError, Model: p.Prefix(), Key: p.Key()}
+	for _, i := range p.rt.stores("machines").Items() {
+		m := AsMachine(i)
+		if m.Allocation != nil && m.Allocation.Pool == p.Name {
+			e.Errorf("Pool %s has Machine %s allocated to %s", p.Name, m.Name, m.Allocation.Owner)
+		}
+	}
+	return e.HasError()
+}
+
+var poolLockMap = map[string][]string{
+	"get":     {"pools"},
+	"create":  {"workflows", "pools"},
+	"update":  {"workflows", "pools"},
+	"patch":   {"workflows", "pools"},
+	"delete":  {"machines", "pools"},
+	"actions": {"pools", "profiles", "params"},
+}
+
+// Locks returns the object lock list for a given action for the Pool object
+func (p *Pool) Locks(action string) []string {
+	return poolLockMap[action]
+}
+
+// PoolAllocationLocks are the locks that must be held to call
+// Pool.Allocate and Pool.Release.
+var PoolAllocationLocks = []string{
+	"stages",
+	"bootenvs",
+	"machines",
+	"tasks",
+	"profiles",
+	"templates",
+	"params",
+	"workflows",
+	"pools",
+	"users",
+	"tenants",
+}
+
+// matches returns whether m meets all of selectors.
+func (p *Pool) matches(rt *RequestTracker, m *Machine, selectors []models.PoolSelector) bool {
+	for i := range selectors {
+		val, found := rt.GetParam(m, selectors[i].Param, true, false)
+		if !selectors[i].Match(val, found) {
+			return false
+		}
+	}
+	return true
+}
+
+// idle returns whether m has finished everything it was asked to do.
+func idle(m *Machine) bool {
+	return len(m.Tasks) == 0 || m.CurrentTask >= len(m.Tasks)
+}
+
+// owns returns whether principal may release a Machine allocated to
+// owner.  Users may release what is allocated to them or to the
+// tenant they are in.
+func owns(rt *RequestTracker, owner, principal string) bool {
+	if owner == principal {
+		return true
+	}
+	if !strings.HasPrefix(owner, "tenant:") || !strings.HasPrefix(principal, "user:") {
+		return false
+	}
+	t := rt.find("tenants", strings.TrimPrefix(owner, "tenant:"))
+	if t == nil {
+		return false
+	}
+	user := strings.TrimPrefix(principal, "user:")
+	for _, u := range AsTenant(t).Users {
+		if u == user {
+			return true
+		}
+	}
+	return false
+}
+
+// setWorkflow puts m in the Workflow name and saves it.  If m is
+// already in it, it is taken out first so that the Workflow starts
+// over.  Whether m may change its Allocation is kept across both
+// saves.
+func setWorkflow(rt *RequestTracker, m *Machine, name string) (*Machine, error) {
+	allocating := m.allocating
+	if name != "" && m.Workflow == name {
+		m.Workflow = ""
+		if _, err := rt.Update(m); err != nil {
+			return nil, err
+		}
+		m = AsMachine(rt.Find("machines", m.UUID()))
+		m.allocating = allocating
+	}
+	if name != "" {
+		m.Workflow = name
+	}
+	if _, err := rt.Update(m); err != nil {
+		return nil, err
+	}
+	return AsMachine(rt.Find("machines", m.UUID())), nil
+}
+
+func (p *Pool) poolError(code int, action string) *models.Error {
+	return &models.Error{
+		Code:  code,
+		Type:  action,
+		Model: p.Prefix(),
+		Key:   p.Key(),
+	}
+}
+
+// Allocate claims req.Count ready Machines that meet the Pool's and
+// req's Selectors for req.Owner, and puts them in the Pool's
+// AllocateWorkflow.  Either all of them are allocated or none are.
+// Only Machines in the caller's Tenant that it may update are
+// claimed, and only admins may set an Owner other than the caller or
+// its Tenant.  PoolAllocationLocks must be held.
+func (p *Pool) Allocate(rt *RequestTracker, req *models.PoolAllocateRequest) ([]*models.Machine, error) {
+	e := p.poolError(http.StatusUnprocessableEntity, "ALLOCATE")
+	if !p.Available {
+		e.Errorf("Pool %s is not available", p.Name)
+		return nil, e
+	}
+	if req.Count < 1 {
+		e.Errorf("Count must be at least 1")
+	}
+	if req.Duration < 0 {
+		e.Errorf("Duration cannot be negative")
+	}
+	for i := range req.Selectors {
+		e.AddError(req.Selectors[i].Validate())
+	}
+	principal := rt.Principal()
+	owner := req.Owner
+	if owner == "" {
+		owner = principal
+	}
+	switch {
+	case strings.HasPrefix(owner, "user:"):
+		if rt.find("users", strings.TrimPrefix(owner, "user:")) == nil {
+			e.Errorf("User %s does not exist", strings.TrimPrefix(owner, "user:"))
+		}
+	case strings.HasPrefix(owner, "tenant:"):
+		if rt.find("tenants", strings.TrimPrefix(owner, "tenant:")) == nil {
+			e.Errorf("Tenant %s does not exist", strings.TrimPrefix(owner, "tenant:"))
+		}
+	default:
+		e.Errorf("Owner must be user:<name> or tenant:<name>, not %q", owner)
+	}
+	if e.ContainsError() {
+		return nil, e
+	}
+	// Only admins can hand machines to someone other than themselves
+	// or their Tenant.
+	if tenant := rt.tenant(); owner != principal &&
+		(tenant == "" || owner != "tenant:"+tenant) &&
+		!rt.allowed("*", "*", "*") {
+		e.Code = http.StatusForbidden
+		e.Errorf("Cannot allocate machines to %s", owner)
+		return nil, e
+	}
+	now := time.Now()
+	picked := []*Machine{}
+	for _, obj := range rt.d("machines").Items() {
+		if len(picked) == req.Count {
+			break
+		}
+		m := AsMachine(obj)
+		if !rt.visible(m) ||
+			!rt.allowed("machines", "update", m.Key()) ||
+			m.LifecycleState() != models.LifecycleReady ||
+			m.Allocation != nil ||
+			m.LockedAgainst(principal, now) ||
+			!idle(m) ||
+			!p.matches(rt, m, p.Selectors) ||
+			!p.matches(rt, m, req.Selectors) {
+			continue
+		}
+		picked = append(picked, m)
+	}
+	if len(picked) < req.Count {
+		e.Code = http.StatusConflict
+		e.Errorf("Only %d of the %d machines asked for are ready in pool %s", len(picked), req.Count, p.Name)
+		return nil, e
+	}
+	duration := req.Duration
+	if duration == 0 {
+		duration = p.LeaseDuration
+	}
+	alloc := models.MachineAllocation{Pool: p.Name, Owner: owner, Allocated: now}
+	if duration > 0 {
+		alloc.Expires = now.Add(time.Duration(duration) * time.Second)
+	}
+	res := []*models.Machine{}
+	done := []*Machine{}
+	for _, orig := range picked {
+		m := AsMachine(ModelToBackend(models.Clone(orig)))
+		m.Lifecycle = models.LifecycleAllocated
+		a := alloc
+		m.Allocation = &a
+		m.allocating = true
+		saved, err := setWorkflow(rt, m, p.AllocateWorkflow)
+		if err != nil {
+			e.Errorf("Failed to allocate machine %s: %v", orig.Name, err)
+			break
+		}
+		done = append(done, orig)
+		res = append(res, saved.Machine)
+	}
+	if e.ContainsError() {
+		// Put back the ones that were allocated before the failure.
+		for _, orig := range done {
+			m := AsMachine(ModelToBackend(models.Clone(orig)))
+			m.allocating = true
+			m.InRunner()
+			m.ForceChange()
+			if _, err := rt.Update(m); err != nil {
+				e.Errorf("Failed to put back machine %s: %v", orig.Name, err)
+			}
+		}
+		return nil, e
+	}
+	for _, m := range res {
+		rt.Infof("Pool %s: allocated machine %s to %s", p.Name, m.Name, owner)
+	}
+	return res, nil
+}
+
+// Release gives the Machines in req back to the Pool and puts them
+// in its ReleaseWorkflow.  Only the owner of an allocation can
+// release it unless force is true.  If some of the Machines cannot be
+// saved, the ones that were released are returned along with the
+// error.  PoolAllocationLocks must be held.
+func (p *Pool) Release(rt *RequestTracker, req *models.PoolReleaseRequest, force bool) ([]*models.Machine, error) {
+	e := p.poolError(http.StatusUnprocessableEntity, "RELEASE")
+	principal := rt.Principal()
+	toRelease := []*Machine{}
+	for _, uuid := range req.Machines {
+		obj := rt.find("machines", uuid)
+		if obj == nil {
+			e.Errorf("Machine %s does not exist", uuid)
+			continue
+		}
+		m := AsMachine(obj)
+		switch {
+		case m.Allocation == nil || m.Allocation.Pool != p.Name:
+			e.Errorf("Machine %s is not allocated from pool %s", m.Name, p.Name)
+		case !force && !owns(rt, m.Allocation.Owner, principal):
+			e.Code = http.StatusForbidden
+			e.Errorf("Machine %s is allocated to %s", m.Name, m.Allocation.Owner)
+		default:
+			toRelease = append(toRelease, m)
+		}
+	}
+	if e.ContainsError() {
+		return nil, e
+	}
+	res := []*models.Machine{}
+	for _, orig := range toRelease {
+		m := AsMachine(ModelToBackend(models.Clone(orig)))
+		m.Lifecycle = models.LifecycleReady
+		m.Allocation = nil
+		m.allocating = true
+		saved, err := setWorkflow(rt, m, p.ReleaseWorkflow)
+		if err != nil {
+			e.Errorf("Failed to release machine %s: %v", orig.Name, err)
+			continue
+		}
+		rt.Infof("Pool %s: released machine %s", p.Name, orig.Name)
+		res = append(res, saved.Machine)
+	}
+	return res, e.HasError()
+}
+
+// ReleaseExpired releases the Machines whose allocations have expired
+// at now.  Machines that someone has locked are left alone until the
+// lock is gone.  It returns the UUIDs of the Machines it released.
+func (p *DataTracker) ReleaseExpired(now time.Time) []string {
+	expired := map[string][]string{}
+	rt := p.Request(p.Logger, "machines")
+	rt.Do(func(d Stores) {
+		for _, obj := range d("machines").Items() {
+			m := AsMachine(obj)
+			if !m.Allocation.Expired(now) {
+				continue
+			}
+			if m.LockedAgainst("pool:"+m.Allocation.Pool, now) {
+				rt.Debugf("Pool %s: not releasing expired machine %s while it is locked by %s", m.Allocation.Pool, m.Name, m.Lock.Owner)
+				continue
+			}
+			expired[m.Allocation.Pool] = append(expired[m.Allocation.Pool], m.UUID())
+		}
+	})
+	res := []string{}
+	for pool, uuids := range expired {
+		rt := p.Request(p.Logger.Fork().SetPrincipal("pool:"+pool), PoolAllocationLocks...)
+		rt.Do(func(d Stores) {
+			obj := rt.find("pools", pool)
+			if obj == nil {
+				rt.Errorf("Pool %s: cannot release expired machines %v: pool does not exist", pool, uuids)
+				return
+			}
+			released, err := AsPool(obj).Release(rt, &models.PoolReleaseRequest{Machines: uuids}, true)
+			if err != nil {
+				rt.Errorf("Pool %s: failed to release expired machines: %v", pool, err)
+			}
+			for _, m := range released {
+				res = append(res, m.UUID())
+			}
+		})
+	}
+	return res
+}
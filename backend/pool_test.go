@@ -0,0 +1,278 @@
+package backend
+
+import (
+	"testing"
+	"time"
+
+	"github.com/digitalrebar/provision/models"
+	"github.com/pborman/uuid"
+)
+
+// testAuth is an Authorizer for a caller in tenant that can see the
+// Machines in machines, and update the ones that map to true.
+type testAuth struct {
+	tenant   string
+	machines map[string]bool
+	admin    bool
+}
+
+func (a *testAuth) Visible(obj models.Model) bool {
+	if obj.Prefix() != "machines" {
+		return true
+	}
+	_, ok := a.machines[obj.Key()]
+	return ok
+}
+
+func (a *testAuth) Allowed(scope, action, specific string) bool {
+	switch {
+	case a.admin:
+		return true
+	case scope == "machines" && action == "update":
+		return a.machines[specific]
+	default:
+		return scope != "*"
+	}
+}
+
+func (a *testAuth) Tenant() string {
+	return a.tenant
+}
+
+func TestPoolAllocation(t *testing.T) {
+	dt := mkDT()
+	rt := dt.Request(dt.Logger.Fork().SetPrincipal("user:rocketskates"))
+	bob := dt.Request(dt.Logger.Fork().SetPrincipal("user:bob"))
+	machines := map[string]*Machine{}
+	rt.AllLocked(func(d Stores) {
+		for _, obj := range []models.Model{
+			&models.Stage{Name: "pool-stage"},
+			&models.Workflow{Name: "burn-in", Stages: []string{"pool-stage"}},
+			&models.Workflow{Name: "wipe", Stages: []string{"pool-stage"}},
+			&models.Pool{
+				Name:             "big",
+				Selectors:        []models.PoolSelector{{Param: "ram-gb", Op: "gte", Value: 256}},
+				AllocateWorkflow: "burn-in",
+				ReleaseWorkflow:  "wipe",
+			},
+			&models.Pool{Name: "any", LeaseDuration: 60},
+		} {
+			if created, err := rt.Create(obj); !created {
+				t.Fatalf("Failed to create %s %s: %v", obj.Prefix(), obj.Key(), err)
+			}
+		}
+		for _, m := range []struct {
+			name, lifecycle string
+			ram             int
+		}{
+			{"big1", models.LifecycleReady, 512},
+			{"big2", models.LifecycleReady, 512},
+			{"small", models.LifecycleReady, 64},
+			{"new", "", 512},
+		} {
+			mc := &Machine{}
+			Fill(mc)
+			mc.Uuid = uuid.NewRandom()
+			mc.Name = m.name + ".example.com"
+			mc.Lifecycle = m.lifecycle
+			mc.Params = map[string]interface{}{"ram-gb": m.ram}
+			if created, err := rt.Create(mc); !created {
+				t.Fatalf("Failed to create machine %s: %v", m.name, err)
+			}
+			machines[m.name] = mc
+		}
+	})
+	pool := func(r *RequestTracker, name string, fn func(*Pool)) {
+		r.AllLocked(func(d Stores) {
+			fn(AsPool(r.RawFind("pools", name)))
+		})
+	}
+	machine := func(name string) *Machine {
+		var res *Machine
+		rt.AllLocked(func(d Stores) {
+			res = AsMachine(rt.Find("machines", machines[name].UUID()))
+		})
+		return res
+	}
+
+	pool(rt, "big", func(p *Pool) {
+		if _, err := p.Allocate(rt, &models.PoolAllocateRequest{Count: 3}); err == nil {
+			t.Errorf("Expected allocating 3 machines from big to fail")
+		} else if err.(*models.Error).Code != 409 {
+			t.Errorf("Expected a 409 when too few machines are ready, not %v", err)
+		}
+		if _, err := p.Allocate(rt, &models.PoolAllocateRequest{Count: 1, Owner: "user:nobody"}); err == nil {
+			t.Errorf("Expected allocating to a missing user to fail")
+		}
+	})
+	for name := range machines {
+		if m := machine(name); m.Allocation != nil || m.Workflow != "" {
+			t.Errorf("Expected failed allocations to leave %s alone, got %v in %s", name, m.Allocation, m.Workflow)
+		}
+	}
+	var allocated []*models.Machine
+	pool(rt, "big", func(p *Pool) {
+		var err error
+		allocated, err = p.Allocate(rt, &models.PoolAllocateRequest{Count: 2})
+		if err != nil {
+			t.Fatalf("Failed to allocate 2 machines from big: %v", err)
+		}
+	})
+	for _, name := range []string{"big1", "big2"} {
+		m := machine(name)
+		if m.LifecycleState() != models.LifecycleAllocated ||
+			m.Workflow != "burn-in" ||
+			m.Allocation == nil ||
+			m.Allocation.Owner != "user:rocketskates" ||
+			!m.Allocation.Expires.IsZero() {
+			t.Errorf("Expected %s allocated to user:rocketskates in burn-in, got %s %s %v", name, m.Lifecycle, m.Workflow, m.Allocation)
+		}
+	}
+
+	// Allocations can only be changed through the pool.
+	rt.AllLocked(func(d Stores) {
+		m := AsMachine(rt.Find("machines", allocated[0].UUID()))
+		m.Allocation = nil
+		m.Description = "burning in"
+		if saved, err := rt.Update(m); !saved {
+			t.Errorf("Failed to update allocated machine: %v", err)
+		}
+		m = AsMachine(rt.Find("machines", allocated[0].UUID()))
+		m.Lifecycle = models.LifecycleReady
+		if saved, _ := rt.Update(m); saved {
+			t.Errorf("Expected making an allocated machine ready to fail")
+		}
+	})
+	if m := machine("big1"); m.Allocation == nil {
+		t.Errorf("Expected update to keep the allocation")
+	}
+
+	release := &models.PoolReleaseRequest{Machines: []string{allocated[0].UUID(), allocated[1].UUID()}}
+	pool(bob, "big", func(p *Pool) {
+		if _, err := p.Release(bob, release, false); err == nil {
+			t.Errorf("Expected user:bob to not be able to release machines allocated to user:rocketskates")
+		}
+	})
+	pool(rt, "any", func(p *Pool) {
+		if _, err := p.Release(rt, release, false); err == nil {
+			t.Errorf("Expected releasing machines to the wrong pool to fail")
+		}
+	})
+	pool(rt, "big", func(p *Pool) {
+		if released, err := p.Release(rt, release, false); err != nil || len(released) != 2 {
+			t.Errorf("Failed to release machines: %v", err)
+		}
+	})
+	for _, name := range []string{"big1", "big2"} {
+		if m := machine(name); m.LifecycleState() != models.LifecycleReady || m.Workflow != "wipe" || m.Allocation != nil {
+			t.Errorf("Expected %s released into wipe, got %s %s %v", name, m.Lifecycle, m.Workflow, m.Allocation)
+		}
+	}
+	// Machines still being wiped cannot be allocated.
+	pool(rt, "big", func(p *Pool) {
+		if _, err := p.Allocate(rt, &models.PoolAllocateRequest{Count: 1}); err == nil {
+			t.Errorf("Expected machines still running wipe to not be allocated")
+		}
+	})
+
+	pool(rt, "any", func(p *Pool) {
+		res, err := p.Allocate(rt, &models.PoolAllocateRequest{
+			Count:     1,
+			Selectors: []models.PoolSelector{{Param: "ram-gb", Op: "lt", Value: 128}},
+		})
+		if err != nil || len(res) != 1 || res[0].UUID() != machines["small"].UUID() {
+			t.Fatalf("Expected small to be allocated from any, got %v: %v", res, err)
+		}
+		if exp := res[0].Allocation.Expires; exp.Before(time.Now().Add(59 * time.Second)) {
+			t.Errorf("Expected allocation to last the pool's LeaseDuration, expires %v", exp)
+		}
+	})
+	if released := dt.ReleaseExpired(time.Now()); len(released) != 0 {
+		t.Errorf("Expected nothing to have expired yet, got %v", released)
+	}
+	if released := dt.ReleaseExpired(time.Now().Add(time.Hour)); len(released) != 1 || released[0] != machines["small"].UUID() {
+		t.Errorf("Expected small's allocation to expire, got %v", released)
+	}
+	if m := machine("small"); m.Allocation != nil || m.LifecycleState() != models.LifecycleReady {
+		t.Errorf("Expected small to be ready again, got %s %v", m.Lifecycle, m.Allocation)
+	}
+	// Expired allocations of Machines someone has locked are left
+	// alone until the lock is gone.
+	pool(rt, "any", func(p *Pool) {
+		res, err := p.Allocate(rt, &models.PoolAllocateRequest{
+			Count:     1,
+			Selectors: []models.PoolSelector{{Param: "ram-gb", Op: "lt", Value: 128}},
+		})
+		if err != nil || len(res) != 1 || res[0].UUID() != machines["small"].UUID() {
+			t.Fatalf("Expected small to be allocated from any again, got %v: %v", res, err)
+		}
+	})
+	lock := func(l *models.MachineLock) {
+		bob.AllLocked(func(d Stores) {
+			m := AsMachine(bob.Find("machines", machines["small"].UUID()))
+			m.Lock = l
+			if saved, err := bob.Update(m); !saved {
+				t.Fatalf("Failed to change the lock of small: %v", err)
+			}
+		})
+	}
+	lock(&models.MachineLock{Reason: "firmware update"})
+	if released := dt.ReleaseExpired(time.Now().Add(time.Hour)); len(released) != 0 {
+		t.Errorf("Expected a locked machine to not be released, got %v", released)
+	}
+	lock(nil)
+	if released := dt.ReleaseExpired(time.Now().Add(time.Hour)); len(released) != 1 {
+		t.Errorf("Expected small to be released once it was unlocked, got %v", released)
+	}
+
+	// Callers only get Machines in their Tenant that they may update,
+	// and only admins may allocate them to someone else.
+	small := machines["small"].UUID()
+	rt.AllLocked(func(d Stores) {
+		for _, obj := range []models.Model{&models.User{Name: "alice"}, &models.Tenant{Name: "lab"}} {
+			if created, err := rt.Create(obj); !created {
+				t.Fatalf("Failed to create %s %s: %v", obj.Prefix(), obj.Key(), err)
+			}
+		}
+	})
+	alice := dt.Request(dt.Logger.Fork().SetPrincipal("user:alice"))
+	for _, test := range []struct {
+		desc  string
+		auth  *testAuth
+		owner string
+		code  int
+	}{
+		{"Allocate a machine in another tenant", &testAuth{tenant: "lab", machines: map[string]bool{}}, "", 409},
+		{"Allocate a machine without update rights", &testAuth{tenant: "lab", machines: map[string]bool{small: false}}, "", 409},
+		{"Allocate to another user", &testAuth{tenant: "lab", machines: map[string]bool{small: true}}, "user:rocketskates", 403},
+		{"Allocate to another tenant", &testAuth{machines: map[string]bool{small: true}}, "tenant:lab", 403},
+	} {
+		alice.SetAuth(test.auth)
+		pool(alice, "any", func(p *Pool) {
+			_, err := p.Allocate(alice, &models.PoolAllocateRequest{Count: 1, Owner: test.owner})
+			if err == nil || err.(*models.Error).Code != test.code {
+				t.Errorf("%s: expected a %d, got %v", test.desc, test.code, err)
+			}
+		})
+	}
+	alice.SetAuth(&testAuth{tenant: "lab", machines: map[string]bool{small: true}})
+	pool(alice, "any", func(p *Pool) {
+		res, err := p.Allocate(alice, &models.PoolAllocateRequest{Count: 1, Owner: "tenant:lab"})
+		if err != nil || len(res) != 1 || res[0].Allocation.Owner != "tenant:lab" {
+			t.Errorf("Expected small to be allocated to tenant:lab, got %v: %v", res, err)
+		}
+	})
+	release = &models.PoolReleaseRequest{Machines: []string{small}}
+	pool(rt, "any", func(p *Pool) {
+		if _, err := p.Release(rt, release, true); err != nil {
+			t.Errorf("Failed to release small: %v", err)
+		}
+	})
+
+	rt.AllLocked(func(d Stores) {
+		big := rt.Find("pools", "big")
+		if _, err := rt.Remove(big); err != nil {
+			t.Errorf("Failed to remove pool big with nothing allocated: %v", err)
+		}
+	})
+}
@@ -2,6 +2,7 @@ package backend
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/digitalrebar/provision/backend/index"
 	"github.com/digitalrebar/provision/models"
@@ -123,9 +124,26 @@ func (p *Profile) BeforeDelete() error {
 			e.Errorf("Stage %s is using profile %s", s.Name, p.Name)
 		}
 	}
+	profiles := p.rt.stores("profiles")
+	for _, i := range profiles.Items() {
+		o := AsProfile(i)
+		if o.HasProfile(p.Name) {
+			e.Errorf("Profile %s is including profile %s", o.Name, p.Name)
+		}
+	}
 	return e.HasError()
 }
 
+// HasProfile returns true if the profile name is in the Profiles list.
+func (p *Profile) HasProfile(name string) bool {
+	for _, e := range p.Profiles {
+		if e == name {
+			return true
+		}
+	}
+	return false
+}
+
 func AsProfile(o models.Model) *Profile {
 	return o.(*Profile)
 }
@@ -137,7 +155,56 @@ func AsProfiles(o []models.Model) []*Profile {
 	}
 	return res
 }
-func (p *Profile) Validate() {
+
+// profileCycle returns the chain of includes that leads from the
+// Profiles in includes back to name, or nil if there is none.
+func profileCycle(name string, includes []string, find func(string) *Profile) []string {
+	seen := map[string]struct{}{}
+	var walk func([]string) []string
+	walk = func(names []string) []string {
+		for _, pn := range names {
+			if pn == name {
+				return []string{pn}
+			}
+			if _, ok := seen[pn]; ok {
+				continue
+			}
+			seen[pn] = struct{}{}
+			if sub := find(pn); sub != nil {
+				if chain := walk(sub.Profiles); chain != nil {
+					return append([]string{pn}, chain...)
+				}
+			}
+		}
+		return nil
+	}
+	return walk(includes)
+}
+
+// checkProfiles makes sure that the Profiles p includes exist and that
+// p does not end up including itself.  An include cycle makes p
+// invalid, but a missing Profile only makes it unavailable.
+func (p *Profile) checkProfiles(find func(string) *Profile) {
+	if chain := profileCycle(p.Name, p.Profiles, find); chain != nil {
+		p.Errorf("Profile %s includes itself: %s", p.Name, strings.Join(append([]string{p.Name}, chain...), " -> "))
+		p.SetInvalid()
+	}
+	wanted := map[string]int{}
+	for i, name := range p.Profiles {
+		if alreadyAt, ok := wanted[name]; ok {
+			p.Errorf("Duplicate profile %s: at %d and %d", name, alreadyAt, i)
+			continue
+		}
+		wanted[name] = i
+		if find(name) == nil {
+			p.Errorf("Profile %s (at %d) does not exist", name, i)
+		}
+	}
+}
+
+// validateProfile validates everything but the Profiles p includes,
+// which may not have been loaded yet when p is.
+func (p *Profile) validateProfile() {
 	p.Profile.Validate()
 	p.AddError(index.CheckUnique(p, p.rt.stores("profiles").Items()))
 	if pk, err := p.rt.PrivateKeyFor(p); err == nil {
@@ -146,6 +213,18 @@ func (p *Profile) Validate() {
 		p.Errorf("Unable to get key: %v", err)
 	}
 	p.SetValid()
+}
+
+func (p *Profile) Validate() {
+	p.validateProfile()
+	if p.Useable() {
+		p.checkProfiles(func(name string) *Profile {
+			if o := p.rt.find("profiles", name); o != nil {
+				return AsProfile(o)
+			}
+			return nil
+		})
+	}
 	p.SetAvailable()
 }
 
@@ -157,10 +236,18 @@ func (p *Profile) BeforeSave() error {
 	return nil
 }
 
+// OnLoad initializes and validates the Profile when loading from
+// the backing store.  The Profiles it includes are checked by
+// rebuildCache once all the Profiles are loaded.
 func (p *Profile) OnLoad() error {
 	defer func() { p.rt = nil }()
 	p.Fill()
-	return p.BeforeSave()
+	p.validateProfile()
+	p.SetAvailable()
+	if !p.Useable() {
+		return p.MakeError(422, ValidationError, p)
+	}
+	return nil
 }
 
 func (p *Profile) AfterDelete() {
@@ -1,9 +1,15 @@
 package backend
 
 import (
+	"log"
+	"os"
+	"strings"
 	"testing"
 
+	"github.com/digitalrebar/logger"
+	"github.com/digitalrebar/provision/backend/index"
 	"github.com/digitalrebar/provision/models"
+	"github.com/pborman/uuid"
 )
 
 func TestProfilesCrud(t *testing.T) {
@@ -74,3 +80,83 @@ func TestProfilesValidation(t *testing.T) {
 		test.Test(t, rt)
 	}
 }
+
+func TestProfileIncludes(t *testing.T) {
+	site := &models.Content{
+		Meta: models.ContentMetaData{Name: "site", Version: "v1.0.0"},
+		Sections: models.Sections{
+			"params": models.Section{
+				"role": &models.Param{Name: "role", Schema: map[string]interface{}{"type": "string"}},
+			},
+			// site includes rack before role, and rack includes dc, so
+			// dc takes precedence over role.
+			"profiles": models.Section{
+				"site": &models.Profile{
+					Name:     "site",
+					Profiles: []string{"rack", "role"},
+					Params:   map[string]interface{}{"ntp": "ntp.site"},
+				},
+				"rack": &models.Profile{
+					Name:     "rack",
+					Profiles: []string{"dc"},
+					Params:   map[string]interface{}{"ntp": "ntp.rack", "rack": "r1"},
+				},
+				"role": &models.Profile{
+					Name:   "role",
+					Params: map[string]interface{}{"role": "web", "rack": "role-rack"},
+				},
+				"dc": &models.Profile{
+					Name:   "dc",
+					Params: map[string]interface{}{"dc": "dc1", "rack": "dc-rack", "role": "db"},
+				},
+			},
+		},
+	}
+	l := logger.New(log.New(os.Stdout, "includes", 0)).Log("backend")
+	dt, err := contentTestTracker(tmpDir, l, []*models.Content{site})
+	if err != nil {
+		t.Fatalf("Failed to load content: %v", err)
+	}
+	rt := dt.Request(dt.Logger)
+	rt.AllLocked(func(d Stores) {
+		m := &Machine{}
+		Fill(m)
+		m.Uuid = uuid.NewRandom()
+		m.Name = "included.example.com"
+		m.Profiles = []string{"site"}
+		if created, err := rt.Create(m); !created {
+			t.Fatalf("Failed to create machine: %v", err)
+		}
+		for k, want := range map[string]string{"ntp": "ntp.site", "rack": "r1", "dc": "dc1", "role": "db"} {
+			if got, _ := rt.GetParam(m, k, true, false); got != want {
+				t.Errorf("Expected %s to be %s, got %v", k, want, got)
+			}
+		}
+		maker, err := m.ParameterMaker(rt, "role")
+		if err != nil {
+			t.Fatalf("Failed to make role index: %v", err)
+		}
+		if res, err := index.All(index.Sort(maker), index.Eq("db"))(rt.Index("machines")); err != nil || res.Count() != 1 {
+			t.Errorf("Expected to find the machine by its included role param: %v", err)
+		}
+		missing := &models.Profile{Name: "loop-a", Profiles: []string{"loop-b"}}
+		if created, err := rt.Create(missing); !created {
+			t.Errorf("Expected profile including a missing profile to be created: %v", err)
+		} else if AsProfile(rt.find("profiles", "loop-a")).Available {
+			t.Errorf("Expected profile including a missing profile to be unavailable")
+		}
+		if created, _ := rt.Create(&models.Profile{Name: "loop-b", Profiles: []string{"loop-a"}}); created {
+			t.Errorf("Expected profile include cycle to be refused")
+		}
+		self := AsProfile(rt.Find("profiles", "dc"))
+		self.Profiles = []string{"site"}
+		if _, err := rt.Update(self); err == nil {
+			t.Errorf("Expected dc -> site -> rack -> dc to be refused")
+		} else if !strings.Contains(err.Error(), "dc -> site -> rack -> dc") {
+			t.Errorf("Unexpected error %v", err)
+		}
+		if removed, _ := rt.Remove(&models.Profile{Name: "dc"}); removed {
+			t.Errorf("Expected removing an included profile to be refused")
+		}
+	})
+}
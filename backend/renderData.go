@@ -33,8 +33,16 @@ type ReadSizer interface {
 
 type renderer struct {
 	path, name string
-	meta       map[string]string
-	write      func(net.IP) (io.Reader, error)
+	// infoName is the Name of the TemplateInfo the renderer was made from.
+	infoName string
+	// mac is the MAC address of the Machine the renderer was made for,
+	// for templates that are rendered once per HardwareAddr.
+	mac   string
+	meta  map[string]string
+	write func(net.IP) (io.Reader, error)
+	// preview renders what write would for RenderPreview, for
+	// renderers that are not made from a template of their target.
+	preview func(*RenderData) (string, error)
 }
 
 func (r renderer) register(fs *FileSystem) {
@@ -95,24 +103,22 @@ func newRenderedTemplate(r *RenderData,
 		keys = append(keys, r.Machine.Key())
 		r.rt.Debugf("Making renderer for %s:%s template %s at path %s", r.Machine.Prefix(), r.Machine.Key(), tmplKey, path)
 	}
+	var mac string
+	if r.Machine != nil {
+		mac = r.Machine.currMac
+	}
 	targetPrefix := r.target.Prefix()
 	dt := r.rt.dt
 	return renderer{
 		path: path,
 		name: tmplKey,
+		mac:  mac,
 		write: func(remoteIP net.IP) (io.Reader, error) {
 			var err error
-			rt := dt.Request(r.rt.Logger.Switch("bootenv"),
-				"templates",
-				"tasks",
-				"stages",
-				"bootenvs",
-				"machines",
-				"profiles",
-				"params",
-				"preferences")
+			rt := dt.Request(r.rt.Logger.Switch("bootenv"), RenderPreviewLocks...)
 			rd := &RenderData{rt: rt}
 			rd.rt.Do(func(d Stores) {
+				var env *BootEnv
 				for i, prefix := range prefixes {
 					item := rd.rt.find(prefix, keys[i])
 					if item == nil {
@@ -124,14 +130,17 @@ func newRenderedTemplate(r *RenderData,
 					case *Stage:
 						rd.Stage = &rStage{Stage: obj, renderData: rd}
 					case *BootEnv:
-						rd.Env = &rBootEnv{BootEnv: obj, renderData: rd}
+						env = obj
 					case *Machine:
-						rd.Machine = &rMachine{Machine: obj, renderData: rd}
+						rd.Machine = &rMachine{Machine: obj, renderData: rd, currMac: mac}
 					default:
 						rd.rt.Errorf("%s:%s is neither Renderable nor a machine", prefix, keys[i])
 						rd.rt.Panicf("Unrenderable Item: %#v", item)
 					}
 				}
+				if env != nil {
+					rd.Env = newRBootEnv(env, rd)
+				}
 			})
 			if err != nil {
 				return nil, err
@@ -184,9 +193,42 @@ func (n *rMachine) MacAddr(params ...string) string {
 	}
 }
 
+// rBootEnv is a BootEnv as seen by the Machine it is being rendered
+// for.  Kernel, Initrds and the ISO fields of OS are those of the
+// Machine's architecture.
 type rBootEnv struct {
 	*BootEnv
 	renderData *RenderData
+	// Arch is the architecture of the Machine, if it is known.
+	Arch    string
+	Kernel  string
+	Initrds []string
+	OS      models.OsInfo
+}
+
+func newRBootEnv(b *BootEnv, r *RenderData) *rBootEnv {
+	res := &rBootEnv{BootEnv: b, renderData: r, OS: b.OS}
+	if r.Machine != nil {
+		res.Arch = r.Machine.arch(r.rt)
+	}
+	info := b.ArchInfo(res.Arch)
+	res.Kernel = info.Kernel
+	res.Initrds = info.Initrds
+	res.OS.IsoFile = info.IsoFile
+	res.OS.IsoSha256 = info.IsoSha256
+	res.OS.IsoUrl = info.IsoUrl
+	return res
+}
+
+// bootParams returns the boot parameter template for the Machine's
+// architecture.
+func (b *rBootEnv) bootParams() *template.Template {
+	if a, ok := models.CanonicalArch(b.Arch); ok {
+		if tmpl, ok := b.archBootParams[a]; ok {
+			return tmpl
+		}
+	}
+	return b.bootParamsTmpl
 }
 
 // PathFor expands the partial paths for kernels and initrds into full
@@ -197,7 +239,7 @@ type rBootEnv struct {
 //    tftp: Will expand to the path the file can be accessed at via TFTP.
 //    disk: Will expand to the path of the file inside the provisioner container.
 func (b *rBootEnv) PathFor(proto, f string) string {
-	tail := b.BootEnv.PathFor(f)
+	tail := b.BootEnv.archPathFor(b.Arch, f)
 	switch proto {
 	case "tftp":
 		return strings.TrimPrefix(tail, "/")
@@ -399,6 +441,8 @@ type RenderData struct {
 	target            renderable
 	tmplKey, tmplPath string
 	remoteIP          net.IP
+	// computing are the computed params being rendered.
+	computing map[string]struct{}
 }
 
 func (r *RenderData) fetchRepos(test func(*Repo) bool) (res []*Repo) {
@@ -411,16 +455,35 @@ func (r *RenderData) fetchRepos(test func(*Repo) bool) (res []*Repo) {
 	if utils.Remarshal(p, &repos) != nil {
 		return
 	}
+	cached := r.useRepoCache()
 	for _, repo := range repos {
 		if !test(repo) {
 			continue
 		}
 		repo.r = r
+		if cached {
+			repo.URL = RepoCacheURL(r.rt.FileURL(r.remoteIP), repo.URL)
+		}
 		res = append(res, repo)
 	}
 	return
 }
 
+// useRepoCache returns whether package-repositories-cache asks for
+// repos to go through the package repository cache, and the cache
+// is turned on.
+func (r *RenderData) useRepoCache() bool {
+	if r.rt.dt.repoCacheLimit() == 0 {
+		return false
+	}
+	p, err := r.Param("package-repositories-cache")
+	if err != nil {
+		return false
+	}
+	v, ok := p.(bool)
+	return ok && v
+}
+
 // Repos is a template helper function that returns an array
 // of all the appropriate repos based upon the tag list.
 func (r *RenderData) Repos(tags ...string) []*Repo {
@@ -519,7 +582,7 @@ func newRenderData(rt *RequestTracker, m *Machine, r renderable) *RenderData {
 	}
 	switch obj := r.(type) {
 	case *BootEnv:
-		res.Env = &rBootEnv{BootEnv: obj, renderData: res}
+		res.Env = newRBootEnv(obj, res)
 	case *Task:
 		res.Task = &rTask{Task: obj, renderData: res}
 	case *Stage:
@@ -529,7 +592,7 @@ func newRenderData(rt *RequestTracker, m *Machine, r renderable) *RenderData {
 		if res.Env == nil {
 			obj := rt.find("bootenvs", m.BootEnv)
 			if obj != nil {
-				res.Env = &rBootEnv{BootEnv: obj.(*BootEnv), renderData: res}
+				res.Env = newRBootEnv(obj.(*BootEnv), res)
 			}
 		}
 		if res.Stage == nil {
@@ -695,6 +758,54 @@ func (r *RenderData) GenerateProfileToken(profile string, duration int) string {
 	return t
 }
 
+// ImageDeploy is a helper function that returns the JSON that tells
+// the task runner how to deploy a disk image to the Machine.  The
+// image comes from the BootEnv named by the image-deploy/bootenv
+// param, or the BootEnv of the Machine if that is not set.  The
+// image-deploy/disk param picks the disk to write it to, and
+// image-deploy/grow-partition the partition to grow afterwards.
+func (r *RenderData) ImageDeploy() (string, error) {
+	var env *BootEnv
+	if r.Env != nil {
+		env = r.Env.BootEnv
+	}
+	if v, err := r.Param("image-deploy/bootenv"); err == nil {
+		name, _ := v.(string)
+		obj := r.rt.find("bootenvs", name)
+		if obj == nil {
+			return "", fmt.Errorf("No such bootenv %v", v)
+		}
+		env = AsBootEnv(obj)
+	}
+	if env == nil || env.Image == nil {
+		return "", fmt.Errorf("Missing bootenv with an image")
+	}
+	res := &models.ImageDeploy{
+		URL:    r.ProvisionerURL() + path.Clean("/"+env.Image.File),
+		Type:   env.Image.ImageType(),
+		Sha256: env.Image.Sha256,
+		Disk:   "/dev/sda",
+	}
+	if v, err := r.Param("image-deploy/disk"); err == nil {
+		if disk, ok := v.(string); ok {
+			res.Disk = disk
+		}
+	}
+	if v, err := r.Param("image-deploy/grow-partition"); err == nil {
+		switch n := v.(type) {
+		case float64:
+			res.GrowPartition = int(n)
+		case int:
+			res.GrowPartition = n
+		}
+	}
+	if err := res.Validate(); err != nil {
+		return "", err
+	}
+	buf, err := json.Marshal(res)
+	return string(buf), err
+}
+
 // BootParams is a helper function that expands the BootParams
 // template from the boot environment.
 func (r *RenderData) BootParams() (string, error) {
@@ -702,10 +813,11 @@ func (r *RenderData) BootParams() (string, error) {
 		return "", fmt.Errorf("Missing bootenv")
 	}
 	res := &bytes.Buffer{}
-	if r.Env.bootParamsTmpl == nil {
+	tmpl := r.Env.bootParams()
+	if tmpl == nil {
 		return "", nil
 	}
-	if err := r.Env.bootParamsTmpl.Execute(res, r); err != nil {
+	if err := tmpl.Execute(res, r); err != nil {
 		return "", err
 	}
 	str := res.String()
@@ -738,7 +850,10 @@ func (r *RenderData) ParseUrl(segment, rawUrl string) (string, error) {
 // Param is a helper function for extracting a parameter from Machine.Params
 func (r *RenderData) Param(key string) (interface{}, error) {
 	if r.Machine != nil {
-		v, ok := r.rt.GetParam(r.Machine, key, true, r.Task != nil)
+		v, ok, err := r.rt.getParam(r.Machine, key, true, r.Task != nil)
+		if err != nil {
+			return nil, err
+		}
 		if ok {
 			return v, nil
 		}
@@ -789,6 +904,9 @@ func (r *RenderData) ParamExists(key string) bool {
 // allows for function expansion of the arguments unlike the built-in
 // template function.
 func (r *RenderData) CallTemplate(name string, data interface{}) (ret interface{}, err error) {
+	if r.target == nil {
+		return nil, fmt.Errorf("Missing template: %s", name)
+	}
 	buf := bytes.NewBuffer([]byte{})
 	tmpl := r.target.templates().Lookup(name)
 	if tmpl == nil {
@@ -831,6 +949,19 @@ func (r *RenderData) addRenderer(e models.ErrorAdder, ti *models.TemplateInfo, r
 	}
 	rt := newRenderedTemplate(r, ti.Id(), tmplPath)
 	rt.meta = ti.Meta
+	rt.infoName = ti.Name
+	if r.Machine != nil && r.Env != nil && r.target.Prefix() == "bootenvs" && isBootLoaderTemplate(ti.Name) {
+		// Bootloader config fetches count as boot attempts.
+		write, dt := rt.write, r.rt.dt
+		uuid, bootEnv := r.Machine.UUID(), r.Env.Name
+		rt.write = func(remoteIP net.IP) (io.Reader, error) {
+			res, err := write(remoteIP)
+			if err == nil {
+				dt.bootAttempt(uuid, bootEnv, time.Now())
+			}
+			return res, err
+		}
+	}
 	return append(rts, rt)
 }
 
@@ -0,0 +1,191 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/digitalrebar/provision/models"
+)
+
+// lookup makes sure that there is a Machine to look things up for
+// and that the stores for prefixes can be used.
+func (r *RenderData) lookup(prefixes ...string) error {
+	if r.Machine == nil {
+		return fmt.Errorf("No machine to look up %s for", strings.Join(prefixes, " and "))
+	}
+	for _, prefix := range prefixes {
+		if !r.rt.locked(prefix) {
+			return fmt.Errorf("%s cannot be looked up here", prefix)
+		}
+	}
+	return nil
+}
+
+// Reservations is a template helper function that returns the MAC
+// address Reservations for the HardwareAddrs of the Machine.
+func (r *RenderData) Reservations() ([]*models.Reservation, error) {
+	if err := r.lookup("reservations"); err != nil {
+		return nil, err
+	}
+	macs := map[string]struct{}{}
+	for _, addr := range r.Machine.HardwareAddrs {
+		if mac, err := net.ParseMAC(addr); err == nil {
+			macs[mac.String()] = struct{}{}
+		}
+	}
+	res := []*models.Reservation{}
+	for _, obj := range r.rt.d("reservations").Items() {
+		resv := AsReservation(obj)
+		if resv.Strategy != "MAC" {
+			continue
+		}
+		if mac, err := net.ParseMAC(resv.Token); err != nil {
+			continue
+		} else if _, ok := macs[mac.String()]; ok {
+			res = append(res, models.Clone(resv.Reservation).(*models.Reservation))
+		}
+	}
+	return res, nil
+}
+
+// Subnets is a template helper function that returns the Subnets
+// that the Address of the Machine or any of its Reservations are in.
+func (r *RenderData) Subnets() ([]*models.Subnet, error) {
+	if err := r.lookup("subnets", "reservations"); err != nil {
+		return nil, err
+	}
+	addrs := []net.IP{}
+	if r.Machine.Address != nil && !r.Machine.Address.IsUnspecified() {
+		addrs = append(addrs, r.Machine.Address)
+	}
+	resvs, _ := r.Reservations()
+	for _, resv := range resvs {
+		addrs = append(addrs, resv.Addr)
+	}
+	res := []*models.Subnet{}
+	for _, obj := range r.rt.d("subnets").Items() {
+		sub := AsSubnet(obj)
+		for _, addr := range addrs {
+			if sub.subnet().Contains(addr) {
+				res = append(res, models.Clone(sub.Subnet).(*models.Subnet))
+				break
+			}
+		}
+	}
+	return res, nil
+}
+
+// peers returns the Machines that the Machine being rendered for can
+// see, sorted by Name.  If the Machine is a member of a Tenant that
+// limits machines, those are the machines of that Tenant.  Otherwise
+// they are the machines that are not limited to any Tenant.
+func (r *RenderData) peers() ([]*Machine, error) {
+	if err := r.lookup("machines", "tenants"); err != nil {
+		return nil, err
+	}
+	var mine map[string]struct{}
+	others := map[string]struct{}{}
+	for _, obj := range r.rt.d("tenants").Items() {
+		t := AsTenant(obj)
+		if t.Members["machines"] == nil {
+			continue
+		}
+		members := t.ExpandedMembers()["machines"]
+		if _, ok := members[r.Machine.Key()]; ok && mine == nil {
+			mine = members
+			continue
+		}
+		for k := range members {
+			others[k] = struct{}{}
+		}
+	}
+	res := []*Machine{}
+	for _, obj := range r.rt.d("machines").Items() {
+		k := obj.Key()
+		if mine != nil {
+			if _, ok := mine[k]; !ok {
+				continue
+			}
+		} else if _, ok := others[k]; ok {
+			continue
+		}
+		res = append(res, AsMachine(obj))
+	}
+	sort.SliceStable(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res, nil
+}
+
+// findPeers returns copies of the Machines from peers that pass
+// test.  The Secret of each Machine is left out.
+func (r *RenderData) findPeers(test func(*Machine) bool) ([]*models.Machine, error) {
+	machines, err := r.peers()
+	if err != nil {
+		return nil, err
+	}
+	res := []*models.Machine{}
+	for _, m := range machines {
+		if !test(m) {
+			continue
+		}
+		peer := models.Clone(m.Machine).(*models.Machine)
+		peer.Secret = ""
+		res = append(res, peer)
+	}
+	return res, nil
+}
+
+// MachinesWithProfile is a template helper function that returns
+// the Machines that have profile in their Profiles.  Only Machines
+// in the same Tenant as the Machine being rendered for are
+// returned, sorted by Name.
+func (r *RenderData) MachinesWithProfile(profile string) ([]*models.Machine, error) {
+	return r.findPeers(func(m *Machine) bool {
+		for _, p := range m.Profiles {
+			if p == profile {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// MachinesWithParam is a template helper function that returns the
+// Machines whose aggregated value of the param key is value.  Only
+// Machines in the same Tenant as the Machine being rendered for are
+// returned, sorted by Name.
+//
+// Computed params of the Machines are rendered knowing which ones are
+// being computed already, so a computed param that looks itself up
+// through MachinesWithParam is an error rather than endless recursion.
+func (r *RenderData) MachinesWithParam(key string, value interface{}) ([]*models.Machine, error) {
+	want, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var paramErr error
+	res, err := r.findPeers(func(m *Machine) bool {
+		if paramErr != nil {
+			return false
+		}
+		peer := &RenderData{rt: r.rt, computing: r.computing}
+		peer.Machine = &rMachine{Machine: m, renderData: peer}
+		v, ok, err := r.rt.getParam(peer.Machine, key, true, false)
+		if err != nil {
+			paramErr = err
+			return false
+		}
+		if !ok {
+			return false
+		}
+		got, err := json.Marshal(v)
+		return err == nil && bytes.Equal(got, want)
+	})
+	if paramErr != nil {
+		return nil, paramErr
+	}
+	return res, err
+}
@@ -0,0 +1,124 @@
+package backend
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/digitalrebar/provision/models"
+	"github.com/pborman/uuid"
+)
+
+func TestRenderHelpers(t *testing.T) {
+	dt := mkDT()
+	rt := dt.Request(dt.Logger)
+	mkMachine := func(name, mac, role string) *Machine {
+		m := &Machine{}
+		Fill(m)
+		m.Uuid = uuid.NewRandom()
+		m.Name = name
+		m.HardwareAddrs = []string{mac}
+		m.Profiles = []string{role}
+		m.Params = map[string]interface{}{"role": role}
+		if created, err := rt.Create(m); !created {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+		return m
+	}
+	rt.AllLocked(func(d Stores) {
+		for _, obj := range []models.Model{
+			&models.Param{Name: "role", Schema: map[string]interface{}{"type": "string"}},
+			&models.Profile{Name: "etcd"},
+			&models.Profile{Name: "worker"},
+			&models.Subnet{Name: "prov", Subnet: "192.168.124.0/24", ActiveStart: net.ParseIP("192.168.124.80"), ActiveEnd: net.ParseIP("192.168.124.254"), ActiveLeaseTime: 60, ReservedLeaseTime: 7200, Strategy: "MAC"},
+			&models.Subnet{Name: "other", Subnet: "192.168.125.0/24", ActiveStart: net.ParseIP("192.168.125.80"), ActiveEnd: net.ParseIP("192.168.125.254"), ActiveLeaseTime: 60, ReservedLeaseTime: 7200, Strategy: "MAC"},
+			&models.Reservation{Addr: net.ParseIP("192.168.124.10"), Token: "52:54:00:00:00:01", Strategy: "MAC"},
+			&models.Reservation{Addr: net.ParseIP("192.168.124.11"), Token: "52:54:00:00:00:02", Strategy: "MAC"},
+			&models.Task{Name: "peers", Templates: []models.TemplateInfo{{
+				Name:     "peers",
+				Path:     "{{range .Subnets}}{{.Name}}{{end}}/peers",
+				Contents: `{{range .MachinesWithProfile "etcd"}}{{.Name}} {{end}}`,
+			}}},
+		} {
+			if created, err := rt.Create(obj); !created {
+				t.Fatalf("Failed to create %s:%s: %v", obj.Prefix(), obj.Key(), err)
+			}
+		}
+		etcd1 := mkMachine("etcd1", "52:54:00:00:00:01", "etcd")
+		mkMachine("etcd2", "52:54:00:00:00:02", "etcd")
+		mkMachine("worker1", "52:54:00:00:00:03", "worker")
+		hidden := mkMachine("etcd3", "52:54:00:00:00:04", "etcd")
+		tenant := &models.Tenant{Name: "other", Members: map[string][]string{"machines": {hidden.Key()}}}
+		if created, err := rt.Create(tenant); !created {
+			t.Fatalf("Failed to create tenant: %v", err)
+		}
+
+		rd := newRenderData(rt, etcd1, nil)
+		resvs, err := rd.Reservations()
+		if err != nil || len(resvs) != 1 || resvs[0].Addr.String() != "192.168.124.10" {
+			t.Errorf("Expected the reservation for etcd1, got %v: %v", resvs, err)
+		}
+		subnets, err := rd.Subnets()
+		if err != nil || len(subnets) != 1 || subnets[0].Name != "prov" {
+			t.Errorf("Expected subnet prov for etcd1, got %v: %v", subnets, err)
+		}
+		names := func(ms []*models.Machine) (res []string) {
+			for _, m := range ms {
+				if m.Secret != "" {
+					t.Errorf("Expected the secret of %s to be left out", m.Name)
+				}
+				res = append(res, m.Name)
+			}
+			return
+		}
+		peers, err := rd.MachinesWithProfile("etcd")
+		if got := names(peers); err != nil || len(got) != 2 || got[0] != "etcd1" || got[1] != "etcd2" {
+			t.Errorf("Expected etcd1 and etcd2, got %v: %v", got, err)
+		}
+		peers, err = rd.MachinesWithParam("role", "worker")
+		if got := names(peers); err != nil || len(got) != 1 || got[0] != "worker1" {
+			t.Errorf("Expected worker1, got %v: %v", got, err)
+		}
+		peers, err = newRenderData(rt, hidden, nil).MachinesWithProfile("etcd")
+		if got := names(peers); err != nil || len(got) != 1 || got[0] != "etcd3" {
+			t.Errorf("Expected a tenant to only see its own machines, got %v: %v", got, err)
+		}
+		if _, err := (&RenderData{rt: rt}).Subnets(); err == nil {
+			t.Errorf("Expected looking up subnets without a machine to fail")
+		}
+
+		// A computed param that looks for machines by its own value
+		// must fail instead of recursing forever.
+		if created, err := rt.Create(&models.Param{Name: "leader", Computed: true, Schema: map[string]interface{}{"type": "string"}}); !created {
+			t.Fatalf("Failed to create param: %v", err)
+		}
+		loop := AsMachine(rt.Find("machines", mkMachine("loop1", "52:54:00:00:00:05", "worker").Key()))
+		loop.Params["leader"] = `{{range .MachinesWithParam "leader" "loop1"}}{{.Name}}{{end}}`
+		if saved, err := rt.Update(loop); !saved {
+			t.Fatalf("Failed to update loop1: %v", err)
+		}
+		if _, _, err := rt.getParam(loop, "leader", true, false); err == nil || !strings.Contains(err.Error(), "depends on itself") {
+			t.Errorf("Expected leader to depend on itself, got %v", err)
+		}
+	})
+	rt = dt.Request(dt.Logger, "machines", "profiles", "params")
+	rt.Do(func(d Stores) {
+		m := AsMachine(rt.find("machines", "Name:etcd1"))
+		if _, err := newRenderData(rt, m, nil).Subnets(); err == nil {
+			t.Errorf("Expected looking up subnets without the subnets lock to fail")
+		}
+	})
+
+	// Tasks can use the helpers with just the locks the job actions
+	// API takes.
+	rt = dt.Request(dt.Logger, (&Job{}).Locks("actions")...)
+	var etcd1 *Machine
+	rt.Do(func(d Stores) {
+		etcd1 = AsMachine(rt.find("machines", "Name:etcd1"))
+	})
+	j := &Job{Job: &models.Job{Task: "peers", Machine: etcd1.Uuid}}
+	actions, err := j.RenderActions(rt, "")
+	if err != nil || len(actions) != 1 || actions[0].Path != "prov/peers" || actions[0].Content != "etcd1 etcd2 " {
+		t.Errorf("Expected task peers to render with the peers of etcd1, got %v: %v", actions, err)
+	}
+}
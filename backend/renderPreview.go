@@ -0,0 +1,159 @@
+package backend
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/digitalrebar/provision/models"
+)
+
+// RenderPreviewLocks are the locks that must be held to call
+// Machine.RenderPreview.  They are the same ones a renderer takes
+// when it is asked for its contents.
+var RenderPreviewLocks = []string{
+	"templates",
+	"tasks",
+	"stages",
+	"bootenvs",
+	"machines",
+	"profiles",
+	"params",
+	"preferences",
+	"subnets",
+	"reservations",
+	"tenants",
+}
+
+// preview renders what rt serves for the target of r, recovering
+// from any panics the template causes along the way.
+func (r *RenderData) preview(rt renderer) (res string, err error) {
+	tmplKey := rt.name
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("Failed to render: %s Check containing objects\n%v", tmplKey, p)
+		}
+	}()
+	if r.Machine != nil {
+		r.Machine.currMac = rt.mac
+	}
+	if rt.preview != nil {
+		return rt.preview(r)
+	}
+	r.tmplKey, r.tmplPath = tmplKey, rt.path
+	tmpl := r.target.templates().Lookup(tmplKey)
+	if tmpl == nil {
+		return "", fmt.Errorf("Missing template: %s", tmplKey)
+	}
+	buf := &bytes.Buffer{}
+	if err = tmpl.Execute(buf, r); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// RenderPreview renders the templates of the passed BootEnv, Stage
+// and Task for the Machine as if it were in them, without saving the
+// Machine or registering anything with the file server.  An empty
+// bootEnv or stage means the one the Machine is currently in, and an
+// empty task means no Task templates are rendered.  Templates are
+// rendered as if they were requested from remoteIP.
+//
+// Problems rendering individual templates are reported in the
+// returned RenderPreview, and the returned error is only set when
+// the BootEnv, Stage or Task cannot be used at all.  The caller must
+// hold RenderPreviewLocks.
+func (n *Machine) RenderPreview(rt *RequestTracker,
+	bootEnv, stage, task string,
+	remoteIP net.IP) (*models.RenderPreview, error) {
+	if bootEnv == "" {
+		bootEnv = n.BootEnv
+	}
+	if stage == "" {
+		stage = n.Stage
+	}
+	e := &models.Error{
+		Code:  http.StatusUnprocessableEntity,
+		Type:  ValidationError,
+		Model: n.Prefix(),
+		Key:   n.Key(),
+	}
+	// Render against a copy, so that the BootEnv and Stage it points
+	// at can be changed without touching the real Machine.
+	m := &Machine{Machine: models.Clone(n.Machine).(*models.Machine)}
+	m.BootEnv, m.Stage = bootEnv, stage
+	targets := []renderable{}
+	if bootEnv != "" {
+		if obj := rt.find("bootenvs", bootEnv); obj == nil {
+			e.Errorf("BootEnv %s does not exist", bootEnv)
+		} else if env := AsBootEnv(obj); !env.Available {
+			e.Errorf("BootEnv %s is not available", bootEnv)
+		} else {
+			targets = append(targets, env)
+		}
+	}
+	if stage != "" {
+		if obj := rt.find("stages", stage); obj == nil {
+			e.Errorf("Stage %s does not exist", stage)
+		} else if s := AsStage(obj); !s.Available {
+			e.Errorf("Stage %s is not available", stage)
+		} else {
+			targets = append(targets, s)
+		}
+	}
+	if task != "" {
+		if obj := rt.find("tasks", task); obj == nil {
+			e.Errorf("Task %s does not exist", task)
+		} else if t := AsTask(obj); !t.Available {
+			e.Errorf("Task %s is not available", task)
+		} else {
+			targets = append(targets, t)
+		}
+	}
+	if e.ContainsError() {
+		return nil, e
+	}
+	res := &models.RenderPreview{
+		Machine:   n.Key(),
+		BootEnv:   bootEnv,
+		Stage:     stage,
+		Task:      task,
+		Templates: []*models.RenderedTemplate{},
+		Errors:    []string{},
+	}
+	for _, target := range targets {
+		source := target.Prefix() + ":" + target.Key()
+		setup := &models.Error{}
+		var rts renderers
+		switch obj := target.(type) {
+		case *BootEnv:
+			rts = obj.render(rt, m, setup)
+		case *Stage:
+			rts = obj.render(rt, m, setup)
+		case *Task:
+			rts = obj.render(rt, m, setup)
+		}
+		for _, msg := range setup.Messages {
+			res.Errors = append(res.Errors, source+": "+msg)
+		}
+		rd := newRenderData(rt, m, target)
+		rd.remoteIP = remoteIP
+		for _, r := range rts {
+			tmpl := &models.RenderedTemplate{
+				Source:       source,
+				Name:         r.name,
+				TemplateInfo: r.infoName,
+				Path:         r.path,
+				Meta:         r.meta,
+			}
+			if content, err := rd.preview(r); err != nil {
+				tmpl.Error = err.Error()
+			} else {
+				tmpl.Content = content
+			}
+			res.Templates = append(res.Templates, tmpl)
+		}
+	}
+	return res, nil
+}
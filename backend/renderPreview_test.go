@@ -0,0 +1,129 @@
+package backend
+
+import (
+	"net"
+	"testing"
+
+	"github.com/digitalrebar/provision/models"
+	"github.com/pborman/uuid"
+)
+
+func TestRenderPreview(t *testing.T) {
+	dt := mkDT()
+	rt := dt.Request(dt.Logger,
+		"stages",
+		"bootenvs",
+		"templates",
+		"machines",
+		"profiles",
+		"params",
+		"tasks",
+		"preferences",
+		"workflows")
+	objs := []crudTest{
+		{"Create included template", rt.Create, &models.Template{ID: "included", Contents: tmplIncluded}, true},
+		{"Create default template", rt.Create, &models.Template{ID: "default", Contents: tmplDefault}, true},
+		{"Create nothing template", rt.Create, &models.Template{ID: "nothing", Contents: tmplNothing}, true},
+		{"Create default bootenv", rt.Create, &models.BootEnv{
+			Name: "default",
+			Templates: []models.TemplateInfo{
+				{Name: "ipxe", Path: "machines/{{.Machine.UUID}}/file", ID: "default"},
+				{Name: "other", Path: "machines/{{.Machine.UUID}}/other", ID: "default"},
+			},
+			BootParams: "{{.Env.Name}}",
+		}, true},
+		{"Create per-MAC bootenv", rt.Create, &models.BootEnv{
+			Name: "permac",
+			Templates: []models.TemplateInfo{
+				{Name: "pxelinux-mac", Path: `pxelinux.cfg/{{.Machine.MacAddr "pxelinux"}}`, Contents: "{{.Machine.MacAddr}}"},
+			},
+			BootParams: "{{.Env.Name}}",
+		}, true},
+		{"Create nothing bootenv", rt.Create, &models.BootEnv{
+			Name: "nothing",
+			Templates: []models.TemplateInfo{
+				{Name: "ipxe", Path: "machines/{{.Machine.UUID}}/file", ID: "nothing"},
+			},
+			BootParams: "{{.Env.Name}}",
+		}, true},
+	}
+	for _, obj := range objs {
+		obj.Test(t, rt)
+	}
+	machine := &Machine{}
+	Fill(machine)
+	machine.Uuid = uuid.NewRandom()
+	machine.Name = "Test Name"
+	machine.Address = net.ParseIP("192.168.124.11")
+	machine.HardwareAddrs = []string{"52:54:00:00:00:01", "52:54:00:00:00:02"}
+	machine.BootEnv = "nothing"
+	machine.Params["foo"] = "bar"
+	rt.Do(func(d Stores) {
+		if created, err := rt.Create(machine); !created {
+			t.Fatalf("Failed to create new test machine: %v", err)
+		}
+	})
+	var res *models.RenderPreview
+	var err error
+	rt.Do(func(d Stores) {
+		res, err = AsMachine(rt.Find("machines", machine.Key())).RenderPreview(rt, "default", "", "", nil)
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error rendering preview: %v", err)
+	}
+	if res.BootEnv != "default" {
+		t.Errorf("Expected preview for bootenv default, not %s", res.BootEnv)
+	}
+	found := 0
+	for _, tmpl := range res.Templates {
+		if tmpl.Source != "bootenvs:default" {
+			continue
+		}
+		found++
+		if tmpl.Name != "default" || (tmpl.TemplateInfo != "ipxe" && tmpl.TemplateInfo != "other") {
+			t.Errorf("Expected template default from ipxe or other, got %s from %s", tmpl.Name, tmpl.TemplateInfo)
+		}
+		if tmpl.Error != "" {
+			t.Errorf("Unexpected error rendering %s: %s", tmpl.Path, tmpl.Error)
+		} else if tmpl.Content != tmplDefaultRenderedWithoutFred {
+			t.Errorf("Failed to render expected template!\nExpected:\n%s\n\nGot:\n%s", tmplDefaultRenderedWithoutFred, tmpl.Content)
+		}
+	}
+	if found != 2 {
+		t.Errorf("Expected 2 templates from bootenvs:default, got %d", found)
+	}
+	rt.Do(func(d Stores) {
+		if m := AsMachine(rt.Find("machines", machine.Key())); m.BootEnv != "nothing" {
+			t.Errorf("Render preview changed the machine bootenv to %s", m.BootEnv)
+		}
+		_, err = AsMachine(rt.Find("machines", machine.Key())).RenderPreview(rt, "missing", "", "", nil)
+	})
+	if err == nil {
+		t.Errorf("Expected an error rendering a missing bootenv")
+	}
+	// Templates rendered once per MAC address are previewed with the
+	// MAC address they were made for.
+	rt.Do(func(d Stores) {
+		res, err = AsMachine(rt.Find("machines", machine.Key())).RenderPreview(rt, "permac", "", "", nil)
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error rendering preview: %v", err)
+	}
+	macs := map[string]string{}
+	for _, tmpl := range res.Templates {
+		if tmpl.Source == "bootenvs:permac" {
+			macs[tmpl.Path] = tmpl.Content
+		}
+	}
+	for path, mac := range map[string]string{
+		"/pxelinux.cfg/01-52-54-00-00-00-01": "52:54:00:00:00:01",
+		"/pxelinux.cfg/01-52-54-00-00-00-02": "52:54:00:00:00:02",
+	} {
+		if macs[path] != mac {
+			t.Errorf("Expected %s to render to %s, got %q", path, mac, macs[path])
+		}
+	}
+	if out, _ := dt.FS.Open("/machines/"+machine.Key()+"/other", nil); out != nil {
+		t.Errorf("Render preview registered a template with the file server")
+	}
+}
@@ -0,0 +1,412 @@
+package backend
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VictorLowther/jsonpatch2/utils"
+)
+
+// RepoCachePath is where the package repository caching proxy lives
+// on the static file server.  Upstream URLs are mapped into it as
+// RepoCachePath/<scheme>/<host>/<path>.
+const RepoCachePath = "/repo-cache"
+
+// defaultRepoCacheMetadataAge is how long cached repository metadata
+// is used before it is fetched again when the repoCacheMetadataAge
+// preference is not set.
+const defaultRepoCacheMetadataAge = 5 * time.Minute
+
+type repoCacheEntry struct {
+	size    int64
+	fetched time.Time
+	used    time.Time
+}
+
+// repoCache tracks what is on disk in the package repository cache
+// so that it can be kept under the size limit.  Least recently used
+// files are evicted first.
+type repoCache struct {
+	sync.Mutex
+	root    string
+	used    int64
+	entries map[string]*repoCacheEntry
+}
+
+func newRepoCache() *repoCache {
+	return &repoCache{entries: map[string]*repoCacheEntry{}}
+}
+
+// load indexes the files already in the cache the first time root is
+// used.  Partial downloads left over from a crash are removed.
+func (c *repoCache) load(root string) {
+	if c.root == root {
+		return
+	}
+	c.root = root
+	c.used = 0
+	c.entries = map[string]*repoCacheEntry{}
+	filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil || !fi.Mode().IsRegular() {
+			return nil
+		}
+		if strings.HasPrefix(fi.Name(), ".repo-cache-") {
+			os.Remove(p)
+			return nil
+		}
+		key := filepath.ToSlash(strings.TrimPrefix(p, root))
+		c.entries[key] = &repoCacheEntry{size: fi.Size(), fetched: fi.ModTime(), used: fi.ModTime()}
+		c.used += fi.Size()
+		return nil
+	})
+}
+
+// open returns the cached file for key if there is one and it is
+// no older than maxAge.  A negative maxAge never expires the file, and
+// stale files are still returned when stale is true.
+func (c *repoCache) open(key string, maxAge time.Duration, stale bool) *cachedFile {
+	c.Lock()
+	defer c.Unlock()
+	ent := c.entries[key]
+	if ent == nil {
+		return nil
+	}
+	if !stale && maxAge >= 0 && time.Since(ent.fetched) > maxAge {
+		return nil
+	}
+	fi, err := os.Open(path.Join(c.root, key))
+	if err != nil {
+		c.used -= ent.size
+		delete(c.entries, key)
+		return nil
+	}
+	ent.used = time.Now()
+	return &cachedFile{File: fi, size: ent.size}
+}
+
+// add records that key has been written to the cache, and evicts
+// the least recently used files until the cache fits in limit.
+func (c *repoCache) add(key string, size, limit int64) {
+	c.Lock()
+	defer c.Unlock()
+	if ent := c.entries[key]; ent != nil {
+		c.used -= ent.size
+	}
+	now := time.Now()
+	c.entries[key] = &repoCacheEntry{size: size, fetched: now, used: now}
+	c.used += size
+	c.evict(limit)
+}
+
+func (c *repoCache) evict(limit int64) {
+	if c.used <= limit {
+		return
+	}
+	keys := make([]string, 0, len(c.entries))
+	for k := range c.entries {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return c.entries[keys[i]].used.Before(c.entries[keys[j]].used)
+	})
+	for _, k := range keys {
+		if c.used <= limit {
+			break
+		}
+		os.Remove(path.Join(c.root, k))
+		c.used -= c.entries[k].size
+		delete(c.entries, k)
+	}
+}
+
+// cachedFile is a file being served out of the cache.
+type cachedFile struct {
+	*os.File
+	size int64
+}
+
+func (f *cachedFile) Size() int64 {
+	return f.size
+}
+
+// repoCacheFill passes an upstream response through to the client
+// while saving it in the cache.  The file is only added to the cache
+// once all of it has been read.
+type repoCacheFill struct {
+	body      io.ReadCloser
+	tmp       *os.File
+	cache     *repoCache
+	key       string
+	dest      string
+	want, got int64
+	limit     int64
+}
+
+func (f *repoCacheFill) Read(buf []byte) (int, error) {
+	n, err := f.body.Read(buf)
+	if n > 0 && f.tmp != nil {
+		if _, werr := f.tmp.Write(buf[:n]); werr != nil {
+			f.abandon()
+		}
+		f.got += int64(n)
+		if f.got > f.limit && f.tmp != nil {
+			f.abandon()
+		}
+	}
+	if err == io.EOF && f.tmp != nil && (f.want < 0 || f.want == f.got) {
+		f.finish()
+	}
+	return n, err
+}
+
+func (f *repoCacheFill) finish() {
+	tmpName := f.tmp.Name()
+	if f.tmp.Close() != nil || os.Rename(tmpName, f.dest) != nil {
+		os.Remove(tmpName)
+	} else {
+		f.cache.add(f.key, f.got, f.limit)
+	}
+	f.tmp = nil
+}
+
+func (f *repoCacheFill) abandon() {
+	f.tmp.Close()
+	os.Remove(f.tmp.Name())
+	f.tmp = nil
+}
+
+func (f *repoCacheFill) Close() error {
+	if f.tmp != nil {
+		f.abandon()
+	}
+	return f.body.Close()
+}
+
+// sizedRepoCacheFill is a repoCacheFill for a response whose length
+// is known up front.
+type sizedRepoCacheFill struct {
+	*repoCacheFill
+}
+
+func (f sizedRepoCacheFill) Size() int64 {
+	return f.want
+}
+
+// isRepoMetadata returns whether p is part of the index of a yum or
+// apt repository.  The index changes in place as the repository is
+// updated, so it must be fetched again every so often.
+func isRepoMetadata(p string) bool {
+	parts := strings.Split(p, "/")
+	for _, part := range parts[:len(parts)-1] {
+		switch part {
+		case "repodata", "dists":
+			return !strings.Contains(p, "/by-hash/")
+		}
+	}
+	return false
+}
+
+// repoCacheUpstream turns a path under RepoCachePath back into the
+// upstream URL it caches and the key it is cached under.
+func repoCacheUpstream(p string) (upstream, key string, ok bool) {
+	rest := strings.TrimPrefix(p, RepoCachePath+"/")
+	if rest == p {
+		return
+	}
+	parts := strings.SplitN(rest, "/", 3)
+	if len(parts) != 3 || parts[1] == "" || parts[2] == "" {
+		return
+	}
+	switch parts[0] {
+	case "http", "https":
+	default:
+		return
+	}
+	if strings.Contains("/"+parts[2]+"/", "/../") {
+		return
+	}
+	u := &url.URL{Scheme: parts[0], Host: parts[1], Path: "/" + parts[2]}
+	return u.String(), "/" + rest, true
+}
+
+// repoUnder returns whether upstream is repoURL or something under
+// it.
+func repoUnder(upstream, repoURL string) bool {
+	u, err := url.Parse(repoURL)
+	if err != nil || u.Host == "" || u.User != nil || u.RawQuery != "" {
+		return false
+	}
+	prefix := strings.TrimSuffix(u.String(), "/")
+	return upstream == prefix || strings.HasPrefix(upstream, prefix+"/")
+}
+
+// repoCacheAllowed returns whether upstream is under the URL of one
+// of the repos in the package-repositories param as a Machine sees
+// it, which is every repo fetchRepos can rewrite to go through the
+// cache.  Only those are fetched, so the cache cannot be used to
+// reach anything else.  The default value and Profiles are checked
+// first, since that is where repos are usually set.
+func (p *DataTracker) repoCacheAllowed(upstream string) bool {
+	res := false
+	check := func(val interface{}) {
+		repos := []*Repo{}
+		if val == nil || utils.Remarshal(val, &repos) != nil {
+			return
+		}
+		for _, repo := range repos {
+			if repoUnder(upstream, repo.URL) {
+				res = true
+				return
+			}
+		}
+	}
+	rt := p.Request(p.Logger, machineLockMap["get"]...)
+	rt.Do(func(d Stores) {
+		if obj := rt.find("params", "package-repositories"); obj != nil {
+			def, _ := AsParam(obj).DefaultValue()
+			check(def)
+		}
+		for _, obj := range d("profiles").Items() {
+			if res {
+				return
+			}
+			check(AsProfile(obj).Params["package-repositories"])
+		}
+		for _, obj := range d("machines").Items() {
+			if res {
+				return
+			}
+			if val, ok, err := rt.getParam(AsMachine(obj), "package-repositories", true, false); ok && err == nil {
+				check(val)
+			}
+		}
+	})
+	return res
+}
+
+// repoCacheClient fetches files for the package repository cache.
+// Upstreams that stop answering must not tie up the static file
+// server forever.
+var repoCacheClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSHandshakeTimeout:   30 * time.Second,
+		ResponseHeaderTimeout: time.Minute,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       90 * time.Second,
+	},
+	Timeout: 30 * time.Minute,
+}
+
+// RepoCacheURL returns the URL that proxies upstream through the
+// package repository cache served at base.  URLs that the cache
+// cannot handle are returned unchanged.
+func RepoCacheURL(base, upstream string) string {
+	u, err := url.Parse(upstream)
+	if err != nil || u.Host == "" || u.User != nil || u.RawQuery != "" {
+		return upstream
+	}
+	switch u.Scheme {
+	case "http", "https":
+	default:
+		return upstream
+	}
+	return base + path.Join(RepoCachePath, u.Scheme, u.Host) + u.EscapedPath()
+}
+
+// repoCacheLimit returns the size limit of the package repository
+// cache in bytes, or 0 if the cache is disabled.
+func (p *DataTracker) repoCacheLimit() int64 {
+	if p.RepoCacheRoot == "" {
+		return 0
+	}
+	v, err := strconv.ParseInt(p.pref("repoCacheSize"), 10, 64)
+	if err != nil || v <= 0 {
+		return 0
+	}
+	return v << 20
+}
+
+func (p *DataTracker) repoCacheMetadataAge() time.Duration {
+	if v, err := strconv.Atoi(p.pref("repoCacheMetadataAge")); err == nil && v >= 0 {
+		return time.Duration(v) * time.Second
+	}
+	return defaultRepoCacheMetadataAge
+}
+
+// repoCacheServe is the static file server side of the package
+// repository caching proxy.  Only files under the URLs of known
+// package repositories are served.  They are served from the cache
+// if they are there, and fetched from upstream and saved otherwise.
+// If upstream cannot be reached, whatever is in the cache is served
+// even if it is stale.
+func (p *DataTracker) repoCacheServe(req *DynamicRequest) (io.Reader, error) {
+	limit := p.repoCacheLimit()
+	if limit == 0 {
+		return nil, nil
+	}
+	upstream, key, ok := repoCacheUpstream(req.Path)
+	if !ok || !p.repoCacheAllowed(upstream) {
+		return nil, nil
+	}
+	c := p.repoCache
+	c.Lock()
+	c.load(p.RepoCacheRoot)
+	c.Unlock()
+	maxAge := time.Duration(-1)
+	if isRepoMetadata(key) {
+		maxAge = p.repoCacheMetadataAge()
+	}
+	if res := c.open(key, maxAge, false); res != nil {
+		return res, nil
+	}
+	resp, err := repoCacheClient.Get(upstream)
+	if err == nil && resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		switch resp.StatusCode {
+		case http.StatusNotFound, http.StatusGone:
+			return nil, nil
+		}
+		err = fmt.Errorf("%s: %s", upstream, resp.Status)
+	}
+	if err != nil {
+		if res := c.open(key, maxAge, true); res != nil {
+			p.Infof("Repo cache: serving stale %s: %v", key, err)
+			return res, nil
+		}
+		return nil, err
+	}
+	fill := &repoCacheFill{
+		body:  resp.Body,
+		cache: c,
+		key:   key,
+		dest:  path.Join(p.RepoCacheRoot, key),
+		want:  resp.ContentLength,
+		limit: limit,
+	}
+	if fill.want <= limit {
+		if err := os.MkdirAll(path.Dir(fill.dest), 0755); err == nil {
+			fill.tmp, _ = ioutil.TempFile(path.Dir(fill.dest), ".repo-cache-")
+		}
+	}
+	if fill.want >= 0 {
+		return sizedRepoCacheFill{fill}, nil
+	}
+	return fill, nil
+}
@@ -0,0 +1,190 @@
+package backend
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/digitalrebar/provision/models"
+	"github.com/pborman/uuid"
+)
+
+func TestRepoCache(t *testing.T) {
+	dt := mkDT()
+	dt.RepoCacheRoot = path.Join(tmpDir, "repo-cache")
+	rt := dt.Request(dt.Logger)
+	hits := map[string]int{}
+	hitMux := &sync.Mutex{}
+	files := map[string][]byte{
+		"/centos/a.rpm":                bytes.Repeat([]byte("a"), 400<<10),
+		"/centos/b.rpm":                bytes.Repeat([]byte("b"), 400<<10),
+		"/centos/c.rpm":                bytes.Repeat([]byte("c"), 400<<10),
+		"/centos/big.rpm":              bytes.Repeat([]byte("d"), 2<<20),
+		"/centos/repodata/repomd.xml":  []byte("<repomd/>"),
+		"/ubuntu/dists/bionic/Release": []byte("Suite: bionic"),
+		"/internal/secret":             []byte("secret"),
+	}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hitMux.Lock()
+		hits[r.URL.Path]++
+		hitMux.Unlock()
+		if buf, ok := files[r.URL.Path]; ok {
+			w.Write(buf)
+		} else {
+			http.NotFound(w, r)
+		}
+	}))
+	defer upstream.Close()
+	host := strings.TrimPrefix(upstream.URL, "http://")
+	setPrefs := func(prefs map[string]string) {
+		rt.AllLocked(func(d Stores) {
+			if err := dt.SetPrefs(rt, prefs); err != nil {
+				t.Fatalf("Failed to set prefs %v: %v", prefs, err)
+			}
+		})
+	}
+	get := func(p string) ([]byte, bool) {
+		out, err := dt.FS.open(&DynamicRequest{Path: path.Join(RepoCachePath, "http", host, p), RemoteIP: net.ParseIP("192.168.124.21")})
+		if err != nil {
+			t.Errorf("Error getting %s: %v", p, err)
+			return nil, false
+		}
+		if out == nil {
+			return nil, false
+		}
+		buf, _ := ioutil.ReadAll(out)
+		out.(interface{ Close() error }).Close()
+		return buf, true
+	}
+	checkHits := func(p string, want int) {
+		hitMux.Lock()
+		defer hitMux.Unlock()
+		if hits[p] != want {
+			t.Errorf("Expected %d upstream fetches of %s, not %d", want, p, hits[p])
+		}
+	}
+	if _, ok := get("/centos/a.rpm"); ok {
+		t.Errorf("Repo cache served a file while it was disabled")
+	}
+	checkHits("/centos/a.rpm", 0)
+
+	setPrefs(map[string]string{"repoCacheSize": "1", "repoCacheMetadataAge": "3600"})
+	if _, ok := get("/centos/a.rpm"); ok {
+		t.Errorf("Repo cache served a file that is not in a known repo")
+	}
+	rt.AllLocked(func(d Stores) {
+		repos := &models.Profile{
+			Name: "repos",
+			Params: map[string]interface{}{
+				"package-repositories": []interface{}{
+					map[string]interface{}{"tag": "centos", "url": upstream.URL + "/centos/"},
+					map[string]interface{}{"tag": "ubuntu", "url": upstream.URL + "/ubuntu"},
+				},
+			},
+		}
+		if created, err := rt.Create(repos); !created {
+			t.Fatalf("Failed to create profile: %v", err)
+		}
+	})
+	for _, p := range []string{"/internal/secret", "/centos/../internal/secret", "/centosx/a.rpm"} {
+		if _, ok := get(p); ok {
+			t.Errorf("Repo cache served %s, which is not in a known repo", p)
+		}
+	}
+	checkHits("/internal/secret", 0)
+	for i := 0; i < 2; i++ {
+		if buf, ok := get("/centos/a.rpm"); !ok || !bytes.Equal(buf, files["/centos/a.rpm"]) {
+			t.Errorf("Fetch %d of a.rpm did not return the upstream contents", i)
+		}
+	}
+	checkHits("/centos/a.rpm", 1)
+	if _, ok := get("/centos/missing.rpm"); ok {
+		t.Errorf("Repo cache served a file upstream does not have")
+	}
+	// Files bigger than the cache are passed through without being saved.
+	for i := 0; i < 2; i++ {
+		if buf, _ := get("/centos/big.rpm"); len(buf) != len(files["/centos/big.rpm"]) {
+			t.Errorf("Fetch %d of big.rpm returned %d bytes", i, len(buf))
+		}
+	}
+	checkHits("/centos/big.rpm", 2)
+	// Filling the cache evicts the least recently used file.
+	get("/centos/b.rpm")
+	get("/centos/a.rpm")
+	get("/centos/c.rpm")
+	get("/centos/a.rpm")
+	get("/centos/b.rpm")
+	checkHits("/centos/a.rpm", 1)
+	checkHits("/centos/b.rpm", 2)
+	checkHits("/centos/c.rpm", 1)
+	if dt.repoCache.used > 1<<20 {
+		t.Errorf("Repo cache is using %d bytes, more than its 1 MB limit", dt.repoCache.used)
+	}
+
+	// Metadata is fetched again once it is too old, unless upstream
+	// cannot be reached.
+	get("/centos/repodata/repomd.xml")
+	get("/centos/repodata/repomd.xml")
+	checkHits("/centos/repodata/repomd.xml", 1)
+	setPrefs(map[string]string{"repoCacheMetadataAge": "0"})
+	get("/centos/repodata/repomd.xml")
+	checkHits("/centos/repodata/repomd.xml", 2)
+	get("/ubuntu/dists/bionic/Release")
+	upstream.Close()
+	if buf, _ := get("/ubuntu/dists/bionic/Release"); string(buf) != "Suite: bionic" {
+		t.Errorf("Expected stale Release file while upstream is down, not %q", string(buf))
+	}
+
+	// package-repositories-cache rewrites repo URLs to go through the cache.
+	m := &Machine{}
+	var repos []*Repo
+	var base string
+	rt.AllLocked(func(d Stores) {
+		Fill(m)
+		m.Uuid = uuid.NewRandom()
+		m.Name = "repo.example.com"
+		m.OS = "centos-7"
+		m.Params = map[string]interface{}{
+			"package-repositories": []interface{}{
+				map[string]interface{}{"tag": "centos-7-base", "os": []interface{}{"centos-7"}, "url": "http://mirror.example.com/centos/7/os/x86_64"},
+				map[string]interface{}{"tag": "centos-7-local", "os": []interface{}{"centos-7"}, "url": "ftp://mirror.example.com/centos"},
+			},
+			"package-repositories-cache": true,
+		}
+		if created, err := rt.Create(m); !created {
+			t.Fatalf("Failed to create machine: %v", err)
+		}
+		repos = newRenderData(rt, m, nil).Repos("centos-7-base", "centos-7-local")
+		base = rt.FileURL(nil)
+	})
+	for _, test := range []struct{ tag, url string }{
+		{"centos-7-base", base + "/repo-cache/http/mirror.example.com/centos/7/os/x86_64"},
+		{"centos-7-local", "ftp://mirror.example.com/centos"},
+	} {
+		found := false
+		for _, repo := range repos {
+			if repo.Tag == test.tag {
+				found = true
+				if repo.URL != test.url {
+					t.Errorf("Repo %s: expected URL %s, not %s", test.tag, test.url, repo.URL)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("Repo %s not found", test.tag)
+		}
+	}
+	// Repos set on the Machine itself can be fetched through the cache.
+	if !dt.repoCacheAllowed("http://mirror.example.com/centos/7/os/x86_64/repodata/repomd.xml") {
+		t.Errorf("Expected the cache to fetch files from a repo set on a machine")
+	}
+	if dt.repoCacheAllowed("http://mirror.example.com/centos/8/os/x86_64/repodata/repomd.xml") {
+		t.Errorf("Expected the cache to refuse files from a repo no machine uses")
+	}
+}
@@ -29,13 +29,57 @@ type RequestTracker struct {
 	dt        *DataTracker
 	locks     []string
 	d         Stores
+	allLocked bool
 	toPublish []func()
+	auth      Authorizer
+}
+
+// Authorizer answers what the caller of an API may do.  The frontend
+// checks the claims an API call needs against the object it names,
+// and an Authorizer lets the backend do the same for the other
+// objects the call ends up touching.
+type Authorizer interface {
+	// Visible returns whether obj is in the Tenant of the caller, if
+	// it has one.
+	Visible(obj models.Model) bool
+	// Allowed returns whether the caller holds the scope:action:specific
+	// claim.
+	Allowed(scope, action, specific string) bool
+	// Tenant returns the name of the Tenant of the caller, if any.
+	Tenant() string
+}
+
+// SetAuth makes rt check what it does on behalf of the caller with a.
+// A RequestTracker without an Authorizer is acting for dr-provision
+// itself, and may do anything.
+func (rt *RequestTracker) SetAuth(a Authorizer) {
+	rt.auth = a
+}
+
+// visible returns whether the caller can see obj.
+func (rt *RequestTracker) visible(obj models.Model) bool {
+	return rt.auth == nil || rt.auth.Visible(obj)
+}
+
+// allowed returns whether the caller holds the scope:action:specific
+// claim.
+func (rt *RequestTracker) allowed(scope, action, specific string) bool {
+	return rt.auth == nil || rt.auth.Allowed(scope, action, specific)
+}
+
+// tenant returns the name of the Tenant of the caller, if any.
+func (rt *RequestTracker) tenant() string {
+	if rt.auth == nil {
+		return ""
+	}
+	return rt.auth.Tenant()
 }
 
 func (rt *RequestTracker) unlocker(u func()) {
 	rt.Lock()
 	u()
 	rt.d = nil
+	rt.allLocked = false
 	for _, f := range rt.toPublish {
 		f()
 	}
@@ -146,6 +190,23 @@ func (rt *RequestTracker) FindByIndex(prefix string, idx index.Maker, key string
 	return items.Find(key)
 }
 
+// locked returns whether the RequestTracker currently holds the
+// lock for prefix.
+func (rt *RequestTracker) locked(prefix string) bool {
+	if rt.d == nil {
+		return false
+	}
+	if rt.allLocked {
+		return true
+	}
+	for _, l := range rt.locks {
+		if l == prefix {
+			return true
+		}
+	}
+	return false
+}
+
 // Index returns the index specified by that name.
 // No validation is done on the name.
 func (rt *RequestTracker) Index(name string) *index.Index {
@@ -177,6 +238,7 @@ func (rt *RequestTracker) AllLocked(thunk func(Stores)) {
 	rt.Lock()
 	d, unlocker := rt.dt.lockAll()
 	rt.d = d
+	rt.allLocked = true
 	rt.Unlock()
 	defer rt.unlocker(unlocker)
 	thunk(d)
@@ -368,6 +430,9 @@ func (rt *RequestTracker) Patch(obj models.Model, key string, patch jsonpatch2.P
 			}
 		}
 	}
+	if a, ok := obj.(unlockForcer); ok && a.UnlockForced() {
+		toSave.(unlockForcer).ForceUnlock()
+	}
 	saved, err := store.Update(backend, toSave)
 	toSave.(validator).clearRT()
 	if saved {
@@ -465,16 +530,20 @@ func (rt *RequestTracker) decryptParam(
 	return ret
 }
 
-func (rt *RequestTracker) getAggParams(obj models.Paramer,
-	params map[string]interface{}, aggregate bool) (sources map[string]models.Paramer) {
-	sources = map[string]models.Paramer{}
-	for k := range params {
-		sources[k] = obj
-	}
-	if !aggregate {
-		return
-	}
-	subObjs := []models.Paramer{}
+// paramLink is an object that params are aggregated from, along with
+// how it was reached.
+type paramLink struct {
+	obj models.Paramer
+	via string
+}
+
+// paramChain returns the objects whose params are aggregated into the
+// params of obj, in order of precedence.  obj itself is not included.
+// Each Profile is followed by the Profiles it includes, depth first,
+// and a Profile that was already reached is skipped.
+func (rt *RequestTracker) paramChain(obj models.Paramer) []paramLink {
+	res := []paramLink{}
+	seen := map[string]struct{}{}
 	var profiles []string
 	var stage string
 	switch ref := obj.(type) {
@@ -484,35 +553,107 @@ func (rt *RequestTracker) getAggParams(obj models.Paramer,
 		profiles, stage = ref.Profiles, ref.Stage
 	case *Machine:
 		profiles, stage = ref.Profiles, ref.Stage
+	case *models.Profile:
+		seen[ref.Name] = struct{}{}
+		profiles = ref.Profiles
+	case *Profile:
+		seen[ref.Name] = struct{}{}
+		profiles = ref.Profiles
+	}
+	var addProfile func(name, via string)
+	addProfile = func(name, via string) {
+		if _, ok := seen[name]; ok {
+			return
+		}
+		pobj := rt.Find("profiles", name)
+		if pobj == nil {
+			return
+		}
+		seen[name] = struct{}{}
+		prof := AsProfile(pobj)
+		res = append(res, paramLink{prof, via})
+		for _, pn := range prof.Profiles {
+			addProfile(pn, "include:"+name)
+		}
 	}
 	for _, pn := range profiles {
-		if pobj := rt.Find("profiles", pn); pobj != nil {
-			subObjs = append(subObjs, pobj.(models.Paramer))
-		}
+		addProfile(pn, "profile")
 	}
 	if stage != "" {
 		if sobj := rt.Find("stages", stage); sobj != nil {
 			for _, pn := range AsStage(sobj).Profiles {
-				if pobj := rt.Find("profiles", pn); pobj != nil {
-					subObjs = append(subObjs, pobj.(models.Paramer))
-				}
+				addProfile(pn, "stage:"+stage)
 			}
 		}
 	}
-	if pobj := rt.Find("profiles", rt.dt.GlobalProfileName); pobj != nil {
-		subObjs = append(subObjs, pobj.(models.Paramer))
+	addProfile(rt.dt.GlobalProfileName, "global")
+	return res
+}
+
+func (rt *RequestTracker) getAggParams(obj models.Paramer,
+	params map[string]interface{}, aggregate bool) (sources map[string]models.Paramer) {
+	sources = map[string]models.Paramer{}
+	for k := range params {
+		sources[k] = obj
+	}
+	if !aggregate {
+		return
 	}
-	for _, sub := range subObjs {
-		for k, v := range sub.GetParams() {
+	for _, link := range rt.paramChain(obj) {
+		for k, v := range link.obj.GetParams() {
 			if _, ok := params[k]; !ok {
 				params[k] = v
-				sources[k] = sub
+				sources[k] = link.obj
 			}
 		}
 	}
 	return
 }
 
+// ExplainParam returns the aggregated value of the param key on obj
+// along with every object that defined it, in the same order of
+// precedence GetParam uses.  The source whose value GetParam would
+// return is marked as Used.  The sources have the values the objects
+// define, but the value of a computed param is the computed one.
+func (rt *RequestTracker) ExplainParam(obj models.Paramer, key string, decrypt bool) *models.ParamExplanation {
+	res := &models.ParamExplanation{Name: key, Sources: []*models.ParamSource{}}
+	add := func(src models.Model, via string, val interface{}) {
+		ps := &models.ParamSource{
+			Prefix: src.Prefix(),
+			Key:    src.Key(),
+			Via:    via,
+			Layer:  rt.dt.Backend.LayerFor(src.Prefix(), src.Key()),
+			Value:  val,
+		}
+		if !res.Found {
+			res.Found, res.Value, ps.Used = true, ps.Value, true
+		}
+		res.Sources = append(res.Sources, ps)
+	}
+	if v, ok := obj.GetParams()[key]; ok {
+		add(obj, "object", rt.decryptParam(obj, key, v, decrypt))
+	}
+	for _, link := range rt.paramChain(obj) {
+		if v, ok := link.obj.GetParams()[key]; ok {
+			add(link.obj, link.via, rt.decryptParam(link.obj, key, v, decrypt))
+		}
+	}
+	if pobj := rt.Find("params", key); pobj != nil {
+		if v, ok := AsParam(pobj).DefaultValue(); ok {
+			add(pobj, "default", v)
+		}
+	}
+	if r := rt.renderDataFor(obj); r != nil && res.Found {
+		val, err := rt.computeParam(r, key, res.Value)
+		if err != nil {
+			res.Value, res.Error = nil, err.Error()
+		} else {
+			res.Value = val
+		}
+	}
+	return res
+}
+
 func (rt *RequestTracker) GetParams(obj models.Paramer, aggregate bool, decrypt bool) map[string]interface{} {
 	res := obj.GetParams()
 	sources := rt.getAggParams(obj, res, aggregate)
@@ -521,22 +662,58 @@ func (rt *RequestTracker) GetParams(obj models.Paramer, aggregate bool, decrypt
 			res[k] = rt.decryptParam(src, k, res[k], decrypt)
 		}
 	}
+	if !aggregate {
+		return res
+	}
+	if r := rt.renderDataFor(obj); r != nil {
+		for k, v := range res {
+			val, err := rt.computeParam(r, k, v)
+			if err != nil {
+				rt.Errorf("%s:%s: %v", obj.Prefix(), obj.Key(), err)
+				delete(res, k)
+				continue
+			}
+			res[k] = val
+		}
+	}
 	return res
 }
 
-func (rt *RequestTracker) GetParam(obj models.Paramer, key string, aggregate bool, decrypt bool) (interface{}, bool) {
+// getParam is GetParam, except that it also returns the error if the
+// param is computed and could not be computed.
+func (rt *RequestTracker) getParam(obj models.Paramer, key string, aggregate bool, decrypt bool) (interface{}, bool, error) {
 	res := obj.GetParams()
 	sources := rt.getAggParams(obj, res, aggregate)
-	if v, ok := res[key]; ok {
-		return rt.decryptParam(sources[key], key, v, decrypt), true
-	}
-	if aggregate {
+	v, ok := res[key]
+	if ok {
+		v = rt.decryptParam(sources[key], key, v, decrypt)
+	} else if aggregate {
 		if pobj := rt.Find("params", key); pobj != nil {
 			rt.Tracef("Param %s not defined, falling back to default value", key)
-			return AsParam(pobj).DefaultValue()
+			v, ok = AsParam(pobj).DefaultValue()
 		}
 	}
-	return nil, false
+	if !ok {
+		return nil, false, nil
+	}
+	if aggregate {
+		if r := rt.renderDataFor(obj); r != nil {
+			val, err := rt.computeParam(r, key, v)
+			if err != nil {
+				return nil, false, err
+			}
+			v = val
+		}
+	}
+	return v, true, nil
+}
+
+func (rt *RequestTracker) GetParam(obj models.Paramer, key string, aggregate bool, decrypt bool) (interface{}, bool) {
+	v, ok, err := rt.getParam(obj, key, aggregate, decrypt)
+	if err != nil {
+		rt.Errorf("%s:%s: %v", obj.Prefix(), obj.Key(), err)
+	}
+	return v, ok
 }
 
 func (rt *RequestTracker) urlFor(scheme string, remoteIP net.IP, port int) string {
@@ -0,0 +1,451 @@
+package backend
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/digitalrebar/provision/backend/index"
+	"github.com/digitalrebar/provision/models"
+	"github.com/digitalrebar/store"
+)
+
+// Rollout is the backend model wrapper for Rollout.
+// This struct also includes validation helpers.
+type Rollout struct {
+	*models.Rollout
+	validate
+}
+
+// SetReadOnly is a helper function to set the ReadOnly flag.
+func (r *Rollout) SetReadOnly(b bool) {
+	r.ReadOnly = b
+}
+
+// SaveClean is a helper function to run the model version's
+// ClearValidation function before converting back to
+// an object that can be stored in the backend.
+func (r *Rollout) SaveClean() store.KeySaver {
+	mod := *r.Rollout
+	mod.ClearValidation()
+	return toBackend(&mod, r.rt)
+}
+
+// AsRollout casts a models.Model interface to *Rollout (helper function)
+func AsRollout(o models.Model) *Rollout {
+	return o.(*Rollout)
+}
+
+// AsRollouts converts a list of models.Model to a list of *Rollout
+// (helper function)
+func AsRollouts(o []models.Model) []*Rollout {
+	res := make([]*Rollout, len(o))
+	for i := range o {
+		res[i] = AsRollout(o[i])
+	}
+	return res
+}
+
+// New creates a new empty instance of Rollout.
+// The ForceChanged and RT fields are propogated.
+func (r *Rollout) New() store.KeySaver {
+	res := &Rollout{Rollout: &models.Rollout{}}
+	if r.Rollout != nil && r.ChangeForced() {
+		res.ForceChange()
+	}
+	res.rt = r.rt
+	res.Fill()
+	return res
+}
+
+// Indexes returns a map of the indexes allowed for Rollout objects.
+func (r *Rollout) Indexes() map[string]index.Maker {
+	fix := AsRollout
+	res := index.MakeBaseIndexes(r)
+	res["Name"] = index.Make(
+		true,
+		"string",
+		func(i, j models.Model) bool {
+			return fix(i).Name < fix(j).Name
+		},
+		func(ref models.Model) (gte, gt index.Test) {
+			name := fix(ref).Name
+			return func(s models.Model) bool {
+					return fix(s).Name >= name
+				},
+				func(s models.Model) bool {
+					return fix(s).Name > name
+				}
+		},
+		func(s string) (models.Model, error) {
+			res := fix(r.New())
+			res.Name = s
+			return res, nil
+		})
+	res["State"] = index.Make(
+		false,
+		"string",
+		func(i, j models.Model) bool {
+			return fix(i).State < fix(j).State
+		},
+		func(ref models.Model) (gte, gt index.Test) {
+			state := fix(ref).State
+			return func(s models.Model) bool {
+					return fix(s).State >= state
+				},
+				func(s models.Model) bool {
+					return fix(s).State > state
+				}
+		},
+		func(s string) (models.Model, error) {
+			res := fix(r.New())
+			res.State = s
+			return res, nil
+		})
+	return res
+}
+
+// filters turns the Rollout's Filter into index filters over
+// Machines.
+func (r *Rollout) filters(rt *RequestTracker) ([]index.Filter, error) {
+	ref := &Machine{}
+	indexes := ref.Indexes()
+	keys := make([]string, 0, len(r.Filter))
+	for k := range r.Filter {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	res := []index.Filter{}
+	for _, k := range keys {
+		maker, ok := indexes[k]
+		if !ok {
+			var err error
+			if maker, err = ref.ParameterMaker(rt, k); err != nil {
+				return nil, err
+			}
+		}
+		f, err := index.ParseFilter(r.Filter[k])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid filter for %s: %v", k, err)
+		}
+		res = append(res, index.Sort(maker), f)
+	}
+	return res, nil
+}
+
+// Validate sets the valid and available flags for the Rollout.  A
+// Rollout is only available if its Workflow or Stage is.
+func (r *Rollout) Validate() {
+	r.Rollout.Validate()
+	r.AddError(index.CheckUnique(r, r.rt.stores("rollouts").Items()))
+	if _, err := r.filters(r.rt); err != nil {
+		r.AddError(err)
+	}
+	if !r.SetValid() {
+		return
+	}
+	if r.Workflow != "" {
+		if wf := r.rt.find("workflows", r.Workflow); wf == nil {
+			r.Errorf("Workflow %s does not exist", r.Workflow)
+		} else if !AsWorkflow(wf).Available {
+			r.Errorf("Workflow %s is not available", r.Workflow)
+		}
+	}
+	if r.Stage != "" {
+		if st := r.rt.find("stages", r.Stage); st == nil {
+			r.Errorf("Stage %s does not exist", r.Stage)
+		} else if !AsStage(st).Available {
+			r.Errorf("Stage %s is not available", r.Stage)
+		}
+	}
+	r.SetAvailable()
+}
+
+// BeforeSave validates the state of the Rollout.
+func (r *Rollout) BeforeSave() error {
+	r.Fill()
+	r.Validate()
+	if !r.Validated {
+		return r.MakeError(422, ValidationError, r)
+	}
+	return nil
+}
+
+// OnLoad initializes the Rollout when loaded from the data store.
+func (r *Rollout) OnLoad() error {
+	defer func() { r.rt = nil }()
+	r.Fill()
+	return r.BeforeSave()
+}
+
+// OnCreate picks the Machines the Rollout applies to.  Only Machines
+// the creator can see are picked, and the creator must be able to
+// update all of them.
+func (r *Rollout) OnCreate() error {
+	r.Failures, r.FailuresSinceResume = 0, 0
+	r.Machines = map[string]*models.RolloutMachine{}
+	filters, err := r.filters(r.rt)
+	if err != nil {
+		e := r.MakeError(422, ValidationError, r).(*models.Error)
+		e.AddError(err)
+		return e
+	}
+	filters = append([]index.Filter{index.Select(r.rt.visible)}, filters...)
+	targets, err := index.All(filters...)(r.rt.Index("machines"))
+	if err != nil {
+		e := r.MakeError(422, ValidationError, r).(*models.Error)
+		e.AddError(err)
+		return e
+	}
+	e := &models.Error{Code: 403, Type: "CREATE", Model: r.Prefix(), Key: r.Key()}
+	for _, obj := range targets.Items() {
+		if !r.rt.allowed("machines", "update", obj.Key()) {
+			e.Errorf("Cannot update Machine %s", AsMachine(obj).Name)
+		}
+	}
+	if e.ContainsError() {
+		return e
+	}
+	for _, obj := range targets.Items() {
+		r.Machines[obj.Key()] = &models.RolloutMachine{
+			Name:  AsMachine(obj).Name,
+			State: models.RolloutMachineWaiting,
+		}
+	}
+	switch {
+	case len(r.Machines) == 0:
+		r.State = models.RolloutFinished
+	case r.Paused:
+		r.State = models.RolloutPaused
+	default:
+		r.State = models.RolloutRunning
+	}
+	return nil
+}
+
+// OnChange keeps what dr-provision tracks about the Rollout, and
+// pauses or resumes it when Paused changes.
+func (r *Rollout) OnChange(oldThing store.KeySaver) error {
+	old := AsRollout(oldThing)
+	r.State, r.Machines = old.State, old.Machines
+	r.Failures, r.FailuresSinceResume = old.Failures, old.FailuresSinceResume
+	e := &models.Error{Code: 422, Type: ValidationError, Model: r.Prefix(), Key: r.Key()}
+	if r.Workflow != old.Workflow {
+		e.Errorf("Cannot change Workflow of a Rollout")
+	}
+	if r.Stage != old.Stage {
+		e.Errorf("Cannot change Stage of a Rollout")
+	}
+	if len(r.Filter) != len(old.Filter) {
+		e.Errorf("Cannot change Filter of a Rollout")
+	} else {
+		for k, v := range r.Filter {
+			if ov, ok := old.Filter[k]; !ok || ov != v {
+				e.Errorf("Cannot change Filter of a Rollout")
+				break
+			}
+		}
+	}
+	if e.ContainsError() {
+		return e
+	}
+	if r.State == models.RolloutFinished {
+		return nil
+	}
+	switch {
+	case old.Paused && !r.Paused:
+		r.FailuresSinceResume = 0
+		r.State = models.RolloutRunning
+	case !old.Paused && r.Paused:
+		r.State = models.RolloutPaused
+	}
+	return nil
+}
+
+var rolloutLockMap = map[string][]string{
+	"get":     {"rollouts"},
+	"create":  {"stages", "workflows", "machines", "profiles", "params", "rollouts"},
+	"update":  {"stages", "workflows", "machines", "profiles", "params", "rollouts"},
+	"patch":   {"stages", "workflows", "machines", "profiles", "params", "rollouts"},
+	"delete":  {"rollouts"},
+	"actions": {"rollouts", "profiles", "params"},
+}
+
+// Locks returns the object lock list for a given action for the Rollout object
+func (r *Rollout) Locks(action string) []string {
+	return rolloutLockMap[action]
+}
+
+// RolloutLocks are the locks that must be held to advance a Rollout.
+var RolloutLocks = []string{
+	"stages",
+	"bootenvs",
+	"machines",
+	"tasks",
+	"profiles",
+	"templates",
+	"params",
+	"workflows",
+	"jobs",
+	"rollouts",
+}
+
+// outcome returns whether m, which the Rollout started at rm.Started,
+// has succeeded or failed by now, and if it failed why.  It returns an
+// empty state if m is still running.
+func (r *Rollout) outcome(rt *RequestTracker, m *Machine, rm *models.RolloutMachine, now time.Time) (state, msg string) {
+	if r.Workflow != "" && m.Workflow != r.Workflow {
+		return models.RolloutMachineFailed, fmt.Sprintf("Machine was moved to Workflow %q", m.Workflow)
+	}
+	if m.ProvisioningError != "" && !m.Runnable {
+		return models.RolloutMachineFailed, m.ProvisioningError
+	}
+	if m.CurrentJob != nil {
+		if obj := rt.find("jobs", m.CurrentJob.String()); obj != nil {
+			job := AsJob(obj)
+			if job.State == "failed" && !job.StartTime.Before(rm.Started) {
+				return models.RolloutMachineFailed, fmt.Sprintf("Job %s for Task %s failed", job.Key(), job.Task)
+			}
+		}
+	}
+	if idle(m) {
+		return models.RolloutMachineSucceeded, ""
+	}
+	if timeout := time.Duration(r.MachineTimeout) * time.Second; timeout > 0 && now.Sub(rm.Started) > timeout {
+		return models.RolloutMachineFailed, fmt.Sprintf("Machine did not finish within %s", timeout)
+	}
+	return "", ""
+}
+
+// start puts the Machine uuid in the Rollout's Workflow or Stage.
+// Machines that are not Runnable would never finish, so they fail
+// right away.
+func (r *Rollout) start(rt *RequestTracker, uuid string) error {
+	obj := rt.Find("machines", uuid)
+	if obj == nil {
+		return fmt.Errorf("Machine was deleted")
+	}
+	m := AsMachine(obj)
+	if !m.Runnable {
+		return fmt.Errorf("Machine is not runnable")
+	}
+	if r.Workflow != "" {
+		_, err := setWorkflow(rt, m, r.Workflow)
+		return err
+	}
+	if m.Workflow != "" {
+		return fmt.Errorf("Machine is in Workflow %s", m.Workflow)
+	}
+	m.Stage = r.Stage
+	_, err := rt.Update(m)
+	return err
+}
+
+// advance records which of the Rollout's running Machines have
+// succeeded or failed, pauses it if too many have failed, and starts
+// waiting Machines until MaxConcurrent are running.  It counts what
+// happened to Machines in res by state, and returns whether the
+// Rollout changed.  RolloutLocks must be held.
+func (r *Rollout) advance(rt *RequestTracker, now time.Time, res map[string]int) bool {
+	if r.State == models.RolloutFinished {
+		return false
+	}
+	changed := false
+	finish := func(rm *models.RolloutMachine, state, msg string) {
+		rm.State, rm.Message, rm.Finished = state, msg, now
+		if state == models.RolloutMachineFailed {
+			r.Failures++
+			r.FailuresSinceResume++
+			rt.Warnf("Rollout %s: machine %s failed: %s", r.Name, rm.Name, msg)
+		}
+		res[state]++
+		changed = true
+	}
+	uuids := make([]string, 0, len(r.Machines))
+	for uuid := range r.Machines {
+		uuids = append(uuids, uuid)
+	}
+	sort.Strings(uuids)
+	running := 0
+	for _, uuid := range uuids {
+		rm := r.Machines[uuid]
+		if rm.State != models.RolloutMachineRunning {
+			continue
+		}
+		obj := rt.find("machines", uuid)
+		if obj == nil {
+			finish(rm, models.RolloutMachineFailed, "Machine was deleted")
+			continue
+		}
+		if state, msg := r.outcome(rt, AsMachine(obj), rm, now); state != "" {
+			finish(rm, state, msg)
+			continue
+		}
+		running++
+	}
+	for _, uuid := range uuids {
+		if r.FailuresSinceResume > r.MaxFailures && !r.Paused {
+			rt.Warnf("Rollout %s: %d machines failed, pausing", r.Name, r.FailuresSinceResume)
+			r.Paused = true
+			r.State = models.RolloutPaused
+			changed = true
+		}
+		if r.Paused || running >= r.MaxConcurrent {
+			break
+		}
+		rm := r.Machines[uuid]
+		if rm.State != models.RolloutMachineWaiting {
+			continue
+		}
+		rm.Started = now
+		if err := r.start(rt, uuid); err != nil {
+			finish(rm, models.RolloutMachineFailed, err.Error())
+			continue
+		}
+		rm.State = models.RolloutMachineRunning
+		res[models.RolloutMachineRunning]++
+		changed = true
+		running++
+	}
+	counts := r.Counts()
+	if counts[models.RolloutMachineWaiting] == 0 && counts[models.RolloutMachineRunning] == 0 {
+		r.State = models.RolloutFinished
+		changed = true
+		rt.Infof("Rollout %s: finished, %d machines succeeded and %d failed", r.Name,
+			counts[models.RolloutMachineSucceeded], counts[models.RolloutMachineFailed])
+	}
+	return changed
+}
+
+// AdvanceRollouts moves every Rollout that is not finished along.  It
+// returns how many Machines were started, succeeded, and failed,
+// keyed by their new state.
+func (p *DataTracker) AdvanceRollouts(now time.Time) map[string]int {
+	res := map[string]int{}
+	names := []string{}
+	rt := p.Request(p.Logger, "rollouts")
+	rt.Do(func(d Stores) {
+		for _, obj := range d("rollouts").Items() {
+			if AsRollout(obj).State != models.RolloutFinished {
+				names = append(names, obj.Key())
+			}
+		}
+	})
+	for _, name := range names {
+		rt := p.Request(p.Logger.Fork().SetPrincipal("rollout:"+name), RolloutLocks...)
+		rt.Do(func(d Stores) {
+			obj := rt.Find("rollouts", name)
+			if obj == nil {
+				return
+			}
+			r := AsRollout(obj)
+			if !r.advance(rt, now, res) {
+				return
+			}
+			if saved, err := rt.Save(r); !saved {
+				rt.Errorf("Rollout %s: failed to save progress: %v", name, err)
+			}
+		})
+	}
+	return res
+}
@@ -0,0 +1,227 @@
+package backend
+
+import (
+	"testing"
+	"time"
+
+	"github.com/digitalrebar/provision/models"
+	"github.com/pborman/uuid"
+)
+
+func TestRollout(t *testing.T) {
+	dt := mkDT()
+	rt := dt.Request(dt.Logger)
+	machines := map[string]string{}
+	rt.AllLocked(func(d Stores) {
+		for _, obj := range []models.Model{
+			&models.Param{Name: "rack", Schema: map[string]interface{}{"type": "integer"}},
+			&models.Stage{Name: "roll-stage"},
+			&models.Workflow{Name: "update", Stages: []string{"roll-stage"}},
+		} {
+			if created, err := rt.Create(obj); !created {
+				t.Fatalf("Failed to create %s %s: %v", obj.Prefix(), obj.Key(), err)
+			}
+		}
+		for name, rack := range map[string]int{"a": 1, "b": 1, "c": 1, "d": 2} {
+			mc := &Machine{}
+			Fill(mc)
+			mc.Uuid = uuid.NewRandom()
+			mc.Name = name + ".example.com"
+			mc.Params = map[string]interface{}{"rack": rack}
+			if created, err := rt.Create(mc); !created {
+				t.Fatalf("Failed to create machine %s: %v", name, err)
+			}
+			machines[name] = mc.UUID()
+		}
+		for _, bad := range []*models.Rollout{
+			{Name: "both", Workflow: "update", Stage: "roll-stage", MaxConcurrent: 1},
+			{Name: "badkey", Workflow: "update", MaxConcurrent: 1, Filter: map[string]string{"nosuch": "Eq(1)"}},
+			{Name: "badfilter", Workflow: "update", MaxConcurrent: 1, Filter: map[string]string{"rack": "Between(1)"}},
+		} {
+			if created, _ := rt.Create(bad); created {
+				t.Errorf("Expected rollout %s to be refused", bad.Name)
+			}
+		}
+		r := &models.Rollout{
+			Name:          "rack1",
+			Filter:        map[string]string{"rack": "Eq(1)"},
+			Workflow:      "update",
+			MaxConcurrent: 2,
+		}
+		if created, err := rt.Create(r); !created {
+			t.Fatalf("Failed to create rollout: %v", err)
+		}
+	})
+	rollout := func() *Rollout {
+		var res *Rollout
+		rt.AllLocked(func(d Stores) {
+			res = AsRollout(rt.Find("rollouts", "rack1"))
+		})
+		return res
+	}
+	inState := func(state string) []string {
+		res := []string{}
+		for uuid, rm := range rollout().Machines {
+			if rm.State == state {
+				res = append(res, uuid)
+			}
+		}
+		return res
+	}
+	machine := func(uuid string, fn func(*Machine)) {
+		rt.AllLocked(func(d Stores) {
+			m := AsMachine(rt.Find("machines", uuid))
+			fn(m)
+			if saved, err := rt.Update(m); !saved {
+				t.Fatalf("Failed to update machine %s: %v", uuid, err)
+			}
+		})
+	}
+	finish := func(uuid string) {
+		machine(uuid, func(m *Machine) {
+			m.InRunner()
+			m.CurrentTask = len(m.Tasks)
+		})
+	}
+
+	r := rollout()
+	if len(r.Machines) != 3 || r.State != models.RolloutRunning {
+		t.Fatalf("Expected rollout to pick the 3 machines in rack 1, got %d in state %s", len(r.Machines), r.State)
+	}
+	if _, ok := r.Machines[machines["d"]]; ok {
+		t.Errorf("Expected rollout to leave out the machine in rack 2")
+	}
+
+	res := dt.AdvanceRollouts(time.Now())
+	running := inState(models.RolloutMachineRunning)
+	if res[models.RolloutMachineRunning] != 2 || len(running) != 2 {
+		t.Fatalf("Expected 2 machines started, got %v", res)
+	}
+	rt.AllLocked(func(d Stores) {
+		for _, uuid := range running {
+			if m := AsMachine(rt.Find("machines", uuid)); m.Workflow != "update" {
+				t.Errorf("Expected machine %s in update, got %q", m.Name, m.Workflow)
+			}
+		}
+	})
+	if res := dt.AdvanceRollouts(time.Now()); len(res) != 0 {
+		t.Errorf("Expected nothing to happen while machines are running, got %v", res)
+	}
+
+	finish(running[0])
+	res = dt.AdvanceRollouts(time.Now())
+	if res[models.RolloutMachineSucceeded] != 1 || res[models.RolloutMachineRunning] != 1 {
+		t.Errorf("Expected 1 machine to succeed and the last one to start, got %v", res)
+	}
+	machine(running[1], func(m *Machine) { m.Workflow = "" })
+	res = dt.AdvanceRollouts(time.Now())
+	if r := rollout(); res[models.RolloutMachineFailed] != 1 ||
+		!r.Paused ||
+		r.State != models.RolloutPaused ||
+		r.Machines[running[1]].Message == "" {
+		t.Errorf("Expected the rollout to pause when a machine failed, got %v in %s", res, r.State)
+	}
+
+	rt.AllLocked(func(d Stores) {
+		r := AsRollout(rt.Find("rollouts", "rack1"))
+		r.Workflow = "other"
+		if saved, _ := rt.Update(r); saved {
+			t.Errorf("Expected changing the workflow of a rollout to fail")
+		}
+		r = AsRollout(rt.Find("rollouts", "rack1"))
+		r.Paused = false
+		r.Machines = nil
+		if saved, err := rt.Update(r); !saved {
+			t.Fatalf("Failed to resume rollout: %v", err)
+		}
+	})
+	if r := rollout(); r.State != models.RolloutRunning || r.Failures != 1 || r.FailuresSinceResume != 0 || len(r.Machines) != 3 {
+		t.Errorf("Expected resuming to keep failures and machines and reset failures since resume, got %s %d %d %d",
+			r.State, r.Failures, r.FailuresSinceResume, len(r.Machines))
+	}
+
+	last := inState(models.RolloutMachineRunning)
+	if len(last) != 1 {
+		t.Fatalf("Expected 1 machine still running, got %v", last)
+	}
+	finish(last[0])
+	dt.AdvanceRollouts(time.Now())
+	if r := rollout(); r.State != models.RolloutFinished {
+		t.Errorf("Expected rollout to finish, got %s", r.State)
+	}
+	if got := len(inState(models.RolloutMachineSucceeded)); got != 2 {
+		t.Errorf("Expected 2 machines to succeed, got %d", got)
+	}
+
+	// Rollouts only pick Machines their creator can see, and the
+	// creator must be able to update all of them.
+	alice := dt.Request(dt.Logger.Fork().SetPrincipal("user:alice"))
+	scoped := func(name string, updatable map[string]bool) (*Rollout, error) {
+		alice.SetAuth(&testAuth{machines: updatable})
+		var res *Rollout
+		var err error
+		alice.AllLocked(func(d Stores) {
+			r := &models.Rollout{Name: name, Filter: map[string]string{"rack": "Eq(1)"}, Workflow: "update", MaxConcurrent: 1, Paused: true}
+			if created, cerr := alice.Create(r); created {
+				res = AsRollout(alice.Find("rollouts", name))
+			} else {
+				err = cerr
+			}
+		})
+		return res, err
+	}
+	if _, err := scoped("readonly", map[string]bool{machines["a"]: true, machines["b"]: false}); err == nil || err.(*models.Error).Code != 403 {
+		t.Errorf("Expected a rollout over a machine alice cannot update to be refused, got %v", err)
+	}
+	r, err := scoped("visible", map[string]bool{machines["a"]: true, machines["d"]: true})
+	if err != nil {
+		t.Fatalf("Failed to create scoped rollout: %v", err)
+	}
+	if _, ok := r.Machines[machines["a"]]; !ok || len(r.Machines) != 1 {
+		t.Errorf("Expected the scoped rollout to only pick machine a, got %v", r.Machines)
+	}
+
+	// Machines that are not Runnable fail instead of being started,
+	// and Machines that run for longer than MachineTimeout fail.
+	rack2 := func(name string, timeout int) {
+		rt.AllLocked(func(d Stores) {
+			r := &models.Rollout{Name: name, Filter: map[string]string{"rack": "Eq(2)"}, Workflow: "update", MaxConcurrent: 1, MachineTimeout: timeout}
+			if created, err := rt.Create(r); !created {
+				t.Fatalf("Failed to create rollout %s: %v", name, err)
+			}
+		})
+	}
+	failed := func(name string) *models.RolloutMachine {
+		var res *models.RolloutMachine
+		rt.AllLocked(func(d Stores) {
+			r := AsRollout(rt.Find("rollouts", name))
+			if rm := r.Machines[machines["d"]]; rm.State == models.RolloutMachineFailed {
+				res = rm
+			}
+		})
+		return res
+	}
+	machine(machines["d"], func(m *Machine) { m.Runnable = false })
+	rack2("norun", 0)
+	if res := dt.AdvanceRollouts(time.Now()); res[models.RolloutMachineFailed] != 1 || res[models.RolloutMachineRunning] != 0 {
+		t.Errorf("Expected a machine that is not runnable to fail without starting, got %v", res)
+	}
+	if rm := failed("norun"); rm == nil || rm.Message != "Machine is not runnable" {
+		t.Errorf("Expected machine d to fail for not being runnable, got %v", rm)
+	}
+	machine(machines["d"], func(m *Machine) { m.Runnable = true })
+	rack2("slow", 60)
+	now := time.Now()
+	if res := dt.AdvanceRollouts(now); res[models.RolloutMachineRunning] != 1 {
+		t.Fatalf("Expected machine d to start, got %v", res)
+	}
+	if res := dt.AdvanceRollouts(now.Add(30 * time.Second)); len(res) != 0 {
+		t.Errorf("Expected machine d to still be running within its timeout, got %v", res)
+	}
+	if res := dt.AdvanceRollouts(now.Add(2 * time.Minute)); res[models.RolloutMachineFailed] != 1 {
+		t.Errorf("Expected machine d to fail after its timeout, got %v", res)
+	}
+	if rm := failed("slow"); rm == nil || rm.Message != "Machine did not finish within 1m0s" {
+		t.Errorf("Expected machine d to fail for running too long, got %v", rm)
+	}
+}
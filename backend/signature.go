@@ -0,0 +1,182 @@
+package backend
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/digitalrebar/provision/models"
+	"github.com/digitalrebar/store"
+)
+
+// signingModes are the allowed values of the contentSigning
+// preference.  off, the default, does not check signatures at all.
+// warn checks them and logs a warning for unsigned or untrusted
+// uploads, and require refuses them.
+var signingModes = map[string]struct{}{
+	"off":     {},
+	"warn":    {},
+	"require": {},
+}
+
+// CheckSignature enforces the contentSigning preference on an
+// uploaded content bundle or plugin provider.  digest is what was
+// signed, and sig is the detached signature that came with the
+// upload, if any.  It must be made by one of the keys in the
+// trustedKeys preference.
+//
+// If the upload must be refused, the returned error says why.
+// Otherwise, the returned warning is set when the upload would have
+// been refused with a contentSigning of require.
+func (p *DataTracker) CheckSignature(model, name string, digest []byte, sig string) (string, *models.Error) {
+	prefs := p.Prefs()
+	mode := prefs["contentSigning"]
+	if mode == "" || mode == "off" {
+		return "", nil
+	}
+	var problem error
+	keys, err := models.ParsePublicKeys(prefs["trustedKeys"])
+	switch {
+	case err != nil:
+		problem = fmt.Errorf("trustedKeys: %v", err)
+	case len(keys) == 0:
+		problem = fmt.Errorf("No trustedKeys to check signatures with")
+	case sig == "":
+		problem = fmt.Errorf("%s %s is not signed", model, name)
+	default:
+		problem = models.VerifyDigest(keys, digest, sig)
+	}
+	if problem == nil {
+		return "", nil
+	}
+	if mode != "require" {
+		p.Logger.Warnf("Accepting %s %s with a bad signature: %v", model, name, problem)
+		return fmt.Sprintf("Bad signature: %v", problem), nil
+	}
+	res := &models.Error{
+		Model: model,
+		Key:   name,
+		Type:  "SIGNATURE_ERROR",
+		Code:  http.StatusForbidden,
+	}
+	res.AddError(problem)
+	return "", res
+}
+
+// uploadSignature is what RecordSignature keeps in the secrets store
+// for an accepted upload.
+type uploadSignature struct {
+	// Type is the Meta.Type a content bundle had when it was signed.
+	// It is changed when the bundle is stored, so it has to be put
+	// back to get the same digest.
+	Type      string `json:",omitempty"`
+	Signature string
+}
+
+func uploadSignatureKey(model, name string) string {
+	return "signature-" + model + "-" + name
+}
+
+// RecordSignature saves the signature that an uploaded content bundle
+// or plugin provider was accepted with in the secrets store, so that
+// it goes into backups and can be checked again when one is restored.
+// metaType is the Meta.Type of a content bundle as it was signed.
+func (p *DataTracker) RecordSignature(model, name, metaType, sig string) error {
+	p.secretsMux.Lock()
+	defer p.secretsMux.Unlock()
+	key := uploadSignatureKey(model, name)
+	if sig == "" {
+		if err := p.Secrets.Remove(key); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return p.Secrets.Save(key, &uploadSignature{Type: metaType, Signature: sig})
+}
+
+// checkRestoreSignatures enforces the contentSigning preference on the
+// content layers and plugin providers in a backup before any of them
+// are restored.  Their signatures are the ones recorded in the secrets
+// store of the backup.  pluginDir is where the plugin providers were
+// unpacked, or "" if they are not being restored.
+func (p *DataTracker) checkRestoreSignatures(secrets store.Store,
+	contents map[string]*models.Content,
+	pluginDir string) error {
+	if mode := p.Prefs()["contentSigning"]; mode == "" || mode == "off" {
+		return nil
+	}
+	res := &models.Error{
+		Model: "system",
+		Key:   "restore",
+		Type:  "SIGNATURE_ERROR",
+		Code:  http.StatusForbidden,
+	}
+	recorded := func(model, name string) *uploadSignature {
+		sig := &uploadSignature{}
+		if err := secrets.Load(uploadSignatureKey(model, name), sig); err != nil {
+			return &uploadSignature{}
+		}
+		return sig
+	}
+	names := []string{}
+	for name := range contents {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		content := contents[name]
+		sig := recorded("contents", name)
+		stored := content.Meta.Type
+		content.Meta.Type = sig.Type
+		digest, err := models.ContentDigest(content)
+		content.Meta.Type = stored
+		if err != nil {
+			res.Errorf("contents %s: %v", name, err)
+			continue
+		}
+		if _, serr := p.CheckSignature("contents", name, digest, sig.Signature); serr != nil {
+			res.AddError(serr)
+		}
+	}
+	if pluginDir == "" {
+		return res.HasError()
+	}
+	ents, err := ioutil.ReadDir(pluginDir)
+	if err != nil && !os.IsNotExist(err) {
+		res.AddError(err)
+	}
+	for _, ent := range ents {
+		name := ent.Name()
+		if !ent.Mode().IsRegular() || strings.HasPrefix(name, ".") {
+			continue
+		}
+		digest, err := fileDigest(filepath.Join(pluginDir, name))
+		if err != nil {
+			res.Errorf("plugin_providers %s: %v", name, err)
+			continue
+		}
+		if _, serr := p.CheckSignature("plugin_providers", name, digest, recorded("plugin_providers", name).Signature); serr != nil {
+			res.AddError(serr)
+		}
+	}
+	return res.HasError()
+}
+
+func fileDigest(name string) ([]byte, error) {
+	fi, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer fi.Close()
+	sum := sha256.New()
+	if _, err := io.Copy(sum, fi); err != nil {
+		return nil, err
+	}
+	return sum.Sum(nil), nil
+}
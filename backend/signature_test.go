@@ -0,0 +1,136 @@
+package backend
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"testing"
+
+	"github.com/digitalrebar/provision/models"
+	"github.com/digitalrebar/store"
+	"golang.org/x/crypto/ed25519"
+)
+
+func TestCheckSignature(t *testing.T) {
+	dt := mkDT()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	sum := sha256.Sum256([]byte("plugin provider"))
+	digest := sum[:]
+	good := models.SignDigest(priv, digest)
+	_, otherPriv, _ := ed25519.GenerateKey(rand.Reader)
+	untrusted := models.SignDigest(otherPriv, digest)
+	rt := dt.Request(dt.Logger, "preferences", "bootenvs", "stages", "workflows")
+	setPrefs := func(prefs map[string]string) {
+		rt.Do(func(d Stores) {
+			if err := dt.SetPrefs(rt, prefs); err != nil {
+				t.Fatalf("Unexpected error setting prefs %v: %v", prefs, err)
+			}
+		})
+	}
+	rt.Do(func(d Stores) {
+		if err := dt.SetPrefs(rt, map[string]string{"contentSigning": "sometimes"}); err == nil {
+			t.Errorf("Expected invalid contentSigning to fail")
+		}
+		if err := dt.SetPrefs(rt, map[string]string{"trustedKeys": "not a key"}); err == nil {
+			t.Errorf("Expected invalid trustedKeys to fail")
+		}
+	})
+	check := func(mode, sig string, wantWarning, wantErr bool) {
+		t.Helper()
+		warning, err := dt.CheckSignature("plugin_providers", "test", digest, sig)
+		if (warning != "") != wantWarning {
+			t.Errorf("%s: unexpected warning %q", mode, warning)
+		}
+		if (err != nil) != wantErr {
+			t.Errorf("%s: unexpected error %v", mode, err)
+		} else if err != nil && err.Code != http.StatusForbidden {
+			t.Errorf("%s: expected a %d, got %d", mode, http.StatusForbidden, err.Code)
+		}
+	}
+	// Nothing is checked until contentSigning is turned on.
+	check("off", "", false, false)
+	setPrefs(map[string]string{"contentSigning": "warn"})
+	check("warn", good, true, false)
+	setPrefs(map[string]string{"trustedKeys": base64.StdEncoding.EncodeToString(pub)})
+	check("warn", good, false, false)
+	check("warn", "", true, false)
+	check("warn", untrusted, true, false)
+	setPrefs(map[string]string{"contentSigning": "require"})
+	check("require", good, false, false)
+	check("require", "", false, true)
+	check("require", untrusted, false, true)
+}
+
+func TestRestoreChecksSignatures(t *testing.T) {
+	dt := mkDT()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	rt := dt.Request(dt.Logger, "preferences", "profiles", "params", "machines", "stages", "workflows", "bootenvs", "tasks", "templates")
+	rt.Do(func(d Stores) {
+		if err := dt.SetPrefs(rt, map[string]string{
+			"contentSigning": "require",
+			"trustedKeys":    base64.StdEncoding.EncodeToString(pub),
+		}); err != nil {
+			t.Fatalf("Failed to set prefs: %v", err)
+		}
+	})
+	pluginDir, err := ioutil.TempDir(tmpDir, "plugins-")
+	if err != nil {
+		t.Fatalf("Failed to create plugin dir: %v", err)
+	}
+	provider := []byte("plugin provider")
+	ioutil.WriteFile(path.Join(pluginDir, "signed"), provider, 0700)
+	sum := sha256.Sum256(provider)
+	if err := dt.RecordSignature("plugin_providers", "signed", "", models.SignDigest(priv, sum[:])); err != nil {
+		t.Fatalf("Failed to record signature: %v", err)
+	}
+	newContent := func(c *models.Content) (store.Store, error) {
+		st, _ := store.Open("memory:///")
+		return st, c.ToStore(st)
+	}
+	restore := func() error {
+		t.Helper()
+		buf := &bytes.Buffer{}
+		trees := map[string]string{"plugins": pluginDir}
+		if _, err := dt.Snapshot(rt, "test", trees, buf); err != nil {
+			t.Fatalf("Failed to snapshot: %v", err)
+		}
+		dir, err := ioutil.TempDir(tmpDir, "restore-")
+		if err != nil {
+			t.Fatalf("Failed to create restore dir: %v", err)
+		}
+		manifest, err := ReadBackup(bytes.NewReader(buf.Bytes()), dir)
+		if err != nil {
+			t.Fatalf("Failed to read backup: %v", err)
+		}
+		hard, _ := dt.Restore(rt, dir, manifest, trees, newContent)
+		return hard
+	}
+	if err := restore(); err != nil {
+		t.Fatalf("Expected a backup with signed plugin providers to restore: %v", err)
+	}
+
+	// An unsigned plugin provider in the backup stops the whole restore.
+	ioutil.WriteFile(path.Join(pluginDir, "unsigned"), []byte("other provider"), 0700)
+	rt.Do(func(d Stores) {
+		rt.Create(&models.Profile{Name: "after-backup"})
+	})
+	err = restore()
+	if serr, ok := err.(*models.Error); !ok || serr.Type != "SIGNATURE_ERROR" {
+		t.Fatalf("Expected an unsigned plugin provider to be refused, got %v", err)
+	}
+	rt.Do(func(d Stores) {
+		if rt.Find("profiles", "after-backup") == nil {
+			t.Errorf("Expected a refused restore to leave profiles alone")
+		}
+	})
+}
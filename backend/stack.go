@@ -225,6 +225,32 @@ func (d *DataStack) Clone() *DataStack {
 	return dtStore
 }
 
+// LayerFor returns the name of the highest layer in the stack that
+// has the object prefix:key, as listed in LayerIndex.  It returns ""
+// if no layer has it.
+func (d *DataStack) LayerFor(prefix, key string) string {
+	layers := d.Layers()
+	for idx, layerName := range d.LayerIndex {
+		if idx >= len(layers) {
+			break
+		}
+		bk := layers[idx].GetSub(prefix)
+		if bk == nil {
+			continue
+		}
+		keys, err := bk.Keys()
+		if err != nil {
+			continue
+		}
+		for _, k := range keys {
+			if k == key {
+				return layerName
+			}
+		}
+	}
+	return ""
+}
+
 // FixerUpper takes a the datastack and a store.Store that is to be
 // added to the passed stack.  FixerUpper is responsible for making
 // sure that it can integrate the new store into the stack, making
@@ -329,6 +355,65 @@ func (d *DataStack) buildStack(fixup FixerUpper, newStore store.Store, logger lo
 	return nil
 }
 
+// prerequisiteErrors returns a message for every prerequisite of the
+// content and plugin layers in d that is not satisfied, by content
+// name.
+func (d *DataStack) prerequisiteErrors() map[string][]string {
+	installed := map[string]string{}
+	prereqs := map[string]string{}
+	for _, layers := range []map[string]store.Store{d.saasContents, d.pluginContents} {
+		for _, st := range layers {
+			mst, ok := st.(store.MetaSaver)
+			if !ok {
+				continue
+			}
+			md := mst.MetaData()
+			installed[md["Name"]] = md["Version"]
+			if md["Prerequisites"] != "" {
+				prereqs[md["Name"]] = md["Prerequisites"]
+			}
+		}
+	}
+	res := map[string][]string{}
+	for name, p := range prereqs {
+		if msgs := models.CheckContentPrerequisites(name, p, installed); len(msgs) > 0 {
+			res[name] = msgs
+		}
+	}
+	return res
+}
+
+// checkPrerequisites makes sure that changing the content and plugin
+// layers of prev to the ones in d does not leave any content without
+// its prerequisites.  Problems that prev already had are left alone,
+// so that a broken stack can still be fixed one layer at a time.
+func (d *DataStack) checkPrerequisites(prev *DataStack) error {
+	ret := &models.Error{
+		Model: "contents",
+		Type:  "STORE_ERROR",
+		Code:  http.StatusUnprocessableEntity,
+	}
+	old := prev.prerequisiteErrors()
+	now := d.prerequisiteErrors()
+	names := make([]string, 0, len(now))
+	for name := range now {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		seen := map[string]struct{}{}
+		for _, msg := range old[name] {
+			seen[msg] = struct{}{}
+		}
+		for _, msg := range now[name] {
+			if _, ok := seen[msg]; !ok {
+				ret.Errorf("%s", msg)
+			}
+		}
+	}
+	return ret.HasError()
+}
+
 func (d *DataStack) rebuild(oldStore, secrets store.Store,
 	logger logger.Logger,
 	fixup FixerUpper,
@@ -352,24 +437,77 @@ func (d *DataStack) RemoveSAAS(name string, logger logger.Logger, secrets store.
 	dtStore := d.Clone()
 	oldStore, _ := dtStore.saasContents[name]
 	delete(dtStore.saasContents, name)
+	if err := dtStore.checkPrerequisites(d); err != nil {
+		return nil, err, nil
+	}
 	return dtStore.rebuild(oldStore, secrets, logger, nil, nil)
 }
 
-func (d *DataStack) AddReplaceSAAS(
+func (d *DataStack) addReplaceSAAS(
 	name string,
 	newStore, secrets store.Store,
 	logger logger.Logger,
-	fixup FixerUpper) (*DataStack, error, error) {
+	fixup FixerUpper,
+	dryRun bool) (*DataStack, error, error) {
 	dtStore := d.Clone()
 	oldStore, _ := dtStore.saasContents[name]
 	dtStore.saasContents[name] = newStore
+	if err := dtStore.checkPrerequisites(d); err != nil {
+		return nil, err, nil
+	}
+	if dryRun {
+		// Leave the old store alone, it is still in use.
+		oldStore = nil
+	}
 	return dtStore.rebuild(oldStore, secrets, logger, fixup, newStore)
 }
 
+func (d *DataStack) AddReplaceSAAS(
+	name string,
+	newStore, secrets store.Store,
+	logger logger.Logger,
+	fixup FixerUpper) (*DataStack, error, error) {
+	return d.addReplaceSAAS(name, newStore, secrets, logger, fixup, false)
+}
+
+// DryRunAddReplaceSAAS builds and validates the DataStack that
+// AddReplaceSAAS would, without cleaning up the content layer that
+// newStore replaces.  The returned DataStack must not be used in
+// place of d.
+func (d *DataStack) DryRunAddReplaceSAAS(
+	name string,
+	newStore, secrets store.Store,
+	logger logger.Logger) (*DataStack, error, error) {
+	return d.addReplaceSAAS(name, newStore, secrets, logger, nil, true)
+}
+
+// replaceLayers returns a validated copy of d with the writable
+// store, the content layers and (if local is not nil) the local
+// content store replaced.
+func (d *DataStack) replaceLayers(
+	writable, local store.Store,
+	saas map[string]store.Store,
+	secrets store.Store,
+	logger logger.Logger) (*DataStack, error, error) {
+	dtStore := d.Clone()
+	dtStore.writeContent = writable
+	if local != nil {
+		dtStore.localContent = local
+	}
+	dtStore.saasContents = saas
+	if err := dtStore.checkPrerequisites(d); err != nil {
+		return nil, err, nil
+	}
+	return dtStore.rebuild(nil, secrets, logger, nil, nil)
+}
+
 func (d *DataStack) RemovePluginLayer(name string, logger logger.Logger, secrets store.Store) (*DataStack, error, error) {
 	dtStore := d.Clone()
 	oldStore, _ := dtStore.pluginContents[name]
 	delete(dtStore.pluginContents, name)
+	if err := dtStore.checkPrerequisites(d); err != nil {
+		return nil, err, nil
+	}
 	return dtStore.rebuild(oldStore, secrets, logger, nil, nil)
 }
 
@@ -381,6 +519,9 @@ func (d *DataStack) AddReplacePluginLayer(
 	dtStore := d.Clone()
 	oldStore, _ := dtStore.pluginContents[name]
 	dtStore.pluginContents[name] = newStore
+	if err := dtStore.checkPrerequisites(d); err != nil {
+		return nil, err, nil
+	}
 	return dtStore.rebuild(oldStore, secrets, logger, fixup, newStore)
 }
 
@@ -519,5 +660,10 @@ func DefaultDataStack(
 			}
 		}
 	}
+	for _, msgs := range dtStore.prerequisiteErrors() {
+		for _, msg := range msgs {
+			logger.Warnf("%s", msg)
+		}
+	}
 	return dtStore, dtStore.buildStack(nil, nil, logger)
 }
@@ -0,0 +1,58 @@
+package backend
+
+import (
+	"log"
+	"os"
+	"testing"
+
+	"github.com/digitalrebar/logger"
+	"github.com/digitalrebar/provision/models"
+	"github.com/digitalrebar/store"
+)
+
+func TestContentPrerequisites(t *testing.T) {
+	l := logger.New(log.New(os.Stdout, "stack", 0)).Log("backend")
+	secrets, _ := store.Open("memory:///")
+	ds, err := DefaultDataStack("", "memory:///", "", "", "", tmpDir, l)
+	if err != nil {
+		t.Fatalf("Failed to create data stack: %v", err)
+	}
+	mk := func(name, version, prereqs string) store.Store {
+		st, _ := store.Open("memory:///")
+		c := &models.Content{Meta: models.ContentMetaData{
+			Name:          name,
+			Version:       version,
+			Prerequisites: prereqs,
+		}}
+		if err := c.ToStore(st); err != nil {
+			t.Fatalf("Failed to build content %s: %v", name, err)
+		}
+		return st
+	}
+	if _, hard, _ := ds.AddReplaceSAAS("app", mk("app", "v1.0.0", "base: ^1.2.0"), secrets, l, nil); hard == nil {
+		t.Errorf("Expected app to be refused without base")
+	}
+	ds2, hard, _ := ds.AddReplaceSAAS("base", mk("base", "v1.3.0", ""), secrets, l, nil)
+	if hard != nil {
+		t.Fatalf("Failed to add base: %v", hard)
+	}
+	ds3, hard, _ := ds2.AddReplaceSAAS("app", mk("app", "v1.0.0", "base: ^1.2.0"), secrets, l, nil)
+	if hard != nil {
+		t.Fatalf("Failed to add app after base: %v", hard)
+	}
+	if _, hard, _ := ds3.AddReplaceSAAS("base", mk("base", "v2.0.0", ""), secrets, l, nil); hard == nil {
+		t.Errorf("Expected base v2.0.0 to be refused, since app needs ^1.2.0")
+	} else if msgs := hard.(*models.Error).Messages; len(msgs) != 1 {
+		t.Errorf("Expected a single conflict to be reported, got %v", msgs)
+	}
+	if _, hard, _ := ds3.RemoveSAAS("base", l, secrets); hard == nil {
+		t.Errorf("Expected removing base to be refused, since app needs it")
+	}
+	ds4, hard, _ := ds3.AddReplaceSAAS("base", mk("base", "v1.4.0", ""), secrets, l, nil)
+	if hard != nil {
+		t.Fatalf("Expected base v1.4.0 to be allowed: %v", hard)
+	}
+	if _, hard, _ := ds4.RemoveSAAS("app", l, secrets); hard != nil {
+		t.Errorf("Expected removing app to be allowed: %v", hard)
+	}
+}
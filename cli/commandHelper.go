@@ -1,7 +1,9 @@
 package cli
 
 import (
+	"encoding/json"
 	"fmt"
+	"os"
 	"strconv"
 	"strings"
 	"time"
@@ -57,6 +59,11 @@ further tweak how the results are returned using the following meta-filters:
 * 'limit' *number* to only return the first *number* items
 * 'offset' *number* to skip *number* items
 * 'sort' *index* to sort items according to *index*
+* 'cursor' *cursor* to return the items after the last item of a
+  previous page.  The cursor for the next page is printed to stderr.
+
+Very large lists can be fetched with --stream, which prints each item as
+the server sends it instead of collecting the whole list first.
 `, o.name, o.name),
 		Args: func(c *cobra.Command, args []string) error {
 			if len(args) == 0 {
@@ -85,6 +92,9 @@ further tweak how the results are returned using the following meta-filters:
 				if slim != "" {
 					args = append(args, fmt.Sprintf("slim=%s", slim))
 				}
+				if c.Flags().Changed("cursor") {
+					args = append(args, fmt.Sprintf("cursor=%s", listCursor))
+				}
 				pargs := []string{}
 				for _, arg := range args {
 					a := strings.SplitN(arg, "=", 2)
@@ -102,22 +112,44 @@ further tweak how the results are returned using the following meta-filters:
 				if slim != "" {
 					args = append(args, "slim", slim)
 				}
+				if c.Flags().Changed("cursor") {
+					args = append(args, "cursor", listCursor)
+				}
 				if len(args) > 0 {
 					req = session.Req().Filter(o.name, args...)
 				}
 			}
-			data := []interface{}{}
-			err := req.Do(&data)
-			if err != nil {
-				return generateError(err, "listing %v", o.name)
+			if listStream {
+				err := req.Stream(func(buf json.RawMessage) error {
+					var item interface{}
+					if err := json.Unmarshal(buf, &item); err != nil {
+						return err
+					}
+					return prettyPrint(item)
+				})
+				if err != nil {
+					return generateError(err, "listing %v", o.name)
+				}
 			} else {
-				return prettyPrint(data)
+				data := []interface{}{}
+				if err := req.Do(&data); err != nil {
+					return generateError(err, "listing %v", o.name)
+				}
+				if err := prettyPrint(data); err != nil {
+					return err
+				}
+			}
+			if next := req.Resp.Header.Get("X-DRP-LIST-CURSOR"); next != "" {
+				fmt.Fprintf(os.Stderr, "Next cursor: %s\n", next)
 			}
+			return nil
 		},
 	}
 	cmds = append(cmds, listCmd)
 	listCmd.Flags().IntVar(&listLimit, "limit", -1, "Maximum number of items to return")
 	listCmd.Flags().IntVar(&listOffset, "offset", -1, "Number of items to skip before starting to return data")
+	listCmd.Flags().StringVar(&listCursor, "cursor", "", "Return items after the one the cursor from a previous page points at")
+	listCmd.Flags().BoolVar(&listStream, "stream", false, "Print items as the server streams them instead of as a single list")
 	if canSlim {
 		listCmd.Flags().StringVar(&slim,
 			"slim",
@@ -19,6 +19,8 @@ func generateError(err error, sfmt string, args ...interface{}) error {
 
 var listLimit = -1
 var listOffset = -1
+var listCursor = ""
+var listStream = false
 
 func PatchWithString(key, js string, op *ops) error {
 	data, clone, err := session.GetModelForPatch(op.name, key)
@@ -2,17 +2,26 @@ package cli
 
 import (
 	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"log"
 	"os"
 	"path"
+	"sort"
 	"strings"
 	"text/template"
 
+	"github.com/digitalrebar/logger"
 	"github.com/digitalrebar/provision/api"
+	"github.com/digitalrebar/provision/backend"
 	"github.com/digitalrebar/provision/models"
 	"github.com/digitalrebar/store"
 	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ed25519"
 )
 
 func init() {
@@ -25,8 +34,8 @@ func findOrFake(field string, args map[string]string) string {
 		if field == "Type" {
 			// Default Type should be dynamic
 			s = "dynamic"
-		} else if field == "RequiredFeatures" {
-			// Default RequiredFeatures should be empty string
+		} else if field == "RequiredFeatures" || field == "Prerequisites" {
+			// Default RequiredFeatures and Prerequisites should be empty string
 			s = ""
 		}
 		return s
@@ -36,15 +45,90 @@ func findOrFake(field string, args map[string]string) string {
 
 }
 
+// checkContentPrerequisites makes sure that uploading layers will not
+// leave any content bundle without its prerequisites, so that all the
+// missing and conflicting bundles can be reported before anything is
+// uploaded.  Problems the server already has are not reported.
+func checkContentPrerequisites(layers ...*models.Content) error {
+	summary, err := session.GetContentSummary()
+	if err != nil {
+		// Leave it to the server.
+		return nil
+	}
+	installed, prereqs := map[string]string{}, map[string]string{}
+	for _, cs := range summary {
+		if cs.Meta.Type != "dynamic" && cs.Meta.Type != "plugin" {
+			continue
+		}
+		installed[cs.Meta.Name] = cs.Meta.Version
+		prereqs[cs.Meta.Name] = cs.Meta.Prerequisites
+	}
+	known := map[string]struct{}{}
+	for name, p := range prereqs {
+		for _, msg := range models.CheckContentPrerequisites(name, p, installed) {
+			known[msg] = struct{}{}
+		}
+	}
+	for _, layer := range layers {
+		installed[layer.Meta.Name] = layer.Meta.Version
+		prereqs[layer.Meta.Name] = layer.Meta.Prerequisites
+	}
+	names := make([]string, 0, len(prereqs))
+	for name := range prereqs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	msgs := []string{}
+	for _, name := range names {
+		for _, msg := range models.CheckContentPrerequisites(name, prereqs[name], installed) {
+			if _, ok := known[msg]; !ok {
+				msgs = append(msgs, msg)
+			}
+		}
+	}
+	if len(msgs) > 0 {
+		return fmt.Errorf("Missing or conflicting content bundles:\n%s", strings.Join(msgs, "\n"))
+	}
+	return nil
+}
+
+// readSignature returns the detached signature of src, which is kept
+// next to it in src.sig.  Content that is not from a file or has no
+// signature file is not signed.
+func readSignature(src string) (string, error) {
+	if fi, err := os.Stat(src); err != nil || fi.IsDir() {
+		return "", nil
+	}
+	buf, err := ioutil.ReadFile(src + ".sig")
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("Failed to read signature for %s: %v", src, err)
+	}
+	return strings.TrimSpace(string(buf)), nil
+}
+
+func uploadContent(layer *models.Content, sig string) (*models.ContentSummary, error) {
+	if res, err := session.ReplaceSignedContent(layer, sig); err == nil {
+		return res, nil
+	}
+	return session.CreateSignedContent(layer, sig)
+}
+
 func replaceContent(path string) error {
 	layer := &models.Content{}
 	if err := into(path, layer); err != nil {
 		return generateError(err, "Error parsing layer")
 	}
-	if res, err := session.ReplaceContent(layer); err == nil {
-		return prettyPrint(res)
+	sig, err := readSignature(path)
+	if err != nil {
+		return err
 	}
-	if res, err := session.CreateContent(layer); err == nil {
+	if err := checkContentPrerequisites(layer); err != nil {
+		return err
+	}
+	if res, err := uploadContent(layer, sig); err == nil {
 		return prettyPrint(res)
 	} else {
 		return generateError(err, "Error uploading layer")
@@ -122,7 +206,11 @@ func registerContent(app *cobra.Command) {
 			if err := into(args[0], layer); err != nil {
 				return generateError(err, "Error parsing layer")
 			}
-			if res, err := session.CreateContent(layer); err != nil {
+			sig, err := readSignature(args[0])
+			if err != nil {
+				return err
+			}
+			if res, err := session.CreateSignedContent(layer, sig); err != nil {
 				return generateError(err, "Error adding content layer")
 			} else {
 				return prettyPrint(res)
@@ -147,26 +235,93 @@ func registerContent(app *cobra.Command) {
 			if id != layer.Meta.Name {
 				return fmt.Errorf("Passed ID %s does not match layer ID %s", id, layer.Meta.Name)
 			}
-			if res, err := session.ReplaceContent(layer); err != nil {
+			sig, err := readSignature(args[1])
+			if err != nil {
+				return err
+			}
+			if res, err := session.ReplaceSignedContent(layer, sig); err != nil {
 				return generateError(err, "Error replacing content layer")
 			} else {
 				return prettyPrint(res)
 			}
 		},
 	})
-	content.AddCommand(&cobra.Command{
-		Use:   "upload [json]",
+	var dryRun bool
+	upload := &cobra.Command{
+		Use:   "upload [json]...",
 		Short: "Upload a content layer into the system, replacing the earlier one if needed.",
+		Long: `Upload sends content layers to the server, replacing the installed
+ones with the same names.  Before anything is uploaded, the
+prerequisites of the layers are checked against the installed content
+and each other, and the missing or conflicting content bundles are
+reported.  When more than one layer is passed, they are uploaded so
+that every layer comes after the ones it requires.  A layer read from
+a file is sent with the detached signature in the file with .sig
+appended to its name, if there is one.
+
+With --dry-run nothing is uploaded.  Instead, the server validates
+each layer against the running system on its own and reports the
+objects it would add, remove, or change, the errors that would keep it
+from being installed, and the machines whose current bootenv, stage,
+or tasks would change.`,
 		Args: func(c *cobra.Command, args []string) error {
-			if len(args) != 1 {
-				return fmt.Errorf("%v requires 1 arguments", c.UseLine())
+			if len(args) < 1 {
+				return fmt.Errorf("%v requires at least 1 argument", c.UseLine())
 			}
 			return nil
 		},
 		RunE: func(c *cobra.Command, args []string) error {
-			return replaceContent(args[0])
+			if len(args) == 1 && !dryRun {
+				return replaceContent(args[0])
+			}
+			layers := []*models.Content{}
+			sigs := map[*models.Content]string{}
+			for _, src := range args {
+				layer := &models.Content{}
+				if err := into(src, layer); err != nil {
+					return generateError(err, "Error parsing layer %s", src)
+				}
+				sig, err := readSignature(src)
+				if err != nil {
+					return err
+				}
+				layers = append(layers, layer)
+				sigs[layer] = sig
+			}
+			if dryRun {
+				diffs := []*models.ContentDiff{}
+				for _, layer := range layers {
+					diff, err := session.DryRunContent(layer, sigs[layer])
+					if err != nil {
+						return generateError(err, "Error checking layer %s", layer.Meta.Name)
+					}
+					diffs = append(diffs, diff)
+				}
+				if len(diffs) == 1 {
+					return prettyPrint(diffs[0])
+				}
+				return prettyPrint(diffs)
+			}
+			layers, err := models.OrderContents(layers)
+			if err != nil {
+				return err
+			}
+			if err := checkContentPrerequisites(layers...); err != nil {
+				return err
+			}
+			res := []*models.ContentSummary{}
+			for _, layer := range layers {
+				cs, err := uploadContent(layer, sigs[layer])
+				if err != nil {
+					return generateError(err, "Error uploading layer %s", layer.Meta.Name)
+				}
+				res = append(res, cs)
+			}
+			return prettyPrint(res)
 		},
-	})
+	}
+	upload.Flags().BoolVar(&dryRun, "dry-run", false, "Show what uploading would change without changing anything")
+	content.AddCommand(upload)
 	content.AddCommand(&cobra.Command{
 		Use:   "destroy [id]",
 		Short: "Remove the content layer [id] from the system.",
@@ -340,6 +495,9 @@ func registerContent(app *cobra.Command) {
 					"Source":           findOrFake("Source", params),
 					"Type":             findOrFake("Type", params),
 				}
+				if p := findOrFake("Prerequisites", params); p != "" {
+					meta["Prerequisites"] = p
+				}
 				dm.SetMetaData(meta)
 			}
 
@@ -390,6 +548,8 @@ func registerContent(app *cobra.Command) {
 					"subnets",
 					"roles",
 					"users",
+					"rollouts",
+					"pools",
 					"workflows",
 					"stages",
 					"bootenvs",
@@ -455,6 +615,9 @@ func registerContent(app *cobra.Command) {
 			}
 
 			for prefix, vals := range content.Sections {
+				if prefix == models.ContentTestsSection {
+					continue
+				}
 				for _, v := range vals {
 					item, _ := models.New(prefix)
 					if err := models.Remarshal(v, item); err != nil {
@@ -503,6 +666,9 @@ func registerContent(app *cobra.Command) {
 			}
 
 			for pref, section := range content.Sections {
+				if pref == models.ContentTestsSection {
+					continue
+				}
 				dlist := []models.Docer{}
 				for key, obj := range section {
 					m, e := models.New(pref)
@@ -539,9 +705,276 @@ func registerContent(app *cobra.Command) {
 			return err
 		},
 	})
+	var testWith []string
+	contentTest := &cobra.Command{
+		Use:   "test [file]",
+		Short: "Run the template tests carried by the content bundle [file]",
+		Long: `Test loads the content bundle [file] into a scratch in-memory
+data store and runs the template tests in its tests section against
+it, rendering templates the same way the server does.  [file] can be a
+bundle file or a directory that would be bundled.  Content the bundle
+depends on can be loaded along with it using --with.  Nothing is sent
+to the server.`,
+		Args: func(c *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return fmt.Errorf("Must provide a file")
+			}
+			return nil
+		},
+		RunE: func(c *cobra.Command, args []string) error {
+			content, err := loadContentBundle(args[0])
+			if err != nil {
+				return err
+			}
+			deps := []*models.Content{}
+			for _, src := range testWith {
+				dep, err := loadContentBundle(src)
+				if err != nil {
+					return err
+				}
+				deps = append(deps, dep)
+			}
+			dst := ioutil.Discard
+			if debug {
+				dst = os.Stderr
+			}
+			l := logger.New(log.New(dst, "", 0)).Log("contents")
+			res, err := backend.RunContentTests(content, deps, l)
+			if err != nil {
+				return generateError(err, "Failed to run tests for %s", args[0])
+			}
+			if err := prettyPrint(res); err != nil {
+				return err
+			}
+			if res.Failed > 0 {
+				return fmt.Errorf("%d of %d tests failed", res.Failed, res.Failed+res.Passed)
+			}
+			return nil
+		},
+	}
+	contentTest.Flags().StringSliceVar(&testWith, "with", []string{}, "Content bundles to load along with [file]")
+	content.AddCommand(contentTest)
+	var lintWith []string
+	contentLint := &cobra.Command{
+		Use:   "lint [file]",
+		Short: "Statically check the content bundle [file] for problems",
+		Long: `Lint checks the content bundle [file] without loading it anywhere.
+Every object is validated on its own, references to other objects are
+checked, and every template is parsed the same way the server does.
+Objects can refer to the basic content and to the content bundles
+loaded using --with.  [file] can be a bundle file or a directory that
+would be bundled.  Lint fails if any errors are found, but warnings
+such as unused templates and params without a definition only get
+reported.  Nothing is sent to the server.`,
+		Args: func(c *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return fmt.Errorf("Must provide a file")
+			}
+			return nil
+		},
+		RunE: func(c *cobra.Command, args []string) error {
+			content, err := loadContentBundle(args[0])
+			if err != nil {
+				return err
+			}
+			deps := []*models.Content{}
+			for _, src := range lintWith {
+				dep, err := loadContentBundle(src)
+				if err != nil {
+					return err
+				}
+				deps = append(deps, dep)
+			}
+			res := backend.LintContent(content, deps)
+			if err := prettyPrint(res); err != nil {
+				return err
+			}
+			if res.Errors > 0 {
+				return fmt.Errorf("%d errors found", res.Errors)
+			}
+			return nil
+		},
+	}
+	contentLint.Flags().StringSliceVar(&lintWith, "with", []string{}, "Content bundles [file] can refer to")
+	content.AddCommand(contentLint)
+	content.AddCommand(&cobra.Command{
+		Use:   "keygen [keyfile]",
+		Short: "Generate a key pair for signing content bundles and plugin providers",
+		Long: `Keygen writes a new ed25519 private key to [keyfile] and the matching
+public key to [keyfile].pub.  The public key is what goes in the
+trustedKeys preference of the servers that should accept what the
+private key signs.`,
+		Args: func(c *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return fmt.Errorf("%v requires 1 argument", c.UseLine())
+			}
+			return nil
+		},
+		RunE: func(c *cobra.Command, args []string) error {
+			pub, priv, err := ed25519.GenerateKey(rand.Reader)
+			if err != nil {
+				return fmt.Errorf("Failed to generate key: %v", err)
+			}
+			if err := ioutil.WriteFile(args[0], []byte(base64.StdEncoding.EncodeToString(priv)+"\n"), 0600); err != nil {
+				return fmt.Errorf("Failed to save private key: %v", err)
+			}
+			if err := ioutil.WriteFile(args[0]+".pub", []byte(base64.StdEncoding.EncodeToString(pub)+"\n"), 0644); err != nil {
+				return fmt.Errorf("Failed to save public key: %v", err)
+			}
+			fmt.Println(base64.StdEncoding.EncodeToString(pub))
+			return nil
+		},
+	})
+	var signKey string
+	sign := &cobra.Command{
+		Use:   "sign [file]",
+		Short: "Sign the content bundle or plugin provider [file]",
+		Long: `Sign writes a detached signature of [file] made with the private key
+in --key to [file].sig, where upload will find it.  [file] can be a
+content bundle or a plugin provider.`,
+		Args: func(c *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return fmt.Errorf("%v requires 1 argument", c.UseLine())
+			}
+			return nil
+		},
+		RunE: func(c *cobra.Command, args []string) error {
+			if signKey == "" {
+				return fmt.Errorf("Must provide a private key with --key")
+			}
+			buf, err := ioutil.ReadFile(signKey)
+			if err != nil {
+				return fmt.Errorf("Failed to read private key: %v", err)
+			}
+			key, err := models.ParsePrivateKey(string(buf))
+			if err != nil {
+				return err
+			}
+			digest, err := fileDigest(args[0])
+			if err != nil {
+				return err
+			}
+			sig := models.SignDigest(key, digest)
+			if err := ioutil.WriteFile(args[0]+".sig", []byte(sig+"\n"), 0644); err != nil {
+				return fmt.Errorf("Failed to save signature: %v", err)
+			}
+			fmt.Println(sig)
+			return nil
+		},
+	}
+	sign.Flags().StringVar(&signKey, "key", "", "File with the private key to sign with")
+	content.AddCommand(sign)
+	var verifyKeys []string
+	verify := &cobra.Command{
+		Use:   "verify [file]",
+		Short: "Verify the signature of the content bundle or plugin provider [file]",
+		Long: `Verify checks that [file].sig is a signature of [file] made by one of
+the keys passed using --key, the same way the server does.  Each key
+can either be a base64 encoded public key or a file containing one.`,
+		Args: func(c *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return fmt.Errorf("%v requires 1 argument", c.UseLine())
+			}
+			return nil
+		},
+		RunE: func(c *cobra.Command, args []string) error {
+			keyList := []string{}
+			for _, k := range verifyKeys {
+				if buf, err := ioutil.ReadFile(k); err == nil {
+					k = string(buf)
+				}
+				keyList = append(keyList, k)
+			}
+			keys, err := models.ParsePublicKeys(strings.Join(keyList, ","))
+			if err != nil {
+				return err
+			}
+			if len(keys) == 0 {
+				return fmt.Errorf("Must provide at least one public key with --key")
+			}
+			sig, err := readSignature(args[0])
+			if err != nil {
+				return err
+			}
+			if sig == "" {
+				return fmt.Errorf("%s is not signed", args[0])
+			}
+			digest, err := fileDigest(args[0])
+			if err != nil {
+				return err
+			}
+			if err := models.VerifyDigest(keys, digest, sig); err != nil {
+				return err
+			}
+			fmt.Printf("%s: signature OK\n", args[0])
+			return nil
+		},
+	}
+	verify.Flags().StringSliceVar(&verifyKeys, "key", []string{}, "Trusted public keys or files containing them")
+	content.AddCommand(verify)
 	app.AddCommand(content)
 }
 
+// loadContentBundle loads a content bundle from src, which can be
+// either a bundle file or a directory that would be bundled.
+func loadContentBundle(src string) (*models.Content, error) {
+	content := &models.Content{}
+	fi, err := os.Stat(src)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open %s: %v", src, err)
+	}
+	if fi.IsDir() {
+		s, _ := store.Open("memory:///")
+		defer s.Close()
+		cc := &api.Client{}
+		if err := cc.BundleContent(src, s, map[string]string{}); err != nil {
+			return nil, fmt.Errorf("Failed to load %s: %v", src, err)
+		}
+		if err := content.FromStore(s); err != nil {
+			return nil, fmt.Errorf("Failed to load %s: %v", src, err)
+		}
+		return content, nil
+	}
+	switch path.Ext(src) {
+	case ".yaml", ".yml", ".json":
+	default:
+		return nil, fmt.Errorf("Unknown store extension %s", path.Ext(src))
+	}
+	buf, err := ioutil.ReadFile(src)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open store %s: %v", src, err)
+	}
+	if err := api.DecodeYaml(buf, content); err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal store content: %v", err)
+	}
+	return content, nil
+}
+
+// fileDigest returns the digest that is signed for src.  Content
+// bundles are signed over their contents, so that a bundle can be
+// converted between YAML and JSON without breaking its signature.
+// Anything else, such as a plugin provider, is signed as is.
+func fileDigest(src string) ([]byte, error) {
+	switch path.Ext(src) {
+	case ".yaml", ".yml", ".json":
+		content, err := loadContentBundle(src)
+		if err != nil {
+			return nil, err
+		}
+		return models.ContentDigest(content)
+	}
+	fi, err := os.Open(src)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open %s: %v", src, err)
+	}
+	defer fi.Close()
+	digest := sha256.New()
+	if _, err := io.Copy(digest, fi); err != nil {
+		return nil, fmt.Errorf("Failed to read %s: %v", src, err)
+	}
+	return digest.Sum(nil), nil
+}
+
 type DocData struct {
 	Name          string
 	Version       string
@@ -78,7 +78,8 @@ You may also pass in a machine UUID or Name to create a new job on that Name.
 	}
 	actionsCmd.Flags().StringVar(&actionsFor, "for-os", "", "OS to fetch actions for.  Defaults to fetching all actions")
 	op.addCommand(actionsCmd)
-	op.addCommand(&cobra.Command{
+	follow := false
+	logCmd := &cobra.Command{
 		Use:   "log [id] [- or string]",
 		Short: "Gets the log or appends to the log if a second argument or stream is given",
 		Args: func(c *cobra.Command, args []string) error {
@@ -93,7 +94,11 @@ You may also pass in a machine UUID or Name to create a new job on that Name.
 		RunE: func(c *cobra.Command, args []string) error {
 			uuid := args[0]
 			if len(args) == 1 {
-				if err := session.Req().UrlFor("jobs", uuid, "log").Do(os.Stdout); err != nil {
+				req := session.Req().UrlFor("jobs", uuid, "log")
+				if follow {
+					req.Params("follow", "true")
+				}
+				if err := req.Do(os.Stdout); err != nil {
 					return generateError(err, "Error getting log")
 				}
 				return nil
@@ -109,6 +114,8 @@ You may also pass in a machine UUID or Name to create a new job on that Name.
 			}
 			return nil
 		},
-	})
+	}
+	logCmd.Flags().BoolVar(&follow, "follow", false, "Keep streaming the log until the job is done")
+	op.addCommand(logCmd)
 	op.command(app)
 }
@@ -326,5 +326,109 @@ the stage runner wait flag.
 	processJobs.Flags().BoolVar(&exitOnFailure, "exit-on-failure", false, "Exit on failure of a task")
 	processJobs.Flags().BoolVar(&oneShot, "oneshot", false, "Do not wait for additional tasks to appear")
 	op.addCommand(processJobs)
+	var renderBootEnv, renderStage, renderTask string
+	render := &cobra.Command{
+		Use:   "render [id]",
+		Short: "Render templates for the machine without changing it",
+		Long: `
+For the provided machine, render the templates of a bootenv, stage and
+task as if the machine were in them, and print the rendered paths and
+contents along with any errors.  Nothing is changed on the server.
+The bootenv and stage default to the ones the machine is currently
+in, and task templates are only rendered if a task is given.
+`,
+		Args: func(c *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return fmt.Errorf("%v requires 1 argument", c.UseLine())
+			}
+			return nil
+		},
+		RunE: func(c *cobra.Command, args []string) error {
+			m, err := op.refOrFill(args[0])
+			if err != nil {
+				return generateError(err, "Failed to fetch %v: %v", op.singleName, args[0])
+			}
+			res, err := session.MachineRender(m.(*models.Machine), renderBootEnv, renderStage, renderTask)
+			if err != nil {
+				return generateError(err, "Failed to render %v: %v", op.singleName, args[0])
+			}
+			return prettyPrint(res)
+		},
+	}
+	render.Flags().StringVar(&renderBootEnv, "bootenv", "", "BootEnv to render.  Defaults to the machine's current bootenv")
+	render.Flags().StringVar(&renderStage, "stage", "", "Stage to render.  Defaults to the machine's current stage")
+	render.Flags().StringVar(&renderTask, "task", "", "Task to render.  Task templates are not rendered if not set")
+	op.addCommand(render)
+	var lockReason string
+	var lockFor time.Duration
+	lock := &cobra.Command{
+		Use:   "lock [id]",
+		Short: "Lock the machine's workflow, stage, and bootenv",
+		Long: `
+Lock the provided machine so that nobody else can change its workflow,
+stage, or bootenv until it is unlocked or the lock expires.  The lock
+is owned by whoever sets it.  Use --force to take a lock someone else
+holds, which needs the machines:forceUnlock claim.
+`,
+		Args: func(c *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return fmt.Errorf("%v requires 1 argument", c.UseLine())
+			}
+			return nil
+		},
+		RunE: func(c *cobra.Command, args []string) error {
+			m, err := op.refOrFill(args[0])
+			if err != nil {
+				return generateError(err, "Failed to fetch %v: %v", op.singleName, args[0])
+			}
+			clone := models.Clone(m).(*models.Machine)
+			clone.Lock = &models.MachineLock{Reason: lockReason}
+			if lockFor > 0 {
+				clone.Lock.Expires = time.Now().Add(lockFor)
+			}
+			req := session.Req().ParanoidPatch().PatchTo(m, clone)
+			if force {
+				req.Params("force", "true")
+			}
+			if err := req.Do(&clone); err != nil {
+				return generateError(err, "Failed to lock %v: %v", op.singleName, args[0])
+			}
+			return prettyPrint(clone)
+		},
+	}
+	lock.Flags().StringVar(&lockReason, "reason", "", "Why the machine is locked")
+	lock.Flags().DurationVar(&lockFor, "for", 0, "How long the lock lasts.  The lock lasts until it is removed if not set")
+	op.addCommand(lock)
+	op.addCommand(&cobra.Command{
+		Use:   "unlock [id]",
+		Short: "Remove the lock on the machine",
+		Long: `
+Remove the lock on the provided machine.  Only the owner of the lock
+can remove it, unless --force is used by someone with the
+machines:forceUnlock claim.
+`,
+		Args: func(c *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return fmt.Errorf("%v requires 1 argument", c.UseLine())
+			}
+			return nil
+		},
+		RunE: func(c *cobra.Command, args []string) error {
+			m, err := op.refOrFill(args[0])
+			if err != nil {
+				return generateError(err, "Failed to fetch %v: %v", op.singleName, args[0])
+			}
+			clone := models.Clone(m).(*models.Machine)
+			clone.Lock = nil
+			req := session.Req().ParanoidPatch().PatchTo(m, clone)
+			if force {
+				req.Params("force", "true")
+			}
+			if err := req.Do(&clone); err != nil {
+				return generateError(err, "Failed to unlock %v: %v", op.singleName, args[0])
+			}
+			return prettyPrint(clone)
+		},
+	})
 	op.command(app)
 }
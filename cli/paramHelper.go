@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	"github.com/VictorLowther/jsonpatch2"
+	"github.com/digitalrebar/provision/models"
 	"github.com/spf13/cobra"
 )
 
@@ -59,6 +60,34 @@ func (o *ops) params() {
 	}
 	getParams.Flags().BoolVar(&aggregate, "aggregate", false, "Should return aggregated view")
 	getParams.Flags().BoolVar(&decode, "decode", false, "Should return decoded secure params")
+	explainDecode := false
+	explainParam := &cobra.Command{
+		Use:   "explain [id] [key]",
+		Short: fmt.Sprintf("Explain where the aggregated value of a parameter on the %s came from", o.singleName),
+		Long: fmt.Sprintf(`A helper function to return the aggregated value of the parameter
+on the %s along with every object that defined it, in order of
+precedence, and the layer each one came from.`, o.singleName),
+		Args: func(c *cobra.Command, args []string) error {
+			if len(args) != 2 {
+				return fmt.Errorf("%v requires 2 arguments", c.UseLine())
+			}
+			return nil
+		},
+		RunE: func(c *cobra.Command, args []string) error {
+			uuid := args[0]
+			res := &models.ParamExplanation{}
+			req := session.Req().UrlFor(o.name, uuid, "params", args[1]).Params("explain", "true")
+			if explainDecode {
+				req.Params("decode", "true")
+			}
+			if err := req.Do(res); err != nil {
+				return generateError(err, "Failed to explain param %v on %v: %v", args[1], o.singleName, uuid)
+			}
+			return prettyPrint(res)
+		},
+	}
+	explainParam.Flags().BoolVar(&explainDecode, "decode", false, "Should return decoded secure params")
+	getParams.AddCommand(explainParam)
 	o.addCommand(getParams)
 	getParam := &cobra.Command{
 		Use:   "get [id] param [key]",
@@ -37,8 +37,16 @@ func registerPluginProvider(app *cobra.Command) {
 				return fmt.Errorf("Error opening %s: %v", filePath, err)
 			}
 			defer fi.Close()
+			sig, err := readSignature(filePath)
+			if err != nil {
+				return err
+			}
 			res := &models.PluginProviderUploadInfo{}
-			if err := session.Req().Post(fi).UrlFor(op.name, name).Do(res); err != nil {
+			req := session.Req().Post(fi).UrlFor(op.name, name)
+			if sig != "" {
+				req.Headers(models.SignatureHeader, sig)
+			}
+			if err := req.Do(res); err != nil {
 				return err
 			}
 			return prettyPrint(res)
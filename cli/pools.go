@@ -0,0 +1,87 @@
+package cli
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/digitalrebar/provision/models"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	addRegistrar(registerPool)
+}
+
+func registerPool(app *cobra.Command) {
+	op := &ops{
+		name:       "pools",
+		singleName: "pool",
+		example:    func() models.Model { return &models.Pool{} },
+	}
+	var owner, selectors string
+	var allocFor time.Duration
+	allocate := &cobra.Command{
+		Use:   "allocate [name] [count]",
+		Short: "Allocate machines from the pool",
+		Long: `
+Allocate [count] ready machines that meet the pool's selectors.  Either
+all of them are allocated or none are.  --selectors takes a JSON or
+YAML list of extra selectors the machines must meet, or a file
+containing one, or - to read it from stdin.
+`,
+		Args: func(c *cobra.Command, args []string) error {
+			if len(args) != 2 {
+				return fmt.Errorf("%v requires 2 arguments", c.UseLine())
+			}
+			return nil
+		},
+		RunE: func(c *cobra.Command, args []string) error {
+			count, err := strconv.Atoi(args[1])
+			if err != nil {
+				return fmt.Errorf("Invalid count %s: %v", args[1], err)
+			}
+			req := &models.PoolAllocateRequest{
+				Count:    count,
+				Owner:    owner,
+				Duration: int(allocFor / time.Second),
+			}
+			if selectors != "" {
+				if err := into(selectors, &req.Selectors); err != nil {
+					return fmt.Errorf("Invalid selectors: %v", err)
+				}
+			}
+			res, err := session.PoolAllocate(args[0], req)
+			if err != nil {
+				return generateError(err, "Failed to allocate from %v: %v", op.singleName, args[0])
+			}
+			return prettyPrint(res)
+		},
+	}
+	allocate.Flags().StringVar(&owner, "owner", "", "Who the machines are for, user:<name> or tenant:<name>.  Defaults to you")
+	allocate.Flags().DurationVar(&allocFor, "for", 0, "How long the allocation lasts.  Defaults to the pool's LeaseDuration")
+	allocate.Flags().StringVar(&selectors, "selectors", "", "Extra selectors the machines must meet")
+	op.addCommand(allocate)
+	op.addCommand(&cobra.Command{
+		Use:   "release [name] [uuid]...",
+		Short: "Release machines back to the pool",
+		Long: `
+Release the machines with the given UUIDs back to the pool.  Only the
+owner of an allocation can release it unless --force is used.
+`,
+		Args: func(c *cobra.Command, args []string) error {
+			if len(args) < 2 {
+				return fmt.Errorf("%v requires at least 2 arguments", c.UseLine())
+			}
+			return nil
+		},
+		RunE: func(c *cobra.Command, args []string) error {
+			res, err := session.PoolRelease(args[0], &models.PoolReleaseRequest{Machines: args[1:]}, force)
+			if err != nil {
+				return generateError(err, "Failed to release to %v: %v", op.singleName, args[0])
+			}
+			return prettyPrint(res)
+		},
+	})
+	op.command(app)
+}
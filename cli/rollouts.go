@@ -0,0 +1,58 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/digitalrebar/provision/models"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	addRegistrar(registerRollout)
+}
+
+func registerRollout(app *cobra.Command) {
+	op := &ops{
+		name:       "rollouts",
+		singleName: "rollout",
+		example:    func() models.Model { return &models.Rollout{} },
+	}
+	setPaused := func(action string, paused bool) *cobra.Command {
+		return &cobra.Command{
+			Use: action + " [id]",
+			Args: func(c *cobra.Command, args []string) error {
+				if len(args) != 1 {
+					return fmt.Errorf("%v requires 1 argument", c.UseLine())
+				}
+				return nil
+			},
+			RunE: func(c *cobra.Command, args []string) error {
+				r, err := op.refOrFill(args[0])
+				if err != nil {
+					return generateError(err, "Failed to fetch %v: %v", op.singleName, args[0])
+				}
+				clone := models.Clone(r).(*models.Rollout)
+				clone.Paused = paused
+				if err := session.Req().ParanoidPatch().PatchTo(r, clone).Do(&clone); err != nil {
+					return generateError(err, "Failed to %s %v: %v", action, op.singleName, args[0])
+				}
+				return prettyPrint(clone)
+			},
+		}
+	}
+	pause := setPaused("pause", true)
+	pause.Short = "Pause the rollout"
+	pause.Long = `
+Stop the rollout from starting more machines.  Machines it has
+already started keep running.
+`
+	op.addCommand(pause)
+	resume := setPaused("resume", false)
+	resume.Short = "Resume the rollout"
+	resume.Long = `
+Let the rollout start machines again, and start counting failures
+from 0.
+`
+	op.addCommand(resume)
+	op.command(app)
+}
@@ -155,7 +155,12 @@ func NewApp() *cobra.Command {
 			for _, sc := range c.Commands() {
 				if !strings.HasPrefix(sc.Use, "bundle") &&
 					!strings.HasPrefix(sc.Use, "unbundle") &&
-					!strings.HasPrefix(sc.Use, "document") {
+					!strings.HasPrefix(sc.Use, "document") &&
+					!strings.HasPrefix(sc.Use, "test") &&
+					!strings.HasPrefix(sc.Use, "lint") &&
+					!strings.HasPrefix(sc.Use, "keygen") &&
+					!strings.HasPrefix(sc.Use, "sign") &&
+					!strings.HasPrefix(sc.Use, "verify") {
 					sc.PersistentPreRunE = ppr
 				}
 			}
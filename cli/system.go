@@ -2,6 +2,7 @@ package cli
 
 import (
 	"fmt"
+	"os"
 
 	"github.com/spf13/cobra"
 )
@@ -29,6 +30,65 @@ func addSystemCommands() (res *cobra.Command) {
 	}
 	op.actions()
 	res.AddCommand(op.extraCommands...)
+	res.AddCommand(&cobra.Command{
+		Use:   "backup [file]",
+		Short: "Save a backup of the whole endpoint to [file]",
+		Long: `Saves a consistent snapshot of the writable store, the secrets store,
+the local content, all content bundles, plugin providers, job logs
+and the files tree as a single gzipped tar archive.  Use - as [file]
+to write the archive to stdout.`,
+		Args: func(c *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return fmt.Errorf("%v requires 1 argument", c.UseLine())
+			}
+			return nil
+		},
+		RunE: func(c *cobra.Command, args []string) error {
+			dest := os.Stdout
+			if args[0] != "-" {
+				var err error
+				dest, err = os.Create(args[0])
+				if err != nil {
+					return fmt.Errorf("Error opening dest file %s: %v", args[0], err)
+				}
+				defer dest.Close()
+			}
+			if err := session.Backup(dest); err != nil {
+				return generateError(err, "Failed to back up system")
+			}
+			return nil
+		},
+	})
+	res.AddCommand(&cobra.Command{
+		Use:   "restore [file]",
+		Short: "Replace everything on the endpoint with the backup in [file]",
+		Long: `Uploads a backup created by 'drpcli system backup' and replaces
+the data on the endpoint with it.  The archive is verified and its
+content validated before anything is replaced.  dr-provision should
+be restarted afterwards to reload plugins.`,
+		Args: func(c *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return fmt.Errorf("%v requires 1 argument", c.UseLine())
+			}
+			return nil
+		},
+		RunE: func(c *cobra.Command, args []string) error {
+			src := os.Stdin
+			if args[0] != "-" {
+				var err error
+				src, err = os.Open(args[0])
+				if err != nil {
+					return fmt.Errorf("Error opening src file %s: %v", args[0], err)
+				}
+				defer src.Close()
+			}
+			manifest, err := session.Restore(src)
+			if err != nil {
+				return generateError(err, "Failed to restore system")
+			}
+			return prettyPrint(manifest)
+		},
+	})
 
 	return res
 }
@@ -44,6 +44,29 @@ type ContentParameter struct {
 	Name string `json:"name"`
 }
 
+// ContentDiffResponse returned on a dry run of a content create or upload
+// swagger:response
+type ContentDiffResponse struct {
+	// in: body
+	Body *models.ContentDiff
+}
+
+// ContentSignatureParameter is the detached signature of uploaded
+// content, checked according to the contentSigning preference
+// swagger:parameters uploadContent createContent
+type ContentSignatureParameter struct {
+	// in: header
+	Signature string `json:"X-DRP-SIGNATURE"`
+}
+
+// ContentDryRunParameter used to see what creating or uploading
+// content would do without doing it
+// swagger:parameters uploadContent createContent
+type ContentDryRunParameter struct {
+	// in: query
+	DryRun bool `json:"dry-run"`
+}
+
 func (f *Frontend) buildNewStore(content *models.Content) (newStore store.Store, err error) {
 	filename := fmt.Sprintf("/%s/%s-%s.yaml", f.SaasDir, content.Meta.Name, content.Meta.Version)
 	count := 1
@@ -96,6 +119,24 @@ func (f *Frontend) findContent(name string) (cst store.Store) {
 	return
 }
 
+// checkContentSignature enforces the contentSigning preference on an
+// uploaded content bundle, sending an error and returning false if
+// it must be refused.
+func (f *Frontend) checkContentSignature(c *gin.Context, content *models.Content) (string, bool) {
+	digest, err := models.ContentDigest(content)
+	if err != nil {
+		res := models.NewError("API_ERROR", http.StatusBadRequest, err.Error())
+		c.JSON(res.Code, res)
+		return "", false
+	}
+	warning, serr := f.dt.CheckSignature("contents", content.Meta.Name, digest, c.GetHeader(models.SignatureHeader))
+	if serr != nil {
+		c.JSON(serr.Code, serr)
+		return "", false
+	}
+	return warning, true
+}
+
 func (f *Frontend) InitContentApi() {
 	// swagger:route GET /contents Contents listContents
 	//
@@ -175,7 +216,12 @@ func (f *Frontend) InitContentApi() {
 	//
 	// Create content into Digital Rebar Provision
 	//
+	// If dry-run is true, the content is validated against the
+	// running system but not created, and what creating it would do
+	// is returned instead.
+	//
 	//     Responses:
+	//       200: ContentDiffResponse
 	//       201: ContentSummaryResponse
 	//       400: ErrorResponse
 	//       401: NoContentResponse
@@ -196,6 +242,11 @@ func (f *Frontend) InitContentApi() {
 			if !f.assureSimpleAuth(c, "contents", "create", content.AuthKey()) {
 				return
 			}
+			sigWarning, ok := f.checkContentSignature(c, content)
+			if !ok {
+				return
+			}
+			signedType := content.Meta.Type
 			name := content.Meta.Name
 			rt := f.rt(c)
 			res := &models.Error{
@@ -205,18 +256,31 @@ func (f *Frontend) InitContentApi() {
 				Code:  http.StatusInternalServerError,
 			}
 			var cs *models.ContentSummary
+			var diff *models.ContentDiff
 			rt.AllLocked(func(d backend.Stores) {
 				if cst := f.findContent(name); cst != nil {
 					res.Code = http.StatusConflict
 					res.Errorf("Content %s already exists", name)
 					return
 				}
+				if c.Query("dry-run") == "true" {
+					var err error
+					if diff, err = f.dt.ContentDryRun(rt, nil, content); err != nil {
+						res.AddError(err)
+					} else if sigWarning != "" {
+						diff.Warnings = append(diff.Warnings, sigWarning)
+					}
+					return
+				}
 				newStore, err := f.buildNewStore(content)
 				if err != nil {
 					res.AddError(err)
 					return
 				}
 				cs = buildSummary(newStore)
+				if sigWarning != "" {
+					cs.Warnings = append(cs.Warnings, sigWarning)
+				}
 				ds := f.dt.Backend
 				nbs, hard, soft := ds.AddReplaceSAAS(name, newStore, f.dt.Secrets, f.Logger, nil)
 				if hard != nil {
@@ -226,14 +290,19 @@ func (f *Frontend) InitContentApi() {
 				}
 				if soft != nil {
 					if berr, ok := soft.(*models.Error); ok {
-						cs.Warnings = berr.Messages
+						cs.Warnings = append(cs.Warnings, berr.Messages...)
 					}
 				}
 				f.dt.ReplaceBackend(rt, nbs)
+				if err := f.dt.RecordSignature("contents", name, signedType, c.GetHeader(models.SignatureHeader)); err != nil {
+					rt.Warnf("Failed to record the signature of content %s: %v", name, err)
+				}
 				rt.Publish("contents", "create", name, cs)
 			})
 			if res.ContainsError() {
 				c.JSON(res.Code, res)
+			} else if diff != nil {
+				c.JSON(http.StatusOK, diff)
 			} else {
 				c.JSON(http.StatusCreated, cs)
 			}
@@ -243,6 +312,10 @@ func (f *Frontend) InitContentApi() {
 	//
 	// Replace content in Digital Rebar Provision
 	//
+	// If dry-run is true, the content is validated against the
+	// running system but not replaced, and a ContentDiff describing
+	// what replacing it would do is returned instead.
+	//
 	//     Responses:
 	//       200: ContentSummaryResponse
 	//       400: ErrorResponse
@@ -264,6 +337,11 @@ func (f *Frontend) InitContentApi() {
 			if !f.assureSimpleAuth(c, "contents", "update", content.AuthKey()) {
 				return
 			}
+			sigWarning, ok := f.checkContentSignature(c, content)
+			if !ok {
+				return
+			}
+			signedType := content.Meta.Type
 			name := c.Param(`name`)
 			res := &models.Error{
 				Model: "contents",
@@ -277,13 +355,30 @@ func (f *Frontend) InitContentApi() {
 				c.JSON(http.StatusBadRequest, res)
 				return
 			}
+			var diff *models.ContentDiff
 			rt := f.rt(c)
 			rt.AllLocked(func(d backend.Stores) {
-				if cst := f.findContent(name); cst == nil {
+				cst := f.findContent(name)
+				if cst == nil {
 					res.Code = http.StatusNotFound
 					res.Errorf("Cannot find %s", name)
 					return
 				}
+				if c.Query("dry-run") == "true" {
+					old, berr := f.buildContent(cst)
+					if berr != nil {
+						res = berr
+						return
+					}
+					var err error
+					if diff, err = f.dt.ContentDryRun(rt, old, content); err != nil {
+						res.Code = http.StatusInternalServerError
+						res.AddError(err)
+					} else if sigWarning != "" {
+						diff.Warnings = append(diff.Warnings, sigWarning)
+					}
+					return
+				}
 
 				newStore, err := f.buildNewStore(content)
 				if err != nil {
@@ -293,6 +388,9 @@ func (f *Frontend) InitContentApi() {
 					return
 				}
 				cs = buildSummary(newStore)
+				if sigWarning != "" {
+					cs.Warnings = append(cs.Warnings, sigWarning)
+				}
 				ds := f.dt.Backend
 				nbs, hard, soft := ds.AddReplaceSAAS(name, newStore, f.dt.Secrets, f.Logger, nil)
 				if hard != nil {
@@ -304,14 +402,19 @@ func (f *Frontend) InitContentApi() {
 				}
 				if soft != nil {
 					if berr, ok := soft.(*models.Error); ok {
-						cs.Warnings = berr.Messages
+						cs.Warnings = append(cs.Warnings, berr.Messages...)
 					}
 				}
 				f.dt.ReplaceBackend(rt, nbs)
+				if err := f.dt.RecordSignature("contents", name, signedType, c.GetHeader(models.SignatureHeader)); err != nil {
+					rt.Warnf("Failed to record the signature of content %s: %v", name, err)
+				}
 				rt.Publish("contents", "update", name, cs)
 			})
 			if res.ContainsError() {
 				c.JSON(res.Code, res)
+			} else if diff != nil {
+				c.JSON(http.StatusOK, diff)
 			} else {
 				c.JSON(http.StatusOK, cs)
 			}
@@ -353,6 +456,9 @@ func (f *Frontend) InitContentApi() {
 				ds := f.dt.Backend
 				nbs, hard, _ := ds.RemoveSAAS(name, f.Logger, f.dt.Secrets)
 				if hard != nil {
+					if herr, ok := hard.(*models.Error); ok {
+						res.Code = herr.Code
+					}
 					res.AddError(hard)
 					return
 				}
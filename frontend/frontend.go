@@ -3,7 +3,9 @@ package frontend
 import (
 	"bytes"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
 	"strconv"
 	"strings"
@@ -66,34 +68,48 @@ func (a *authBlob) tenantOK(prefix, key string) bool {
 	return res
 }
 
+// Visible returns whether m is in the Tenant of the caller.  Jobs,
+// Leases and Reservations are in the Tenant of their Machine.
+func (a *authBlob) Visible(m models.Model) bool {
+	prefix, key := m.Prefix(), m.Key()
+	if a.tenantOK(prefix, key) {
+		return true
+	}
+	switch o := m.(type) {
+	case *models.Job:
+		return a.tenantOK("machines", o.Machine.String())
+	case *backend.Job:
+		return a.tenantOK("machines", o.Machine.String())
+	case *models.Lease:
+		return a.tenantOK("machines", a.f.dt.MacToMachineUUID(o.Token))
+	case *backend.Lease:
+		return a.tenantOK("machines", a.f.dt.MacToMachineUUID(o.Token))
+	case *models.Reservation:
+		return a.tenantOK("machines", a.f.dt.MacToMachineUUID(o.Token))
+	case *backend.Reservation:
+		return a.tenantOK("machines", a.f.dt.MacToMachineUUID(o.Token))
+	}
+	a.f.Logger.Tracef("Visible: %s:%s: default denied", prefix, key)
+	return false
+}
+
+// Allowed returns whether the caller holds the scope:action:specific
+// claim.
+func (a *authBlob) Allowed(scope, action, specific string) bool {
+	return a.matchClaim(models.MakeRole("", scope, action, specific).Compile()) && a.isLicensed(scope, action)
+}
+
+// Tenant returns the name of the Tenant of the caller, if any.
+func (a *authBlob) Tenant() string {
+	return a.currentTenant
+}
+
 func (a *authBlob) tenantSelect(scope string) index.Filter {
 	if a.tenantMembers == nil {
 		a.f.Logger.Tracef("tenantSelect: %s: not scoped, allowed", scope)
 		return nil
 	}
-	test := func(m models.Model) bool {
-		prefix, key := m.Prefix(), m.Key()
-		if a.tenantOK(prefix, key) {
-			return true
-		}
-		switch o := m.(type) {
-		case *models.Job:
-			return a.tenantOK("machines", o.Machine.String())
-		case *backend.Job:
-			return a.tenantOK("machines", o.Machine.String())
-		case *models.Lease:
-			return a.tenantOK("machines", a.f.dt.MacToMachineUUID(o.Token))
-		case *backend.Lease:
-			return a.tenantOK("machines", a.f.dt.MacToMachineUUID(o.Token))
-		case *models.Reservation:
-			return a.tenantOK("machines", a.f.dt.MacToMachineUUID(o.Token))
-		case *backend.Reservation:
-			return a.tenantOK("machines", a.f.dt.MacToMachineUUID(o.Token))
-		}
-		a.f.Logger.Tracef("tenantSelect: %s:%s: default denied", prefix, key)
-		return false
-	}
-	return index.Select(test)
+	return index.Select(a.Visible)
 }
 
 func (a *authBlob) Principal() string {
@@ -209,7 +225,11 @@ func (f *Frontend) Find(c *gin.Context, rt *backend.RequestTracker, prefix, key
 
 func (f *Frontend) rt(c *gin.Context, locks ...string) *backend.RequestTracker {
 	if c != nil {
-		return f.dt.Request(f.l(c), locks...)
+		rt := f.dt.Request(f.l(c), locks...)
+		if auth, ok := c.Get("DRP-AUTH"); ok {
+			rt.SetAuth(auth.(*authBlob))
+		}
+		return rt
 	}
 	return f.dt.Request(f.Logger, locks...)
 }
@@ -336,6 +356,9 @@ func (fe *Frontend) userAuth() gin.HandlerFunc {
 				c.Set("logger", logger)
 			}
 			c.Set("DRP-AUTH", auth)
+			if auth.currentMachine != nil {
+				fe.dt.MachineSeen(auth.currentMachine.UUID(), "api", net.ParseIP(c.ClientIP()))
+			}
 			c.Next()
 			return
 		}
@@ -493,6 +516,7 @@ func NewFrontend(
 			"X-Return-Attributes",
 			"X-DRP-LIST-COUNT",
 			"X-DRP-LIST-TOTAL-COUNT",
+			"X-DRP-LIST-CURSOR",
 		},
 	}))
 
@@ -548,6 +572,8 @@ func NewFrontend(
 	me.InitEventApi()
 	me.InitContentApi()
 	me.InitTenantApi()
+	me.InitPoolApi()
+	me.InitRolloutApi()
 	me.InitSystemApi()
 
 	if EmbeddedAssetsServerFunc != nil {
@@ -683,52 +709,6 @@ func assureDecode(c *gin.Context, val interface{}) bool {
 	return false
 }
 
-// This processes the value into a function, if function not specified, assume Eq.
-// Supported Forms:
-//
-//   Eq(value)
-//   Lt(value)
-//   Lte(value)
-//   Gt(value)
-//   Gte(value)
-//   Ne(value)
-//   Between(valueLower, valueHigher)
-//   Except(valueLower, valueHigher)
-//
-func convertValueToFilter(v string) (index.Filter, error) {
-	args := strings.SplitN(v, "(", 2)
-	switch args[0] {
-	case "Eq":
-		subargs := strings.SplitN(args[1], ")", 2)
-		return index.Eq(subargs[0]), nil
-	case "Lt":
-		subargs := strings.SplitN(args[1], ")", 2)
-		return index.Lt(subargs[0]), nil
-	case "Lte":
-		subargs := strings.SplitN(args[1], ")", 2)
-		return index.Lte(subargs[0]), nil
-	case "Gt":
-		subargs := strings.SplitN(args[1], ")", 2)
-		return index.Gt(subargs[0]), nil
-	case "Gte":
-		subargs := strings.SplitN(args[1], ")", 2)
-		return index.Gte(subargs[0]), nil
-	case "Ne":
-		subargs := strings.SplitN(args[1], ")", 2)
-		return index.Ne(subargs[0]), nil
-	case "Between":
-		subargs := strings.SplitN(args[1], ")", 2)
-		parts := strings.Split(subargs[0], ",")
-		return index.Between(parts[0], parts[1]), nil
-	case "Except":
-		subargs := strings.SplitN(args[1], ")", 2)
-		parts := strings.Split(subargs[0], ",")
-		return index.Except(parts[0], parts[1]), nil
-	default:
-		return index.Eq(v), nil
-	}
-}
-
 type dynParameter interface {
 	ParameterMaker(*backend.RequestTracker, string) (index.Maker, error)
 }
@@ -744,7 +724,7 @@ func (f *Frontend) processFilters(rt *backend.RequestTracker, d backend.Stores,
 	}
 
 	for k, vs := range params {
-		if k == "offset" || k == "limit" || k == "sort" || k == "reverse" || k == "slim" {
+		if k == "offset" || k == "limit" || k == "sort" || k == "reverse" || k == "slim" || k == "cursor" || k == "stream" {
 			continue
 		}
 		// Did we find an existing index?
@@ -822,7 +802,7 @@ func (f *Frontend) processFilters(rt *backend.RequestTracker, d backend.Stores,
 			filters = append(filters, index.Sort(maker))
 			subfilters := []index.Filter{}
 			for _, v := range vs {
-				f, err := convertValueToFilter(v)
+				f, err := index.ParseFilter(v)
 				if err != nil {
 					return nil, err
 				}
@@ -848,6 +828,22 @@ func (f *Frontend) processFilters(rt *backend.RequestTracker, d backend.Stores,
 		filters = append(filters, index.Reverse())
 	}
 
+	// cursor must come after ordering but before offset and limit
+	if vs, ok := params["cursor"]; ok {
+		if _, sorted := params["sort"]; sorted {
+			return nil, fmt.Errorf("cursor cannot be combined with sort")
+		}
+		if _, offset := params["offset"]; offset {
+			return nil, fmt.Errorf("cursor cannot be combined with offset")
+		}
+		key, err := decodeCursor(ref.Prefix(), vs[0])
+		if err != nil {
+			return nil, err
+		}
+		_, reverse := params["reverse"]
+		filters = append(filters, index.After(key, reverse))
+	}
+
 	// offset and limit must be last
 	if vs, ok := params["offset"]; ok {
 		num, err := strconv.Atoi(vs[0])
@@ -869,6 +865,36 @@ func (f *Frontend) processFilters(rt *backend.RequestTracker, d backend.Stores,
 	return filters, nil
 }
 
+// encodeCursor makes an opaque continuation cursor that will pick up
+// a list of prefix objects right after the one with key.
+func encodeCursor(prefix, key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(prefix + "/" + key))
+}
+
+// decodeCursor returns the key an opaque cursor refers to.  An empty
+// cursor starts at the beginning of the list.
+func decodeCursor(prefix, cursor string) (string, error) {
+	if cursor == "" {
+		return "", nil
+	}
+	buf, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", fmt.Errorf("Cursor not valid: %v", err)
+	}
+	parts := strings.SplitN(string(buf), "/", 2)
+	if len(parts) != 2 || parts[0] != prefix {
+		return "", fmt.Errorf("Cursor not valid for %s", prefix)
+	}
+	return parts[1], nil
+}
+
+// wantsStream returns true if the client asked for a list to be
+// streamed back as newline delimited JSON.
+func wantsStream(c *gin.Context) bool {
+	return c.Query("stream") == "true" ||
+		strings.Contains(c.GetHeader("Accept"), "application/x-ndjson")
+}
+
 func jsonError(c *gin.Context, err error, code int, base string) {
 	if ne, ok := err.(*models.Error); ok {
 		c.JSON(ne.Code, ne)
@@ -888,6 +914,9 @@ func (f *Frontend) emptyList(c *gin.Context, statsOnly bool) {
 	c.Header("X-DRP-LIST-COUNT", "0")
 	if statsOnly {
 		c.Status(http.StatusOK)
+	} else if wantsStream(c) {
+		c.Header("Content-Type", "application/x-ndjson")
+		c.Status(http.StatusOK)
 	} else {
 		c.JSON(http.StatusOK, []models.Model{})
 	}
@@ -917,10 +946,49 @@ func processItem(obj models.Model, slim string) models.Model {
 	return obj
 }
 
+// streamBatchSize is the number of objects that are cloned under a
+// single lock when streaming a list.
+const streamBatchSize = 100
+
+// streamList writes the objects with the passed keys as newline
+// delimited JSON.  Objects are looked up and cloned in batches so
+// that neither the whole result set is held in memory nor the store
+// is locked while writing to a slow client.  Objects deleted since
+// the list was filtered are skipped.
+func (f *Frontend) streamList(c *gin.Context, rt *backend.RequestTracker, prefix string, keys []string, slim string) {
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Status(http.StatusOK)
+	enc := json.NewEncoder(c.Writer)
+	for start := 0; start < len(keys); start += streamBatchSize {
+		end := start + streamBatchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		batch := make([]models.Model, 0, end-start)
+		rt.Do(func(d backend.Stores) {
+			for _, key := range keys[start:end] {
+				if item := d(prefix).Find(key); item != nil {
+					batch = append(batch, processItem(models.Clone(item), slim))
+				}
+			}
+		})
+		for _, item := range batch {
+			if err := enc.Encode(item); err != nil {
+				f.l(c).Debugf("Streaming %s aborted: %v", prefix, err)
+				return
+			}
+		}
+		c.Writer.Flush()
+	}
+}
+
 func (f *Frontend) list(c *gin.Context, ref store.KeySaver, statsOnly bool) {
 	backend.Fill(ref)
 	arr := []models.Model{}
+	keys := []string{}
 	var totalCount, count int
+	var nextCursor string
+	stream := !statsOnly && wantsStream(c)
 	if !f.getAuth(c).matchClaim(models.MakeRole("", ref.Prefix(), "list", "").Compile()) {
 		f.emptyList(c, statsOnly)
 		return
@@ -954,13 +1022,23 @@ func (f *Frontend) list(c *gin.Context, ref store.KeySaver, statsOnly bool) {
 		}
 		count = idx.Count()
 
+		if _, paged := c.GetQuery("cursor"); paged && count > 0 {
+			if limit, err := strconv.Atoi(c.Query("limit")); err == nil && count == limit {
+				nextCursor = encodeCursor(ref.Prefix(), idx.Items()[count-1].Key())
+			}
+		}
+
 		if statsOnly {
 			return
 		}
 
 		items := idx.Items()
 		for _, item := range items {
-			arr = append(arr, processItem(models.Clone(item), slim))
+			if stream {
+				keys = append(keys, item.Key())
+			} else {
+				arr = append(arr, processItem(models.Clone(item), slim))
+			}
 		}
 	})
 
@@ -970,7 +1048,12 @@ func (f *Frontend) list(c *gin.Context, ref store.KeySaver, statsOnly bool) {
 	}
 	c.Header("X-DRP-LIST-TOTAL-COUNT", fmt.Sprintf("%d", totalCount))
 	c.Header("X-DRP-LIST-COUNT", fmt.Sprintf("%d", count))
-	if statsOnly {
+	if nextCursor != "" {
+		c.Header("X-DRP-LIST-CURSOR", nextCursor)
+	}
+	if stream {
+		f.streamList(c, rt, ref.Prefix(), keys, slim)
+	} else if statsOnly {
 		c.Status(http.StatusOK)
 	} else {
 		c.JSON(http.StatusOK, arr)
@@ -2,7 +2,10 @@ package frontend
 
 import (
 	"fmt"
+	"io"
 	"net/http"
+	"os"
+	"time"
 
 	"github.com/VictorLowther/jsonpatch2"
 	"github.com/digitalrebar/provision/backend"
@@ -88,6 +91,13 @@ type JobPathParameter struct {
 	Uuid uuid.UUID `json:"uuid"`
 }
 
+// JobLogQueryParameter used to follow a Job log
+// swagger:parameters getJobLog
+type JobLogQueryParameter struct {
+	// in: query
+	Follow bool `json:"follow"`
+}
+
 // JobParamsBodyParameter used to set Job Params
 // swagger:parameters postJobParams
 type JobParamsBodyParameter struct {
@@ -104,6 +114,10 @@ type JobListPathParameter struct {
 	// in: query
 	Limit int `json:"limit"`
 	// in: query
+	Cursor string `json:"cursor"`
+	// in: query
+	Stream string `json:"stream"`
+	// in: query
 	Available string
 	// in: query
 	Valid string
@@ -171,6 +185,57 @@ type JobActionBodyParameter struct {
 	Body map[string]interface{}
 }
 
+const (
+	// jobLogPoll is how often a followed job log is checked when
+	// nothing has been written to it.
+	jobLogPoll = 30 * time.Second
+	// jobLogGrace is how long a followed job log is kept open after
+	// the job ends, as the runner keeps logging for a bit after it
+	// updates the job state.
+	jobLogGrace = 5 * time.Second
+)
+
+// followJobLog streams src to the client as the job appends to it,
+// until the job ends or the client goes away.  It relies on
+// DataTracker.WatchLog, so a slow client never holds up the runner
+// writing the log.
+func (f *Frontend) followJobLog(c *gin.Context, rt *backend.RequestTracker, uuid string, src io.Reader) {
+	notify, stop := f.dt.WatchLog(uuid)
+	defer stop()
+	if _, ok := src.(*os.File); !ok {
+		// Compressed logs belong to jobs that are long done.
+		io.Copy(c.Writer, src)
+		return
+	}
+	for {
+		if _, err := io.Copy(c.Writer, src); err != nil {
+			return
+		}
+		c.Writer.Flush()
+		done := true
+		rt.Do(func(d backend.Stores) {
+			if jo := d("jobs").Find(uuid); jo != nil {
+				j := backend.AsJob(jo)
+				done = !j.Current || j.State == "finished" || j.State == "failed"
+			}
+		})
+		wait := jobLogPoll
+		if done {
+			wait = jobLogGrace
+		}
+		select {
+		case <-notify:
+		case <-time.After(wait):
+			if done {
+				io.Copy(c.Writer, src)
+				return
+			}
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+}
+
 func (f *Frontend) InitJobApi() {
 	// swagger:route GET /jobs Jobs listJobs
 	//
@@ -181,6 +246,8 @@ func (f *Frontend) InitJobApi() {
 	// You may specify:
 	//    Offset = integer, 0-based inclusive starting point in filter data.
 	//    Limit = integer, number of items to return
+	//    Cursor = opaque string from X-DRP-LIST-CURSOR, start after the last item of the previous page
+	//    Stream = true, return items as newline delimited JSON
 	//
 	// Functional Indexs:
 	//    Uuid = string
@@ -228,6 +295,8 @@ func (f *Frontend) InitJobApi() {
 	// You may specify:
 	//    Offset = integer, 0-based inclusive starting point in filter data.
 	//    Limit = integer, number of items to return
+	//    Cursor = opaque string from X-DRP-LIST-CURSOR, start after the last item of the previous page
+	//    Stream = true, return items as newline delimited JSON
 	//
 	// Functional Indexs:
 	//    Uuid = string
@@ -477,6 +546,11 @@ func (f *Frontend) InitJobApi() {
 	//
 	// Get log for the Job specified by {uuid} or return NotFound.
 	//
+	// If follow=true is passed as a query parameter, the log is
+	// streamed as it is appended to until the job is finished,
+	// failed, superseded or removed, or the client goes away.  A
+	// running job that has not written its log yet is waited on.
+	//
 	//     Produces:
 	//       application/octet-stream
 	//       application/json
@@ -493,7 +567,6 @@ func (f *Frontend) InitJobApi() {
 			j := &backend.Job{}
 			var bad bool
 			var err *models.Error
-			var path string
 			rt := f.rt(c, j.Locks("get")...)
 			rt.Do(func(d backend.Stores) {
 				var jo models.Model
@@ -504,7 +577,6 @@ func (f *Frontend) InitJobApi() {
 					return
 				}
 				j = backend.AsJob(jo)
-				path = j.LogPath(rt)
 			})
 			if bad {
 				c.JSON(err.Code, err)
@@ -515,8 +587,31 @@ func (f *Frontend) InitJobApi() {
 				return
 			}
 
+			// The log may have been compressed by the job janitor, and
+			// a job that just started may not have one yet.
+			follow := c.Query("follow") == "true"
+			var src io.ReadCloser
+			var logErr error
+			if follow {
+				src, logErr = j.WaitLogReader(rt, c.Request.Context().Done())
+			} else {
+				src, logErr = j.LogReader(rt)
+			}
+			if logErr != nil {
+				err = &models.Error{Code: http.StatusNotFound, Model: "jobs", Key: uuid, Type: c.Request.Method}
+				err.Errorf("Cannot open log")
+				err.AddError(logErr)
+				c.JSON(err.Code, err)
+				return
+			}
+			defer src.Close()
 			c.Writer.Header().Set("Content-Type", "application/octet-stream")
-			c.File(path)
+			c.Status(http.StatusOK)
+			if follow {
+				f.followJobLog(c, rt, uuid, src)
+				return
+			}
+			io.Copy(c.Writer, src)
 		})
 
 	// swagger:route PUT /jobs/{uuid}/log Jobs putJobLog
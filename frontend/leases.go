@@ -54,6 +54,10 @@ type LeaseListPathParameter struct {
 	// in: query
 	Limit int `json:"limit"`
 	// in: query
+	Cursor string `json:"cursor"`
+	// in: query
+	Stream string `json:"stream"`
+	// in: query
 	Available string
 	// in: query
 	Valid string
@@ -118,6 +122,8 @@ func (f *Frontend) InitLeaseApi() {
 	// You may specify:
 	//    Offset = integer, 0-based inclusive starting point in filter data.
 	//    Limit = integer, number of items to return
+	//    Cursor = opaque string from X-DRP-LIST-CURSOR, start after the last item of the previous page
+	//    Stream = true, return items as newline delimited JSON
 	//
 	// Functional Indexs:
 	//    Addr = IP Address
@@ -161,6 +167,8 @@ func (f *Frontend) InitLeaseApi() {
 	// You may specify:
 	//    Offset = integer, 0-based inclusive starting point in filter data.
 	//    Limit = integer, number of items to return
+	//    Cursor = opaque string from X-DRP-LIST-CURSOR, start after the last item of the previous page
+	//    Stream = true, return items as newline delimited JSON
 	//
 	// Functional Indexs:
 	//    Addr = IP Address
@@ -1,6 +1,9 @@
 package frontend
 
 import (
+	"net"
+	"net/http"
+
 	"github.com/VictorLowther/jsonpatch2"
 	"github.com/digitalrebar/provision/backend"
 	"github.com/digitalrebar/provision/models"
@@ -88,7 +91,7 @@ type MachinePostParamsParameter struct {
 }
 
 // MachinePathParameter used to find a Machine in the path
-// swagger:parameters putMachines getMachine putMachine patchMachine deleteMachine headMachine patchMachineParams postMachineParams getMachinePubKey
+// swagger:parameters putMachines getMachine putMachine patchMachine deleteMachine headMachine patchMachineParams postMachineParams getMachinePubKey postMachineHeartbeat
 type MachinePathParameter struct {
 	// in: path
 	// required: true
@@ -128,6 +131,8 @@ type MachineGetParamPathParemeter struct {
 	Aggregate string `json:"aggregate"`
 	// in: query
 	Decode string `json:"decode"`
+	// in: query
+	Explain string `json:"explain"`
 	// in: path
 	// required: true
 	// swagger:strfmt uuid
@@ -179,6 +184,28 @@ type MachineActionBodyParameter struct {
 	Body map[string]interface{}
 }
 
+// MachineRenderResponse is returned on a successful GET of a Machine render preview
+// swagger:response
+type MachineRenderResponse struct {
+	// in: body
+	Body *models.RenderPreview
+}
+
+// MachineRenderParameter used to pick what to render for a Machine
+// swagger:parameters getMachineRender
+type MachineRenderParameter struct {
+	// in: path
+	// required: true
+	// swagger:strfmt uuid
+	Uuid uuid.UUID `json:"uuid"`
+	// in: query
+	BootEnv string `json:"bootenv"`
+	// in: query
+	Stage string `json:"stage"`
+	// in: query
+	Task string `json:"task"`
+}
+
 // MachineListPathParameter used to limit lists of Machine by path options
 // swagger:parameters listMachines listStatsMachines
 type MachineListPathParameter struct {
@@ -187,6 +214,10 @@ type MachineListPathParameter struct {
 	// in: query
 	Limit int `json:"limit"`
 	// in: query
+	Cursor string `json:"cursor"`
+	// in: query
+	Stream string `json:"stream"`
+	// in: query
 	Available string
 	// in: query
 	Valid string
@@ -202,6 +233,8 @@ type MachineListPathParameter struct {
 	Address string
 	// in: query
 	Runnable string
+	// in: query
+	LastSeen string
 }
 
 func (f *Frontend) InitMachineApi() {
@@ -214,6 +247,8 @@ func (f *Frontend) InitMachineApi() {
 	// You may specify:
 	//    Offset = integer, 0-based inclusive starting point in filter data.
 	//    Limit = integer, number of items to return
+	//    Cursor = opaque string from X-DRP-LIST-CURSOR, start after the last item of the previous page
+	//    Stream = true, return items as newline delimited JSON
 	//
 	// Functional Indexs:
 	//    Uuid = UUID string
@@ -260,6 +295,8 @@ func (f *Frontend) InitMachineApi() {
 	// You may specify:
 	//    Offset = integer, 0-based inclusive starting point in filter data.
 	//    Limit = integer, number of items to return
+	//    Cursor = opaque string from X-DRP-LIST-CURSOR, start after the last item of the previous page
+	//    Stream = true, return items as newline delimited JSON
 	//
 	// Functional Indexs:
 	//    Uuid = UUID string
@@ -376,6 +413,9 @@ func (f *Frontend) InitMachineApi() {
 			backend.Fill(machine)
 			if c.Query("force") == "true" {
 				machine.ForceChange()
+				if f.getAuth(c).matchClaim(models.MakeRole("", "machines", "forceUnlock", c.Param(`uuid`)).Compile()) {
+					machine.ForceUnlock()
+				}
 			}
 			f.Patch(c, machine, c.Param(`uuid`))
 		})
@@ -400,6 +440,9 @@ func (f *Frontend) InitMachineApi() {
 			backend.Fill(machine)
 			if c.Query("force") == "true" {
 				machine.ForceChange()
+				if f.getAuth(c).matchClaim(models.MakeRole("", "machines", "forceUnlock", c.Param(`uuid`)).Compile()) {
+					machine.ForceUnlock()
+				}
 			}
 			f.Update(c, machine, c.Param(`uuid`))
 		})
@@ -454,7 +497,9 @@ func (f *Frontend) InitMachineApi() {
 	//
 	// Get a single machine parameter
 	//
-	// Get a single parameter {key} for a Machine specified by {uuid}
+	// Get a single parameter {key} for a Machine specified by {uuid}.
+	// With explain=true, the aggregated value is returned along with
+	// every profile and default that defined it, in order of precedence.
 	//
 	//     Responses:
 	//       200: MachineParamResponse
@@ -564,4 +609,105 @@ func (f *Frontend) InitMachineApi() {
 	//       409: ErrorResponse
 	f.ApiGroup.POST("/machines/:uuid/actions/:cmd", pRun)
 
+	// swagger:route GET /machines/{uuid}/render Machines getMachineRender
+	//
+	// Render templates for a Machine without changing anything
+	//
+	// Renders the templates of a BootEnv, Stage and Task for the
+	// Machine specified by {uuid} as if the Machine were in them, and
+	// returns every rendered path and body along with any errors.
+	// Nothing is saved and nothing is made available to the file
+	// server.
+	//
+	// The bootenv and stage query parameters default to the BootEnv
+	// and Stage the Machine is currently in.  Task templates are only
+	// rendered if the task query parameter is passed, and rendering
+	// them requires access to the secure params of the Machine.
+	//
+	//     Responses:
+	//       200: MachineRenderResponse
+	//       401: NoContentResponse
+	//       403: NoContentResponse
+	//       404: ErrorResponse
+	//       422: ErrorResponse
+	f.ApiGroup.GET("/machines/:uuid/render",
+		func(c *gin.Context) {
+			uuid := c.Param(`uuid`)
+			task := c.Query("task")
+			rt := f.rt(c, backend.RenderPreviewLocks...)
+			var m *backend.Machine
+			rt.Do(func(d backend.Stores) {
+				if mo := rt.Find("machines", uuid); mo != nil {
+					m = backend.AsMachine(mo)
+				}
+			})
+			if m == nil {
+				err := &models.Error{
+					Code:  http.StatusNotFound,
+					Type:  c.Request.Method,
+					Model: "machines",
+					Key:   uuid,
+				}
+				err.Errorf("Not Found")
+				c.JSON(err.Code, err)
+				return
+			}
+			if !f.assureSimpleAuth(c, "machines", "render", m.AuthKey()) {
+				return
+			}
+			if task != "" && !f.assureSimpleAuth(c, "machines", "getSecure", m.AuthKey()) {
+				return
+			}
+			var res *models.RenderPreview
+			var err error
+			rt.Do(func(d backend.Stores) {
+				res, err = m.RenderPreview(rt, c.Query("bootenv"), c.Query("stage"), task, m.Address)
+			})
+			if err != nil {
+				be := err.(*models.Error)
+				c.JSON(be.Code, be)
+				return
+			}
+			c.JSON(http.StatusOK, res)
+		})
+
+	// swagger:route POST /machines/{uuid}/heartbeat Machines postMachineHeartbeat
+	//
+	// Tell dr-provision a Machine is alive
+	//
+	// Records that the Machine specified by {uuid} was seen via
+	// heartbeat.  The machine agent calls this periodically while
+	// it runs.
+	//
+	//     Responses:
+	//       204: NoContentResponse
+	//       401: NoContentResponse
+	//       403: NoContentResponse
+	//       404: ErrorResponse
+	f.ApiGroup.POST("/machines/:uuid/heartbeat",
+		func(c *gin.Context) {
+			uuid := c.Param(`uuid`)
+			if !f.assureSimpleAuth(c, "machines", "heartbeat", uuid) {
+				return
+			}
+			found := false
+			rt := f.rt(c, "machines")
+			rt.Do(func(d backend.Stores) {
+				found = rt.Find("machines", uuid) != nil
+			})
+			if !found {
+				err := &models.Error{
+					Code:  http.StatusNotFound,
+					Type:  c.Request.Method,
+					Model: "machines",
+					Key:   uuid,
+				}
+				err.Errorf("Not Found")
+				c.JSON(err.Code, err)
+				return
+			}
+			f.dt.MachineSeen(uuid, "heartbeat", net.ParseIP(c.ClientIP()))
+			c.Data(http.StatusNoContent, gin.MIMEJSON, nil)
+		})
+
 }
@@ -107,6 +107,10 @@ func (f *Frontend) makeParamEndpoints(obj models.Paramer, idKey string) (
 			}
 			var val interface{}
 			rt.Do(func(d backend.Stores) {
+				if c.Query("explain") == "true" {
+					val = rt.ExplainParam(ob.(models.Paramer), key, decoder(c))
+					return
+				}
 				val, _ = rt.GetParam(ob.(models.Paramer), key, aggregator(c), decoder(c))
 			})
 			c.JSON(http.StatusOK, val)
@@ -35,6 +35,14 @@ type PluginProviderData struct {
 	Body interface{}
 }
 
+// PluginProviderSignatureParameter is the detached signature of the
+// upload, checked according to the contentSigning preference
+// swagger:parameters uploadPluginProvider
+type PluginProviderSignatureParameter struct {
+	// in: header
+	Signature string `json:"X-DRP-SIGNATURE"`
+}
+
 // PluginProviderInfoResponse returned on a successful upload of an iso
 // swagger:response
 type PluginProviderInfoResponse struct {
@@ -0,0 +1,481 @@
+package frontend
+
+import (
+	"net/http"
+
+	"github.com/VictorLowther/jsonpatch2"
+	"github.com/digitalrebar/provision/backend"
+	"github.com/digitalrebar/provision/models"
+	"github.com/gin-gonic/gin"
+)
+
+// PoolResponse returned on a successful GET, PUT, PATCH, or POST of a single pool
+// swagger:response
+type PoolResponse struct {
+	// in: body
+	Body *models.Pool
+}
+
+// PoolsResponse returned on a successful GET of all the pools
+// swagger:response
+type PoolsResponse struct {
+	//in: body
+	Body []*models.Pool
+}
+
+// PoolBodyParameter used to inject a Pool
+// swagger:parameters createPool putPool
+type PoolBodyParameter struct {
+	// in: body
+	// required: true
+	Body *models.Pool
+}
+
+// PoolPatchBodyParameter used to patch a Pool
+// swagger:parameters patchPool
+type PoolPatchBodyParameter struct {
+	// in: body
+	// required: true
+	Body jsonpatch2.Patch
+}
+
+// PoolPathParameter used to name a Pool in the path
+// swagger:parameters putPools getPool putPool patchPool deletePool headPool
+type PoolPathParameter struct {
+	// in: path
+	// required: true
+	Name string `json:"name"`
+}
+
+// PoolListPathParameter used to limit lists of Pool by path options
+// swagger:parameters listPools listStatsPools
+type PoolListPathParameter struct {
+	// in: query
+	Offest int `json:"offset"`
+	// in: query
+	Limit int `json:"limit"`
+	// in: query
+	Available string
+	// in: query
+	Valid string
+	// in: query
+	ReadOnly string
+	// in: query
+	Name string
+}
+
+// PoolAllocateParameter used to allocate Machines from a Pool
+// swagger:parameters postPoolAllocate
+type PoolAllocateParameter struct {
+	// in: path
+	// required: true
+	Name string `json:"name"`
+	// in: body
+	// required: true
+	Body *models.PoolAllocateRequest
+}
+
+// PoolReleaseParameter used to release Machines to a Pool
+// swagger:parameters postPoolRelease
+type PoolReleaseParameter struct {
+	// in: path
+	// required: true
+	Name string `json:"name"`
+	// in: query
+	Force string `json:"force"`
+	// in: body
+	// required: true
+	Body *models.PoolReleaseRequest
+}
+
+// PoolMachinesResponse returned on a successful allocate or release
+// swagger:response
+type PoolMachinesResponse struct {
+	// in: body
+	Body []*models.Machine
+}
+
+// PoolReleaseErrorResponse returned when only some of the Machines
+// could be released
+// swagger:response
+type PoolReleaseErrorResponse struct {
+	// in: body
+	Body *models.PoolReleaseError
+}
+
+// PoolActionsPathParameter used to find a Pool / Actions in the path
+// swagger:parameters getPoolActions
+type PoolActionsPathParameter struct {
+	// in: path
+	// required: true
+	Name string `json:"name"`
+	// in: query
+	Plugin string `json:"plugin"`
+}
+
+// PoolActionPathParameter used to find a Pool / Action in the path
+// swagger:parameters getPoolAction
+type PoolActionPathParameter struct {
+	// in: path
+	// required: true
+	Name string `json:"name"`
+	// in: path
+	// required: true
+	Cmd string `json:"cmd"`
+	// in: query
+	Plugin string `json:"plugin"`
+}
+
+// PoolActionBodyParameter used to post a Pool / Action in the path
+// swagger:parameters postPoolAction
+type PoolActionBodyParameter struct {
+	// in: path
+	// required: true
+	Name string `json:"name"`
+	// in: path
+	// required: true
+	Cmd string `json:"cmd"`
+	// in: query
+	Plugin string `json:"plugin"`
+	// in: body
+	// required: true
+	Body map[string]interface{}
+}
+
+func (f *Frontend) InitPoolApi() {
+	// swagger:route GET /pools Pools listPools
+	//
+	// Lists Pools filtered by some parameters.
+	//
+	// This will show all Pools by default.
+	//
+	// You may specify:
+	//    Offset = integer, 0-based inclusive starting point in filter data.
+	//    Limit = integer, number of items to return
+	//
+	// Functional Indexs:
+	//    Name = string
+	//    Available = boolean
+	//
+	// Functions:
+	//    Eq(value) = Return items that are equal to value
+	//    Lt(value) = Return items that are less than value
+	//    Lte(value) = Return items that less than or equal to value
+	//    Gt(value) = Return items that are greater than value
+	//    Gte(value) = Return items that greater than or equal to value
+	//    Between(lower,upper) = Return items that are inclusively between lower and upper
+	//    Except(lower,upper) = Return items that are not inclusively between lower and upper
+	//
+	// Example:
+	//    Name=fred - returns items named fred
+	//    Name=Lt(fred) - returns items that alphabetically less than fred.
+	//    Name=Lt(fred)&Available=true - returns items with Name less than fred and Available is true
+	//
+	// Responses:
+	//    200: PoolsResponse
+	//    401: NoContentResponse
+	//    403: NoContentResponse
+	//    406: ErrorResponse
+	f.ApiGroup.GET("/pools",
+		func(c *gin.Context) {
+			f.List(c, &backend.Pool{})
+		})
+
+	// swagger:route HEAD /pools Pools listStatsPools
+	//
+	// Stats of the List Pools filtered by some parameters.
+	//
+	// This will return headers with the stats of the list.
+	//
+	// You may specify:
+	//    Offset = integer, 0-based inclusive starting point in filter data.
+	//    Limit = integer, number of items to return
+	//
+	// Functional Indexs:
+	//    Name = string
+	//    Available = boolean
+	//
+	// Functions:
+	//    Eq(value) = Return items that are equal to value
+	//    Lt(value) = Return items that are less than value
+	//    Lte(value) = Return items that less than or equal to value
+	//    Gt(value) = Return items that are greater than value
+	//    Gte(value) = Return items that greater than or equal to value
+	//    Between(lower,upper) = Return items that are inclusively between lower and upper
+	//    Except(lower,upper) = Return items that are not inclusively between lower and upper
+	//
+	// Example:
+	//    Name=fred - returns items named fred
+	//    Name=Lt(fred) - returns items that alphabetically less than fred.
+	//    Name=Lt(fred)&Available=true - returns items with Name less than fred and Available is true
+	//
+	// Responses:
+	//    200: NoContentResponse
+	//    401: NoContentResponse
+	//    403: NoContentResponse
+	//    406: ErrorResponse
+	f.ApiGroup.HEAD("/pools",
+		func(c *gin.Context) {
+			f.ListStats(c, &backend.Pool{})
+		})
+
+	// swagger:route POST /pools Pools createPool
+	//
+	// Create a Pool
+	//
+	// Create a Pool from the provided object
+	//
+	//     Responses:
+	//       201: PoolResponse
+	//       400: ErrorResponse
+	//       401: NoContentResponse
+	//       403: NoContentResponse
+	//       409: ErrorResponse
+	//       422: ErrorResponse
+	f.ApiGroup.POST("/pools",
+		func(c *gin.Context) {
+			b := &backend.Pool{}
+			f.Create(c, b)
+		})
+	// swagger:route GET /pools/{name} Pools getPool
+	//
+	// Get a Pool
+	//
+	// Get the Pool specified by {name} or return NotFound.
+	//
+	//     Responses:
+	//       200: PoolResponse
+	//       401: NoContentResponse
+	//       403: NoContentResponse
+	//       404: ErrorResponse
+	f.ApiGroup.GET("/pools/:name",
+		func(c *gin.Context) {
+			f.Fetch(c, &backend.Pool{}, c.Param(`name`))
+		})
+
+	// swagger:route HEAD /pools/{name} Pools headPool
+	//
+	// See if a Pool exists
+	//
+	// Return 200 if the Pool specifiec by {name} exists, or return NotFound.
+	//
+	//     Responses:
+	//       200: NoContentResponse
+	//       401: NoContentResponse
+	//       403: NoContentResponse
+	//       404: NoContentResponse
+	f.ApiGroup.HEAD("/pools/:name",
+		func(c *gin.Context) {
+			f.Exists(c, &backend.Pool{}, c.Param(`name`))
+		})
+
+	// swagger:route PATCH /pools/{name} Pools patchPool
+	//
+	// Patch a Pool
+	//
+	// Update a Pool specified by {name} using a RFC6902 Patch structure
+	//
+	//     Responses:
+	//       200: PoolResponse
+	//       400: ErrorResponse
+	//       401: NoContentResponse
+	//       403: NoContentResponse
+	//       404: ErrorResponse
+	//       406: ErrorResponse
+	//       409: ErrorResponse
+	//       422: ErrorResponse
+	f.ApiGroup.PATCH("/pools/:name",
+		func(c *gin.Context) {
+			f.Patch(c, &backend.Pool{}, c.Param(`name`))
+		})
+
+	// swagger:route PUT /pools/{name} Pools putPool
+	//
+	// Put a Pool
+	//
+	// Update a Pool specified by {name} using a JSON Pool
+	//
+	//     Responses:
+	//       200: PoolResponse
+	//       400: ErrorResponse
+	//       401: NoContentResponse
+	//       403: NoContentResponse
+	//       404: ErrorResponse
+	//       409: ErrorResponse
+	//       422: ErrorResponse
+	f.ApiGroup.PUT("/pools/:name",
+		func(c *gin.Context) {
+			f.Update(c, &backend.Pool{}, c.Param(`name`))
+		})
+
+	// swagger:route DELETE /pools/{name} Pools deletePool
+	//
+	// Delete a Pool
+	//
+	// Delete a Pool specified by {name}
+	//
+	//     Responses:
+	//       200: PoolResponse
+	//       401: NoContentResponse
+	//       403: NoContentResponse
+	//       404: ErrorResponse
+	//       409: ErrorResponse
+	//       422: ErrorResponse
+	f.ApiGroup.DELETE("/pools/:name",
+		func(c *gin.Context) {
+			f.Remove(c, &backend.Pool{}, c.Param(`name`))
+		})
+
+	pool := &backend.Pool{}
+	pActions, pAction, pRun := f.makeActionEndpoints(pool.Prefix(), pool, "name")
+
+	// swagger:route GET /pools/{name}/actions Pools getPoolActions
+	//
+	// List pool actions Pool
+	//
+	// List Pool actions for a Pool specified by {name}
+	//
+	// Optionally, a query parameter can be used to limit the scope to a specific plugin.
+	//   e.g. ?plugin=fred
+	//
+	//     Responses:
+	//       200: ActionsResponse
+	//       401: NoContentResponse
+	//       403: NoContentResponse
+	//       404: ErrorResponse
+	f.ApiGroup.GET("/pools/:name/actions", pActions)
+
+	// swagger:route GET /pools/{name}/actions/{cmd} Pools getPoolAction
+	//
+	// List specific action for a pool Pool
+	//
+	// List specific {cmd} action for a Pool specified by {name}
+	//
+	// Optionally, a query parameter can be used to limit the scope to a specific plugin.
+	//   e.g. ?plugin=fred
+	//
+	//     Responses:
+	//       200: ActionResponse
+	//       400: ErrorResponse
+	//       401: NoContentResponse
+	//       403: NoContentResponse
+	//       404: ErrorResponse
+	f.ApiGroup.GET("/pools/:name/actions/:cmd", pAction)
+
+	// swagger:route POST /pools/{name}/actions/{cmd} Pools postPoolAction
+	//
+	// Call an action on the node.
+	//
+	// Optionally, a query parameter can be used to limit the scope to a specific plugin.
+	//   e.g. ?plugin=fred
+	//
+	//
+	//     Responses:
+	//       400: ErrorResponse
+	//       200: ActionPostResponse
+	//       401: NoContentResponse
+	//       403: NoContentResponse
+	//       404: ErrorResponse
+	//       409: ErrorResponse
+	f.ApiGroup.POST("/pools/:name/actions/:cmd", pRun)
+
+	// swagger:route POST /pools/{name}/allocate Pools postPoolAllocate
+	//
+	// Allocate Machines from a Pool
+	//
+	// Claims Count ready Machines that meet the Selectors of the Pool
+	// specified by {name} and of the request for Owner, and puts them
+	// in the Pool's AllocateWorkflow.  Either all of them are
+	// allocated or none are.  Only Machines the caller can see and
+	// update are claimed, and Owner must be the caller or the caller's
+	// Tenant unless the caller is an admin.
+	//
+	//     Responses:
+	//       200: PoolMachinesResponse
+	//       400: ErrorResponse
+	//       401: NoContentResponse
+	//       403: ErrorResponse
+	//       404: ErrorResponse
+	//       409: ErrorResponse
+	//       422: ErrorResponse
+	f.ApiGroup.POST("/pools/:name/allocate",
+		func(c *gin.Context) {
+			req := &models.PoolAllocateRequest{}
+			if !assureDecode(c, req) {
+				return
+			}
+			f.poolCall(c, "allocate", func(rt *backend.RequestTracker, p *backend.Pool) ([]*models.Machine, error) {
+				return p.Allocate(rt, req)
+			})
+		})
+
+	// swagger:route POST /pools/{name}/release Pools postPoolRelease
+	//
+	// Release Machines to a Pool
+	//
+	// Gives the Machines allocated from the Pool specified by {name}
+	// back to it, and puts them in the Pool's ReleaseWorkflow.  Only
+	// the owner of an allocation can release it unless force=true is
+	// passed.  If only some of the Machines could be released, the
+	// error also lists the ones that were.
+	//
+	//     Responses:
+	//       200: PoolMachinesResponse
+	//       400: ErrorResponse
+	//       401: NoContentResponse
+	//       403: ErrorResponse
+	//       404: ErrorResponse
+	//       422: PoolReleaseErrorResponse
+	f.ApiGroup.POST("/pools/:name/release",
+		func(c *gin.Context) {
+			req := &models.PoolReleaseRequest{}
+			if !assureDecode(c, req) {
+				return
+			}
+			force := c.Query("force") == "true"
+			f.poolCall(c, "release", func(rt *backend.RequestTracker, p *backend.Pool) ([]*models.Machine, error) {
+				return p.Release(rt, req, force)
+			})
+		})
+}
+
+// poolCall checks that the caller may take action on the Pool named
+// in the path, and calls fn on it with the Pool allocation locks held.
+func (f *Frontend) poolCall(c *gin.Context, action string,
+	fn func(*backend.RequestTracker, *backend.Pool) ([]*models.Machine, error)) {
+	name := c.Param(`name`)
+	if !f.assureSimpleAuth(c, "pools", action, name) {
+		return
+	}
+	rt := f.rt(c, backend.PoolAllocationLocks...)
+	var res []*models.Machine
+	var err error
+	found := false
+	rt.Do(func(d backend.Stores) {
+		if obj := rt.RawFind("pools", name); obj != nil {
+			found = true
+			res, err = fn(rt, backend.AsPool(obj))
+		}
+	})
+	if !found {
+		err := &models.Error{
+			Code:  http.StatusNotFound,
+			Type:  c.Request.Method,
+			Model: "pools",
+			Key:   name,
+		}
+		err.Errorf("Not Found")
+		c.JSON(err.Code, err)
+		return
+	}
+	if err != nil {
+		be := err.(*models.Error)
+		if len(res) > 0 {
+			c.JSON(be.Code, &models.PoolReleaseError{Error: be, Released: res})
+			return
+		}
+		c.JSON(be.Code, be)
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
@@ -80,13 +80,26 @@ func (f *Frontend) InitPrefApi() {
 					if !f.assureSimpleAuth(c, "prefs", "post", k) {
 						return
 					}
-				case "knownTokenTimeout", "unknownTokenTimeout":
+				case "knownTokenTimeout", "unknownTokenTimeout",
+					"jobRetentionCount", "jobRetentionMaxAge", "jobLogCompressAge",
+					"repoCacheSize", "repoCacheMetadataAge", "bootLoopThreshold", "provisioningStallTimeout":
 					if !f.assureSimpleAuth(c, "prefs", "post", k) {
 						return
 					}
 					if _, e := strconv.Atoi(prefs[k]); e != nil {
 						err.Errorf("%s: %v", k, e)
 					}
+				case "jobRetentionKeepFailed":
+					if !f.assureSimpleAuth(c, "prefs", "post", k) {
+						return
+					}
+					if _, e := strconv.ParseBool(prefs[k]); e != nil {
+						err.Errorf("%s: %v", k, e)
+					}
+				case "contentSigning", "trustedKeys":
+					if !f.assureSimpleAuth(c, "prefs", "post", k) {
+						return
+					}
 				default:
 					err.Errorf("Unknown Preference %s", k)
 				}
@@ -1,9 +1,45 @@
 package frontend
 
 import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+
+	"github.com/digitalrebar/provision"
 	"github.com/digitalrebar/provision/backend"
+	"github.com/digitalrebar/provision/models"
+	"github.com/gin-gonic/gin"
 )
 
+// BackupManifestResponse returned on a successful restore
+// swagger:response
+type BackupManifestResponse struct {
+	// in: body
+	Body *models.BackupManifest
+}
+
+// BackupData body of the restore
+// swagger:parameters restoreSystem
+type BackupData struct {
+	// in: body
+	Body interface{}
+}
+
+// backupTrees returns the file trees that are part of a backup,
+// keyed by their name in the backup archive.
+func (f *Frontend) backupTrees() map[string]string {
+	res := map[string]string{
+		"files":    path.Join(f.FileRoot, "files"),
+		"job-logs": f.dt.LogRoot,
+	}
+	if f.pc != nil {
+		res["plugins"] = f.pc.PluginDir()
+	}
+	return res
+}
+
 // SystemActionsPathParameter used to find a System / Actions in the path
 // swagger:parameters getSystemActions
 type SystemActionsPathParameter struct {
@@ -87,4 +123,139 @@ func (f *Frontend) InitSystemApi() {
 	//       404: ErrorResponse
 	//       409: ErrorResponse
 	f.ApiGroup.POST("/system/actions/:cmd", pRun)
+
+	// swagger:route GET /system/backup System backupSystem
+	//
+	// Back up the system
+	//
+	// Returns a gzipped tar archive containing the writable store,
+	// the secrets store, the local content, all content layers,
+	// plugin providers, job logs and the files tree.  The snapshot
+	// is taken with everything locked, so it is consistent.  The
+	// archive includes a manifest.json with checksums of all the
+	// other files in it.
+	//
+	//     Produces:
+	//       application/octet-stream
+	//       application/json
+	//
+	//     Responses:
+	//       200: FileResponse
+	//       401: NoContentResponse
+	//       403: NoContentResponse
+	//       500: ErrorResponse
+	f.ApiGroup.GET("/system/backup",
+		func(c *gin.Context) {
+			if !f.assureSimpleAuth(c, "system", "backup", "") {
+				return
+			}
+			res := &models.Error{
+				Model: "system",
+				Key:   "backup",
+				Type:  c.Request.Method,
+				Code:  http.StatusInternalServerError,
+			}
+			// The backup contains secrets, so it must not be
+			// staged anywhere the static file server can see.
+			tmp, err := ioutil.TempFile("", "drp-backup-")
+			if err != nil {
+				res.AddError(err)
+				c.JSON(res.Code, res)
+				return
+			}
+			defer os.Remove(tmp.Name())
+			defer tmp.Close()
+			manifest, err := f.dt.Snapshot(f.rt(c), provision.RSVersion, f.backupTrees(), tmp)
+			if err != nil {
+				res.Errorf("Failed to create backup")
+				res.AddError(err)
+				c.JSON(res.Code, res)
+				return
+			}
+			c.Writer.Header().Set("Content-Type", "application/octet-stream")
+			c.Writer.Header().Set("Content-Disposition",
+				fmt.Sprintf("attachment; filename=drp-backup-%s.tgz", manifest.Created.Format("20060102-150405")))
+			c.File(tmp.Name())
+		})
+
+	// swagger:route POST /system/restore System restoreSystem
+	//
+	// Restore the system from a backup
+	//
+	// Replaces the writable store, the secrets store, the local
+	// content, all content layers, plugin providers, job logs and
+	// the files tree with the ones in a backup created by GET
+	// /system/backup.  The archive checksums are verified and the
+	// restored data is validated before anything is replaced, and
+	// the content layers and plugin providers in it are held to the
+	// contentSigning preference.  dr-provision should be restarted
+	// after a restore to reload plugins.
+	//
+	//     Consumes:
+	//       application/octet-stream
+	//
+	//     Produces:
+	//       application/json
+	//
+	//     Responses:
+	//       200: BackupManifestResponse
+	//       400: ErrorResponse
+	//       401: NoContentResponse
+	//       403: ErrorResponse
+	//       422: ErrorResponse
+	//       500: ErrorResponse
+	f.ApiGroup.POST("/system/restore",
+		func(c *gin.Context) {
+			if !f.assureSimpleAuth(c, "system", "restore", "") {
+				return
+			}
+			if !assureContentType(c, "application/octet-stream") {
+				return
+			}
+			res := &models.Error{
+				Model: "system",
+				Key:   "restore",
+				Type:  c.Request.Method,
+				Code:  http.StatusBadRequest,
+			}
+			if c.Request.Body == nil {
+				res.Errorf("Missing upload body")
+				c.JSON(res.Code, res)
+				return
+			}
+			dir, err := ioutil.TempDir("", "drp-restore-")
+			if err != nil {
+				res.Code = http.StatusInternalServerError
+				res.AddError(err)
+				c.JSON(res.Code, res)
+				return
+			}
+			defer os.RemoveAll(dir)
+			manifest, err := backend.ReadBackup(c.Request.Body, dir)
+			if err != nil {
+				res.Errorf("Invalid backup")
+				res.AddError(err)
+				c.JSON(res.Code, res)
+				return
+			}
+			rt := f.rt(c)
+			hard, soft := f.dt.Restore(rt, dir, manifest, f.backupTrees(), f.buildNewStore)
+			if serr, ok := hard.(*models.Error); ok && serr.Type == "SIGNATURE_ERROR" {
+				c.JSON(serr.Code, serr)
+				return
+			}
+			if hard != nil {
+				res.Code = http.StatusUnprocessableEntity
+				res.Errorf("Failed to restore backup")
+				res.AddError(hard)
+				res.AddError(soft)
+				c.JSON(res.Code, res)
+				return
+			}
+			if soft != nil {
+				rt.Warnf("Backup restored with warnings: %v", soft)
+			}
+			rt.Infof("Restored backup taken at %s by %s", manifest.Created, manifest.Version)
+			c.JSON(http.StatusOK, manifest)
+		})
 }
@@ -0,0 +1,47 @@
+package midlayer
+
+import (
+	"time"
+
+	"github.com/digitalrebar/logger"
+	"github.com/digitalrebar/provision/backend"
+	"github.com/digitalrebar/provision/utils"
+)
+
+// AllocationReaper periodically releases the Machines whose Pool
+// allocations have expired.
+type AllocationReaper struct {
+	dt *backend.DataTracker
+	l  logger.Logger
+	p  *utils.Prometheus
+	*periodic
+}
+
+// StartAllocationReaper starts an AllocationReaper that runs every
+// interval.
+func StartAllocationReaper(dt *backend.DataTracker, l logger.Logger, interval time.Duration) *AllocationReaper {
+	mets := []*utils.Metric{
+		{
+			ID:          "released",
+			Name:        "allocations_expired_total",
+			Description: "How many machines were released because their allocation expired.",
+			Type:        "counter",
+		},
+	}
+	ar := &AllocationReaper{
+		dt:       dt,
+		l:        l,
+		p:        utils.NewPrometheus(l, "drp_allocation_reaper", mets),
+		periodic: newPeriodic(interval),
+	}
+	ar.start(ar.check)
+	return ar
+}
+
+func (ar *AllocationReaper) check() {
+	released := ar.dt.ReleaseExpired(time.Now())
+	ar.p.Counter("released").Add(float64(len(released)))
+	if len(released) > 0 {
+		ar.l.Infof("Allocation reaper: released %d machines: %v", len(released), released)
+	}
+}
@@ -2,6 +2,7 @@ package midlayer
 
 import (
 	"context"
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -162,6 +163,11 @@ func (pc *PluginController) Reserve() error {
 func (pc *PluginController) Release() {}
 func (pc *PluginController) Unload()  {}
 
+// PluginDir returns the directory plugin providers are stored in.
+func (pc *PluginController) PluginDir() string {
+	return pc.pluginDir
+}
+
 func (pc *PluginController) GetPluginProvider(name string) *models.PluginProvider {
 	pc.Tracef("Starting GetPluginProvider\n")
 	pc.lock.Lock()
@@ -214,6 +220,9 @@ func (pc *PluginController) buildNewStore(content *models.Content) (newStore sto
 			"Version":     content.Meta.Version,
 			"Type":        content.Meta.Type,
 		}
+		if content.Meta.Prerequisites != "" {
+			data["Prerequisites"] = content.Meta.Prerequisites
+		}
 		md.SetMetaData(data)
 	}
 
@@ -449,9 +458,11 @@ func (pc *PluginController) UploadPluginProvider(c *gin.Context, fileRoot, name
 			fmt.Sprintf("upload: Unable to upload %s: %v", name, err))
 	}
 
+	digest := sha256.New()
+	dst := io.MultiWriter(tgt, digest)
 	switch strings.Split(ctype, "; ")[0] {
 	case `application/octet-stream`:
-		copied, err = io.Copy(tgt, c.Request.Body)
+		copied, err = io.Copy(dst, c.Request.Body)
 		if err != nil {
 			os.Remove(ppTmpName)
 			return nil, models.NewError("API ERROR", http.StatusInsufficientStorage,
@@ -466,7 +477,7 @@ func (pc *PluginController) UploadPluginProvider(c *gin.Context, fileRoot, name
 		header, _ := c.FormFile("file")
 		file, _ := header.Open()
 		defer file.Close()
-		copied, err = io.Copy(tgt, file)
+		copied, err = io.Copy(dst, file)
 		if err != nil {
 			return nil, models.NewError("API ERROR", http.StatusBadRequest,
 				fmt.Sprintf("upload: iso %s could not save", header.Filename))
@@ -475,9 +486,17 @@ func (pc *PluginController) UploadPluginProvider(c *gin.Context, fileRoot, name
 	}
 	tgt.Close()
 
+	if _, serr := pc.dt.CheckSignature("plugin_providers", name, digest.Sum(nil), c.GetHeader(models.SignatureHeader)); serr != nil {
+		os.Remove(ppTmpName)
+		return nil, serr
+	}
+
 	os.Remove(ppName)
 	os.Rename(ppTmpName, ppName)
 	os.Chmod(ppName, 0700)
+	if err := pc.dt.RecordSignature("plugin_providers", path.Base(name), "", c.GetHeader(models.SignatureHeader)); err != nil {
+		pc.Warnf("Failed to record the signature of plugin provider %s: %v", name, err)
+	}
 
 	pc.lock.Lock()
 	defer pc.lock.Unlock()
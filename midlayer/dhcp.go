@@ -251,11 +251,19 @@ func (dhr *DhcpRequest) checkMachine(l *backend.Lease) {
 		if l.Fake() {
 			return
 		}
+		if dhr.bootRequest() {
+			rt.BootAttempt(dhr.machine)
+		}
 		// We want the machine to PXE boot, and we know what address it is
 		// getting.  However, that address may not be one that we are
 		// currently rendering templates for.  Check to see if we need to
 		// update the machine's address of record.
 		machineSave := !dhr.machine.Address.Equal(l.Addr)
+		if arch := dhr.clientArch(); arch != "" && arch != dhr.machine.Arch {
+			rt.Infof("%s: Machine %s has arch %s", dhr.xid(), dhr.machine.UUID(), arch)
+			dhr.machine.Arch = arch
+			machineSave = true
+		}
 		others, err := index.All(
 			index.Sort(dhr.machine.Indexes()["Address"]),
 			index.Eq(l.Addr.String()))(rt.Index("machines"))
@@ -272,8 +280,10 @@ func (dhr *DhcpRequest) checkMachine(l *backend.Lease) {
 			}
 		}
 		if machineSave {
-			rt.Warnf("%s: Updating machine %s address from %s to %s", dhr.xid(), dhr.machine.UUID(), dhr.machine.Address, l.Addr)
-			dhr.machine.Address = l.Addr
+			if !dhr.machine.Address.Equal(l.Addr) {
+				rt.Warnf("%s: Updating machine %s address from %s to %s", dhr.xid(), dhr.machine.UUID(), dhr.machine.Address, l.Addr)
+				dhr.machine.Address = l.Addr
+			}
 			rt.Save(dhr.machine)
 		}
 	})
@@ -593,6 +603,7 @@ func (dhr *DhcpRequest) ServeDHCP() string {
 			reply.CHAddr(),
 			serverID)
 		dhr.Reply(reply)
+		dhr.handler.bk.MacSeen("dhcp", reply.CHAddr().String(), lease.Addr)
 		return "ACK"
 	case dhcp.Discover:
 		for _, s := range dhr.handler.strats {
@@ -16,6 +16,7 @@ import (
 	"github.com/digitalrebar/logger"
 	"github.com/digitalrebar/pinger"
 	"github.com/digitalrebar/provision/backend"
+	dhcp "github.com/krolaw/dhcp4"
 )
 
 /*
@@ -151,3 +152,26 @@ func TestDHCPCases(t *testing.T) {
 		}
 	}
 }
+
+func TestDHCPBootRequest(t *testing.T) {
+	mac, _ := net.ParseMAC("52:54:00:00:00:01")
+	for _, test := range []struct {
+		name   string
+		ciaddr net.IP
+		opts   []dhcp.Option
+		want   bool
+	}{
+		{"pxe", net.IPv4zero, []dhcp.Option{{Code: dhcp.OptionVendorClassIdentifier, Value: []byte("PXEClient:Arch:00000")}}, true},
+		{"ipxe", net.IPv4zero, []dhcp.Option{{Code: dhcp.OptionUserClass, Value: []byte("iPXE")}}, true},
+		{"os", net.IPv4zero, nil, false},
+		{"renewal", net.IPv4(192, 168, 124, 10), []dhcp.Option{{Code: dhcp.OptionVendorClassIdentifier, Value: []byte("PXEClient:Arch:00000")}}, false},
+		{"ipxe renewal", net.IPv4(192, 168, 124, 10), []dhcp.Option{{Code: dhcp.OptionUserClass, Value: []byte("iPXE")}}, false},
+	} {
+		dhr := rt(t)
+		dhr.request = dhcp.RequestPacket(dhcp.Request, mac, test.ciaddr, []byte{1, 2, 3, 4}, false, test.opts)
+		dhr.pktOpts = dhr.request.ParseOptions()
+		if got := dhr.bootRequest(); got != test.want {
+			t.Errorf("%s: expected bootRequest %v, got %v", test.name, test.want, got)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package midlayer
+
+import (
+	"time"
+
+	"github.com/digitalrebar/logger"
+	"github.com/digitalrebar/provision/backend"
+	"github.com/digitalrebar/provision/utils"
+)
+
+// JobJanitor periodically applies the job retention policy and
+// compresses old job logs, and keeps metrics on what it reclaimed.
+type JobJanitor struct {
+	dt *backend.DataTracker
+	l  logger.Logger
+	p  *utils.Prometheus
+	*periodic
+}
+
+// StartJobJanitor starts a JobJanitor that runs every interval.
+func StartJobJanitor(dt *backend.DataTracker, l logger.Logger, interval time.Duration) *JobJanitor {
+	mets := []*utils.Metric{
+		{
+			ID:          "pruned",
+			Name:        "jobs_pruned_total",
+			Description: "How many jobs were removed, partitioned by retention rule.",
+			Type:        "counter_vec",
+			Args:        []string{"reason"},
+		},
+		{
+			ID:          "compressed",
+			Name:        "logs_compressed_total",
+			Description: "How many job logs were compressed.",
+			Type:        "counter",
+		},
+		{
+			ID:          "reclaimed",
+			Name:        "reclaimed_bytes_total",
+			Description: "How much disk space was freed by removing and compressing job logs.",
+			Type:        "counter",
+		},
+		{
+			ID:          "runDur",
+			Name:        "run_duration_seconds",
+			Description: "How long each janitor pass took in seconds.",
+			Type:        "summary",
+		},
+	}
+	jj := &JobJanitor{
+		dt:       dt,
+		l:        l,
+		p:        utils.NewPrometheus(l, "drp_job_janitor", mets),
+		periodic: newPeriodic(interval),
+	}
+	jj.start(jj.clean)
+	return jj
+}
+
+func (jj *JobJanitor) clean() {
+	start := time.Now()
+	res := jj.dt.CleanupJobs(start)
+	jj.p.Observe("runDur", time.Since(start).Seconds())
+	for reason, count := range res.Pruned {
+		jj.p.CounterWithLabelValues("pruned", reason).Add(float64(count))
+	}
+	jj.p.Counter("compressed").Add(float64(res.LogsCompressed))
+	jj.p.Counter("reclaimed").Add(float64(res.BytesReclaimed))
+	if len(res.Pruned) > 0 || res.LogsCompressed > 0 {
+		jj.l.Infof("Job janitor: pruned %v jobs, compressed %d logs, reclaimed %d bytes",
+			res.Pruned, res.LogsCompressed, res.BytesReclaimed)
+	}
+}
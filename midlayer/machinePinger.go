@@ -0,0 +1,76 @@
+package midlayer
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/digitalrebar/logger"
+	"github.com/digitalrebar/pinger"
+	"github.com/digitalrebar/provision/backend"
+	"github.com/digitalrebar/provision/utils"
+)
+
+// MachinePinger periodically pings the Machines dr-provision has not
+// heard from since its last pass, and records the ones that answer
+// as seen via ping.
+type MachinePinger struct {
+	dt     *backend.DataTracker
+	l      logger.Logger
+	p      *utils.Prometheus
+	pinger pinger.Pinger
+	*periodic
+}
+
+// StartMachinePinger starts a MachinePinger that uses pinger to ping
+// Machines every interval.
+func StartMachinePinger(dt *backend.DataTracker, l logger.Logger, pinger pinger.Pinger, interval time.Duration) *MachinePinger {
+	mets := []*utils.Metric{
+		{
+			ID:          "pinged",
+			Name:        "machines_pinged_total",
+			Description: "How many machines were pinged, partitioned by whether they answered.",
+			Type:        "counter_vec",
+			Args:        []string{"result"},
+		},
+	}
+	mp := &MachinePinger{
+		dt:       dt,
+		l:        l,
+		p:        utils.NewPrometheus(l, "drp_machine_pinger", mets),
+		pinger:   pinger,
+		periodic: newPeriodic(interval),
+	}
+	mp.start(mp.check)
+	return mp
+}
+
+func (mp *MachinePinger) check() {
+	targets := mp.dt.MachinesNotSeen(time.Now().Add(-mp.interval))
+	wg := &sync.WaitGroup{}
+	for uuid, addr := range targets {
+		wg.Add(1)
+		go func(uuid string, addr net.IP, res <-chan bool) {
+			defer wg.Done()
+			if inUse, valid := <-res; valid && inUse {
+				mp.p.CounterWithLabelValues("pinged", "reachable").Inc()
+				mp.dt.MachineSeen(uuid, "ping", addr)
+			} else if valid {
+				mp.p.CounterWithLabelValues("pinged", "unreachable").Inc()
+				mp.l.Debugf("Machine pinger: %s at %s did not answer", uuid, addr)
+			}
+		}(uuid, addr, mp.pinger.InUse(addr.String(), 3*time.Second))
+	}
+	wg.Wait()
+}
+
+// Shutdown stops the MachinePinger and closes its pinger once the
+// pass in progress, if any, has finished.
+func (mp *MachinePinger) Shutdown(ctx context.Context) error {
+	if err := mp.periodic.Shutdown(ctx); err != nil {
+		return err
+	}
+	mp.pinger.Close()
+	return nil
+}
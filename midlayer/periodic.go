@@ -0,0 +1,69 @@
+package midlayer
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// periodic runs a function every interval, and whenever it is poked,
+// until it is shut down.  The background workers in this package
+// embed it to share the ticker loop and its shutdown handling.
+type periodic struct {
+	interval time.Duration
+	wake     chan struct{}
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+func newPeriodic(interval time.Duration) *periodic {
+	return &periodic{
+		interval: interval,
+		wake:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// start runs fn in the background every interval.
+func (p *periodic) start(fn func()) {
+	go p.run(fn)
+}
+
+// poke makes the periodic run its function as soon as it can instead
+// of waiting for the next tick.  It never blocks.
+func (p *periodic) poke() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (p *periodic) run(fn func()) {
+	defer close(p.done)
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.wake:
+			fn()
+		case <-ticker.C:
+			fn()
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// Shutdown stops the periodic and waits for the run in progress, if
+// any, to finish or for ctx to be done.
+func (p *periodic) Shutdown(ctx context.Context) error {
+	p.stopOnce.Do(func() { close(p.stop) })
+	select {
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -207,6 +207,31 @@ func (dhr *DhcpRequest) offerPXE() bool {
 	return false
 }
 
+// bootRequest returns whether the request comes from PXE or iPXE
+// firmware getting an address to boot with, as opposed to a running
+// OS renewing its lease.  Only those are counted as boot attempts.
+func (dhr *DhcpRequest) bootRequest() bool {
+	if ciaddr := dhr.request.CIAddr(); ciaddr != nil && !ciaddr.IsUnspecified() {
+		return false
+	}
+	if dhr.offerPXE() {
+		return true
+	}
+	val, ok := dhr.pktOpts[dhcp.OptionUserClass]
+	return ok && string(val) == "iPXE"
+}
+
+// clientArch returns the architecture the client reported in the
+// client system architecture option, or "" if it did not send one
+// we know.
+func (dhr *DhcpRequest) clientArch() string {
+	val, ok := dhr.pktOpts[dhcp.OptionClientArchitecture]
+	if !ok || len(val) < 2 {
+		return ""
+	}
+	return models.DhcpArch(uint(val[0])<<8 + uint(val[1]))
+}
+
 // fillForPXE is responsible for determining whether we should handle
 // this options as a PXE request, and adding any required out options
 // based
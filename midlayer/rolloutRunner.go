@@ -0,0 +1,90 @@
+package midlayer
+
+import (
+	"context"
+	"time"
+
+	"github.com/digitalrebar/logger"
+	"github.com/digitalrebar/provision/backend"
+	"github.com/digitalrebar/provision/models"
+	"github.com/digitalrebar/provision/utils"
+)
+
+// RolloutRunner advances Rollouts whenever a Machine, Job, or Rollout
+// changes, and every interval in case it missed something.
+type RolloutRunner struct {
+	dt   *backend.DataTracker
+	l    logger.Logger
+	p    *utils.Prometheus
+	pubs *backend.Publishers
+	*periodic
+}
+
+// StartRolloutRunner starts a RolloutRunner that listens for events
+// on pubs and also runs every interval.
+func StartRolloutRunner(dt *backend.DataTracker, l logger.Logger, pubs *backend.Publishers, interval time.Duration) *RolloutRunner {
+	mets := []*utils.Metric{
+		{
+			ID:          models.RolloutMachineRunning,
+			Name:        "machines_started_total",
+			Description: "How many machines rollouts have started.",
+			Type:        "counter",
+		},
+		{
+			ID:          models.RolloutMachineSucceeded,
+			Name:        "machines_succeeded_total",
+			Description: "How many machines have succeeded in rollouts.",
+			Type:        "counter",
+		},
+		{
+			ID:          models.RolloutMachineFailed,
+			Name:        "machines_failed_total",
+			Description: "How many machines have failed in rollouts.",
+			Type:        "counter",
+		},
+	}
+	rr := &RolloutRunner{
+		dt:       dt,
+		l:        l,
+		p:        utils.NewPrometheus(l, "drp_rollout_runner", mets),
+		pubs:     pubs,
+		periodic: newPeriodic(interval),
+	}
+	pubs.Add(rr)
+	rr.start(rr.check)
+	return rr
+}
+
+// Publish wakes the RolloutRunner up when something it cares about
+// changes.  It never blocks and does not log.
+func (rr *RolloutRunner) Publish(e *models.Event) error {
+	switch e.Type {
+	case "machines", "jobs", "rollouts":
+		rr.poke()
+	}
+	return nil
+}
+
+// Reserve is part of the Publisher interface.
+func (rr *RolloutRunner) Reserve() error {
+	return nil
+}
+
+// Release is part of the Publisher interface.
+func (rr *RolloutRunner) Release() {}
+
+// Unload is part of the Publisher interface.
+func (rr *RolloutRunner) Unload() {}
+
+func (rr *RolloutRunner) check() {
+	res := rr.dt.AdvanceRollouts(time.Now())
+	for state, count := range res {
+		rr.p.Counter(state).Add(float64(count))
+	}
+}
+
+// Shutdown stops listening for events and stops the RolloutRunner.
+func (rr *RolloutRunner) Shutdown(ctx context.Context) error {
+	rr.pubs.Remove(rr)
+	return rr.periodic.Shutdown(ctx)
+}
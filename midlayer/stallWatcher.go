@@ -0,0 +1,47 @@
+package midlayer
+
+import (
+	"time"
+
+	"github.com/digitalrebar/logger"
+	"github.com/digitalrebar/provision/backend"
+	"github.com/digitalrebar/provision/utils"
+)
+
+// StallWatcher periodically marks Machines whose provisioning has
+// stalled as not Runnable.  Boot loops are caught as they happen;
+// stalls are only noticed by looking.
+type StallWatcher struct {
+	dt *backend.DataTracker
+	l  logger.Logger
+	p  *utils.Prometheus
+	*periodic
+}
+
+// StartStallWatcher starts a StallWatcher that runs every interval.
+func StartStallWatcher(dt *backend.DataTracker, l logger.Logger, interval time.Duration) *StallWatcher {
+	mets := []*utils.Metric{
+		{
+			ID:          "stalled",
+			Name:        "machines_stalled_total",
+			Description: "How many machines were marked not runnable because provisioning stalled.",
+			Type:        "counter",
+		},
+	}
+	sw := &StallWatcher{
+		dt:       dt,
+		l:        l,
+		p:        utils.NewPrometheus(l, "drp_stall_watcher", mets),
+		periodic: newPeriodic(interval),
+	}
+	sw.start(sw.check)
+	return sw
+}
+
+func (sw *StallWatcher) check() {
+	stalled := sw.dt.CheckStalled(time.Now())
+	sw.p.Counter("stalled").Add(float64(len(stalled)))
+	if len(stalled) > 0 {
+		sw.l.Infof("Stall watcher: marked %d machines not runnable: %v", len(stalled), stalled)
+	}
+}
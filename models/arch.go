@@ -0,0 +1,122 @@
+package models
+
+import "strings"
+
+// archAliases maps the names architectures go by to the name
+// dr-provision uses for them, which is the one Go uses.
+var archAliases = map[string]string{
+	"amd64":   "amd64",
+	"x86_64":  "amd64",
+	"x86-64":  "amd64",
+	"386":     "386",
+	"i386":    "386",
+	"i686":    "386",
+	"x86":     "386",
+	"arm64":   "arm64",
+	"aarch64": "arm64",
+	"arm":     "arm",
+	"armhf":   "arm",
+	"armv7l":  "arm",
+	"ppc64le": "ppc64le",
+	"ppc64el": "ppc64le",
+	"s390x":   "s390x",
+}
+
+// CanonicalArch returns the name dr-provision uses for the
+// architecture a, and whether a is an architecture it knows about.
+func CanonicalArch(a string) (string, bool) {
+	res, ok := archAliases[strings.ToLower(strings.TrimSpace(a))]
+	return res, ok
+}
+
+// DhcpArch returns the architecture for a DHCP client system
+// architecture type (option 93), or "" if it is not one dr-provision
+// can boot.
+func DhcpArch(code uint) string {
+	switch code {
+	case 0, 7, 9, 16:
+		return "amd64"
+	case 6, 15:
+		return "386"
+	case 10, 18:
+		return "arm"
+	case 11, 19:
+		return "arm64"
+	default:
+		return ""
+	}
+}
+
+// ArchInfo holds the architecture specific parts of a BootEnv.
+// swagger:model
+type ArchInfo struct {
+	// The partial path to the kernel for this architecture.
+	//
+	// required: true
+	Kernel string
+	// Partial paths to the initrds for this architecture.  If this is
+	// not set, the BootEnv's Initrds are used.
+	Initrds []string
+	// A template that will be expanded to create the full list of
+	// boot parameters for this architecture.  If this is empty, the
+	// BootEnv's BootParams are used.
+	BootParams string
+	// The name of the ISO that the OS for this architecture should
+	// install from.
+	IsoFile string
+	// The SHA256 of the ISO file.
+	IsoSha256 string
+	// The URL that the ISO can be downloaded from, if any.
+	//
+	// swagger:strfmt uri
+	IsoUrl string
+}
+
+// ArchInfo returns the kernel, initrds, boot parameters and ISO that
+// machines of architecture arch should use.  The BootEnv's own fields
+// are returned for architectures that do not have an entry in Arches.
+func (b *BootEnv) ArchInfo(arch string) ArchInfo {
+	if a, ok := CanonicalArch(arch); ok {
+		if res, ok := b.Arches[a]; ok {
+			if res.Initrds == nil {
+				res.Initrds = b.Initrds
+			}
+			if res.BootParams == "" {
+				res.BootParams = b.BootParams
+			}
+			return res
+		}
+	}
+	return ArchInfo{
+		Kernel:     b.Kernel,
+		Initrds:    b.Initrds,
+		BootParams: b.BootParams,
+		IsoFile:    b.OS.IsoFile,
+		IsoSha256:  b.OS.IsoSha256,
+		IsoUrl:     b.OS.IsoUrl,
+	}
+}
+
+// HasArch returns whether the BootEnv has its own entry in Arches
+// for arch.
+func (b *BootEnv) HasArch(arch string) bool {
+	a, ok := CanonicalArch(arch)
+	if !ok {
+		return false
+	}
+	_, ok = b.Arches[a]
+	return ok
+}
+
+func (b *BootEnv) validateArches() {
+	for name, info := range b.Arches {
+		if a, ok := CanonicalArch(name); !ok {
+			b.Errorf("Arch %s is not a known architecture", name)
+		} else if a != name {
+			b.Errorf("Arch %s must be named %s", name, a)
+		}
+		if info.Kernel == "" && b.NetBoot() {
+			b.Errorf("Arch %s needs a Kernel", name)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package models
+
+import "time"
+
+// BackupEntry describes a single file contained in a backup archive.
+//
+// swagger:model
+type BackupEntry struct {
+	// Path is the path of the file inside the archive.
+	Path string
+	// Size is the size of the file in bytes.
+	Size int64
+	// Sha256 is the hex encoded SHA256 checksum of the file.
+	Sha256 string
+}
+
+// BackupManifest describes the contents of a backup archive created
+// by GET /system/backup.  It is stored in the archive as
+// manifest.json, and every other file in the archive must be listed
+// in Entries for the archive to be accepted by a restore.
+//
+// swagger:model
+type BackupManifest struct {
+	// Version is the version of dr-provision that created the backup.
+	Version string
+	// Created is when the backup was taken.
+	Created time.Time
+	// Contents lists the names of the content layers in the backup.
+	Contents []string
+	// Trees lists the file trees (files, job-logs, plugins) in the
+	// backup.
+	Trees []string
+	// Entries lists every file in the backup along with its
+	// checksum.
+	Entries []BackupEntry
+}
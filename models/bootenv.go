@@ -1,6 +1,7 @@
 package models
 
 import (
+	"path"
 	"strconv"
 	"strings"
 )
@@ -162,6 +163,22 @@ type BootEnv struct {
 	//
 	// required: true
 	OnlyUnknown bool
+	// Ignition is a template that will be expanded to create an
+	// Ignition spec v3 config fragment for Machines in the boot
+	// environment.  When it is set, each Machine gets the fragment
+	// merged with the ones in its ignition/fragments params at
+	// machines/<uuid>/ignition.json.
+	Ignition string `json:",omitempty"`
+	// Image is the disk image that Machines in the boot environment
+	// get, for BootEnvs that deploy images instead of running an
+	// installer.  Tasks deploy it with the .ImageDeploy template
+	// helper.
+	Image *ImageInfo `json:",omitempty"`
+	// Arches holds the kernel, initrds, boot parameters and ISO for
+	// architectures that cannot boot the ones in the fields above,
+	// keyed by architecture.  Machines whose architecture has no
+	// entry here use the fields above.
+	Arches map[string]ArchInfo `json:",omitempty"`
 }
 
 func (b *BootEnv) GetMeta() Meta {
@@ -194,6 +211,15 @@ func (b *BootEnv) Validate() {
 			tmplNames[tmpl.Name] = i
 		}
 	}
+	b.validateArches()
+	if b.Image != nil {
+		if b.Image.File == "" || path.IsAbs(b.Image.File) || strings.HasPrefix(path.Clean(b.Image.File), "..") {
+			b.Errorf("Image file %q must be a path inside the file root", b.Image.File)
+		}
+		if _, _, err := SplitImageType(b.Image.ImageType()); err != nil {
+			b.AddError(err)
+		}
+	}
 }
 
 func (b *BootEnv) Prefix() string {
@@ -0,0 +1,162 @@
+package models
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+	"strconv"
+)
+
+// cidrInt converts v into an int64.  Template literals are ints,
+// but numbers that come from params have been through JSON and are
+// float64s, so both (and strings) are accepted.
+func cidrInt(v interface{}) (int64, error) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), nil
+	case int32:
+		return int64(n), nil
+	case int64:
+		return n, nil
+	case uint:
+		return int64(n), nil
+	case uint32:
+		return int64(n), nil
+	case uint64:
+		return int64(n), nil
+	case float32:
+		if float32(int64(n)) == n {
+			return int64(n), nil
+		}
+	case float64:
+		if float64(int64(n)) == n {
+			return int64(n), nil
+		}
+	case string:
+		return strconv.ParseInt(n, 0, 64)
+	}
+	return 0, fmt.Errorf("%v is not an integer", v)
+}
+
+// ipInt returns the address as a number along with the number of
+// bits in the address.
+func ipInt(ip net.IP) (*big.Int, int) {
+	if v4 := ip.To4(); v4 != nil {
+		return new(big.Int).SetBytes(v4), 32
+	}
+	return new(big.Int).SetBytes(ip.To16()), 128
+}
+
+// intIP is the reverse of ipInt.  It returns nil if n does not fit
+// in bits.
+func intIP(n *big.Int, bits int) net.IP {
+	if n.Sign() < 0 || n.BitLen() > bits {
+		return nil
+	}
+	b := n.Bytes()
+	res := make(net.IP, bits/8)
+	copy(res[len(res)-len(b):], b)
+	return res
+}
+
+func parseCIDR(cidr string) (*net.IPNet, error) {
+	_, res, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid CIDR %s: %v", cidr, err)
+	}
+	return res, nil
+}
+
+// CIDRHost returns the address of host number num in cidr.
+// Negative numbers count back from the end of the network, so -1
+// is the broadcast address of an IPv4 network.
+func CIDRHost(cidr string, num interface{}) (string, error) {
+	network, err := parseCIDR(cidr)
+	if err != nil {
+		return "", err
+	}
+	n, err := cidrInt(num)
+	if err != nil {
+		return "", err
+	}
+	base, bits := ipInt(network.IP)
+	ones, _ := network.Mask.Size()
+	size := new(big.Int).Lsh(big.NewInt(1), uint(bits-ones))
+	host := big.NewInt(n)
+	if n < 0 {
+		host.Add(host, size)
+	}
+	if host.Sign() < 0 || host.Cmp(size) >= 0 {
+		return "", fmt.Errorf("Host %d is not in %s", n, cidr)
+	}
+	return intIP(host.Add(host, base), bits).String(), nil
+}
+
+// CIDRNetmask returns the netmask of cidr in address form.
+func CIDRNetmask(cidr string) (string, error) {
+	network, err := parseCIDR(cidr)
+	if err != nil {
+		return "", err
+	}
+	return net.IP(network.Mask).String(), nil
+}
+
+// CIDRSubnet splits cidr into networks with newbits more bits of
+// prefix and returns network number num of them.
+func CIDRSubnet(cidr string, newbits, num interface{}) (string, error) {
+	network, err := parseCIDR(cidr)
+	if err != nil {
+		return "", err
+	}
+	nb, err := cidrInt(newbits)
+	if err != nil {
+		return "", err
+	}
+	n, err := cidrInt(num)
+	if err != nil {
+		return "", err
+	}
+	base, bits := ipInt(network.IP)
+	ones, _ := network.Mask.Size()
+	if nb < 0 || int64(ones)+nb > int64(bits) {
+		return "", fmt.Errorf("Cannot add %d bits of prefix to %s", nb, cidr)
+	}
+	if n < 0 || big.NewInt(n).Cmp(new(big.Int).Lsh(big.NewInt(1), uint(nb))) >= 0 {
+		return "", fmt.Errorf("%s has no subnet %d with %d more bits of prefix", cidr, n, nb)
+	}
+	prefix := ones + int(nb)
+	offset := new(big.Int).Lsh(big.NewInt(n), uint(bits-prefix))
+	return fmt.Sprintf("%s/%d", intIP(offset.Add(offset, base), bits), prefix), nil
+}
+
+// CIDRContains returns whether ip is in cidr.
+func CIDRContains(cidr, ip string) (bool, error) {
+	network, err := parseCIDR(cidr)
+	if err != nil {
+		return false, err
+	}
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false, fmt.Errorf("Invalid IP address %s", ip)
+	}
+	return network.Contains(addr), nil
+}
+
+// IPAdd returns the address num addresses after ip.  num may be
+// negative.
+func IPAdd(ip string, num interface{}) (string, error) {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return "", fmt.Errorf("Invalid IP address %s", ip)
+	}
+	n, err := cidrInt(num)
+	if err != nil {
+		return "", err
+	}
+	base, bits := ipInt(addr)
+	res := intIP(base.Add(base, big.NewInt(n)), bits)
+	if res == nil {
+		return "", fmt.Errorf("%s plus %d is not an IP address", ip, n)
+	}
+	return res.String(), nil
+}
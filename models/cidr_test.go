@@ -0,0 +1,46 @@
+package models
+
+import "testing"
+
+func TestCIDRMath(t *testing.T) {
+	for _, test := range []struct {
+		name, want string
+		fn         func() (string, error)
+	}{
+		{"first host", "10.0.0.1", func() (string, error) { return CIDRHost("10.0.0.0/24", 1) }},
+		{"last address", "10.0.0.255", func() (string, error) { return CIDRHost("10.0.0.0/24", -1) }},
+		{"host from a param", "10.0.0.12", func() (string, error) { return CIDRHost("10.0.0.0/24", float64(12)) }},
+		{"v6 host", "fd00::10", func() (string, error) { return CIDRHost("fd00::/64", 16) }},
+		{"netmask", "255.255.252.0", func() (string, error) { return CIDRNetmask("10.0.4.0/22") }},
+		{"subnet", "10.0.3.0/24", func() (string, error) { return CIDRSubnet("10.0.0.0/16", 8, 3) }},
+		{"v6 subnet", "fd00:0:0:2::/64", func() (string, error) { return CIDRSubnet("fd00::/48", 16, "2") }},
+		{"add", "10.0.1.4", func() (string, error) { return IPAdd("10.0.0.250", 10) }},
+		{"subtract", "10.0.0.240", func() (string, error) { return IPAdd("10.0.0.250", -10) }},
+	} {
+		got, err := test.fn()
+		if err != nil || got != test.want {
+			t.Errorf("%s: expected %s, got %s: %v", test.name, test.want, got, err)
+		}
+	}
+	for _, test := range []struct {
+		name string
+		fn   func() (string, error)
+	}{
+		{"host out of range", func() (string, error) { return CIDRHost("10.0.0.0/24", 256) }},
+		{"fractional host", func() (string, error) { return CIDRHost("10.0.0.0/24", 1.5) }},
+		{"bad cidr", func() (string, error) { return CIDRNetmask("10.0.0.0") }},
+		{"too many bits", func() (string, error) { return CIDRSubnet("10.0.0.0/24", 9, 0) }},
+		{"subnet out of range", func() (string, error) { return CIDRSubnet("10.0.0.0/24", 2, 4) }},
+		{"add past the end", func() (string, error) { return IPAdd("255.255.255.255", 1) }},
+	} {
+		if got, err := test.fn(); err == nil {
+			t.Errorf("%s: expected an error, got %s", test.name, got)
+		}
+	}
+	if in, err := CIDRContains("10.0.0.0/8", "10.1.2.3"); err != nil || !in {
+		t.Errorf("Expected 10.1.2.3 to be in 10.0.0.0/8: %v", err)
+	}
+	if in, err := CIDRContains("10.0.0.0/8", "11.1.2.3"); err != nil || in {
+		t.Errorf("Expected 11.1.2.3 to not be in 10.0.0.0/8: %v", err)
+	}
+}
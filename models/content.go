@@ -1,6 +1,12 @@
 package models
 
-import "github.com/digitalrebar/store"
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/digitalrebar/store"
+)
 
 // All fields must be strings
 type ContentMetaData struct {
@@ -13,6 +19,13 @@ type ContentMetaData struct {
 	// Optional fields
 	Documentation    string
 	RequiredFeatures string
+	// Prerequisites are the other content bundles this one needs, as
+	// a comma separated list of names, each optionally followed by a
+	// colon and the VersionRange of the bundle that will do.  For
+	// example:
+	//
+	//    drp-community-content: >=1.5.0 <2.0.0, task-library
+	Prerequisites string `json:",omitempty"`
 
 	// Informational Fields
 	Writable     bool
@@ -60,6 +73,9 @@ func (c *Content) ToStore(dest store.Store) error {
 			"Documentation":    c.Meta.Documentation,
 			"RequiredFeatures": c.Meta.RequiredFeatures,
 		}
+		if c.Meta.Prerequisites != "" {
+			meta["Prerequisites"] = c.Meta.Prerequisites
+		}
 		if err := dmeta.SetMetaData(meta); err != nil {
 			return err
 		}
@@ -97,11 +113,13 @@ func (c *Content) FromStore(src store.Store) error {
 				c.Meta.Documentation = v
 			case "RequiredFeatures":
 				c.Meta.RequiredFeatures = v
+			case "Prerequisites":
+				c.Meta.Prerequisites = v
 			}
 		}
 	}
 	for section, subStore := range src.Subs() {
-		if _, err := New(section); err != nil {
+		if _, err := New(section); err != nil && section != ContentTestsSection {
 			continue
 		}
 		keys, err := subStore.Keys()
@@ -110,7 +128,12 @@ func (c *Content) FromStore(src store.Store) error {
 		}
 		c.Sections[section] = map[string]interface{}{}
 		for _, key := range keys {
-			val, _ := New(section)
+			var val interface{}
+			if section == ContentTestsSection {
+				val = &TemplateTest{}
+			} else {
+				val, _ = New(section)
+			}
 			if f, ok := val.(Filler); ok {
 				f.Fill()
 			}
@@ -125,6 +148,133 @@ func (c *Content) FromStore(src store.Store) error {
 	return nil
 }
 
+// ContentPrerequisite is a content bundle that another content
+// bundle needs.
+type ContentPrerequisite struct {
+	// Name of the content bundle.
+	Name string
+	// Versions of it that will do.  Any version will do if it is nil.
+	Versions *VersionRange
+}
+
+func (p *ContentPrerequisite) String() string {
+	if p.Versions == nil {
+		return p.Name
+	}
+	return p.Name + " " + p.Versions.String()
+}
+
+// ParseContentPrerequisites parses the Prerequisites of a
+// ContentMetaData.
+func ParseContentPrerequisites(prereqs string) ([]*ContentPrerequisite, error) {
+	res := []*ContentPrerequisite{}
+	for _, item := range strings.Split(prereqs, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		parts := strings.SplitN(item, ":", 2)
+		p := &ContentPrerequisite{Name: strings.TrimSpace(parts[0])}
+		if p.Name == "" {
+			return nil, fmt.Errorf("Prerequisite %s has no name", item)
+		}
+		if len(parts) == 2 && strings.TrimSpace(parts[1]) != "" {
+			r, err := ParseVersionRange(parts[1])
+			if err != nil {
+				return nil, fmt.Errorf("Prerequisite %s: %v", p.Name, err)
+			}
+			p.Versions = r
+		}
+		res = append(res, p)
+	}
+	return res, nil
+}
+
+// CheckContentPrerequisites returns a message for every prerequisite
+// of the content bundle name that is not satisfied by installed,
+// which maps the names of content bundles to their versions.
+func CheckContentPrerequisites(name, prereqs string, installed map[string]string) []string {
+	ps, err := ParseContentPrerequisites(prereqs)
+	if err != nil {
+		return []string{fmt.Sprintf("Content %s has invalid prerequisites: %v", name, err)}
+	}
+	res := []string{}
+	for _, p := range ps {
+		version, ok := installed[p.Name]
+		if !ok {
+			res = append(res, fmt.Sprintf("Content %s requires %s, which is not installed", name, p))
+			continue
+		}
+		if p.Versions == nil {
+			continue
+		}
+		v, err := ParseSemVer(version)
+		if err != nil {
+			res = append(res, fmt.Sprintf("Content %s requires %s, but the installed version %s is not a semantic version", name, p, version))
+			continue
+		}
+		if !p.Versions.Matches(v) {
+			res = append(res, fmt.Sprintf("Content %s requires %s, but version %s is installed", name, p, version))
+		}
+	}
+	return res
+}
+
+// OrderContents sorts contents so that every content bundle comes
+// after the ones it requires, keeping the order of contents where it
+// can.  Prerequisites that are not in contents are ignored.  An error
+// is returned if the prerequisites are invalid or form a cycle.
+func OrderContents(contents []*Content) ([]*Content, error) {
+	index := map[string]int{}
+	for i, c := range contents {
+		index[c.Meta.Name] = i
+	}
+	needs := make([]map[int]struct{}, len(contents))
+	for i, c := range contents {
+		ps, err := ParseContentPrerequisites(c.Meta.Prerequisites)
+		if err != nil {
+			return nil, fmt.Errorf("Content %s has invalid prerequisites: %v", c.Meta.Name, err)
+		}
+		needs[i] = map[int]struct{}{}
+		for _, p := range ps {
+			if j, ok := index[p.Name]; ok && j != i {
+				needs[i][j] = struct{}{}
+			}
+		}
+	}
+	res := make([]*Content, 0, len(contents))
+	done := make([]bool, len(contents))
+	for len(res) < len(contents) {
+		progress := false
+		for i, c := range contents {
+			if done[i] {
+				continue
+			}
+			ready := true
+			for j := range needs[i] {
+				ready = ready && done[j]
+			}
+			if ready {
+				res = append(res, c)
+				done[i] = true
+				progress = true
+				break
+			}
+		}
+		if !progress {
+			cycle := []string{}
+			for i, c := range contents {
+				if !done[i] {
+					cycle = append(cycle, c.Meta.Name)
+				}
+			}
+			sort.Strings(cycle)
+			return nil, fmt.Errorf("Content prerequisites form a cycle: %s", strings.Join(cycle, ", "))
+		}
+	}
+	return res, nil
+}
+
 type Sections map[string]Section
 type Section map[string]interface{}
 
@@ -185,6 +335,8 @@ func (c *ContentSummary) FromStore(src store.Store) {
 				c.Meta.Documentation = v
 			case "RequiredFeatures":
 				c.Meta.RequiredFeatures = v
+			case "Prerequisites":
+				c.Meta.Prerequisites = v
 			}
 		}
 	}
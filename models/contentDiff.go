@@ -0,0 +1,55 @@
+package models
+
+// ContentObjectDiff is a change to a single object made by replacing
+// a content bundle.
+//
+// swagger:model
+type ContentObjectDiff struct {
+	// Prefix of the object.
+	Prefix string
+	// Key of the object.
+	Key string
+	// Change is one of added, removed, or changed.
+	Change string
+	// Fields are the top level fields of a changed object that
+	// changed.
+	Fields []string `json:",omitempty"`
+}
+
+// ContentMachineImpact is a Machine whose current BootEnv, Stage, or
+// Tasks would change if a content bundle were replaced.
+//
+// swagger:model
+type ContentMachineImpact struct {
+	// UUID of the Machine.
+	UUID string
+	// Name of the Machine.
+	Name string
+	// Reasons lists the objects the Machine uses that would change.
+	Reasons []string
+}
+
+// ContentDiff is what adding or replacing a content bundle would do,
+// as reported by a dry run.
+//
+// swagger:model
+type ContentDiff struct {
+	// Content is the name of the content bundle.
+	Content string
+	// OldVersion is the version of the installed content bundle, if
+	// any.
+	OldVersion string
+	// NewVersion is the version of the new content bundle.
+	NewVersion string
+	// Objects are the objects that would be added, removed, or
+	// changed, sorted by Prefix and Key.
+	Objects []*ContentObjectDiff
+	// Machines are the Machines that would be affected.
+	Machines []*ContentMachineImpact
+	// Errors are the reasons the content bundle would be refused.
+	// The content bundle can be installed if there are none.
+	Errors []string
+	// Warnings are the problems the content bundle would be installed
+	// with.
+	Warnings []string
+}
@@ -0,0 +1,35 @@
+package models
+
+// ContentLintFinding is a single problem found in a content bundle by
+// a lint.
+//
+// swagger:model
+type ContentLintFinding struct {
+	// Severity is either error, for things that will fail when the
+	// content is used, or warning, for things that are likely
+	// mistakes.
+	Severity string
+	// Code identifies the kind of problem, such as missing-template
+	// or undefined-param.  Codes do not change between releases, so
+	// they can be used to filter findings.
+	Code string
+	// Object is the object the problem was found in, as prefix:key.
+	Object string
+	// Message describes the problem.
+	Message string
+}
+
+// ContentLintResults are all the problems found in a content bundle
+// by a lint.
+//
+// swagger:model
+type ContentLintResults struct {
+	// Content is the name of the content bundle.
+	Content string
+	// Errors is the number of findings with a Severity of error.
+	Errors int
+	// Warnings is the number of findings with a Severity of warning.
+	Warnings int
+	// Findings are the problems found, sorted by Object.
+	Findings []*ContentLintFinding
+}
@@ -0,0 +1,104 @@
+package models
+
+// ContentTestsSection is the section of a content bundle that holds
+// its TemplateTests.  It is not a model the server keeps track of, so
+// the server carries it along with the rest of the bundle but
+// otherwise ignores it.
+const ContentTestsSection = "tests"
+
+// TemplateExpectation is an assertion about the output of a template
+// rendered by a TemplateTest.  The rendered templates it applies to
+// are picked by Source, Name and Path, and at least one of them must
+// match.
+//
+// swagger:model
+type TemplateExpectation struct {
+	// Source limits the expectation to templates from a single object,
+	// as prefix:key.  For example bootenvs:ubuntu-16.04-install.
+	Source string
+	// Name is the Name of the TemplateInfo the template came from.
+	//
+	// required: true
+	Name string
+	// Path limits the expectation to templates rendered to this path.
+	Path string
+	// Output is the exact output the template must render to, if set.
+	Output string
+	// Matches are regular expressions that must all match the
+	// rendered template.
+	Matches []string
+	// NotMatches are regular expressions that must not match the
+	// rendered template.
+	NotMatches []string
+	// Error is a regular expression that must match the error that
+	// rendering the template fails with.  If it is not set, the
+	// template must render without errors.
+	Error string
+}
+
+// TemplateTest is a test fixture carried in the tests section of a
+// content bundle.  It describes a fake Machine, the BootEnv, Stage
+// and Task to render for it, and what the rendered templates must
+// look like.
+//
+// swagger:model
+type TemplateTest struct {
+	// Name of the test.
+	//
+	// required: true
+	Name string
+	// Description of the test.
+	Description string
+	// Machine is the fake Machine to render templates for.  A
+	// Machine named test.example.com with a random UUID is used if it
+	// is not set.
+	Machine *Machine
+	// Params are added to the Params of the Machine.
+	Params map[string]interface{}
+	// Profiles are created before the Machine and added to its list
+	// of Profiles.
+	Profiles []*Profile
+	// BootEnv to render.  Defaults to the BootEnv of the Machine.
+	BootEnv string
+	// Stage to render.  Defaults to the Stage of the Machine.
+	Stage string
+	// Task to render.  Task templates are not rendered if it is not
+	// set.
+	Task string
+	// Expect are the assertions about the rendered templates.
+	Expect []*TemplateExpectation
+}
+
+func (t *TemplateTest) Key() string {
+	return t.Name
+}
+
+// TemplateTestResult is the outcome of a single TemplateTest.
+//
+// swagger:model
+type TemplateTestResult struct {
+	// Name of the test.
+	Name string
+	// Passed is true if all the expectations of the test held.
+	Passed bool
+	// Failures describes every way the test failed.
+	Failures []string
+	// Rendered are the templates the test rendered.  They are only
+	// included when the test failed.
+	Rendered []*RenderedTemplate `json:",omitempty"`
+}
+
+// ContentTestResults are the outcome of running the TemplateTests of
+// a content bundle.
+//
+// swagger:model
+type ContentTestResults struct {
+	// Content is the name of the content bundle.
+	Content string
+	// Passed is the number of tests that passed.
+	Passed int
+	// Failed is the number of tests that failed.
+	Failed int
+	// Results are the outcomes of the individual tests.
+	Results []*TemplateTestResult
+}
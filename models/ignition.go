@@ -0,0 +1,202 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+)
+
+// IgnitionVersion is the Ignition spec version that merged configs
+// get when none of their fragments ask for a later one.
+const IgnitionVersion = "3.0.0"
+
+// ignitionKeys are the fields that identify entries in the lists of
+// an Ignition config.  When fragments are merged, an entry with the
+// same key as an earlier one is merged into it instead of being
+// added to the list.
+var ignitionKeys = []string{"path", "name", "device", "number", "label"}
+
+// ignitionKey returns the field and value that identify v, if it
+// has one.
+func ignitionKey(v interface{}) (string, interface{}) {
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return "", nil
+	}
+	for _, k := range ignitionKeys {
+		if val, ok := obj[k]; ok {
+			return k, val
+		}
+	}
+	return "", nil
+}
+
+func ignitionScalar(v interface{}) bool {
+	switch v.(type) {
+	case map[string]interface{}, []interface{}:
+		return false
+	}
+	return true
+}
+
+func mergeIgnition(base, child interface{}) interface{} {
+	switch c := child.(type) {
+	case map[string]interface{}:
+		b, ok := base.(map[string]interface{})
+		if !ok {
+			b = map[string]interface{}{}
+		}
+		res := make(map[string]interface{}, len(b)+len(c))
+		for k, v := range b {
+			res[k] = v
+		}
+		for k, v := range c {
+			res[k] = mergeIgnition(b[k], v)
+		}
+		return res
+	case []interface{}:
+		b, _ := base.([]interface{})
+		res := append([]interface{}{}, b...)
+	items:
+		for _, item := range c {
+			field, key := ignitionKey(item)
+			for i := range res {
+				if field == "" {
+					if ignitionScalar(item) && ignitionScalar(res[i]) && res[i] == item {
+						continue items
+					}
+					continue
+				}
+				if f, k := ignitionKey(res[i]); f == field && k == key {
+					res[i] = mergeIgnition(res[i], item)
+					continue items
+				}
+			}
+			res = append(res, mergeIgnition(nil, item))
+		}
+		return res
+	default:
+		return child
+	}
+}
+
+// ignitionVersion returns the Ignition spec version that frag asks
+// for, if any.
+func ignitionVersion(frag map[string]interface{}) (*SemVer, error) {
+	ign, ok := frag["ignition"].(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+	raw, ok := ign["version"]
+	if !ok {
+		return nil, nil
+	}
+	s, ok := raw.(string)
+	if !ok {
+		return nil, fmt.Errorf("ignition.version must be a string")
+	}
+	v, err := ParseSemVer(s)
+	if err != nil {
+		return nil, fmt.Errorf("ignition.version: %v", err)
+	}
+	if v.Major != 3 {
+		return nil, fmt.Errorf("ignition.version %s is not a 3.x version", s)
+	}
+	return v, nil
+}
+
+// MergeIgnition merges Ignition config fragments into one config.
+// Later fragments override earlier ones.  Objects are merged field
+// by field, and lists are appended to, except that list entries
+// with the same path, name, device, number or label as an earlier
+// one are merged into it.  The merged config gets the latest
+// ignition.version of the fragments.
+func MergeIgnition(fragments ...map[string]interface{}) (map[string]interface{}, error) {
+	version, _ := ParseSemVer(IgnitionVersion)
+	var res interface{} = map[string]interface{}{}
+	for i, frag := range fragments {
+		v, err := ignitionVersion(frag)
+		if err != nil {
+			return nil, fmt.Errorf("Fragment %d: %v", i, err)
+		}
+		if v != nil && v.Compare(version) > 0 {
+			version = v
+		}
+		res = mergeIgnition(res, frag)
+	}
+	cfg := res.(map[string]interface{})
+	ign, _ := cfg["ignition"].(map[string]interface{})
+	if ign == nil {
+		ign = map[string]interface{}{}
+		cfg["ignition"] = ign
+	}
+	ign["version"] = version.String()
+	return cfg, nil
+}
+
+// ignitionLists are the lists of objects in an Ignition config,
+// along with the fields each of their entries must have.
+var ignitionLists = []struct {
+	section, list string
+	fields        []string
+}{
+	{"storage", "files", []string{"path"}},
+	{"storage", "directories", []string{"path"}},
+	{"storage", "links", []string{"path", "target"}},
+	{"storage", "disks", []string{"device"}},
+	{"storage", "filesystems", []string{"device"}},
+	{"storage", "raid", []string{"name"}},
+	{"storage", "luks", []string{"name"}},
+	{"systemd", "units", []string{"name"}},
+	{"passwd", "users", []string{"name"}},
+	{"passwd", "groups", []string{"name"}},
+}
+
+// ValidateIgnition checks that cfg looks like an Ignition spec v3
+// config, and adds what is wrong with it to e.
+func ValidateIgnition(cfg map[string]interface{}, e ErrorAdder) {
+	for k := range cfg {
+		switch k {
+		case "ignition", "kernelArguments", "passwd", "storage", "systemd":
+		default:
+			e.Errorf("Ignition config has unknown field %s", k)
+		}
+	}
+	if _, err := ignitionVersion(cfg); err != nil {
+		e.Errorf("Ignition config: %v", err)
+	}
+	for _, l := range ignitionLists {
+		section, ok := cfg[l.section]
+		if !ok {
+			continue
+		}
+		sec, ok := section.(map[string]interface{})
+		if !ok {
+			e.Errorf("Ignition config %s must be an object", l.section)
+			continue
+		}
+		raw, ok := sec[l.list]
+		if !ok {
+			continue
+		}
+		entries, ok := raw.([]interface{})
+		if !ok {
+			e.Errorf("Ignition config %s.%s must be a list", l.section, l.list)
+			continue
+		}
+		for i, entry := range entries {
+			obj, ok := entry.(map[string]interface{})
+			if !ok {
+				e.Errorf("Ignition config %s.%s[%d] must be an object", l.section, l.list, i)
+				continue
+			}
+			for _, f := range l.fields {
+				val, _ := obj[f].(string)
+				if val == "" {
+					e.Errorf("Ignition config %s.%s[%d] needs a %s", l.section, l.list, i, f)
+				} else if f == "path" && !strings.HasPrefix(val, "/") {
+					e.Errorf("Ignition config %s.%s[%d] path %s must be absolute", l.section, l.list, i, val)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func ignitionFrag(t *testing.T, s string) map[string]interface{} {
+	res := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(s), &res); err != nil {
+		t.Fatalf("Bad fragment %s: %v", s, err)
+	}
+	return res
+}
+
+func TestMergeIgnition(t *testing.T) {
+	cfg, err := MergeIgnition(
+		ignitionFrag(t, `{"ignition":{"version":"3.0.0"},"storage":{"files":[{"path":"/etc/hostname","contents":{"source":"data:,a"}}]},"kernelArguments":{"shouldExist":["quiet"]}}`),
+		ignitionFrag(t, `{"ignition":{"version":"3.2.0"},"storage":{"files":[{"path":"/etc/hostname","mode":420},{"path":"/etc/motd"}]},"kernelArguments":{"shouldExist":["quiet","nosmt"]}}`),
+		ignitionFrag(t, `{"systemd":{"units":[{"name":"etcd.service","enabled":true}]}}`),
+	)
+	if err != nil {
+		t.Fatalf("Failed to merge: %v", err)
+	}
+	buf, _ := json.Marshal(cfg)
+	want := `{"ignition":{"version":"3.2.0"},"kernelArguments":{"shouldExist":["quiet","nosmt"]},"storage":{"files":[{"contents":{"source":"data:,a"},"mode":420,"path":"/etc/hostname"},{"path":"/etc/motd"}]},"systemd":{"units":[{"enabled":true,"name":"etcd.service"}]}}`
+	if string(buf) != want {
+		t.Errorf("Expected:\n%s\ngot:\n%s", want, buf)
+	}
+	e := &Error{}
+	ValidateIgnition(cfg, e)
+	if e.ContainsError() {
+		t.Errorf("Expected merged config to be valid: %v", e)
+	}
+	if _, err := MergeIgnition(ignitionFrag(t, `{"ignition":{"version":"2.2.0"}}`)); err == nil {
+		t.Errorf("Expected a 2.x fragment to be refused")
+	}
+	e = &Error{}
+	ValidateIgnition(ignitionFrag(t, `{"ignition":{"version":"3.1.0"},"networkd":{},"storage":{"files":[{"path":"etc/motd"},{}]},"systemd":{"units":[{"enabled":true}]}}`), e)
+	for _, want := range []string{"unknown field networkd", "must be absolute", "storage.files[1] needs a path", "systemd.units[0] needs a name"} {
+		if !strings.Contains(e.Error(), want) {
+			t.Errorf("Expected %q in %v", want, e)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package models
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// imageFormats are the formats of disk images that can be deployed.
+var imageFormats = map[string]bool{"raw": true, "qcow2": true}
+
+// imageCompressions are the compressions disk images can have, by
+// the extension they get.
+var imageCompressions = map[string]bool{"": true, "gz": true, "bz2": true, "xz": true, "zst": true}
+
+// ImageType guesses the type of the disk image at file from its
+// extension.  Images that are not qcow2 are taken to be raw.
+func ImageType(file string) string {
+	base := path.Base(file)
+	comp := ""
+	if ext := strings.TrimPrefix(path.Ext(base), "."); imageCompressions[ext] {
+		comp = ext
+		base = strings.TrimSuffix(base, "."+ext)
+	}
+	format := "raw"
+	if path.Ext(base) == ".qcow2" {
+		format = "qcow2"
+	}
+	if comp == "" {
+		return format
+	}
+	return format + "." + comp
+}
+
+// SplitImageType splits an image type into its format and its
+// compression, which is empty if it is not compressed.
+func SplitImageType(t string) (format, compression string, err error) {
+	parts := strings.SplitN(t, ".", 2)
+	format = parts[0]
+	if len(parts) == 2 {
+		compression = parts[1]
+	}
+	if !imageFormats[format] || !imageCompressions[compression] {
+		return "", "", fmt.Errorf("Invalid image type %s", t)
+	}
+	return
+}
+
+// ImageInfo describes the disk image that a BootEnv deploys.
+//
+// swagger:model
+type ImageInfo struct {
+	// File is the path to the image relative to the file root.
+	//
+	// required: true
+	File string
+	// Type is raw or qcow2, optionally followed by .gz, .bz2, .xz
+	// or .zst if the image is compressed.  If it is empty, it is
+	// guessed from the extension of File.
+	Type string `json:",omitempty"`
+	// Sha256 is the SHA256 checksum of File.  If it is set, the
+	// image is checked against it as it is deployed.
+	Sha256 string `json:",omitempty"`
+}
+
+// ImageType returns the Type of the image, guessing it if need be.
+func (i *ImageInfo) ImageType() string {
+	if i.Type != "" {
+		return i.Type
+	}
+	return ImageType(i.File)
+}
+
+// ImageDeploy is what a task runner needs to write a disk image to
+// a disk.  It is the Content of JobActions that have the
+// ImageDeploy meta set to true.
+//
+// swagger:model
+type ImageDeploy struct {
+	// URL is where the image is fetched from.
+	//
+	// required: true
+	URL string
+	// Type is the type of the image, as in ImageInfo.
+	//
+	// required: true
+	Type string
+	// Sha256 is the SHA256 checksum of the image as it is fetched.
+	// An empty Sha256 skips checking it.
+	Sha256 string
+	// Disk is the block device to write the image to.
+	//
+	// required: true
+	Disk string
+	// GrowPartition is the number of the partition on Disk to grow
+	// to fill the rest of the disk after the image is written.  0
+	// leaves the partitions alone.
+	GrowPartition int
+}
+
+// Validate returns an error if the ImageDeploy cannot be done.
+func (i *ImageDeploy) Validate() error {
+	e := &Error{Model: "images", Key: i.URL, Type: "ValidationError"}
+	if i.URL == "" {
+		e.Errorf("Missing URL")
+	}
+	if _, _, err := SplitImageType(i.Type); err != nil {
+		e.AddError(err)
+	}
+	if !strings.HasPrefix(i.Disk, "/") {
+		e.Errorf("Disk %q must be the path to a block device", i.Disk)
+	}
+	if i.GrowPartition < 0 {
+		e.Errorf("Invalid partition %d to grow", i.GrowPartition)
+	}
+	return e.HasError()
+}
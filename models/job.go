@@ -11,6 +11,8 @@ import (
 // Job Action is something that job runner will need to do.
 // If path is specified, then the runner will place the contents into that location.
 // If path is not specified, then the runner will attempt to bash exec the contents.
+// If the ImageDeploy meta is true, then the contents are an ImageDeploy in JSON,
+// and the runner will write the disk image it describes to disk.
 // swagger:model
 type JobAction struct {
 	// required: true
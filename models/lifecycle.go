@@ -0,0 +1,87 @@
+package models
+
+import "time"
+
+// The lifecycle states a Machine can be in.
+const (
+	LifecycleDiscovered  = "discovered"
+	LifecycleReady       = "ready"
+	LifecycleAllocated   = "allocated"
+	LifecycleMaintenance = "in-maintenance"
+	LifecycleRetired     = "retired"
+)
+
+// lifecycleTransitions maps each lifecycle state to the states a
+// Machine in it may move to.
+var lifecycleTransitions = map[string][]string{
+	LifecycleDiscovered:  {LifecycleReady, LifecycleMaintenance, LifecycleRetired},
+	LifecycleReady:       {LifecycleAllocated, LifecycleMaintenance, LifecycleRetired},
+	LifecycleAllocated:   {LifecycleReady, LifecycleMaintenance},
+	LifecycleMaintenance: {LifecycleDiscovered, LifecycleReady, LifecycleRetired},
+	LifecycleRetired:     {LifecycleDiscovered},
+}
+
+// ValidLifecycle returns whether s is a lifecycle state.
+func ValidLifecycle(s string) bool {
+	_, ok := lifecycleTransitions[s]
+	return ok
+}
+
+// LifecycleTransition returns whether a Machine may move from the
+// lifecycle state from to the state to.
+func LifecycleTransition(from, to string) bool {
+	if from == to {
+		return true
+	}
+	for _, s := range lifecycleTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// LifecycleState returns the lifecycle state of the Machine.
+// Machines without one are discovered.
+func (n *Machine) LifecycleState() string {
+	if n.Lifecycle == "" {
+		return LifecycleDiscovered
+	}
+	return n.Lifecycle
+}
+
+// MachineLock keeps anyone but its Owner from changing the Workflow,
+// Stage, or BootEnv of a Machine, or the lock itself, until it
+// expires.
+// swagger:model
+type MachineLock struct {
+	// Owner is who holds the lock.  dr-provision sets it to whoever
+	// sets the lock.
+	Owner string
+	// Reason is why the Machine is locked.
+	Reason string
+	// Expires is when the lock stops applying.  A lock without one
+	// lasts until it is removed.
+	//
+	// swagger:strfmt date-time
+	Expires time.Time
+}
+
+// Active returns whether the lock applies at now.
+func (l *MachineLock) Active(now time.Time) bool {
+	return l != nil && (l.Expires.IsZero() || now.Before(l.Expires))
+}
+
+// Equal returns whether l and o are the same lock.
+func (l *MachineLock) Equal(o *MachineLock) bool {
+	if l == nil || o == nil {
+		return l == o
+	}
+	return l.Owner == o.Owner && l.Reason == o.Reason && l.Expires.Equal(o.Expires)
+}
+
+// LockedAgainst returns whether the Machine has a lock at now that
+// is held by someone other than principal.
+func (n *Machine) LockedAgainst(principal string, now time.Time) bool {
+	return n.Lock.Active(now) && n.Lock.Owner != principal
+}
@@ -4,6 +4,7 @@ import (
 	"net"
 	"reflect"
 	"strings"
+	"time"
 
 	"github.com/pborman/uuid"
 )
@@ -83,6 +84,50 @@ type Machine struct {
 	//
 	// required: true
 	Workflow string
+	// Arch is the architecture of the machine, as reported in its
+	// DHCP requests.  The machine/arch param overrides it when
+	// picking what a multi-architecture BootEnv boots.
+	Arch string `json:",omitempty"`
+	// ProvisioningError is set when dr-provision marks the machine
+	// not Runnable because it keeps booting into the same BootEnv or
+	// has stopped making progress in it.  It is cleared when the
+	// machine changes BootEnv or is made Runnable again.
+	ProvisioningError string `json:",omitempty"`
+	// LastSeen is the last time dr-provision heard from the machine
+	// on any channel.  It is maintained by dr-provision, and is not
+	// set until the machine has been heard from.
+	//
+	// swagger:strfmt date-time
+	LastSeen *time.Time `json:",omitempty"`
+	// LastSeenVia is the channel dr-provision last heard from the
+	// machine on.
+	LastSeenVia string `json:",omitempty"`
+	// Seen records when dr-provision last heard from the machine on
+	// each channel it listens on: dhcp, tftp, http, api, heartbeat,
+	// and ping.
+	Seen map[string]MachineSeen `json:",omitempty"`
+	// Lifecycle is where the machine is in its life: discovered,
+	// ready, allocated, in-maintenance, or retired.  Machines
+	// without one are discovered.
+	Lifecycle string `json:",omitempty"`
+	// Lock, while it is set and has not expired, keeps anyone but
+	// its Owner from changing the machine's Workflow, Stage, BootEnv,
+	// or Lock.
+	Lock *MachineLock `json:",omitempty"`
+	// Allocation is who the machine is allocated to, if it was
+	// allocated from a Pool.
+	Allocation *MachineAllocation `json:",omitempty"`
+}
+
+// MachineSeen records when dr-provision last heard from a machine on
+// one channel, and the address the machine used.
+// swagger:model
+type MachineSeen struct {
+	// required: true
+	// swagger:strfmt date-time
+	Time time.Time
+	// swagger:strfmt ipv4
+	Address net.IP `json:",omitempty"`
 }
 
 func (n *Machine) GetMeta() Meta {
@@ -127,6 +172,14 @@ func (n *Machine) Validate() {
 			n.Errorf("Invalid Hardware Address `%s`: %v", m, err)
 		}
 	}
+	if n.Arch != "" {
+		if _, ok := CanonicalArch(n.Arch); !ok {
+			n.Errorf("Invalid Arch %s", n.Arch)
+		}
+	}
+	if n.Lifecycle != "" && !ValidLifecycle(n.Lifecycle) {
+		n.Errorf("Invalid Lifecycle %s", n.Lifecycle)
+	}
 }
 
 func (n *Machine) UUID() string {
@@ -1,6 +1,11 @@
 package models
 
-import "github.com/xeipuuv/gojsonschema"
+import (
+	"fmt"
+	"text/template"
+
+	"github.com/xeipuuv/gojsonschema"
+)
 
 // Param represents metadata about a Parameter or a Preference.
 // Specifically, it contains a description of what the information
@@ -29,6 +34,13 @@ type Param struct {
 	//
 	// required: true
 	Schema interface{}
+	// Computed implies that the values of this Param (including its
+	// default value) are Go templates.  When the aggregated params of
+	// a Machine are read, they are rendered for that Machine and the
+	// output is used as the value.  Unless the Schema says the Param
+	// is a string, the output is parsed as JSON.  The result must match
+	// the Schema.  Computed Params cannot be Secure.
+	Computed bool `json:",omitempty"`
 }
 
 func (p *Param) GetMeta() Meta {
@@ -61,6 +73,14 @@ func (p *Param) TypeValue() (interface{}, bool) {
 
 func (p *Param) Validate() {
 	p.AddError(ValidParamName("Invalid Name", p.Name))
+	if p.Computed {
+		if p.Secure {
+			p.Errorf("Computed params cannot be Secure")
+		}
+		if dv, ok := p.DefaultValue(); ok {
+			p.AddError(ParseComputedValue(p.Name, dv))
+		}
+	}
 	if p.Schema != nil {
 		validator, err := gojsonschema.NewSchema(gojsonschema.NewGoLoader(p.Schema))
 		if err != nil {
@@ -68,7 +88,7 @@ func (p *Param) Validate() {
 			return
 		}
 		dv, ok := p.DefaultValue()
-		if !ok {
+		if !ok || p.Computed {
 			return
 		}
 		res, err := validator.Validate(gojsonschema.NewGoLoader(dv))
@@ -82,6 +102,19 @@ func (p *Param) Validate() {
 	}
 }
 
+// ParseComputedValue returns an error unless val is a string holding
+// a valid template, as the values of Computed params must be.
+func ParseComputedValue(name string, val interface{}) error {
+	tmpl, ok := val.(string)
+	if !ok {
+		return fmt.Errorf("Computed param %s must be a template, not %T", name, val)
+	}
+	if _, err := template.New(name).Funcs(DrpSafeFuncMap()).Parse(tmpl); err != nil {
+		return fmt.Errorf("Computed param %s: %v", name, err)
+	}
+	return nil
+}
+
 func (p *Param) SetName(s string) {
 	p.Name = s
 }
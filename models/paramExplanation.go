@@ -0,0 +1,46 @@
+package models
+
+// ParamSource is one of the places an aggregated param was found.
+//
+// swagger:model
+type ParamSource struct {
+	// Prefix of the object that defined the param.  It is params
+	// for the default value of the param.
+	Prefix string
+	// Key of the object that defined the param.
+	Key string
+	// Via is how the object was reached.  It is one of object (the
+	// object the param was looked up on), profile (one of its
+	// Profiles), stage:<name> (a Profile of its Stage),
+	// include:<name> (a Profile included by the Profile name), global
+	// (the global Profile), or default (the default value of the
+	// param).
+	Via string
+	// Layer is the data layer the object came from, such as
+	// writable or content-<name>.
+	Layer string
+	// Value is the value defined by the object.
+	Value interface{}
+	// Used is true for the source whose value was used.
+	Used bool
+}
+
+// ParamExplanation is an aggregated param value along with every
+// place it was defined, in order of precedence.
+//
+// swagger:model
+type ParamExplanation struct {
+	// Name of the param.
+	Name string
+	// Value is the aggregated value of the param.
+	Value interface{}
+	// Found is false if the param was not defined anywhere and has
+	// no default value.
+	Found bool
+	// Error is why the value of a computed param could not be
+	// computed, if it could not.
+	Error string `json:",omitempty"`
+	// Sources are the places the param was defined, in order of
+	// precedence.
+	Sources []*ParamSource
+}
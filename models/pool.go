@@ -0,0 +1,296 @@
+package models
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// PoolSelector is a rule a Machine must meet to be allocated from a
+// Pool.
+// swagger:model
+type PoolSelector struct {
+	// Param is the name of the param the rule checks.  The Machine's
+	// aggregated value of the param is used, so it can come from the
+	// Machine, its Profiles, or the param's default.
+	//
+	// required: true
+	Param string
+	// Path picks a value out of a param whose value is an object,
+	// such as an inventory param.  It is a list of keys separated by
+	// periods.  An empty Path checks the whole value.
+	Path string `json:",omitempty"`
+	// Op is how the value is compared to Value: exists, eq, ne, lt,
+	// lte, gt, gte, or in.  Values that are both numbers are compared
+	// as numbers and all others as strings.  in matches if the value
+	// equals any member of Value, which must be a list.
+	//
+	// required: true
+	Op string
+	// Value is what the param's value is compared to.  It is not
+	// used by exists.
+	Value interface{} `json:",omitempty"`
+}
+
+var poolSelectorOps = map[string]struct{}{
+	"exists": {}, "eq": {}, "ne": {}, "lt": {}, "lte": {}, "gt": {}, "gte": {}, "in": {},
+}
+
+// Validate returns an error if the PoolSelector is malformed.
+func (s *PoolSelector) Validate() error {
+	if err := ValidParamName("Invalid Param", s.Param); err != nil {
+		return err
+	}
+	if _, ok := poolSelectorOps[s.Op]; !ok {
+		return fmt.Errorf("Invalid Op %s for param %s", s.Op, s.Param)
+	}
+	if s.Op == "in" {
+		if _, ok := s.Value.([]interface{}); !ok {
+			return fmt.Errorf("Op in for param %s needs a list Value", s.Param)
+		}
+	}
+	return nil
+}
+
+// Match returns whether val, the value of the PoolSelector's Param,
+// meets the rule.  found is whether the param has a value at all.
+func (s *PoolSelector) Match(val interface{}, found bool) bool {
+	if found && s.Path != "" {
+		for _, key := range strings.Split(s.Path, ".") {
+			obj, ok := val.(map[string]interface{})
+			if !ok {
+				found = false
+				break
+			}
+			if val, found = obj[key]; !found {
+				break
+			}
+		}
+	}
+	if !found {
+		return s.Op == "ne"
+	}
+	switch s.Op {
+	case "exists":
+		return true
+	case "in":
+		for _, v := range s.Value.([]interface{}) {
+			if compareSelectorValues(val, v) == 0 {
+				return true
+			}
+		}
+		return false
+	}
+	cmp := compareSelectorValues(val, s.Value)
+	switch s.Op {
+	case "eq":
+		return cmp == 0
+	case "ne":
+		return cmp != 0
+	case "lt":
+		return cmp < 0
+	case "lte":
+		return cmp <= 0
+	case "gt":
+		return cmp > 0
+	case "gte":
+		return cmp >= 0
+	}
+	return false
+}
+
+func selectorNumber(v interface{}) (float64, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	}
+	return 0, false
+}
+
+func compareSelectorValues(a, b interface{}) int {
+	if an, ok := selectorNumber(a); ok {
+		if bn, ok := selectorNumber(b); ok {
+			switch {
+			case an < bn:
+				return -1
+			case an > bn:
+				return 1
+			}
+			return 0
+		}
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+// Pool is a set of Machines picked by Selectors that can be
+// allocated to users and tenants, and released again when they are
+// done with them.
+//
+// swagger:model
+type Pool struct {
+	Validation
+	Access
+	Meta
+	// Name is the name of the Pool.
+	//
+	// required: true
+	Name        string
+	Description string
+	// Documentation of this pool.  This should tell what the pool is
+	// for, any special considerations that should be taken into
+	// account when using it, etc. in rich structured text (rst).
+	Documentation string
+	// Selectors are the rules a Machine must meet to be allocated
+	// from the Pool.  A Pool without Selectors can allocate any ready
+	// Machine.
+	Selectors []PoolSelector
+	// AllocateWorkflow is the Workflow Machines are put in when they
+	// are allocated.  Machines keep the Workflow they have if it is
+	// empty.
+	AllocateWorkflow string
+	// ReleaseWorkflow is the Workflow Machines are put in when they
+	// are released, to clean them up for the next user.  Machines keep
+	// the Workflow they have if it is empty.
+	ReleaseWorkflow string
+	// LeaseDuration is how many seconds allocations last if the
+	// request does not say.  Allocations last until they are released
+	// if it is 0.
+	LeaseDuration int
+}
+
+func (p *Pool) GetMeta() Meta {
+	return p.Meta
+}
+
+func (p *Pool) SetMeta(d Meta) {
+	p.Meta = d
+}
+
+func (p *Pool) GetDocumentation() string {
+	return p.Documentation
+}
+
+func (p *Pool) Prefix() string {
+	return "pools"
+}
+
+func (p *Pool) Key() string {
+	return p.Name
+}
+
+func (p *Pool) KeyName() string {
+	return "Name"
+}
+
+func (p *Pool) Fill() {
+	p.Validation.fill()
+	if p.Meta == nil {
+		p.Meta = Meta{}
+	}
+	if p.Selectors == nil {
+		p.Selectors = []PoolSelector{}
+	}
+}
+
+func (p *Pool) AuthKey() string {
+	return p.Key()
+}
+
+func (p *Pool) SliceOf() interface{} {
+	ps := []*Pool{}
+	return &ps
+}
+
+func (p *Pool) ToModels(obj interface{}) []Model {
+	items := obj.(*[]*Pool)
+	res := make([]Model, len(*items))
+	for i, item := range *items {
+		res[i] = Model(item)
+	}
+	return res
+}
+
+func (p *Pool) Validate() {
+	p.AddError(ValidName("Invalid Name", p.Name))
+	for i := range p.Selectors {
+		p.AddError(p.Selectors[i].Validate())
+	}
+	if p.AllocateWorkflow != "" {
+		p.AddError(ValidName("Invalid AllocateWorkflow", p.AllocateWorkflow))
+	}
+	if p.ReleaseWorkflow != "" {
+		p.AddError(ValidName("Invalid ReleaseWorkflow", p.ReleaseWorkflow))
+	}
+	if p.LeaseDuration < 0 {
+		p.Errorf("LeaseDuration cannot be negative")
+	}
+}
+
+func (p *Pool) CanHaveActions() bool {
+	return true
+}
+
+// MachineAllocation records who a Machine is allocated to.
+// swagger:model
+type MachineAllocation struct {
+	// Pool is the Pool the Machine was allocated from.
+	Pool string
+	// Owner is who the Machine is allocated to, either user:<name>
+	// or tenant:<name>.
+	Owner string
+	// swagger:strfmt date-time
+	Allocated time.Time
+	// Expires is when the allocation is released on its own.
+	// Allocations without one last until they are released.
+	//
+	// swagger:strfmt date-time
+	Expires time.Time
+}
+
+// Expired returns whether the allocation has expired at now.
+func (a *MachineAllocation) Expired(now time.Time) bool {
+	return a != nil && !a.Expires.IsZero() && !now.Before(a.Expires)
+}
+
+// PoolAllocateRequest asks a Pool for Machines.
+// swagger:model
+type PoolAllocateRequest struct {
+	// Count is how many Machines to allocate.  Either all of them are
+	// allocated or none are.
+	//
+	// required: true
+	Count int
+	// Owner is who the Machines are for, either user:<name> or
+	// tenant:<name>.  It defaults to the user making the request.
+	Owner string
+	// Duration is how many seconds the allocation lasts.  It defaults
+	// to the Pool's LeaseDuration.
+	Duration int
+	// Selectors are rules the Machines must meet on top of the Pool's.
+	Selectors []PoolSelector
+}
+
+// PoolReleaseRequest gives Machines back to a Pool.
+// swagger:model
+type PoolReleaseRequest struct {
+	// Machines are the UUIDs of the Machines to release.
+	//
+	// required: true
+	Machines []string
+}
+
+// PoolReleaseError is returned when only some of the Machines in a
+// PoolReleaseRequest could be released.  It is an Error with the
+// Machines that were released added.
+// swagger:model
+type PoolReleaseError struct {
+	*Error
+	// Released are the Machines that were released.
+	Released []*Machine
+}
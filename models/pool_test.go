@@ -0,0 +1,29 @@
+package models
+
+import "testing"
+
+func TestPoolSelectorMatch(t *testing.T) {
+	inventory := map[string]interface{}{"Memory": map[string]interface{}{"GB": float64(256)}, "Vendor": "Dell"}
+	for _, test := range []struct {
+		sel   PoolSelector
+		val   interface{}
+		found bool
+		want  bool
+	}{
+		{PoolSelector{Op: "exists"}, nil, true, true},
+		{PoolSelector{Op: "exists"}, nil, false, false},
+		{PoolSelector{Op: "ne", Value: "x"}, nil, false, true},
+		{PoolSelector{Op: "gte", Value: 256}, float64(256), true, true},
+		{PoolSelector{Op: "gt", Value: 256}, float64(256), true, false},
+		{PoolSelector{Op: "lt", Value: "b"}, "a", true, true},
+		{PoolSelector{Op: "eq", Path: "Vendor", Value: "Dell"}, inventory, true, true},
+		{PoolSelector{Op: "gte", Path: "Memory.GB", Value: 128}, inventory, true, true},
+		{PoolSelector{Op: "gte", Path: "Memory.Missing", Value: 128}, inventory, true, false},
+		{PoolSelector{Op: "in", Value: []interface{}{"HP", "Dell"}}, "Dell", true, true},
+		{PoolSelector{Op: "in", Value: []interface{}{"HP"}}, "Dell", true, false},
+	} {
+		if got := test.sel.Match(test.val, test.found); got != test.want {
+			t.Errorf("%s %s %v against %v: expected %v, got %v", test.sel.Path, test.sel.Op, test.sel.Value, test.val, test.want, got)
+		}
+	}
+}
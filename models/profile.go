@@ -28,6 +28,12 @@ type Profile struct {
 	// for BootEnv, as documented by that boot environment's
 	// RequiredParams and OptionalParams.
 	Params map[string]interface{}
+	// Profiles are other profiles whose params this profile includes.
+	// Params set on this profile take precedence over included ones,
+	// and earlier profiles in the list (along with the profiles they
+	// include) take precedence over later ones.  A profile cannot end
+	// up including itself.
+	Profiles []string `json:",omitempty"`
 }
 
 func (p *Profile) GetMeta() Meta {
@@ -43,6 +49,9 @@ func (p *Profile) Validate() {
 	for k := range p.Params {
 		p.AddError(ValidParamName("Invalid Param Name", k))
 	}
+	for _, name := range p.Profiles {
+		p.AddError(ValidName("Invalid Profile", name))
+	}
 }
 
 func (p *Profile) Prefix() string {
@@ -94,6 +103,15 @@ func (p *Profile) SetParams(pl map[string]interface{}) {
 	p.Params = copyMap(pl)
 }
 
+// match Profiler interface
+func (p *Profile) GetProfiles() []string {
+	return p.Profiles
+}
+
+func (p *Profile) SetProfiles(pl []string) {
+	p.Profiles = pl
+}
+
 func (p *Profile) SetName(n string) {
 	p.Name = n
 }
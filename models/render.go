@@ -0,0 +1,47 @@
+package models
+
+// RenderedTemplate is a single template rendered for a Machine by a
+// render preview.
+//
+// swagger:model
+type RenderedTemplate struct {
+	// Source is the object the template came from, as prefix:key.
+	// For example bootenvs:discovery or tasks:ubuntu-install.
+	Source string
+	// Name is the ID of the template.
+	Name string
+	// TemplateInfo is the Name of the TemplateInfo the template came
+	// from.
+	TemplateInfo string
+	// Path is where the template would be served from or written to.
+	// It is empty for task templates that would be executed.
+	Path string
+	// Meta is the metadata of the template.
+	Meta map[string]string
+	// Content is the rendered template.  It is empty if rendering
+	// failed.
+	Content string
+	// Error is why rendering the template failed, if it did.
+	Error string
+}
+
+// RenderPreview is the result of rendering the templates of a
+// BootEnv, Stage and Task for a Machine without changing anything.
+//
+// swagger:model
+type RenderPreview struct {
+	// Machine is the UUID of the Machine the templates were rendered for.
+	Machine string
+	// BootEnv is the BootEnv whose templates were rendered, if any.
+	BootEnv string
+	// Stage is the Stage whose templates were rendered, if any.
+	Stage string
+	// Task is the Task whose templates were rendered, if any.
+	Task string
+	// Templates are the rendered templates.
+	Templates []*RenderedTemplate
+	// Errors are the problems that kept templates from being
+	// rendered at all, such as missing required parameters or
+	// paths that failed to render.
+	Errors []string
+}
@@ -29,13 +29,15 @@ var (
 		"interfaces": "list, get",
 		"info":       "get",
 		"isos":       "list, get, post, delete",
+		"system":     "actions, backup, restore",
 	}
 
 	addedActions = map[string]string{
 		"users":    "token, password",
 		"jobs":     "log",
-		"machines": "getSecure, updateSecure",
+		"machines": "getSecure, updateSecure, render, heartbeat, forceUnlock",
 		"plugins":  "getSecure, updateSecure",
+		"pools":    "allocate, release",
 		"profiles": "getSecure, updateSecure",
 	}
 
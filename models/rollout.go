@@ -0,0 +1,189 @@
+package models
+
+import "time"
+
+// The states a Rollout can be in.
+const (
+	RolloutRunning  = "running"
+	RolloutPaused   = "paused"
+	RolloutFinished = "finished"
+)
+
+// The states a Machine in a Rollout can be in.
+const (
+	RolloutMachineWaiting   = "waiting"
+	RolloutMachineRunning   = "running"
+	RolloutMachineSucceeded = "succeeded"
+	RolloutMachineFailed    = "failed"
+)
+
+// RolloutMachine records how a Rollout went for one Machine.
+// swagger:model
+type RolloutMachine struct {
+	// Name is the Name of the Machine.
+	Name string
+	// State is waiting, running, succeeded, or failed.
+	State string
+	// Started is when the Rollout put the Machine in its Workflow or
+	// Stage.
+	//
+	// swagger:strfmt date-time
+	Started time.Time
+	// Finished is when the Machine succeeded or failed.
+	//
+	// swagger:strfmt date-time
+	Finished time.Time
+	// Message says why the Machine failed.
+	Message string `json:",omitempty"`
+}
+
+// Rollout changes the Workflow or Stage of a set of Machines a few at
+// a time, instead of all at once.  dr-provision starts the next
+// Machine as soon as one finishes, and pauses the Rollout when too
+// many fail.
+//
+// swagger:model
+type Rollout struct {
+	Validation
+	Access
+	Meta
+	// Name is the name of the Rollout.
+	//
+	// required: true
+	Name        string
+	Description string
+	// Documentation of this rollout.  This should tell what the
+	// rollout is for, any special considerations that should be taken
+	// into account when using it, etc. in rich structured text (rst).
+	Documentation string
+	// Filter picks the Machines the Rollout applies to.  Its keys are
+	// Machine indexes or params and its values are filters like the
+	// ones list queries take, such as Eq(value) or Lt(value).  The
+	// Machines are picked once, when the Rollout is created.
+	Filter map[string]string
+	// Workflow is the Workflow the Machines are put in.  Exactly one
+	// of Workflow and Stage must be set.
+	Workflow string
+	// Stage is the Stage the Machines are put in.
+	Stage string
+	// MaxConcurrent is how many Machines can be running at a time.
+	//
+	// required: true
+	MaxConcurrent int
+	// MaxFailures is how many Machines can fail before the Rollout
+	// pauses.  0 pauses it on the first failure.
+	MaxFailures int
+	// MachineTimeout is how many seconds a Machine can run before it
+	// counts as failed.  0 lets Machines run for as long as they
+	// need.
+	MachineTimeout int
+	// Paused stops the Rollout from starting more Machines.
+	// dr-provision sets it when more than MaxFailures Machines fail,
+	// and clearing it resumes the Rollout and starts counting
+	// FailuresSinceResume again.
+	Paused bool
+	// State is running, paused, or finished.  It is set by
+	// dr-provision.
+	State string
+	// Failures is how many Machines have failed since the Rollout
+	// was created.  It is set by dr-provision.
+	Failures int
+	// FailuresSinceResume is how many Machines have failed since the
+	// Rollout was created or last resumed.  The Rollout pauses when
+	// it is more than MaxFailures.  It is set by dr-provision.
+	FailuresSinceResume int
+	// Machines records how the Rollout went for each Machine, keyed
+	// by UUID.  It is set by dr-provision.
+	Machines map[string]*RolloutMachine
+}
+
+func (r *Rollout) GetMeta() Meta {
+	return r.Meta
+}
+
+func (r *Rollout) SetMeta(d Meta) {
+	r.Meta = d
+}
+
+func (r *Rollout) GetDocumentation() string {
+	return r.Documentation
+}
+
+func (r *Rollout) Prefix() string {
+	return "rollouts"
+}
+
+func (r *Rollout) Key() string {
+	return r.Name
+}
+
+func (r *Rollout) KeyName() string {
+	return "Name"
+}
+
+func (r *Rollout) Fill() {
+	r.Validation.fill()
+	if r.Meta == nil {
+		r.Meta = Meta{}
+	}
+	if r.Filter == nil {
+		r.Filter = map[string]string{}
+	}
+	if r.Machines == nil {
+		r.Machines = map[string]*RolloutMachine{}
+	}
+}
+
+func (r *Rollout) AuthKey() string {
+	return r.Key()
+}
+
+func (r *Rollout) SliceOf() interface{} {
+	rs := []*Rollout{}
+	return &rs
+}
+
+func (r *Rollout) ToModels(obj interface{}) []Model {
+	items := obj.(*[]*Rollout)
+	res := make([]Model, len(*items))
+	for i, item := range *items {
+		res[i] = Model(item)
+	}
+	return res
+}
+
+func (r *Rollout) Validate() {
+	r.AddError(ValidName("Invalid Name", r.Name))
+	switch {
+	case r.Workflow == "" && r.Stage == "":
+		r.Errorf("Rollout needs a Workflow or a Stage")
+	case r.Workflow != "" && r.Stage != "":
+		r.Errorf("Rollout cannot have both a Workflow and a Stage")
+	case r.Workflow != "":
+		r.AddError(ValidName("Invalid Workflow", r.Workflow))
+	default:
+		r.AddError(ValidName("Invalid Stage", r.Stage))
+	}
+	if r.MaxConcurrent < 1 {
+		r.Errorf("MaxConcurrent must be at least 1")
+	}
+	if r.MaxFailures < 0 {
+		r.Errorf("MaxFailures cannot be negative")
+	}
+	if r.MachineTimeout < 0 {
+		r.Errorf("MachineTimeout cannot be negative")
+	}
+}
+
+func (r *Rollout) CanHaveActions() bool {
+	return true
+}
+
+// Counts returns how many Machines in the Rollout are in each state.
+func (r *Rollout) Counts() map[string]int {
+	res := map[string]int{}
+	for _, m := range r.Machines {
+		res[m.State]++
+	}
+	return res
+}
@@ -0,0 +1,272 @@
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SemVer is a semantic version, as described at https://semver.org
+type SemVer struct {
+	Major uint64
+	Minor uint64
+	Patch uint64
+	// Pre are the dot separated identifiers of the pre-release
+	// version, if any.
+	Pre []string
+	// Build is the build metadata, if any.  It is ignored when
+	// comparing versions.
+	Build string
+}
+
+// ParseSemVer parses a semantic version.  Since content bundles have
+// traditionally been versioned like v1.2.3, a leading v is allowed.
+// A missing minor or patch version is taken to be 0.
+func ParseSemVer(s string) (*SemVer, error) {
+	orig := s
+	s = strings.TrimPrefix(strings.TrimSpace(s), "v")
+	res := &SemVer{}
+	if i := strings.Index(s, "+"); i != -1 {
+		res.Build = s[i+1:]
+		s = s[:i]
+	}
+	if i := strings.Index(s, "-"); i != -1 {
+		res.Pre = strings.Split(s[i+1:], ".")
+		s = s[:i]
+		for _, id := range res.Pre {
+			if id == "" {
+				return nil, fmt.Errorf("Invalid version %s: empty pre-release identifier", orig)
+			}
+		}
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 {
+		return nil, fmt.Errorf("Invalid version %s: too many parts", orig)
+	}
+	nums := []*uint64{&res.Major, &res.Minor, &res.Patch}
+	for i, part := range parts {
+		n, err := strconv.ParseUint(part, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid version %s", orig)
+		}
+		*nums[i] = n
+	}
+	return res, nil
+}
+
+func (v *SemVer) String() string {
+	res := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if len(v.Pre) > 0 {
+		res += "-" + strings.Join(v.Pre, ".")
+	}
+	if v.Build != "" {
+		res += "+" + v.Build
+	}
+	return res
+}
+
+func cmpUint(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// leadingRun splits s after its leading run of digits or of
+// non-digits.
+func leadingRun(s string) (run, rest string) {
+	isDigit := func(c byte) bool { return c >= '0' && c <= '9' }
+	digits := isDigit(s[0])
+	i := 1
+	for i < len(s) && isDigit(s[i]) == digits {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+// cmpIdentifier compares two alphanumeric pre-release identifiers.
+// Runs of digits in them are compared as numbers, so that rc10 comes
+// after rc9 the way a version written without the dot in rc.10 means
+// it to.
+func cmpIdentifier(a, b string) int {
+	for x, y := a, b; x != "" && y != ""; {
+		var xRun, yRun string
+		xRun, x = leadingRun(x)
+		yRun, y = leadingRun(y)
+		xn, xErr := strconv.ParseUint(xRun, 10, 64)
+		yn, yErr := strconv.ParseUint(yRun, 10, 64)
+		var c int
+		if xErr == nil && yErr == nil {
+			c = cmpUint(xn, yn)
+		} else {
+			c = strings.Compare(xRun, yRun)
+		}
+		if c != 0 {
+			return c
+		}
+		if x == "" || y == "" {
+			if c = cmpUint(uint64(len(x)), uint64(len(y))); c != 0 {
+				return c
+			}
+		}
+	}
+	return strings.Compare(a, b)
+}
+
+// Compare returns -1, 0, or 1 depending on whether v is lower than,
+// the same as, or higher than o.  Pre-release versions are lower
+// than the release they are for, and are ordered by semver
+// precedence, except that numbers inside alphanumeric identifiers
+// are compared as numbers.
+func (v *SemVer) Compare(o *SemVer) int {
+	if c := cmpUint(v.Major, o.Major); c != 0 {
+		return c
+	}
+	if c := cmpUint(v.Minor, o.Minor); c != 0 {
+		return c
+	}
+	if c := cmpUint(v.Patch, o.Patch); c != 0 {
+		return c
+	}
+	switch {
+	case len(v.Pre) == 0 && len(o.Pre) == 0:
+		return 0
+	case len(v.Pre) == 0:
+		return 1
+	case len(o.Pre) == 0:
+		return -1
+	}
+	for i := 0; i < len(v.Pre) && i < len(o.Pre); i++ {
+		a, aErr := strconv.ParseUint(v.Pre[i], 10, 64)
+		b, bErr := strconv.ParseUint(o.Pre[i], 10, 64)
+		var c int
+		switch {
+		case aErr == nil && bErr == nil:
+			c = cmpUint(a, b)
+		case aErr == nil:
+			// Numeric identifiers are lower than alphanumeric ones.
+			c = -1
+		case bErr == nil:
+			c = 1
+		default:
+			c = cmpIdentifier(v.Pre[i], o.Pre[i])
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return cmpUint(uint64(len(v.Pre)), uint64(len(o.Pre)))
+}
+
+type versionConstraint struct {
+	op  string
+	ver *SemVer
+}
+
+func (c *versionConstraint) matches(v *SemVer) bool {
+	cmp := v.Compare(c.ver)
+	switch c.op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case "~":
+		return cmp >= 0 && v.Major == c.ver.Major && v.Minor == c.ver.Minor
+	case "^":
+		if cmp < 0 || v.Major != c.ver.Major {
+			return false
+		}
+		if c.ver.Major > 0 {
+			return true
+		}
+		if v.Minor != c.ver.Minor {
+			return false
+		}
+		return c.ver.Minor > 0 || v.Patch == c.ver.Patch
+	}
+	return false
+}
+
+// VersionRange is a set of versions, written as space separated
+// comparisons that must all hold, such as ">=1.2.0 <2.0.0".  Sets of
+// comparisons can be joined with ||, in which case a version only
+// has to satisfy one of them.
+//
+// The comparison operators are =, !=, >, >=, <, and <=, along with ~,
+// which allows patch level changes (~1.2.3 is >=1.2.3 <1.3.0), and ^,
+// which allows changes that do not modify the left-most non-zero
+// number (^1.2.3 is >=1.2.3 <2.0.0, ^0.2.3 is >=0.2.3 <0.3.0).  A
+// version without an operator must match exactly.
+type VersionRange struct {
+	src    string
+	groups [][]*versionConstraint
+}
+
+var versionOps = []string{">=", "<=", "!=", ">", "<", "=", "~", "^"}
+
+// ParseVersionRange parses a VersionRange.
+func ParseVersionRange(s string) (*VersionRange, error) {
+	res := &VersionRange{src: strings.TrimSpace(s)}
+	for _, group := range strings.Split(s, "||") {
+		constraints := []*versionConstraint{}
+		fields := strings.Fields(group)
+		for i := 0; i < len(fields); i++ {
+			field := fields[i]
+			op := "="
+			for _, o := range versionOps {
+				if strings.HasPrefix(field, o) {
+					op = o
+					field = strings.TrimPrefix(field, o)
+					break
+				}
+			}
+			// Allow for a space between the operator and the version.
+			if field == "" && i+1 < len(fields) {
+				i++
+				field = fields[i]
+			}
+			ver, err := ParseSemVer(field)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid version range %s: %v", res.src, err)
+			}
+			constraints = append(constraints, &versionConstraint{op: op, ver: ver})
+		}
+		if len(constraints) == 0 {
+			return nil, fmt.Errorf("Invalid version range %s: empty comparison", res.src)
+		}
+		res.groups = append(res.groups, constraints)
+	}
+	return res, nil
+}
+
+// Matches returns whether v is in r.
+func (r *VersionRange) Matches(v *SemVer) bool {
+	for _, group := range r.groups {
+		matched := true
+		for _, c := range group {
+			if !c.matches(v) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *VersionRange) String() string {
+	return r.src
+}
@@ -0,0 +1,132 @@
+package models
+
+import "testing"
+
+func TestSemVerCompare(t *testing.T) {
+	ordered := []string{
+		"0.9.9",
+		"1.0.0-alpha",
+		"1.0.0-alpha.1",
+		"1.0.0-alpha.beta",
+		"1.0.0-beta.2",
+		"1.0.0-beta.11",
+		"1.0.0-rc.1",
+		"1.0.0-rc2",
+		"1.0.0-rc10",
+		"1.0.0-rc10a",
+		"v1.0.0",
+		"1.0.1",
+		"1.2",
+		"2.0.0",
+	}
+	for i := range ordered {
+		a, err := ParseSemVer(ordered[i])
+		if err != nil {
+			t.Fatalf("Failed to parse %s: %v", ordered[i], err)
+		}
+		for j := range ordered {
+			b, _ := ParseSemVer(ordered[j])
+			want := cmpUint(uint64(i), uint64(j))
+			if got := a.Compare(b); got != want {
+				t.Errorf("Comparing %s to %s: wanted %d, got %d", ordered[i], ordered[j], want, got)
+			}
+		}
+	}
+	for _, bad := range []string{"", "1.x", "1.2.3.4", "1.0.0-", "1.0.0-a..b", "tip"} {
+		if _, err := ParseSemVer(bad); err == nil {
+			t.Errorf("Expected %q to not parse", bad)
+		}
+	}
+}
+
+func TestVersionRange(t *testing.T) {
+	tests := []struct {
+		rng     string
+		matches []string
+		misses  []string
+	}{
+		{">=1.2.0 <2.0.0", []string{"1.2.0", "v1.9.9"}, []string{"1.1.9", "2.0.0"}},
+		{">= 1.2", []string{"1.2.0", "3.0.0"}, []string{"1.1.0"}},
+		{"1.2.3", []string{"v1.2.3", "1.2.3+build"}, []string{"1.2.4"}},
+		{"!=1.2.3", []string{"1.2.4"}, []string{"1.2.3"}},
+		{"~1.2.3", []string{"1.2.3", "1.2.9"}, []string{"1.2.2", "1.3.0"}},
+		{"^1.2.3", []string{"1.2.3", "1.9.0"}, []string{"1.2.2", "2.0.0"}},
+		{"^0.2.3", []string{"0.2.3", "0.2.9"}, []string{"0.3.0"}},
+		{"^0.0.3", []string{"0.0.3"}, []string{"0.0.4"}},
+		{"<1.0.0 || >=2.0.0", []string{"0.5.0", "2.1.0"}, []string{"1.5.0"}},
+		{">=1.2.0-rc1", []string{"1.2.0-rc1", "1.2.0-rc2", "1.2.0-rc10", "1.2.0", "v1.2.0-rc1+b5"}, []string{"1.2.0-beta", "1.2.0-rc0", "1.1.9"}},
+		{">=1.2.0-rc.2", []string{"1.2.0-rc.2", "1.2.0-rc.10", "1.2.0-rc.2.1"}, []string{"1.2.0-rc.1", "1.2.0-rc"}},
+		{">=1.1.0 <1.2.0", []string{"1.1.0", "1.1.5-rc1", "1.2.0-rc1"}, []string{"1.1.0-rc1", "1.2.0"}},
+		{">1.2.0-rc9", []string{"1.2.0-rc10", "1.2.0"}, []string{"1.2.0-rc9", "1.2.0-rc1"}},
+		{"<=1.2.0-rc2", []string{"1.2.0-alpha", "1.2.0-rc2"}, []string{"1.2.0-rc3", "1.2.0"}},
+		{"1.2.0-rc1", []string{"1.2.0-rc1"}, []string{"1.2.0-rc01", "1.2.0"}},
+	}
+	for _, test := range tests {
+		r, err := ParseVersionRange(test.rng)
+		if err != nil {
+			t.Errorf("Failed to parse range %s: %v", test.rng, err)
+			continue
+		}
+		for _, s := range test.matches {
+			v, _ := ParseSemVer(s)
+			if !r.Matches(v) {
+				t.Errorf("Expected %s to match %s", s, test.rng)
+			}
+		}
+		for _, s := range test.misses {
+			v, _ := ParseSemVer(s)
+			if r.Matches(v) {
+				t.Errorf("Expected %s to not match %s", s, test.rng)
+			}
+		}
+	}
+	for _, bad := range []string{"", ">=", ">=1.0 ||", "=>1.0"} {
+		if _, err := ParseVersionRange(bad); err == nil {
+			t.Errorf("Expected range %q to not parse", bad)
+		}
+	}
+}
+
+func TestContentPrerequisites(t *testing.T) {
+	installed := map[string]string{
+		"drp-community-content": "v1.5.0",
+		"task-library":          "tip",
+	}
+	msgs := CheckContentPrerequisites("test",
+		"drp-community-content: >=1.0.0 <2.0.0, task-library",
+		installed)
+	if len(msgs) != 0 {
+		t.Errorf("Expected prerequisites to be satisfied, got %v", msgs)
+	}
+	msgs = CheckContentPrerequisites("test",
+		"drp-community-content: >=2.0.0, task-library: >=1.0.0, missing",
+		installed)
+	if len(msgs) != 3 {
+		t.Errorf("Expected 3 problems, got %v", msgs)
+	}
+	if msgs := CheckContentPrerequisites("test", "foo: >=bar", installed); len(msgs) != 1 {
+		t.Errorf("Expected invalid prerequisites to be reported, got %v", msgs)
+	}
+
+	mk := func(name, prereqs string) *Content {
+		return &Content{Meta: ContentMetaData{Name: name, Prerequisites: prereqs}}
+	}
+	ordered, err := OrderContents([]*Content{
+		mk("c", "b, a"),
+		mk("a", ""),
+		mk("b", "a: >=1.0.0, elsewhere"),
+	})
+	if err != nil {
+		t.Fatalf("Failed to order contents: %v", err)
+	}
+	names := ""
+	for _, c := range ordered {
+		names += c.Meta.Name
+	}
+	if names != "abc" {
+		t.Errorf("Expected contents to be ordered abc, got %s", names)
+	}
+	if _, err := OrderContents([]*Content{mk("a", "b"), mk("b", "a")}); err == nil {
+		t.Errorf("Expected a prerequisite cycle to be reported")
+	}
+}
@@ -0,0 +1,87 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+// SignatureHeader is the HTTP header that carries the detached
+// signature of an uploaded content bundle or plugin provider.
+const SignatureHeader = "X-DRP-SIGNATURE"
+
+// ContentDigest returns the SHA-256 digest that is signed for a
+// content bundle.  It is taken over a canonical JSON encoding of the
+// bundle, so it does not matter whether the bundle was read from YAML
+// or JSON or what order its keys were in.
+func ContentDigest(c *Content) ([]byte, error) {
+	var generic interface{}
+	if err := Remarshal(c, &generic); err != nil {
+		return nil, err
+	}
+	buf, err := json.Marshal(generic)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(buf)
+	return sum[:], nil
+}
+
+// SignDigest signs a digest, returning the base64 encoded signature.
+func SignDigest(key ed25519.PrivateKey, digest []byte) string {
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(key, digest))
+}
+
+// VerifyDigest returns nil if sig is a base64 encoded signature of
+// digest made by the private key of one of keys.
+func VerifyDigest(keys []ed25519.PublicKey, digest []byte, sig string) error {
+	buf, err := base64.StdEncoding.DecodeString(strings.TrimSpace(sig))
+	if err != nil {
+		return fmt.Errorf("Invalid signature: %v", err)
+	}
+	if len(buf) != ed25519.SignatureSize {
+		return fmt.Errorf("Invalid signature: must be %d bytes long", ed25519.SignatureSize)
+	}
+	for _, key := range keys {
+		if ed25519.Verify(key, digest, buf) {
+			return nil
+		}
+	}
+	return fmt.Errorf("Signature was not made by a trusted key")
+}
+
+// ParsePublicKeys parses a list of base64 encoded ed25519 public keys
+// separated by commas or whitespace, such as the trustedKeys
+// preference.
+func ParsePublicKeys(s string) ([]ed25519.PublicKey, error) {
+	res := []ed25519.PublicKey{}
+	for _, field := range strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+	}) {
+		buf, err := base64.StdEncoding.DecodeString(field)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid public key %s: %v", field, err)
+		}
+		if len(buf) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("Invalid public key %s: must be %d bytes long", field, ed25519.PublicKeySize)
+		}
+		res = append(res, ed25519.PublicKey(buf))
+	}
+	return res, nil
+}
+
+// ParsePrivateKey parses a base64 encoded ed25519 private key.
+func ParsePrivateKey(s string) (ed25519.PrivateKey, error) {
+	buf, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid private key: %v", err)
+	}
+	if len(buf) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("Invalid private key: must be %d bytes long", ed25519.PrivateKeySize)
+	}
+	return ed25519.PrivateKey(buf), nil
+}
@@ -0,0 +1,71 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+
+	"github.com/ghodss/yaml"
+	"golang.org/x/crypto/ed25519"
+)
+
+func TestContentSignature(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	other, _, _ := ed25519.GenerateKey(rand.Reader)
+	c := &Content{
+		Meta: ContentMetaData{Name: "signed", Version: "v1.0.0"},
+		Sections: Sections{
+			"params": Section{"p": &Param{Name: "p", Description: "A param"}},
+		},
+	}
+	digest, err := ContentDigest(c)
+	if err != nil {
+		t.Fatalf("Failed to digest content: %v", err)
+	}
+	// The digest must not depend on how the bundle was encoded.
+	buf, _ := json.Marshal(c)
+	ybuf, err := yaml.JSONToYAML(buf)
+	if err != nil {
+		t.Fatalf("Failed to convert to YAML: %v", err)
+	}
+	fromYaml := &Content{}
+	if err := yaml.Unmarshal(ybuf, fromYaml); err != nil {
+		t.Fatalf("Failed to read YAML: %v", err)
+	}
+	yDigest, err := ContentDigest(fromYaml)
+	if err != nil {
+		t.Fatalf("Failed to digest YAML content: %v", err)
+	}
+	if string(yDigest) != string(digest) {
+		t.Errorf("Digest changed when read from YAML")
+	}
+	sig := SignDigest(priv, digest)
+	keys, err := ParsePublicKeys(base64.StdEncoding.EncodeToString(other) + ", " + base64.StdEncoding.EncodeToString(pub))
+	if err != nil || len(keys) != 2 {
+		t.Fatalf("Failed to parse public keys: %v", err)
+	}
+	if err := VerifyDigest(keys, digest, sig); err != nil {
+		t.Errorf("Expected signature to verify, got %v", err)
+	}
+	if err := VerifyDigest(keys[:1], digest, sig); err == nil {
+		t.Errorf("Expected signature by an untrusted key to fail")
+	}
+	c.Meta.Version = "v1.0.1"
+	changed, _ := ContentDigest(c)
+	if err := VerifyDigest(keys, changed, sig); err == nil {
+		t.Errorf("Expected signature of changed content to fail")
+	}
+	if err := VerifyDigest(keys, digest, "not a signature"); err == nil {
+		t.Errorf("Expected garbage signature to fail")
+	}
+	if _, err := ParsePublicKeys("Zm9v"); err == nil {
+		t.Errorf("Expected short public key to fail")
+	}
+	if parsed, err := ParsePrivateKey(base64.StdEncoding.EncodeToString(priv)); err != nil || SignDigest(parsed, digest) != sig {
+		t.Errorf("Failed to round trip private key: %v", err)
+	}
+}
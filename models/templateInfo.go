@@ -19,6 +19,13 @@ func DrpSafeFuncMap() template.FuncMap {
 	delete(gfm, "ago")
 	delete(gfm, "now")
 
+	// Address math
+	gfm["cidrHost"] = CIDRHost
+	gfm["cidrNetmask"] = CIDRNetmask
+	gfm["cidrSubnet"] = CIDRSubnet
+	gfm["cidrContains"] = CIDRContains
+	gfm["ipAdd"] = IPAdd
+
 	return template.FuncMap(gfm)
 }
 
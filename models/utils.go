@@ -75,6 +75,8 @@ func All() []Model {
 		&User{},
 		&Workflow{},
 		&Tenant{},
+		&Pool{},
+		&Rollout{},
 	}
 }
 
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/digitalrebar/provision/backend"
+	"github.com/digitalrebar/store"
+)
+
+// storeRoot resolves a relative data or secrets root for the passed
+// store type.  Directory and bolt stores live under base, consul
+// stores at the top of the key space.  Bolt stores keep everything
+// in a single database file.
+func storeRoot(storeType, base, root string) string {
+	if strings.IndexRune(root, filepath.Separator) == 0 {
+		return root
+	}
+	switch storeType {
+	case "directory":
+		return filepath.Join(base, root)
+	case "bolt":
+		return filepath.Join(base, root+".db")
+	case "consul":
+		return fmt.Sprintf("/%s", root)
+	}
+	return root
+}
+
+// openStore opens storeType, which is either a full store URI or
+// the name of a store type to open at root.
+func openStore(storeType, root string) (store.Store, error) {
+	if u, err := url.Parse(storeType); err == nil && u.Scheme != "" {
+		return store.Open(storeType)
+	}
+	return store.Open(fmt.Sprintf("%s://%s", storeType, root))
+}
+
+// migrateOne copies everything in the from store into the to store.
+func migrateOne(localLogger *log.Logger, what, fromType, fromRoot, toType, toRoot string) error {
+	src, err := openStore(fromType, fromRoot)
+	if err != nil {
+		return fmt.Errorf("Unable to open source %s store: %v", what, err)
+	}
+	defer src.Close()
+	dst, err := openStore(toType, toRoot)
+	if err != nil {
+		return fmt.Errorf("Unable to open destination %s store: %v", what, err)
+	}
+	defer dst.Close()
+	localLogger.Printf("Migrating %s from %s to %s", what, src.Type(), dst.Type())
+	count, err := backend.CopyStore(dst, src)
+	if err != nil {
+		return fmt.Errorf("Failed to migrate %s after %d items: %v", what, count, err)
+	}
+	localLogger.Printf("Migrated %d %s items", count, what)
+	return nil
+}
+
+// migrate copies the writable data and secrets from the stores named
+// by MigrateFrom and MigrateSecretsFrom into the stores dr-provision
+// is configured to use.  dataRoot and secretsRoot are the roots as
+// passed on the command line, before they were resolved for the
+// destination store types.  dr-provision must not be running against
+// either store while this happens.
+func migrate(localLogger *log.Logger, cOpts *ProgOpts, dataRoot, secretsRoot string) string {
+	secretsFrom := cOpts.MigrateSecretsFrom
+	if secretsFrom == "" {
+		secretsFrom = cOpts.MigrateFrom
+	}
+	if cOpts.MigrateFrom == cOpts.BackEndType {
+		return fmt.Sprintf("Cannot migrate %s onto itself", cOpts.MigrateFrom)
+	}
+	if err := migrateOne(localLogger, "data",
+		cOpts.MigrateFrom, storeRoot(cOpts.MigrateFrom, cOpts.BaseRoot, dataRoot),
+		cOpts.BackEndType, cOpts.DataRoot); err != nil {
+		return err.Error()
+	}
+	if secretsFrom != cOpts.SecretsType {
+		if err := migrateOne(localLogger, "secrets",
+			secretsFrom, storeRoot(secretsFrom, cOpts.BaseRoot, secretsRoot),
+			cOpts.SecretsType, cOpts.SecretsRoot); err != nil {
+			return err.Error()
+		}
+	}
+	return "Migration complete, restart dr-provision without --migrate-from"
+}
@@ -32,7 +32,6 @@ import (
 	"log"
 	"net"
 	"net/http"
-	"net/url"
 	"os"
 	"os/signal"
 	"path"
@@ -43,12 +42,12 @@ import (
 	"time"
 
 	"github.com/digitalrebar/logger"
+	"github.com/digitalrebar/pinger"
 	"github.com/digitalrebar/provision"
 	"github.com/digitalrebar/provision/backend"
 	"github.com/digitalrebar/provision/frontend"
 	"github.com/digitalrebar/provision/midlayer"
 	"github.com/digitalrebar/provision/utils"
-	"github.com/digitalrebar/store"
 )
 
 // EmbeddedAssetsExtractFunc is a function pointer that can set at initialization
@@ -74,8 +73,8 @@ type ProgOpts struct {
 	OurAddress          string `long:"static-ip" description:"IP address to advertise for the static HTTP file server" default:""`
 	ForceStatic         bool   `long:"force-static" description:"Force the system to always use the static IP."`
 
-	BackEndType    string `long:"backend" description:"Storage to use for persistent data. Can be either 'consul', 'directory', or a store URI" default:"directory"`
-	SecretsType    string `long:"secrets" description:"Storage to use for persistent data. Can be either 'consul', 'directory', or a store URI.  Will default to being the same as 'backend'" default:""`
+	BackEndType    string `long:"backend" description:"Storage to use for persistent data. Can be either 'consul', 'directory', 'bolt', or a store URI" default:"directory"`
+	SecretsType    string `long:"secrets" description:"Storage to use for persistent data. Can be either 'consul', 'directory', 'bolt', or a store URI.  Will default to being the same as 'backend'" default:""`
 	LocalContent   string `long:"local-content" description:"Storage to use for local overrides." default:"directory:///etc/dr-provision?codec=yaml"`
 	DefaultContent string `long:"default-content" description:"Store URL for local content" default:"file:///usr/share/dr-provision/default.yaml?codec=yaml"`
 
@@ -88,6 +87,7 @@ type ProgOpts struct {
 	SaasContentRoot string `long:"saas-content-root" description:"Directory for additional content" default:"saas-content"`
 	FileRoot        string `long:"file-root" description:"Root of filesystem we should manage" default:"tftpboot"`
 	ReplaceRoot     string `long:"replace-root" description:"Root of filesystem we should use to replace embedded assets" default:"replace"`
+	RepoCacheRoot   string `long:"repo-cache-root" description:"Directory for the package repository cache" default:"repo-cache"`
 
 	LocalUI        string `long:"local-ui" description:"Root of Local UI Pages" default:"ux"`
 	UIUrl          string `long:"ui-url" description:"URL to redirect to UI" default:"https://portal.rackn.io"`
@@ -119,6 +119,15 @@ type ProgOpts struct {
 	PromGwUrl      string `long:"prometheus-gateway-url" description:"URL to push metrics to" default:""`
 	PromInterval   int    `long:"prometheus-interval" description:"Duration in seconds to push metrics" default:"5"`
 	CleanupCorrupt bool   `long:"cleanup" description:"Clean up corrupted writable data.  Only use when directed."`
+
+	JobJanitorInterval      int `long:"job-janitor-interval" description:"How often in seconds to prune old jobs and compress job logs.  0 disables the job janitor." default:"300"`
+	StallCheckInterval      int `long:"stall-check-interval" description:"How often in seconds to look for machines whose provisioning has stalled.  0 disables the check." default:"60"`
+	MachinePingInterval     int `long:"machine-ping-interval" description:"How often in seconds to ping machines that have not been heard from since the last time.  0 disables pinging." default:"0"`
+	AllocationCheckInterval int `long:"allocation-check-interval" description:"How often in seconds to release machines whose pool allocation has expired.  0 disables the check." default:"60"`
+	RolloutCheckInterval    int `long:"rollout-check-interval" description:"How often in seconds to advance rollouts, on top of whenever a machine or job changes.  0 disables rollouts." default:"60"`
+
+	MigrateFrom        string `long:"migrate-from" description:"Copy all persistent data from this backend into the one given by 'backend' and exit.  Can be either 'consul', 'directory', 'bolt', or a store URI.  dr-provision must not be running." default:""`
+	MigrateSecretsFrom string `long:"migrate-secrets-from" description:"Copy all secrets from this backend into the one given by 'secrets' as part of 'migrate-from'.  Will default to being the same as 'migrate-from'" default:""`
 }
 
 func mkdir(d string) error {
@@ -146,6 +155,8 @@ func server(localLogger *log.Logger, cOpts *ProgOpts) string {
 		return fmt.Sprintf("Error creating required directory %s: %v", cOpts.BaseRoot, err)
 	}
 
+	dataRoot, secretsRoot := cOpts.DataRoot, cOpts.SecretsRoot
+
 	// Make other dirs as needed - adjust the dirs as well.
 	if strings.IndexRune(cOpts.FileRoot, filepath.Separator) != 0 {
 		cOpts.FileRoot = filepath.Join(cOpts.BaseRoot, cOpts.FileRoot)
@@ -153,12 +164,7 @@ func server(localLogger *log.Logger, cOpts *ProgOpts) string {
 	if cOpts.SecretsType == "" {
 		cOpts.SecretsType = cOpts.BackEndType
 	}
-	if cOpts.SecretsType == "directory" && strings.IndexRune(cOpts.SecretsRoot, filepath.Separator) != 0 {
-		cOpts.SecretsRoot = filepath.Join(cOpts.BaseRoot, cOpts.SecretsRoot)
-	}
-	if cOpts.SecretsType == "consul" && strings.IndexRune(cOpts.SecretsRoot, filepath.Separator) != 0 {
-		cOpts.SecretsRoot = fmt.Sprintf("/%s", cOpts.SecretsRoot)
-	}
+	cOpts.SecretsRoot = storeRoot(cOpts.SecretsType, cOpts.BaseRoot, cOpts.SecretsRoot)
 	if strings.IndexRune(cOpts.PluginRoot, filepath.Separator) != 0 {
 		cOpts.PluginRoot = filepath.Join(cOpts.BaseRoot, cOpts.PluginRoot)
 	}
@@ -168,12 +174,7 @@ func server(localLogger *log.Logger, cOpts *ProgOpts) string {
 	if len(cOpts.PluginCommRoot) > 70 {
 		return fmt.Sprintf("PluginCommRoot Must be less than 70 characters")
 	}
-	if cOpts.BackEndType == "directory" && strings.IndexRune(cOpts.DataRoot, filepath.Separator) != 0 {
-		cOpts.DataRoot = filepath.Join(cOpts.BaseRoot, cOpts.DataRoot)
-	}
-	if cOpts.BackEndType == "consul" && strings.IndexRune(cOpts.DataRoot, filepath.Separator) != 0 {
-		cOpts.DataRoot = fmt.Sprintf("/%s", cOpts.DataRoot)
-	}
+	cOpts.DataRoot = storeRoot(cOpts.BackEndType, cOpts.BaseRoot, cOpts.DataRoot)
 	if strings.IndexRune(cOpts.LogRoot, filepath.Separator) != 0 {
 		cOpts.LogRoot = filepath.Join(cOpts.BaseRoot, cOpts.LogRoot)
 	}
@@ -183,6 +184,9 @@ func server(localLogger *log.Logger, cOpts *ProgOpts) string {
 	if strings.IndexRune(cOpts.ReplaceRoot, filepath.Separator) != 0 {
 		cOpts.ReplaceRoot = filepath.Join(cOpts.BaseRoot, cOpts.ReplaceRoot)
 	}
+	if strings.IndexRune(cOpts.RepoCacheRoot, filepath.Separator) != 0 {
+		cOpts.RepoCacheRoot = filepath.Join(cOpts.BaseRoot, cOpts.RepoCacheRoot)
+	}
 	if strings.IndexRune(cOpts.LocalUI, filepath.Separator) != 0 {
 		cOpts.LocalUI = filepath.Join(cOpts.BaseRoot, cOpts.LocalUI)
 	}
@@ -220,6 +224,9 @@ func server(localLogger *log.Logger, cOpts *ProgOpts) string {
 			return fmt.Sprintf("Error creating required directory %s: %v", cOpts.SecretsRoot, err)
 		}
 	}
+	if cOpts.MigrateFrom != "" {
+		return migrate(localLogger, cOpts, dataRoot, secretsRoot)
+	}
 	// Validate HA args - Assumes a local consul server running talking to the "cluster"
 	if cOpts.HaEnabled {
 		if cOpts.SecretsType != "consul" || cOpts.BackEndType != "consul" {
@@ -302,12 +309,7 @@ func server(localLogger *log.Logger, cOpts *ProgOpts) string {
 	if err != nil {
 		return fmt.Sprintf("Unable to create DataStack: %v", err)
 	}
-	var secretStore store.Store
-	if u, perr := url.Parse(cOpts.SecretsType); perr == nil && u.Scheme != "" {
-		secretStore, err = store.Open(cOpts.SecretsType)
-	} else {
-		secretStore, err = store.Open(fmt.Sprintf("%s://%s", cOpts.SecretsType, cOpts.SecretsRoot))
-	}
+	secretStore, err := openStore(cOpts.SecretsType, cOpts.SecretsRoot)
 	if err != nil {
 		return fmt.Sprintf("Unable to open secrets store: %v", err)
 	}
@@ -344,6 +346,7 @@ func server(localLogger *log.Logger, cOpts *ProgOpts) string {
 	if cOpts.CleanupCorrupt {
 		dt.Cleanup = true
 	}
+	dt.RepoCacheRoot = cOpts.RepoCacheRoot
 	// No DrpId - get a mac address
 	if cOpts.DrpId == "" {
 		intfs, err := net.Interfaces()
@@ -366,6 +369,33 @@ func server(localLogger *log.Logger, cOpts *ProgOpts) string {
 		}
 	}
 
+	if cOpts.JobJanitorInterval > 0 {
+		services = append(services, midlayer.StartJobJanitor(dt, buf.Log("backend"),
+			time.Duration(cOpts.JobJanitorInterval)*time.Second))
+	}
+	if cOpts.StallCheckInterval > 0 {
+		services = append(services, midlayer.StartStallWatcher(dt, buf.Log("backend"),
+			time.Duration(cOpts.StallCheckInterval)*time.Second))
+	}
+	if cOpts.MachinePingInterval > 0 {
+		var machinePinger pinger.Pinger
+		if cOpts.FakePinger {
+			machinePinger = pinger.Fake(true)
+		} else if machinePinger, err = pinger.ICMP(); err != nil {
+			return fmt.Sprintf("Error starting machine pinger: %v", err)
+		}
+		services = append(services, midlayer.StartMachinePinger(dt, buf.Log("backend"), machinePinger,
+			time.Duration(cOpts.MachinePingInterval)*time.Second))
+	}
+	if cOpts.AllocationCheckInterval > 0 {
+		services = append(services, midlayer.StartAllocationReaper(dt, buf.Log("backend"),
+			time.Duration(cOpts.AllocationCheckInterval)*time.Second))
+	}
+	if cOpts.RolloutCheckInterval > 0 {
+		services = append(services, midlayer.StartRolloutRunner(dt, buf.Log("backend"), publishers,
+			time.Duration(cOpts.RolloutCheckInterval)*time.Second))
+	}
+
 	pc, err := midlayer.InitPluginController(cOpts.PluginRoot, cOpts.PluginCommRoot, dt, publishers)
 	if err != nil {
 		return fmt.Sprintf("Error starting plugin service: %v", err)
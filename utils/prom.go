@@ -54,3 +54,17 @@ func (p *Prometheus) CounterWithLabelValues(id string, args ...string) prometheu
 	}
 	return o.WithLabelValues(args...)
 }
+
+func (p *Prometheus) Counter(id string) prometheus.Counter {
+	m, ok := p.metrics[id]
+	if !ok {
+		p.l.Errorf("Failed to lookup metric: %s", id)
+		return nil
+	}
+	o, ok := m.MetricCollector.(prometheus.Counter)
+	if !ok {
+		p.l.Errorf("metric, %s, is not a Counter, %+v", id, m.MetricCollector)
+		return nil
+	}
+	return o
+}